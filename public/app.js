@@ -1,22 +1,114 @@
-async function fetchEventsAndToken() {
+function currentLocation() {
+    return new Promise((resolve) => {
+      if (!navigator.geolocation) return resolve(null);
+      navigator.geolocation.getCurrentPosition(
+        (position) => resolve(position.coords),
+        () => resolve(null),
+        { timeout: 5000, maximumAge: 600000 }
+      );
+    });
+  }
+
+  const api = new MapthensClient();
+
+  // escapeHTML makes event text, which comes from scraped pages and public
+  // submissions, safe to put in markup.
+  function escapeHTML(value) {
+    return String(value ?? '')
+      .replace(/&/g, '&amp;')
+      .replace(/</g, '&lt;')
+      .replace(/>/g, '&gt;')
+      .replace(/"/g, '&quot;')
+      .replace(/'/g, '&#39;');
+  }
+
+  // safeURL escapes an http(s) link for an href, and drops any other.
+  function safeURL(value) {
+    return /^https?:\/\//i.test(value ?? '') ? escapeHTML(value) : '#';
+  }
+
+  async function fetchEventsAndConfig() {
     try {
-      const response = await fetch('/api/events');
-      if (!response.ok) throw new Error('Failed to fetch data');
-  
-      const data = await response.json();
-  
-      const mapboxToken = data.mapbox_token;
+      const coords = await currentLocation();
+      const params = { v: 2, expand: 'display' };
+      if (coords) params.from = `${coords.latitude},${coords.longitude}`;
+      const [config, data] = await Promise.all([api.config(), api.events(params)]);
       const events = data.events;
-  
-      mapboxgl.accessToken = mapboxToken;
-  
+
+      if (config.proxy_url) {
+        // The proxy adds the real token; Mapbox GL just needs one to be set
+        mapboxgl.accessToken = 'proxied';
+      } else {
+        mapboxgl.accessToken = config.mapbox_token;
+      }
+
       displayEvents(events);
-      initializeMap(events);
+      initializeMap(events, config, data.bounds);
     } catch (error) {
       console.error('Error fetching data:', error);
     }
   }
   
+  function trackEvent(event, action) {
+    const body = JSON.stringify({ event_id: event.id, action });
+    if (navigator.sendBeacon) {
+      navigator.sendBeacon('/api/track', body);
+    } else {
+      fetch('/api/track', { method: 'POST', body, keepalive: true });
+    }
+  }
+  
+  function trackLinkClicks(element, event) {
+    element.querySelectorAll('a').forEach((link) => {
+      link.addEventListener('click', () => trackEvent(event, 'click'));
+    });
+  }
+  
+  // popupContent builds a popup's contents, counting clicks on its links.
+  // Popups keep their contents between openings, so the links' listeners
+  // are only added here.
+  function popupContent(event) {
+    const content = document.createElement('div');
+    content.innerHTML = `
+      <h3>${escapeHTML(event.title)}</h3>
+      <p>${escapeHTML(event.venue)}</p>
+      <p>${escapeHTML(event.datetime)}</p>
+      <a href="${safeURL(event.event_link)}" target="_blank">More Info</a>
+      <a href="/api/events/ical/${encodeURIComponent(event.id)}.ics">Add to Calendar</a>
+    `;
+    trackLinkClicks(content, event);
+    return content;
+  }
+
+  // showRelated adds a "You might also like" list to an open popup,
+  // replacing the one from its last opening.
+  function showRelated(popup, event) {
+    api.related(event.id, { limit: 3 })
+      .then((data) => {
+        if (!data || data.related.length === 0 || !popup.isOpen()) return;
+        const section = document.createElement('div');
+        section.className = 'related-events';
+        const heading = document.createElement('h4');
+        heading.textContent = 'You might also like';
+        const list = document.createElement('ul');
+        data.related.forEach((related) => {
+          const item = document.createElement('li');
+          item.textContent = `${related.title} \u00b7 ${related.venue}`;
+          list.appendChild(item);
+        });
+        section.append(heading, list);
+
+        const content = popup.getElement().querySelector('.mapboxgl-popup-content');
+        const previous = content.querySelector('.related-events');
+        if (previous) {
+          previous.replaceWith(section);
+        } else {
+          content.appendChild(section);
+        }
+      })
+      .catch(() => {});
+  }
+
   function displayEvents(events) {
     const eventList = document.getElementById('event-list');
     eventList.innerHTML = ''; 
@@ -25,13 +117,15 @@ async function fetchEventsAndToken() {
       const eventItem = document.createElement('div');
       eventItem.className = 'event-item';
       eventItem.innerHTML = `
-        <h3>${event.title}</h3>
-        <p><strong>Date:</strong> ${event.datetime}</p>
-        <p><strong>Category:</strong> ${event.category}</p>
-        <p><strong>Venue:</strong> ${event.venue}</p>
-        <p>${event.description}</p>
-        <a href="${event.event_link}" target="_blank">More Info</a>
+        <h3>${escapeHTML(event.title)}</h3>
+        <p><strong>Date:</strong> ${escapeHTML(event.datetime)}</p>
+        <p><strong>Category:</strong> ${escapeHTML(event.category)}</p>
+        <p><strong>Venue:</strong> ${escapeHTML(event.venue)}</p>
+        ${event.walking_minutes != null ? `<p>${escapeHTML(event.walking_minutes)} minutes away on foot</p>` : ''}
+        <p>${escapeHTML(event.description)}</p>
+        <a href="${safeURL(event.event_link)}" target="_blank">More Info</a>
       `;
+      trackLinkClicks(eventItem, event);
       eventItem.addEventListener('click', () => {
         flyToEvent(event);
         createPopup(event);
@@ -40,18 +134,77 @@ async function fetchEventsAndToken() {
     });
   }
   
-  function initializeMap(events) {
+  function addMarker(map, markers, event) {
+    const el = document.createElement('div');
+    el.className = 'marker';
+    el.style.backgroundColor = event.featured ? '#f5b041' : '#ffffff';
+    el.style.width = '14px';
+    el.style.height = '14px';
+    el.style.borderRadius = '50%';
+    el.style.border = event.featured ? '2px solid #f5b041' : '2px solid #ffffff';
+    el.style.cursor = 'pointer';
+
+    const popup = new mapboxgl.Popup({ offset: 25 }).setDOMContent(popupContent(event));
+    popup.on('open', () => {
+      trackEvent(event, 'popup');
+      showRelated(popup, event);
+    });
+
+    // Events stacked at one venue are drawn a little apart
+    const marker = new mapboxgl.Marker(el)
+      .setLngLat([event.display_longitude ?? event.longitude, event.display_latitude ?? event.latitude])
+      .setPopup(popup)
+      .addTo(map);
+    markers.set(event.id, marker);
+  }
+
+  // subscribeLive applies the diffs pushed over /ws to the markers and the
+  // event list, so listings added during the day show up without a reload.
+  function subscribeLive(map, markers, events) {
+    if (!window.WebSocket) return;
+    const current = new Map(events.map((event) => [event.id, event]));
+    const scheme = location.protocol === 'https:' ? 'wss' : 'ws';
+    const socket = new WebSocket(`${scheme}://${location.host}/ws`);
+
+    socket.addEventListener('message', (message) => {
+      const data = JSON.parse(message.data);
+      if (data.type !== 'diff') return;
+
+      (data.removed || []).forEach((id) => {
+        if (markers.has(id)) markers.get(id).remove();
+        markers.delete(id);
+        current.delete(id);
+      });
+      [...(data.added || []), ...(data.updated || [])].forEach((event) => {
+        if (markers.has(event.id)) markers.get(event.id).remove();
+        addMarker(map, markers, event);
+        current.set(event.id, { ...current.get(event.id), ...event });
+      });
+      displayEvents([...current.values()]);
+    });
+  }
+
+  function initializeMap(events, config, bounds) {
     const map = new mapboxgl.Map({
       container: 'map',
-      style: 'mapbox://styles/mapbox/dark-v11',
-      center: [-83.3789, 33.9519],
-      zoom: 12,
+      style: config.map_style,
+      center: config.center,
+      zoom: config.zoom,
+      transformRequest: (url) => {
+        if (config.proxy_url && url.startsWith('https://api.mapbox.com')) {
+          return { url: url.replace('https://api.mapbox.com', config.proxy_url) };
+        }
+        return { url };
+      },
       pitch: 45,
       bearing: -17.6,
       antialias: true
     });
   
     map.on('load', () => {
+      if (bounds) {
+        map.fitBounds(bounds, { padding: 40, maxZoom: 15, duration: 0 });
+      }
       const layers = map.getStyle().layers;
       let labelLayerId;
       for (const layer of layers) {
@@ -79,28 +232,9 @@ async function fetchEventsAndToken() {
         labelLayerId
       );
   
-      events.forEach(event => {
-        const el = document.createElement('div');
-        el.className = 'marker';
-        el.style.backgroundColor = '#ffffff';
-        el.style.width = '14px';
-        el.style.height = '14px';
-        el.style.borderRadius = '50%';
-        el.style.border = '2px solid #ffffff';
-        el.style.cursor = 'pointer';
-  
-        const popup = new mapboxgl.Popup({ offset: 25 }).setHTML(`
-          <h3>${event.title}</h3>
-          <p>${event.venue}</p>
-          <p>${event.datetime}</p>
-          <a href="${event.event_link}" target="_blank">More Info</a>
-        `);
-  
-        new mapboxgl.Marker(el)
-          .setLngLat([event.longitude, event.latitude])
-          .setPopup(popup)
-          .addTo(map);
-      });
+      const markers = new Map();
+      events.forEach(event => addMarker(map, markers, event));
+      subscribeLive(map, markers, events);
     });
   
     function flyToEvent(event) {
@@ -112,16 +246,12 @@ async function fetchEventsAndToken() {
     }
   
     function createPopup(event) {
+      trackEvent(event, 'popup');
       new mapboxgl.Popup()
         .setLngLat([event.longitude, event.latitude])
-        .setHTML(`
-          <h3>${event.title}</h3>
-          <p>${event.venue}</p>
-          <p>${event.datetime}</p>
-          <a href="${event.event_link}" target="_blank">More Info</a>
-        `)
+        .setDOMContent(popupContent(event))
         .addTo(map);
     }
   }
   
-  window.onload = fetchEventsAndToken;
\ No newline at end of file
+  window.onload = fetchEventsAndConfig;
\ No newline at end of file
@@ -8,4 +8,6 @@ fi
 
 echo "Starting Mapthens server..."
 cd server
-go run main.go
+go run . client ../public/client
+go run . compress-assets ../public
+go run .
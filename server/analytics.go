@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracked popup opens and link clicks are also counted per day and added to
+// the event store's totals with the rest of the tracking data, every
+// minute, so server processes sharing a database or cache directory count
+// together. The
+// admin API reads them back:
+//
+//	GET /api/analytics/events/{id}        an event's counts by day
+//	GET /api/analytics/top?date=&limit=   a day's most popular events
+//
+// Without a database the daily counts are kept in analytics.json in the
+// cache directory for analyticsRetention.
+
+const (
+	analyticsRetention = 90 // days
+	defaultTopLimit    = 20
+	maxTopLimit        = 100
+)
+
+// Data Structures
+
+type DailyStats struct {
+	Date string `json:"date"`
+	EventStats
+	Score int `json:"score"`
+}
+
+type EventAnalytics struct {
+	EventID string       `json:"event_id"`
+	Title   string       `json:"title,omitempty"`
+	Days    []DailyStats `json:"days"`
+	Total   EventStats   `json:"total"`
+	Score   int          `json:"score"`
+}
+
+// Global Variables
+var (
+	// dailyCounts holds the counts by day, then event ID, that haven't been
+	// added to the store yet; it's guarded by trackMutex
+	dailyCounts = map[string]map[string]*EventStats{}
+
+	// The memory store's file of daily counts, by day and then event ID
+	analyticsFile = "analytics.json"
+)
+
+// Helper Functions
+
+// countDaily adds a tracked action to today's counts. The caller holds
+// trackMutex.
+func countDaily(id, action string) {
+	day := today()
+	counts, ok := dailyCounts[day]
+	if !ok {
+		counts = map[string]*EventStats{}
+		dailyCounts[day] = counts
+	}
+	stats, ok := counts[id]
+	if !ok {
+		stats = &EventStats{}
+		counts[id] = stats
+	}
+	switch action {
+	case "popup":
+		stats.PopupOpens++
+	case "click":
+		stats.LinkClicks++
+	}
+}
+
+// flushAnalytics adds the counts since the last flush to the store's
+// totals. Days that fail to save are kept for the next flush.
+func flushAnalytics() error {
+	trackMutex.Lock()
+	pending := dailyCounts
+	dailyCounts = map[string]map[string]*EventStats{}
+	trackMutex.Unlock()
+
+	var err error
+	for day, counts := range pending {
+		added := make(map[string]EventStats, len(counts))
+		for id, stats := range counts {
+			added[id] = *stats
+		}
+		if saveErr := eventStore.AddAnalytics(day, added); saveErr != nil {
+			trackMutex.Lock()
+			for id, stats := range counts {
+				kept, ok := dailyCounts[day]
+				if !ok {
+					kept = map[string]*EventStats{}
+					dailyCounts[day] = kept
+				}
+				if kept[id] == nil {
+					kept[id] = &EventStats{}
+				}
+				kept[id].add(*stats)
+			}
+			trackMutex.Unlock()
+			err = fmt.Errorf("saving %s: %v", day, saveErr)
+		}
+	}
+	return err
+}
+
+func readAnalyticsFile() (map[string]map[string]EventStats, error) {
+	saved := map[string]map[string]EventStats{}
+	if err := readJSONFile(analyticsFile, &saved); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// AddAnalytics adds counts to the analytics file under its lock, dropping
+// days older than analyticsRetention.
+func (memoryStore) AddAnalytics(day string, counts map[string]EventStats) error {
+	saved := map[string]map[string]EventStats{}
+	return updateJSONFile(analyticsFile, &saved, func() error {
+		stored, ok := saved[day]
+		if !ok {
+			stored = map[string]EventStats{}
+			saved[day] = stored
+		}
+		for id, stats := range counts {
+			total := stored[id]
+			total.add(stats)
+			stored[id] = total
+		}
+		oldest := now().In(getConfig().Location).AddDate(0, 0, -analyticsRetention).Format("2006-01-02")
+		for d := range saved {
+			if d < oldest {
+				delete(saved, d)
+			}
+		}
+		return nil
+	})
+}
+
+func (memoryStore) Analytics(day string) (map[string]EventStats, error) {
+	saved, err := readAnalyticsFile()
+	if err != nil {
+		return nil, err
+	}
+	counts := saved[day]
+	if counts == nil {
+		counts = map[string]EventStats{}
+	}
+	return counts, nil
+}
+
+func (memoryStore) EventAnalytics(id string) (map[string]EventStats, error) {
+	saved, err := readAnalyticsFile()
+	if err != nil {
+		return nil, err
+	}
+	days := map[string]EventStats{}
+	for day, counts := range saved {
+		if stats, ok := counts[id]; ok {
+			days[day] = stats
+		}
+	}
+	return days, nil
+}
+
+// eventTitle finds an event's title, for events still in the cache or the
+// recent archive.
+func eventTitle(id string) string {
+	if e, ok := findEvent(id); ok {
+		return e.Title
+	}
+	return ""
+}
+
+// HTTP Handlers
+
+// analyticsEventHandler serves GET /api/analytics/events/{id}.
+func analyticsEventHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/analytics/events/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	days, err := eventStore.EventAnalytics(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading analytics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	title := eventTitle(id)
+	if len(days) == 0 && title == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	analytics := EventAnalytics{EventID: id, Title: title, Days: []DailyStats{}}
+	for day, stats := range days {
+		analytics.Days = append(analytics.Days, DailyStats{Date: day, EventStats: stats, Score: popularityScore(stats)})
+		analytics.Total.PopupOpens += stats.PopupOpens
+		analytics.Total.LinkClicks += stats.LinkClicks
+	}
+	sort.Slice(analytics.Days, func(i, j int) bool {
+		return analytics.Days[i].Date < analytics.Days[j].Date
+	})
+	analytics.Score = popularityScore(analytics.Total)
+	writeJSON(w, analytics)
+}
+
+// analyticsTopHandler serves GET /api/analytics/top?date=2026-10-15&limit=20,
+// a day's events by popularity. The date defaults to today.
+func analyticsTopHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	day := r.URL.Query().Get("date")
+	if day == "" {
+		day = today()
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "Invalid date parameter: expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	limit := defaultTopLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxTopLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxTopLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	counts, err := eventStore.Analytics(day)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading analytics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	titles := map[string]string{}
+	if listed, err := eventStore.Listed([]string{day}); err == nil {
+		for _, e := range listed[day] {
+			titles[e.ID] = e.Title
+		}
+	}
+
+	top := []PopularEvent{}
+	for id, stats := range counts {
+		title, ok := titles[id]
+		if !ok {
+			title = eventTitle(id)
+		}
+		top = append(top, PopularEvent{EventID: id, Title: title, EventStats: stats, Score: popularityScore(stats)})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Score != top[j].Score {
+			return top[i].Score > top[j].Score
+		}
+		return top[i].EventID < top[j].EventID
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	writeJSON(w, top)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// /api/events?as_of=2026-05-01T12:00:00Z returns the events as they were
+// published at that moment, for research and for diffing what was shown
+// when. Each scrape that saves the daily snapshot is recorded in
+// snapshots/publications.json, and the snapshot it published is kept as
+// snapshots/versions/2026-05-01/160512.json.gz (its UTC time). ?as_of=
+// resolves to the last publication at or before the moment and serves its
+// version. Once a day is compacted into its month's archive (see
+// retention.go) its versions are deleted, and only the day's last
+// publication can still be served exactly, from the archive; an earlier one
+// falls back to it and is reported as inexact. Moments before the first
+// recorded publication are answered with the snapshot or archive of their
+// day, also inexact.
+
+const publicationLayout = "150405"
+
+// Data Structures
+
+type Publication struct {
+	Day         string    `json:"day"`
+	PublishedAt time.Time `json:"published_at"`
+	Hash        string    `json:"hash,omitempty"`
+	// File is the published snapshot's version, relative to snapshotDir
+	File string `json:"file"`
+}
+
+// AsOfInfo reports which publication answered an ?as_of= request.
+type AsOfInfo struct {
+	Requested time.Time `json:"requested"`
+	ListedOn  string    `json:"listed_on"`
+	// PublishedAt is left out when no publication was recorded by then
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	// Exact is false when the events are the day's last listing rather
+	// than the one published at PublishedAt
+	Exact bool `json:"exact"`
+}
+
+// Helper Functions
+
+func publicationsFile() string {
+	return filepath.Join(snapshotDir, "publications.json")
+}
+
+func versionsDir(day string) string {
+	return filepath.Join(snapshotDir, "versions", day)
+}
+
+func loadPublications() ([]Publication, error) {
+	var publications []Publication
+	if err := readJSONFile(publicationsFile(), &publications); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return publications, nil
+}
+
+// recordPublication keeps a version of the snapshot published for day and
+// adds it to the publication history. Publications of days whose archives
+// have been deleted are dropped from the history.
+func recordPublication(day string, events []Event, publishedAt time.Time, hash string) error {
+	publication := Publication{
+		Day:         day,
+		PublishedAt: publishedAt.UTC(),
+		Hash:        hash,
+		File:        filepath.ToSlash(filepath.Join("versions", day, publishedAt.UTC().Format(publicationLayout)+".json"+gzipSuffix)),
+	}
+	if err := os.MkdirAll(versionsDir(day), 0755); err != nil {
+		return err
+	}
+	if err := writeEventsFile(filepath.Join(snapshotDir, publication.File), events); err != nil {
+		return err
+	}
+
+	publications, err := loadPublications()
+	if err != nil {
+		return err
+	}
+	publications = append(publications, publication)
+	if months := getConfig().ArchiveMonths; months > 0 {
+		oldest := localNow().AddDate(0, -months, 0).Format("2006-01") + "-01"
+		kept := publications[:0]
+		for _, p := range publications {
+			if p.Day >= oldest {
+				kept = append(kept, p)
+			}
+		}
+		publications = kept
+	}
+	sort.SliceStable(publications, func(i, j int) bool { return publications[i].PublishedAt.Before(publications[j].PublishedAt) })
+	data, err := json.MarshalIndent(publications, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(publicationsFile(), data, 0644)
+}
+
+// dayListing returns day's last listing: its snapshot, or its events in the
+// month's archive. ok is false when neither holds the day.
+func dayListing(day string, index map[string]ArchiveInfo) ([]Event, bool, error) {
+	if snapshot, err := readEventsFile(snapshotFile(day)); err == nil {
+		return snapshot, true, nil
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	archive, ok := index[day[:7]]
+	if !ok {
+		return nil, false, nil
+	}
+	archived, err := readArchive(filepath.Join(snapshotDir, archive.File))
+	if err != nil {
+		return nil, false, fmt.Errorf("reading the %s archive: %v", archive.Month, err)
+	}
+	var events []Event
+	for _, e := range archived {
+		if e.ListedOn == day {
+			events = append(events, e.Event)
+		}
+	}
+	// Days with no events are still archived
+	listed := len(events) > 0
+	for _, d := range archive.Days {
+		listed = listed || d == day
+	}
+	return events, listed, nil
+}
+
+// eventsAsOf returns the events published as of at. ok is false when
+// nothing was published by then.
+func eventsAsOf(at time.Time) ([]Event, AsOfInfo, bool, error) {
+	info := AsOfInfo{Requested: at.UTC()}
+	publications, err := loadPublications()
+	if err != nil {
+		return nil, info, false, fmt.Errorf("reading the publication history: %v", err)
+	}
+	index, err := loadArchiveIndex()
+	if err != nil {
+		return nil, info, false, err
+	}
+
+	i := sort.Search(len(publications), func(i int) bool { return publications[i].PublishedAt.After(at) }) - 1
+	if i < 0 {
+		info.ListedOn = at.In(getConfig().Location).Format("2006-01-02")
+		events, ok, err := dayListing(info.ListedOn, index)
+		return events, info, ok, err
+	}
+
+	publication := publications[i]
+	info.ListedOn, info.PublishedAt = publication.Day, &publication.PublishedAt
+	if events, err := readEventsFile(filepath.Join(snapshotDir, filepath.FromSlash(publication.File))); err == nil {
+		info.Exact = true
+		return events, info, true, nil
+	} else if !os.IsNotExist(err) {
+		return nil, info, false, err
+	}
+	// The version was compacted away; the day's listing is the one this
+	// publication put out only if it was the day's last
+	last := i == len(publications)-1 || publications[i+1].Day != publication.Day
+	events, ok, err := dayListing(publication.Day, index)
+	info.Exact = ok && last
+	return events, info, ok, err
+}
+
+// HTTP Handlers
+
+// asOfEventsHandler serves /api/events?as_of=. The other /api/events filters
+// apply, except ?from=.
+func asOfEventsHandler(w http.ResponseWriter, r *http.Request) {
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("as_of"))
+	if err != nil {
+		http.Error(w, "Invalid as_of parameter: must be an RFC 3339 time, e.g. 2026-05-01T12:00:00Z", http.StatusBadRequest)
+		return
+	}
+	if at.After(now()) {
+		http.Error(w, "Invalid as_of parameter: must not be in the future", http.StatusBadRequest)
+		return
+	}
+
+	events, info, ok, err := eventsAsOf(at)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading snapshots: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No events were published by then", http.StatusNotFound)
+		return
+	}
+	if events, ok = filterEvents(w, r, events); !ok {
+		return
+	}
+
+	cacheInfo := CacheInfo{Status: cacheMiss, AsOf: &info}
+	if info.PublishedAt != nil {
+		cacheInfo.ScrapedAt = *info.PublishedAt
+	}
+	writeEventsResponse(w, r, events, len(events), eventOrder, cacheInfo)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A week view needs a week of listings. /api/events?dates=2026-10-12,2026-10-13
+// (or a range, ?dates=2026-10-12..2026-10-18) returns each day's events in
+// one response, keyed by date. Today's come from the cache and the rest from
+// the event store in a single read.
+
+const maxBatchDates = 31
+
+// Data Structures
+
+type BatchResponse struct {
+	Version int                `json:"version"`
+	Dates   map[string][]Event `json:"dates"`
+	// Left out of the v2 envelope, as in APIResponse
+	MapboxToken    string    `json:"mapbox_token,omitempty"`
+	ScrapedAt      time.Time `json:"scraped_at"`
+	DataAgeSeconds int64     `json:"data_age_seconds"`
+	// Total counts the events across all dates; an event running on
+	// several of them is counted once per date
+	Total int    `json:"total"`
+	Order string `json:"order"`
+	// As in APIResponse
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// sparseBatchResponse is BatchResponse with its events cut down to the
+// selected fields, like sparseResponse.
+type sparseBatchResponse struct {
+	BatchResponse
+	Dates map[string][]map[string]interface{} `json:"dates"`
+}
+
+// Helper Functions
+
+// parseDates parses a comma-separated list of dates, or a first..last range,
+// into sorted, distinct YYYY-MM-DD days.
+func parseDates(value string) ([]string, error) {
+	var days []string
+	if first, last, ok := strings.Cut(value, ".."); ok {
+		start, err := time.Parse("2006-01-02", strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", first)
+		}
+		end, err := time.Parse("2006-01-02", strings.TrimSpace(last))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", last)
+		}
+		if end.Before(start) {
+			return nil, fmt.Errorf("range ends before it starts")
+		}
+		if end.Sub(start) >= maxBatchDates*24*time.Hour {
+			return nil, fmt.Errorf("at most %d dates", maxBatchDates)
+		}
+		for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+			days = append(days, day.Format("2006-01-02"))
+		}
+		return days, nil
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ",") {
+		day := strings.TrimSpace(part)
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("invalid date %q", part)
+		}
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	if len(days) > maxBatchDates {
+		return nil, fmt.Errorf("at most %d dates", maxBatchDates)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// HTTP Handlers
+
+// batchEventsHandler serves /api/events?dates=. The other /api/events
+// filters apply to every date, except ?from=, which is only served for a
+// single day.
+func batchEventsHandler(w http.ResponseWriter, r *http.Request) {
+	days, err := parseDates(r.URL.Query().Get("dates"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid dates parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid fields parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tz parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Make sure today's events have been scraped and saved
+	current, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var stored []string
+	for _, day := range days {
+		if day != today() {
+			stored = append(stored, day)
+		}
+	}
+	listed := map[string][]Event{}
+	if len(stored) > 0 {
+		if listed, err = eventStore.Listed(stored); err != nil {
+			http.Error(w, fmt.Sprintf("Error querying events: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, day := range days {
+		if day == today() {
+			listed[day] = current
+		}
+	}
+
+	response := BatchResponse{
+		Version:        1,
+		Dates:          make(map[string][]Event, len(days)),
+		ScrapedAt:      info.ScrapedAt,
+		DataAgeSeconds: int64(since(info.ScrapedAt).Seconds()),
+		Order:          eventOrder,
+	}
+	for _, day := range days {
+		events, ok := filterEvents(w, r, listed[day])
+		if !ok {
+			return
+		}
+		if events, ok = expandEvents(w, r, events); !ok {
+			return
+		}
+		if loc != nil {
+			convertEventTimes(events, loc)
+		}
+		response.Dates[day] = events
+		response.Total += len(events)
+	}
+	if loc != nil {
+		response.ScrapedAt, response.TimeZone = response.ScrapedAt.In(loc), loc.String()
+	}
+	switch r.URL.Query().Get("v") {
+	case "", "1":
+		response.MapboxToken = clientConfig(getConfig()).MapboxToken
+	case "2":
+		response.Version = 2
+	default:
+		http.Error(w, "Invalid v parameter: must be 1 or 2", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Status", info.Status)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Vary", "Accept-Language")
+	if fields != nil {
+		sparse := sparseBatchResponse{BatchResponse: response, Dates: make(map[string][]map[string]interface{}, len(days))}
+		for day, events := range response.Dates {
+			sparse.Dates[day] = selectFields(events, fields)
+		}
+		json.NewEncoder(w).Encode(sparse)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Personalized ordering without accounts. The frontend keeps a user's
+// favorite venues and categories itself and passes them along:
+//
+//	/api/events?boost_venues=40-watt-club,georgia-theatre&boost_categories=music
+//
+// Events at a boosted venue (by the ID used in /api/venues/{id}) come first,
+// then events in a boosted category; an event matching both outranks either.
+// The sort is stable, so within each tier events keep eventOrder and the same
+// request always gets the same order. Nothing is filtered out, and the
+// response's order names the boosts, e.g. "boost,start_time,venue,title".
+
+const maxBoosts = 50
+
+// Scores an event gets for each kind of match; a venue outweighs a category
+const (
+	venueBoost    = 2
+	categoryBoost = 1
+)
+
+// Data Structures
+
+type eventBoost struct {
+	Venues     []string
+	Categories []string
+}
+
+// Helper Functions
+
+// parseBoost reads ?boost_venues= and ?boost_categories=, returning nil when
+// neither is set.
+func parseBoost(r *http.Request) (*eventBoost, error) {
+	venues := splitList(r.URL.Query().Get("boost_venues"))
+	categories := splitList(r.URL.Query().Get("boost_categories"))
+	if len(venues) > maxBoosts {
+		return nil, fmt.Errorf("boost_venues lists more than %d venues", maxBoosts)
+	}
+	if len(categories) > maxBoosts {
+		return nil, fmt.Errorf("boost_categories lists more than %d categories", maxBoosts)
+	}
+	if len(venues) == 0 && len(categories) == 0 {
+		return nil, nil
+	}
+	return &eventBoost{Venues: venues, Categories: categories}, nil
+}
+
+// splitList splits a comma-separated parameter, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (b *eventBoost) score(e Event) int {
+	score := 0
+	if e.Venue != "" && containsFold(b.Venues, venueID(e.Venue)) {
+		score += venueBoost
+	}
+	if containsFold(b.Categories, e.Category) {
+		score += categoryBoost
+	}
+	return score
+}
+
+// boostEvents returns events with the boosted ones first, otherwise in their
+// existing order.
+func boostEvents(events []Event, b *eventBoost) []Event {
+	scores := make([]int, len(events))
+	for i, e := range events {
+		scores[i] = b.score(e)
+	}
+	indexes := make([]int, len(events))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return scores[indexes[i]] > scores[indexes[j]]
+	})
+
+	boosted := make([]Event, len(events))
+	for i, index := range indexes {
+		boosted[i] = events[index]
+	}
+	return boosted
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// After each Parquet export the directory's partitions are catalogued for
+// Athena, so a new day can be queried as soon as it's synced without running
+// MSCK REPAIR TABLE. Two files are written at the top of the directory
+// (Athena skips files starting with an underscore):
+//
+//   - _manifest.json lists every partition with its location and row count
+//   - _athena.sql creates the mapthens_events table if it doesn't exist and
+//     adds any partitions it's missing
+//
+// Partitions are located under MAPTHENS_PARQUET_LOCATION, the URL the
+// directory is synced to (e.g. "s3://bucket/mapthens"), or the directory
+// itself when it isn't set. The sync job runs _athena.sql after uploading;
+// every statement is safe to repeat.
+
+const catalogTable = "mapthens_events"
+
+// Data Structures
+
+type catalogPartition struct {
+	ListedOn string `json:"listed_on"`
+	Category string `json:"category"`
+	Location string `json:"location"`
+	Rows     int64  `json:"rows"`
+}
+
+type catalogManifest struct {
+	Table      string             `json:"table"`
+	Location   string             `json:"location"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+	Partitions []catalogPartition `json:"partitions"`
+}
+
+// Helper Functions
+
+// catalogColumns lists parquetEvent's columns with their Athena types.
+func catalogColumns() []string {
+	var columns []string
+	t := reflect.TypeOf(parquetEvent{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("parquet"), ",")
+		var kind string
+		switch field.Type {
+		case reflect.TypeOf(time.Time{}):
+			kind = "timestamp"
+		default:
+			switch field.Type.Kind() {
+			case reflect.String:
+				kind = "string"
+			case reflect.Bool:
+				kind = "boolean"
+			case reflect.Float64:
+				kind = "double"
+			case reflect.Int32:
+				kind = "int"
+			default:
+				panic(fmt.Sprintf("no Athena type for %s", field.Type))
+			}
+		}
+		columns = append(columns, fmt.Sprintf("`%s` %s", name, kind))
+	}
+	return columns
+}
+
+// catalogPartitions finds the exported partitions under dir, oldest day
+// first.
+func catalogPartitions(dir, location string) ([]catalogPartition, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "listed_on=*", "category=*", "events.parquet"))
+	if err != nil {
+		return nil, err
+	}
+	partitions := []catalogPartition{}
+	for _, path := range paths {
+		categoryDir := filepath.Dir(path)
+		dayDir := filepath.Dir(categoryDir)
+		day := strings.TrimPrefix(filepath.Base(dayDir), "listed_on=")
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			continue // a staging or retired copy
+		}
+		rows, err := parquetRows(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		partitions = append(partitions, catalogPartition{
+			ListedOn: day,
+			Category: strings.TrimPrefix(filepath.Base(categoryDir), "category="),
+			Location: location + "/" + filepath.Base(dayDir) + "/" + filepath.Base(categoryDir) + "/",
+			Rows:     rows,
+		})
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].ListedOn != partitions[j].ListedOn {
+			return partitions[i].ListedOn < partitions[j].ListedOn
+		}
+		return partitions[i].Category < partitions[j].Category
+	})
+	return partitions, nil
+}
+
+func parquetRows(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	file, err := parquet.OpenFile(f, info.Size(), parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return 0, err
+	}
+	return file.NumRows(), nil
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// athenaSQL creates the table and registers partitions.
+func athenaSQL(manifest catalogManifest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS %s (\n  %s\n)\n", manifest.Table, strings.Join(catalogColumns(), ",\n  "))
+	fmt.Fprintf(&b, "PARTITIONED BY (`listed_on` string, `category` string)\n")
+	fmt.Fprintf(&b, "STORED AS PARQUET\nLOCATION %s;\n", sqlQuote(manifest.Location+"/"))
+	if len(manifest.Partitions) > 0 {
+		fmt.Fprintf(&b, "\nALTER TABLE %s ADD IF NOT EXISTS", manifest.Table)
+		for _, p := range manifest.Partitions {
+			fmt.Fprintf(&b, "\n  PARTITION (listed_on = %s, category = %s) LOCATION %s",
+				sqlQuote(p.ListedOn), sqlQuote(p.Category), sqlQuote(p.Location))
+		}
+		b.WriteString(";\n")
+	}
+	return b.String()
+}
+
+// writeCatalog rewrites dir's manifest and Athena statements.
+func writeCatalog(dir, location string) error {
+	if location == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		location = "file://" + filepath.ToSlash(abs)
+	}
+	location = strings.TrimSuffix(location, "/")
+
+	partitions, err := catalogPartitions(dir, location)
+	if err != nil {
+		return err
+	}
+	manifest := catalogManifest{
+		Table:      catalogTable,
+		Location:   location,
+		UpdatedAt:  time.Now().UTC(),
+		Partitions: partitions,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "_manifest.json"), data, 0644); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "_athena.sql"), []byte(athenaSQL(manifest)), 0644)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Alongside each daily snapshot, snapshots/{day}_diff.json records what
+// changed since the previous snapshot: venues and series (events by title)
+// that weren't listed then, and how the event count changed, overall and
+// per category. The previous snapshot is usually the day before's, but is
+// whichever earlier day was scraped last when days were missed. Diffs are
+// rewritten with their snapshot on every changed scrape, deleted when their
+// day is compacted into its month's archive, and served by
+// /api/events/changes.
+
+// Data Structures
+
+type CountDelta struct {
+	Count    int `json:"count"`
+	Previous int `json:"previous"`
+	Delta    int `json:"delta"`
+}
+
+type SeriesChange struct {
+	Title string `json:"title"`
+	Venue string `json:"venue,omitempty"`
+	ID    string `json:"id"`
+}
+
+type SnapshotDiff struct {
+	Date         string    `json:"date"`
+	PreviousDate string    `json:"previous_date"`
+	GeneratedAt  time.Time `json:"generated_at"`
+
+	Events     CountDelta            `json:"events"`
+	Categories map[string]CountDelta `json:"categories"`
+	// Events whose IDs weren't (or are no longer) listed
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+
+	NewVenues []string       `json:"new_venues"`
+	NewSeries []SeriesChange `json:"new_series"`
+}
+
+// Helper Functions
+
+func snapshotDiffFile(day string) string {
+	return filepath.Join(snapshotDir, day+"_diff.json")
+}
+
+// diffSnapshots compares a day's events with an earlier day's.
+func diffSnapshots(day string, events []Event, previousDay string, previous []Event) SnapshotDiff {
+	diff := SnapshotDiff{
+		Date:         day,
+		PreviousDate: previousDay,
+		GeneratedAt:  now(),
+		Events:       CountDelta{Count: len(events), Previous: len(previous), Delta: len(events) - len(previous)},
+		Categories:   map[string]CountDelta{},
+		NewVenues:    []string{},
+		NewSeries:    []SeriesChange{},
+	}
+
+	ids := map[string]bool{}
+	venues := map[string]bool{}
+	titles := map[string]bool{}
+	for _, e := range previous {
+		ids[e.ID] = true
+		venues[normalizeVenue(e.Venue)] = true
+		titles[normalizeTitle(e.Title)] = true
+		category := diff.Categories[e.Category]
+		category.Previous++
+		diff.Categories[e.Category] = category
+	}
+
+	current := map[string]bool{}
+	for _, e := range events {
+		current[e.ID] = true
+		if !ids[e.ID] {
+			diff.Added++
+		}
+		category := diff.Categories[e.Category]
+		category.Count++
+		diff.Categories[e.Category] = category
+
+		if venue := normalizeVenue(e.Venue); venue != "" && !venues[venue] {
+			venues[venue] = true
+			diff.NewVenues = append(diff.NewVenues, e.Venue)
+		}
+		if title := normalizeTitle(e.Title); title != "" && !titles[title] {
+			titles[title] = true
+			diff.NewSeries = append(diff.NewSeries, SeriesChange{Title: e.Title, Venue: e.Venue, ID: e.ID})
+		}
+	}
+	for id := range ids {
+		if !current[id] {
+			diff.Removed++
+		}
+	}
+	for name, category := range diff.Categories {
+		category.Delta = category.Count - category.Previous
+		diff.Categories[name] = category
+	}
+	sort.Strings(diff.NewVenues)
+	sort.Slice(diff.NewSeries, func(i, j int) bool { return diff.NewSeries[i].Title < diff.NewSeries[j].Title })
+	return diff
+}
+
+// saveSnapshotDiff writes day's diff against the latest earlier snapshot.
+// Nothing is written when there's no earlier snapshot to compare with.
+func saveSnapshotDiff(day string, events []Event) error {
+	days, err := snapshotDays()
+	if err != nil {
+		return err
+	}
+	previousDay := ""
+	for _, d := range days {
+		if d < day {
+			previousDay = d
+		}
+	}
+	if previousDay == "" {
+		return nil
+	}
+	previous, err := readEventsFile(snapshotFile(previousDay))
+	if err != nil {
+		return fmt.Errorf("error reading the %s snapshot: %v", previousDay, err)
+	}
+	data, err := json.Marshal(diffSnapshots(day, events, previousDay, previous))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(snapshotDiffFile(day), data, 0644)
+}
+
+// HTTP Handlers
+
+// changesHandler serves GET /api/events/changes, the diff of a day's
+// snapshot (today by default) against the previous one.
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	day := r.URL.Query().Get("date")
+	if day == "" {
+		day = today()
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "Invalid date parameter: expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	var diff SnapshotDiff
+	if err := readJSONFile(snapshotDiffFile(day), &diff); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("No changes recorded for %s", day), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error reading changes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, diff)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+)
+
+// The frontend's map settings are served from /api/config, apart from the
+// events, so clients polling for events don't download them every time and
+// clients that only draw the map don't download the events. Responses in the
+// v2 envelope (/api/events?v=2) leave the token out for the same reason.
+
+// Data Structures
+
+type ClientConfig struct {
+	MapStyle string `json:"map_style"`
+	// Exactly one of MapboxToken and ProxyURL is set when a token is
+	// configured; neither is set otherwise
+	MapboxToken string     `json:"mapbox_token,omitempty"`
+	ProxyURL    string     `json:"proxy_url,omitempty"`
+	Center      [2]float64 `json:"center"` // lng, lat as Mapbox GL expects
+	Zoom        float64    `json:"zoom"`
+}
+
+// Helper Functions
+
+func clientConfig(cfg Config) ClientConfig {
+	client := ClientConfig{
+		MapStyle: cfg.MapStyle,
+		Center:   [2]float64{cfg.MapCenter.Longitude, cfg.MapCenter.Latitude},
+		Zoom:     cfg.MapZoom,
+	}
+	switch {
+	case cfg.MapProxyURL != "":
+		client.ProxyURL = cfg.MapProxyURL
+	case cfg.MapboxToken != "":
+		client.MapboxToken = cfg.MapboxToken
+	}
+	return client
+}
+
+// HTTP Handlers
+
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, clientConfig(getConfig()))
+}
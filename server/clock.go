@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+	_ "time/tzdata" // so the configured timezone loads on hosts without zoneinfo
+
+	"mapthens-server/pkg/archive"
+)
+
+// now is the server's clock. Everything that decides which day it is or how
+// old the cache is goes through it, so it can be swapped for a fixed clock.
+var now = time.Now
+
+// localNow returns the current time in the configured timezone. Flagpole
+// lists events in Athens local time, so "today" must be computed there
+// rather than in the host's zone.
+func localNow() time.Time {
+	return now().In(getConfig().Location)
+}
+
+// today returns the current date in the configured timezone as YYYY-MM-DD,
+// the format flagpole uses in its datetime attributes.
+func today() string {
+	return archive.DateKey(localNow())
+}
+
+func since(t time.Time) time.Duration {
+	return now().Sub(t)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// withClock runs the server with its clock stopped at t and its timezone set
+// to America/New_York, like Athens.
+func withClock(t *testing.T, at time.Time) {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousConfig, previousNow := getConfig(), now
+	t.Cleanup(func() {
+		setConfig(previousConfig)
+		now = previousNow
+	})
+	setConfig(Config{Location: loc})
+	now = func() time.Time { return at }
+}
+
+func TestToday(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		at    string
+		today string
+		local string
+	}{
+		{"before midnight", "2026-10-16T03:59:59Z", "2026-10-15", "2026-10-15T23:59:59-04:00"},
+		{"at midnight", "2026-10-16T04:00:00Z", "2026-10-16", "2026-10-16T00:00:00-04:00"},
+		{"UTC date ahead", "2026-10-16T02:00:00Z", "2026-10-15", "2026-10-15T22:00:00-04:00"},
+
+		{"midnight before spring forward", "2026-03-08T05:00:00Z", "2026-03-08", "2026-03-08T00:00:00-05:00"},
+		{"last second of EST", "2026-03-08T06:59:59Z", "2026-03-08", "2026-03-08T01:59:59-05:00"},
+		{"first second of EDT", "2026-03-08T07:00:00Z", "2026-03-08", "2026-03-08T03:00:00-04:00"},
+		{"before midnight after spring forward", "2026-03-09T03:59:59Z", "2026-03-08", "2026-03-08T23:59:59-04:00"},
+		{"midnight after spring forward", "2026-03-09T04:00:00Z", "2026-03-09", "2026-03-09T00:00:00-04:00"},
+
+		{"last second of EDT", "2026-11-01T05:59:59Z", "2026-11-01", "2026-11-01T01:59:59-04:00"},
+		{"repeated hour in EST", "2026-11-01T06:00:00Z", "2026-11-01", "2026-11-01T01:00:00-05:00"},
+		{"before midnight after fall back", "2026-11-02T04:59:59Z", "2026-11-01", "2026-11-01T23:59:59-05:00"},
+		{"midnight after fall back", "2026-11-02T05:00:00Z", "2026-11-02", "2026-11-02T00:00:00-05:00"},
+
+		{"end of Feb 28", "2028-02-29T04:59:59Z", "2028-02-28", "2028-02-28T23:59:59-05:00"},
+		{"leap day", "2028-02-29T05:00:00Z", "2028-02-29", "2028-02-29T00:00:00-05:00"},
+		{"end of leap day", "2028-03-01T04:59:59Z", "2028-02-29", "2028-02-29T23:59:59-05:00"},
+		{"March 1 after leap day", "2028-03-01T05:00:00Z", "2028-03-01", "2028-03-01T00:00:00-05:00"},
+		{"March 1 in a common year", "2027-03-01T05:00:00Z", "2027-03-01", "2027-03-01T00:00:00-05:00"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, test.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			withClock(t, at)
+			if got := today(); got != test.today {
+				t.Errorf("today() = %s, want %s", got, test.today)
+			}
+			if got := localNow().Format(time.RFC3339); got != test.local {
+				t.Errorf("localNow() = %s, want %s", got, test.local)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	// An hour of wall-clock time passes across the fall-back transition
+	// even though the local clock reads the same hour twice
+	at, _ := time.Parse(time.RFC3339, "2026-11-01T06:30:00Z")
+	withClock(t, at)
+	earlier, _ := time.Parse(time.RFC3339, "2026-11-01T05:30:00Z")
+	if got := since(earlier); got != time.Hour {
+		t.Errorf("since() = %v, want 1h", got)
+	}
+}
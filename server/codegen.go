@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	goclient "mapthens-server/pkg/client"
+)
+
+// The frontend's TypeScript definitions and API client are generated from
+// the Go types, so a field added to Event shows up in the editor of anyone
+// working on the map without a hand edit:
+//
+//	mapthens-server client ../public/client
+//
+// writes mapthens.d.ts, an interface for each payload type, and mapthens.js,
+// a small fetch-based client (MapthensClient) that loads as a plain script
+// or a CommonJS module. Both are committed; run.sh regenerates them, and
+// -check fails when they're out of date, e.g. in CI after a model change.
+// The Go client in pkg/client is written by hand, so -check also fails when
+// its Event has drifted from the server's: a JSON field one has and the
+// other doesn't, or that they give different types.
+// Fields tagged omitempty are optional, and pointers that aren't may be
+// null, matching the JSON Schemas in schema.go.
+
+const clientDir = "../public/client"
+
+// Global Variables
+
+// clientTypes are the payloads the client's methods return or take, in the
+// order their interfaces are written. Types they refer to follow them.
+var clientTypes = []reflect.Type{
+	reflect.TypeOf(ClientConfig{}),
+	reflect.TypeOf(APIResponse{}),
+	reflect.TypeOf(BatchResponse{}),
+	reflect.TypeOf(Event{}),
+	reflect.TypeOf(RelatedResponse{}),
+	reflect.TypeOf(EventQuery{}),
+}
+
+// Helper Functions
+
+// tsType is the TypeScript type of values of t, adding the structs it
+// names to queue.
+func tsType(t reflect.Type, queue *[]reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return tsType(t.Elem(), queue)
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		elem := tsType(t.Elem(), queue)
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Array:
+		elems := make([]string, t.Len())
+		for i := range elems {
+			elems[i] = tsType(t.Elem(), queue)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case reflect.Map:
+		return "Record<string, " + tsType(t.Elem(), queue) + ">"
+	case reflect.Struct:
+		*queue = append(*queue, t)
+		return t.Name()
+	}
+	return "unknown"
+}
+
+// typeDefinitions writes an interface for each of types and the structs
+// they refer to.
+func typeDefinitions(types []reflect.Type) string {
+	var b strings.Builder
+	queue := append([]reflect.Type{}, types...)
+	written := map[reflect.Type]bool{}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		if written[t] {
+			continue
+		}
+		written[t] = true
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+		for _, field := range jsonFields(t) {
+			typ := tsType(field.typ, &queue)
+			switch {
+			case field.optional:
+				fmt.Fprintf(&b, "  %s?: %s;\n", field.name, typ)
+			case field.typ.Kind() == reflect.Pointer:
+				fmt.Fprintf(&b, "  %s: %s | null;\n", field.name, typ)
+			default:
+				fmt.Fprintf(&b, "  %s: %s;\n", field.name, typ)
+			}
+		}
+		b.WriteString("}\n\n")
+	}
+	return b.String()
+}
+
+// jsonShape names the JSON type of values of t, e.g. "[]integer", and
+// returns the struct they hold, if any, for its fields to be compared.
+func jsonShape(t reflect.Type) (string, reflect.Type) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "date-time", nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonShape(t.Elem())
+	case reflect.Slice, reflect.Array:
+		shape, elem := jsonShape(t.Elem())
+		return "[]" + shape, elem
+	case reflect.Map:
+		shape, elem := jsonShape(t.Elem())
+		return "map[string]" + shape, elem
+	case reflect.Struct:
+		return "object", t
+	case reflect.Int, reflect.Int64:
+		return "integer", nil
+	case reflect.Float64:
+		return "number", nil
+	}
+	return t.Kind().String(), nil
+}
+
+// goClientMismatches lists the JSON fields in which server, a server type,
+// and mirror, pkg/client's copy of it, differ, and those of the structs
+// they refer to, naming each field by its path from name.
+func goClientMismatches(name string, server, mirror reflect.Type) []string {
+	mirrored := map[string]reflect.Type{}
+	for _, field := range jsonFields(mirror) {
+		mirrored[field.name] = field.typ
+	}
+	var mismatches []string
+	for _, field := range jsonFields(server) {
+		path := name + "." + field.name
+		typ, ok := mirrored[field.name]
+		if !ok {
+			mismatches = append(mismatches, path+" is missing from pkg/client")
+			continue
+		}
+		delete(mirrored, field.name)
+		serverShape, serverStruct := jsonShape(field.typ)
+		mirrorShape, mirrorStruct := jsonShape(typ)
+		if serverShape != mirrorShape {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, but %s in pkg/client", path, serverShape, mirrorShape))
+		} else if serverStruct != nil {
+			mismatches = append(mismatches, goClientMismatches(path, serverStruct, mirrorStruct)...)
+		}
+	}
+	for _, field := range jsonFields(mirror) {
+		if _, ok := mirrored[field.name]; ok {
+			mismatches = append(mismatches, name+"."+field.name+" is only in pkg/client")
+		}
+	}
+	return mismatches
+}
+
+// generateClient returns the client's files by name.
+func generateClient() map[string][]byte {
+	return map[string][]byte{
+		"mapthens.d.ts": []byte(clientHeader + typeDefinitions(clientTypes) + clientDeclarations),
+		"mapthens.js":   []byte(clientHeader + clientScript),
+	}
+}
+
+// clientCommand runs `mapthens-server client [-check] [dir]`.
+func clientCommand(args []string) error {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	check := flags.Bool("check", false, "fail if the files in dir are out of date instead of writing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dir := clientDir
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: client [-check] [dir]")
+	} else if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	files := generateClient()
+	var stale []string
+	for _, name := range []string{"mapthens.d.ts", "mapthens.js"} {
+		path := filepath.Join(dir, name)
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, files[name]) {
+			continue
+		}
+		if *check {
+			stale = append(stale, path)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, files[name], 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("out of date, run `go run . client`: %s", strings.Join(stale, ", "))
+	}
+	if *check {
+		if mismatches := goClientMismatches("Event", reflect.TypeOf(Event{}), reflect.TypeOf(goclient.Event{})); len(mismatches) > 0 {
+			return fmt.Errorf("pkg/client.Event doesn't match Event: %s", strings.Join(mismatches, "; "))
+		}
+	}
+	return nil
+}
+
+const clientHeader = `// Generated by ` + "`go run . client`" + ` from the server's Go types. Do not edit.
+
+`
+
+const clientDeclarations = `/** Query parameters every endpoint returning events takes. */
+export interface ResponseParams {
+  v?: 1 | 2;
+  fields?: string | string[];
+  expand?: string | string[];
+  tz?: string;
+}
+
+/** Query parameters of /api/events; see the README for each. */
+export interface EventsParams extends ResponseParams {
+  outdoor?: boolean;
+  venue_type?: string | string[];
+  size?: 'small' | 'medium' | 'large';
+  featured?: boolean;
+  family?: boolean;
+  from?: string;
+  as_of?: string;
+  boost_venues?: string | string[];
+  boost_categories?: string | string[];
+}
+
+export interface NearbyParams extends ResponseParams {
+  date?: string;
+  bbox?: string | number[];
+  from?: string;
+  radius?: number;
+  limit?: number;
+}
+
+export interface RandomParams extends ResponseParams {
+  n?: number;
+  seed?: string;
+  category?: string | string[];
+}
+
+/** Thrown for any response that isn't a 2xx, with the server's message. */
+export interface MapthensError extends Error {
+  status: number;
+}
+
+export class MapthensClient {
+  /** baseURL defaults to the page's own origin. */
+  constructor(baseURL?: string);
+  config(): Promise<ClientConfig>;
+  events(params: EventsParams & { dates: string }): Promise<BatchResponse>;
+  events(params?: EventsParams): Promise<APIResponse>;
+  event(id: string, params?: Pick<ResponseParams, 'expand' | 'tz'>): Promise<Event>;
+  related(id: string, params?: { limit?: number }): Promise<RelatedResponse>;
+  nearby(params?: NearbyParams): Promise<APIResponse>;
+  random(params?: RandomParams): Promise<APIResponse>;
+  query(query: EventQuery, params?: ResponseParams): Promise<APIResponse>;
+}
+`
+
+const clientScript = `(function (root) {
+  'use strict';
+
+  // queryString encodes params, joining arrays with commas and leaving out
+  // unset values.
+  function queryString(params) {
+    const search = new URLSearchParams();
+    Object.keys(params || {}).forEach((key) => {
+      const value = params[key];
+      if (value === undefined || value === null) return;
+      search.set(key, Array.isArray(value) ? value.join(',') : String(value));
+    });
+    const encoded = search.toString();
+    return encoded ? '?' + encoded : '';
+  }
+
+  class MapthensClient {
+    constructor(baseURL) {
+      this.baseURL = (baseURL || '').replace(/\/$/, '');
+    }
+
+    async request(path, params, init) {
+      const response = await fetch(this.baseURL + path + queryString(params), init);
+      if (!response.ok) {
+        const error = new Error(response.status + ' ' + (await response.text()).trim());
+        error.status = response.status;
+        throw error;
+      }
+      return response.json();
+    }
+
+    config() {
+      return this.request('/api/config');
+    }
+
+    events(params) {
+      return this.request('/api/events', params);
+    }
+
+    event(id, params) {
+      return this.request('/api/events/' + encodeURIComponent(id), params);
+    }
+
+    related(id, params) {
+      return this.request('/api/events/' + encodeURIComponent(id) + '/related', params);
+    }
+
+    nearby(params) {
+      return this.request('/api/events/nearby', params);
+    }
+
+    random(params) {
+      return this.request('/api/events/random', params);
+    }
+
+    query(query, params) {
+      return this.request('/api/events/query', params, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(query),
+      });
+    }
+  }
+
+  if (typeof module !== 'undefined' && module.exports) {
+    module.exports = { MapthensClient };
+  } else {
+    root.MapthensClient = MapthensClient;
+  }
+})(typeof self !== 'undefined' ? self : this);
+`
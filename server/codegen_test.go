@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	goclient "mapthens-server/pkg/client"
+)
+
+// The Go client's types are written by hand; they must keep describing the
+// JSON the server sends.
+func TestGoClientMatchesServer(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		server, mirror interface{}
+	}{
+		{"Event", Event{}, goclient.Event{}},
+		{"VenueDetail", VenueDetail{}, goclient.VenueDetail{}},
+		{"Tickets", Tickets{}, goclient.Tickets{}},
+		{"Weather", Weather{}, goclient.Weather{}},
+	} {
+		for _, mismatch := range goClientMismatches(test.name, reflect.TypeOf(test.server), reflect.TypeOf(test.mirror)) {
+			t.Error(mismatch)
+		}
+	}
+}
+
+// An event the server writes reads back through the Go client unchanged.
+func TestGoClientRoundTrip(t *testing.T) {
+	sent := marshaled(t, populatedEvent())
+	data, err := json.Marshal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received goclient.Event
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if got := marshaled(t, received); !reflect.DeepEqual(got, sent) {
+		t.Errorf("round trip through pkg/client changed the event:\nsent %v\ngot  %v", sent, got)
+	}
+}
+
+func TestGoClientMismatches(t *testing.T) {
+	type tier struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}
+	type server struct {
+		ID       string            `json:"id"`
+		Tiers    []tier            `json:"tiers,omitempty"`
+		Sources  map[string]string `json:"field_sources,omitempty"`
+		Internal string            `json:"-"`
+	}
+	type mirrorTier struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+	type mirror struct {
+		ID    string       `json:"id"`
+		Tiers []mirrorTier `json:"tiers,omitempty"`
+		Extra bool         `json:"extra"`
+	}
+	got := goClientMismatches("E", reflect.TypeOf(server{}), reflect.TypeOf(mirror{}))
+	want := []string{
+		"E.tiers.price is number, but integer in pkg/client",
+		"E.field_sources is missing from pkg/client",
+		"E.extra is only in pkg/client",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("goClientMismatches() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand handles one-shot maintenance subcommands, e.g.
+//
+//	mapthens-server convert events.json events.ndjson
+//	mapthens-server warm-gazetteer -verify
+//	mapthens-server compress-assets ../public
+//	mapthens-server signing-key
+//	mapthens-server loadtest -duration 30s
+//	mapthens-server import history.csv
+//	mapthens-server replay raw/2026-10-15/140512/flagpole.com/001.gz
+//	mapthens-server client ../public/client
+func runCommand(args []string) {
+	switch args[0] {
+	case "convert":
+		if len(args) != 3 {
+			log.Fatal("usage: convert <src> <dst>")
+		}
+		if err := convertEventsFile(args[1], args[2]); err != nil {
+			log.Fatalf("Failed to convert %s: %v", args[1], err)
+		}
+		fmt.Printf("Converted %s to %s\n", args[1], args[2])
+	case "warm-gazetteer":
+		if err := warmGazetteer(args[1:]); err != nil {
+			log.Fatalf("Failed to warm the gazetteer: %v", err)
+		}
+	case "compress-assets":
+		if len(args) != 2 {
+			log.Fatal("usage: compress-assets <dir>")
+		}
+		n, err := compressAssets(args[1])
+		if err != nil {
+			log.Fatalf("Failed to compress assets in %s: %v", args[1], err)
+		}
+		fmt.Printf("Compressed %d assets in %s\n", n, args[1])
+	case "signing-key":
+		if err := generateSigningKey(); err != nil {
+			log.Fatalf("Failed to generate a signing key: %v", err)
+		}
+	case "loadtest":
+		if err := loadTest(args[1:]); err != nil {
+			log.Fatalf("Load test failed: %v", err)
+		}
+	case "import":
+		if err := importCommand(args[1:]); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+	case "replay":
+		if err := replayCommand(args[1:]); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+	case "client":
+		if err := clientCommand(args[1:]); err != nil {
+			log.Fatalf("Failed to generate the client: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q", args[0])
+	}
+}
+
+// migrateDataFile converts an events file left in another storage format or
+// compression (e.g. after switching MAPTHENS_STORAGE_FORMAT) so the cache
+// isn't lost.
+func migrateDataFile() {
+	if _, err := os.Stat(dataFile); err == nil {
+		return
+	}
+
+	base := strings.TrimSuffix(dataFile, gzipSuffix)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	for _, format := range []string{formatJSON, formatNDJSON} {
+		for _, suffix := range []string{"", gzipSuffix} {
+			src := base + "." + format + suffix
+			if src == dataFile {
+				continue
+			}
+			info, err := os.Stat(src)
+			if err != nil {
+				continue
+			}
+
+			if err := convertEventsFile(src, dataFile); err != nil {
+				log.Printf("Warning: Failed to convert %s to %s: %v", src, dataFile, err)
+				return
+			}
+			// Keep the scrape time, which is read from the file's mtime
+			os.Chtimes(dataFile, info.ModTime(), info.ModTime())
+			log.Printf("Converted cached events from %s to %s.", filepath.Base(src), filepath.Base(dataFile))
+			return
+		}
+	}
+}
@@ -0,0 +1,853 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Data Structures
+
+type Config struct {
+	Port        string
+	PublicURL   string
+	CacheDir    string
+	MapboxToken string
+	StrictToken bool
+	MapStyle    string
+	MapCenter   coordinates
+	MapZoom     float64
+	// MapProxyURL replaces https://api.mapbox.com in the frontend's map
+	// requests; the token is kept off the wire when it's set
+	MapProxyURL   string
+	StorageFormat string
+	CompressCache bool
+	CacheTTL      time.Duration
+	// ScrapeTimeout is how long a scrape may run before the watchdog cancels
+	// it; 0 disables the watchdog
+	ScrapeTimeout time.Duration
+	RefreshMode   string
+	ListingSource string
+	GeocodingMode string
+	BatchGeocode  bool
+	// MonthlyGeocodeBudget caps geocodes per calendar month; 0 is unlimited
+	MonthlyGeocodeBudget int
+	// The in-memory geocode cache's entry cap and TTL; see geocache.go
+	GeocodeCacheSize int
+	GeocodeCacheTTL  time.Duration
+	Location         *time.Location
+	PicksURL         string
+	UGACalendarURL   string
+	SourcePriority   []string
+
+	Metrics        string
+	PushgatewayURL string
+
+	LinkCheckInterval time.Duration
+	LinkFallback      bool
+
+	// TicketCheckInterval is how often ticketing pages are read; see
+	// tickets.go
+	TicketCheckInterval time.Duration
+
+	DetailWorkers   int
+	DetailHostDelay time.Duration
+	DetailBudget    time.Duration
+
+	// How scraping requests leave the server; see fetch.go
+	FetchProxy  string
+	FetchDNS    string
+	FetchRoutes map[string]FetchRoute
+	MaxPageSize int64
+
+	// Flags holds the configured feature flags; see flags.go
+	Flags      map[string]bool
+	AdminToken string
+
+	// OpenID Connect tokens accepted for the admin API; see oidc.go
+	OIDCIssuer      string
+	OIDCAudience    string
+	OIDCGroups      []string
+	OIDCGroupsClaim string
+
+	// Submission moderation; see moderation.go
+	ModerationURL   string
+	ModerationToken string
+	ApproveScore    float64
+	RejectScore     float64
+
+	// SigningKey signs /api/events responses when set; see signing.go
+	SigningKey ed25519.PrivateKey
+
+	// Family tags events family_friendly; see family.go
+	Family *familyClassifier
+
+	GoogleClientID     string
+	GoogleClientSecret string
+	ConfigFile         string
+	VenuesFile         string
+	OverridesFile      string
+	DatabaseURL        string
+	// ParquetDir receives a Parquet export of each scrape; see export.go.
+	// ParquetLocation is where it's synced to; see catalog.go
+	ParquetDir      string
+	ParquetLocation string
+	// DescriptionLimit is the length descriptions are cut to in list
+	// responses; 0 leaves them whole. See truncate.go
+	DescriptionLimit int
+
+	// WebhookSecrets holds each venue's webhook secret by venue ID; see
+	// webhook.go
+	WebhookSecrets map[string]string
+
+	// How long daily snapshots and their monthly archives are kept; see
+	// retention.go
+	SnapshotDays  int
+	ArchiveMonths int
+	ArchiveFormat string
+
+	// WeatherURL is the National Weather Service API read for
+	// ?expand=weather; empty turns weather off. See weather.go
+	WeatherURL string
+
+	// DeploymentMode is "server" or "self-hosted"; see deployment.go
+	DeploymentMode string
+	// RefreshSchedule holds the times of day, in minutes after midnight,
+	// events are refreshed at; see schedule.go
+	RefreshSchedule []int
+	// SourceSchedules refreshes single sources on their own cron
+	// schedules, by origin
+	SourceSchedules map[string]cronSchedule
+
+	// SourceFailureLimit is how many runs in a row a source may fail before
+	// it's quarantined; 0 never quarantines. See quarantine.go
+	SourceFailureLimit int
+
+	// AlertWebhookURL receives SLO alerts; see slo.go
+	AlertWebhookURL string
+	// DigestWebhookURL receives each day's digest; see digest.go
+	DigestWebhookURL string
+
+	// RawPageDays is how many days of raw scraped pages are kept; 0 keeps
+	// none. See rawpages.go
+	RawPageDays int
+}
+
+// fileConfig is the layout of the optional JSON config file. Environment
+// variables take precedence over values set in the file.
+type fileConfig struct {
+	Port          string  `json:"port"`
+	PublicURL     string  `json:"public_url"`
+	CacheDir      string  `json:"cache_dir"`
+	StrictToken   bool    `json:"strict_token"`
+	MapStyle      string  `json:"map_style"`
+	MapCenter     string  `json:"map_center"`
+	MapZoom       float64 `json:"map_zoom"`
+	MapProxyURL   string  `json:"map_proxy_url"`
+	StorageFormat string  `json:"storage_format"`
+	CompressCache bool    `json:"compress_cache"`
+	CacheTTL      string  `json:"cache_ttl"`
+	ScrapeTimeout string  `json:"scrape_timeout"`
+	RefreshMode   string  `json:"refresh_mode"`
+	ListingSource string  `json:"listing_source"`
+	GeocodingMode string  `json:"geocoding_mode"`
+	BatchGeocode  bool    `json:"batch_geocoding"`
+	MonthlyBudget int     `json:"monthly_geocoding_budget"`
+	Timezone      string  `json:"timezone"`
+	PicksURL      *string `json:"picks_url"`
+	LinkCheck     string  `json:"link_check_interval"`
+	Metrics       string  `json:"metrics"`
+	Pushgateway   string  `json:"pushgateway_url"`
+	LinkFallback  bool    `json:"link_fallback"`
+	DetailWorkers int     `json:"detail_workers"`
+	DetailDelay   string  `json:"detail_host_delay"`
+	DetailBudget  string  `json:"detail_budget"`
+	VenuesFile    string  `json:"venues_file"`
+	OverridesFile string  `json:"overrides_file"`
+	DatabaseURL   string  `json:"database_url"`
+
+	// Feature flags by name; see flags.go
+	Flags map[string]bool `json:"flags"`
+
+	// Other calendars merged with flagpole's; see sources.go
+	UGACalendarURL string `json:"uga_calendar_url"`
+	SourcePriority string `json:"source_priority"`
+
+	// Scraping egress by host; see fetch.go
+	FetchProxy  string                `json:"fetch_proxy"`
+	FetchDNS    string                `json:"fetch_dns"`
+	FetchRoutes map[string]FetchRoute `json:"fetch_routes"`
+	MaxPageSize string                `json:"max_page_size"`
+
+	// Submission moderation; see moderation.go
+	ModerationURL string   `json:"moderation_url"`
+	ApproveScore  *float64 `json:"auto_approve_score"`
+	RejectScore   *float64 `json:"auto_reject_score"`
+
+	// Family-friendly classification rules; see family.go
+	Family *FamilyRules `json:"family"`
+
+	// How often ticketing pages are read; see tickets.go
+	TicketCheck string `json:"ticket_check_interval"`
+
+	// Analytics export; see export.go
+	ParquetDir      string `json:"parquet_dir"`
+	ParquetLocation string `json:"parquet_location"`
+
+	// Length of descriptions in list responses; see truncate.go. Unset
+	// means the default, and 0 turns truncation off
+	DescriptionLimit *int `json:"description_limit"`
+
+	// Admin sign-in through OpenID Connect; see oidc.go
+	OIDCIssuer      string `json:"oidc_issuer"`
+	OIDCAudience    string `json:"oidc_audience"`
+	OIDCGroups      string `json:"oidc_groups"`
+	OIDCGroupsClaim string `json:"oidc_groups_claim"`
+
+	// Secrets venues sign webhook pushes with; see webhook.go
+	WebhookSecrets map[string]string `json:"webhook_secrets"`
+
+	// Snapshot retention; see retention.go. Unset means the default
+	SnapshotDays  *int   `json:"snapshot_days"`
+	ArchiveMonths *int   `json:"archive_months"`
+	ArchiveFormat string `json:"archive_format"`
+
+	// Forecasts for ?expand=weather; see weather.go. An explicitly empty
+	// weather_url turns weather off
+	WeatherURL *string `json:"weather_url"`
+
+	// "server" or "self-hosted"; see deployment.go
+	DeploymentMode string `json:"deployment_mode"`
+	// Scheduled refreshes, e.g. "06:00,18:00"; see schedule.go
+	RefreshSchedule string `json:"refresh_schedule"`
+	// Cron expressions by source, e.g. {"uga": "*/30 * * * *"}
+	SourceSchedules map[string]string `json:"source_schedules"`
+
+	// The in-memory geocode cache; see geocache.go. Unset means the default
+	GeocodeCacheSize *int   `json:"geocode_cache_size"`
+	GeocodeCacheTTL  string `json:"geocode_cache_ttl"`
+
+	// Failures in a row before a source is quarantined; see quarantine.go
+	SourceFailureLimit *int `json:"source_failure_limit"`
+
+	AlertWebhookURL  string `json:"alert_webhook_url"`
+	DigestWebhookURL string `json:"digest_webhook_url"`
+
+	// Days of raw scraped pages to keep; see rawpages.go
+	RawPageDays *int `json:"raw_page_days"`
+}
+
+const (
+	defaultPicksURL  = "https://flagpole.com/events/calendar-picks/"
+	defaultMapStyle  = "mapbox://styles/mapbox/dark-v11"
+	defaultMapCenter = "33.9519,-83.3789"
+	defaultMapZoom   = 12
+)
+
+// Global Variables
+var (
+	config      Config
+	configMutex sync.RWMutex
+)
+
+// Helper Functions
+
+// loadConfig reads the server configuration from the optional config file
+// named by MAPTHENS_CONFIG and the environment.
+//
+//	PORT                          HTTP listen port (default 8080)
+//	MAPTHENS_PUBLIC_URL           public origin used for sitemap links, e.g.
+//	                              "https://mapthens.com" (default: the
+//	                              request's host)
+//	MAPTHENS_CACHE_DIR            where events and tracking data are stored
+//	                              (default $XDG_CACHE_HOME/mapthens, or the
+//	                              platform equivalent)
+//	MAPBOX_ACCESS_TOKEN           token used for geocoding and by the map
+//	                              frontend
+//	MAPTHENS_MAP_STYLE            style URL the frontend map uses (default
+//	                              Mapbox dark-v11)
+//	MAPTHENS_MAP_CENTER           initial map center as "lat,lng" (default
+//	                              downtown Athens)
+//	MAPTHENS_MAP_ZOOM             initial map zoom level (default 12)
+//	MAPTHENS_MAP_PROXY_URL        proxy the frontend sends Mapbox requests
+//	                              through instead of api.mapbox.com; the token
+//	                              is then not sent to browsers
+//	MAPTHENS_STRICT_TOKEN         exit at startup if the token is missing or
+//	                              invalid instead of running without geocoding
+//	MAPTHENS_STORAGE_FORMAT       "json" (default) or "ndjson"
+//	MAPTHENS_COMPRESS_CACHE       gzip the events file (events.json.gz)
+//	MAPTHENS_CACHE_TTL            how long scraped events are served before
+//	                              re-scraping, e.g. "90m" (default 6h)
+//	MAPTHENS_DEPLOYMENT_MODE      "server" (default) refreshes when requests
+//	                              find the events stale; "self-hosted" runs
+//	                              the whole pipeline on the refresh schedule
+//	                              with local storage only
+//	MAPTHENS_REFRESH_SCHEDULE     times of day events are also refreshed at,
+//	                              e.g. "06:00,18:00" (default none, or
+//	                              "06:00,18:00" when self-hosted)
+//	MAPTHENS_SOURCE_SCHEDULES     cron expressions single sources are also
+//	                              refreshed on, as "source=expression;...",
+//	                              e.g. "uga=*/30 * * * *" (default none)
+//	MAPTHENS_SOURCE_FAILURE_LIMIT runs in a row a source may fail before it's
+//	                              quarantined until an admin releases it
+//	                              (default 5, "0" disables)
+//	MAPTHENS_DESCRIPTION_LIMIT    characters descriptions are cut to in list
+//	                              responses (default 280, "0" disables)
+//	MAPTHENS_SCRAPE_TIMEOUT       how long a scrape may run before it's
+//	                              cancelled and retried (default 10m, "0"
+//	                              disables)
+//	MAPTHENS_REFRESH_MODE         "full" (default) replaces the events on
+//	                              every re-scrape; "incremental" merges
+//	                              re-scrapes on the same day into them,
+//	                              geocoding only new events
+//	MAPTHENS_LISTING_SOURCE       "api" (default) reads flagpole's events API,
+//	                              falling back to the HTML list; "html" only
+//	                              scrapes the list; "shadow" serves the list
+//	                              and compares the API's events with it
+//	MAPBOX_GEOCODING_MODE         "permanent" (default) or "temporary";
+//	                              results may only be stored when geocoded
+//	                              permanently
+//	MAPBOX_BATCH_GEOCODING        geocode up to 1000 addresses per request
+//	                              with the batch API, falling back to single
+//	                              requests
+//	MAPBOX_MONTHLY_BUDGET         stop geocoding and walking times once this
+//	                              many geocodes and matrix elements were
+//	                              used this month (default unlimited)
+//	MAPTHENS_GEOCODE_CACHE_SIZE   geocoded addresses kept in memory (default
+//	                              10000, "0" disables)
+//	MAPTHENS_GEOCODE_CACHE_TTL    how long a geocoded address is kept in
+//	                              memory (default 720h)
+//	MAPTHENS_TIMEZONE             IANA timezone that decides which day is
+//	                              "today" (default America/New_York)
+//	MAPTHENS_PICKS_URL            flagpole Calendar Picks column or archive
+//	                              page used to mark featured events; empty
+//	                              disables
+//	MAPTHENS_UGA_CALENDAR_URL     UGA Localist events API merged with
+//	                              flagpole's listings, e.g.
+//	                              "https://calendar.uga.edu/api/2/events";
+//	                              empty (default) disables
+//	MAPTHENS_SOURCE_PRIORITY      which source wins when listings of the same
+//	                              event disagree, as a comma-separated list
+//	                              (default "flagpole,uga,venue")
+//	MAPTHENS_LINK_CHECK_INTERVAL  how often event links are checked for 404s
+//	                              (default 6h, "0" disables)
+//	MAPTHENS_LINK_FALLBACK        link broken events to their venue's homepage
+//	MAPTHENS_TICKETS_INTERVAL     how often ticketing pages are read for prices
+//	                              and sell-outs (default 2h, "0" disables)
+//	MAPTHENS_METRICS              "emf" to print scrape metrics in CloudWatch
+//	                              Embedded Metric Format, or "prometheus" to
+//	                              push them to a Pushgateway
+//	MAPTHENS_PUSHGATEWAY_URL      base URL of the Prometheus Pushgateway
+//	MAPTHENS_ALERT_WEBHOOK_URL    URL SLO breaches, source quarantines, and
+//	                              their recoveries are POSTed to, e.g. a
+//	                              Slack incoming webhook
+//	MAPTHENS_DIGEST_WEBHOOK_URL   URL each day's digest of events is POSTed
+//	                              to after its first scheduled refresh
+//	MAPTHENS_DETAIL_WORKERS       event detail pages fetched in parallel
+//	                              (default 4)
+//	MAPTHENS_DETAIL_HOST_DELAY    minimum gap between detail requests to the
+//	                              same host (default 500ms)
+//	MAPTHENS_DETAIL_BUDGET        time allowed for fetching detail pages per
+//	                              scrape; the rest are skipped (default 30s)
+//	MAPTHENS_FETCH_PROXY          proxy for scraping requests, as an http://,
+//	                              https://, or socks5:// URL, or "direct"
+//	                              (default: HTTP_PROXY, HTTPS_PROXY, and
+//	                              NO_PROXY)
+//	MAPTHENS_FETCH_DNS            DNS server scraped hosts are resolved with,
+//	                              e.g. "10.0.0.2:53" (default: the system
+//	                              resolver)
+//	MAPTHENS_RAW_PAGE_DAYS        days of raw scraped pages kept for replay
+//	                              (default 0, none)
+//	MAPTHENS_MAX_PAGE_SIZE        largest scraped page, after decompression,
+//	                              in bytes or with a KB or MB suffix
+//	                              (default 10MB)
+//	MAPTHENS_FLAG_<NAME>          turns a feature flag on or off, e.g.
+//	                              MAPTHENS_FLAG_DETAIL_ENRICHMENT=false
+//	MAPTHENS_ADMIN_TOKEN          bearer token for the admin API; disabled
+//	                              without it or an OIDC issuer
+//	MAPTHENS_SNAPSHOT_DAYS        days each scrape's daily snapshot is kept
+//	                              before it's compacted into its month's
+//	                              archive (default 30, "0" keeps no
+//	                              snapshots)
+//	MAPTHENS_ARCHIVE_MONTHS       months monthly archives and Parquet export
+//	                              partitions are kept (default 24, "0"
+//	                              keeps them forever)
+//	MAPTHENS_ARCHIVE_FORMAT       "ndjson" (default) for gzipped NDJSON
+//	                              archives, or "parquet"
+//	MAPTHENS_WEATHER_URL          National Weather Service API used for
+//	                              ?expand=weather (default
+//	                              "https://api.weather.gov"); empty disables
+//	MAPTHENS_OIDC_ISSUER          OpenID Connect issuer whose tokens are also
+//	                              accepted for the admin API, e.g.
+//	                              "https://accounts.google.com"
+//	MAPTHENS_OIDC_AUDIENCE        audience those tokens must be issued for
+//	                              (required with an issuer)
+//	MAPTHENS_OIDC_GROUPS          comma-separated groups allowed in; any
+//	                              valid token when empty
+//	MAPTHENS_OIDC_GROUPS_CLAIM    claim listing the token's groups (default
+//	                              "groups")
+//	MAPTHENS_MODERATION_URL       external moderation service scoring
+//	                              submissions (POST {"text": ...}, answering
+//	                              {"score": 0-1}); local checks only without
+//	                              it
+//	MAPTHENS_MODERATION_TOKEN     bearer token sent to the moderation service
+//	MAPTHENS_AUTO_APPROVE_SCORE   submissions scoring at or below this are
+//	                              published without review (default 0.2)
+//	MAPTHENS_AUTO_REJECT_SCORE    submissions scoring at or above this are
+//	                              rejected without review (default 0.8)
+//	MAPTHENS_WEBHOOK_SECRETS      venues allowed to push events, with the
+//	                              secrets they sign pushes with, as
+//	                              "venue-id=secret,..."; pushes are refused
+//	                              without it
+//	MAPTHENS_SIGNING_KEY          base64 Ed25519 key (32-byte seed or 64-byte
+//	                              private key) /api/events responses are
+//	                              signed with; unsigned without it
+//	GOOGLE_CLIENT_ID              OAuth client for the Google Calendar export;
+//	GOOGLE_CLIENT_SECRET          the integration is disabled without it
+//	MAPTHENS_VENUES_FILE          venue gazetteer replacing the built-in table
+//	MAPTHENS_OVERRIDES_FILE       coordinate overrides for events and venues
+//	MAPTHENS_DATABASE_URL         Postgres (with PostGIS) connection string;
+//	                              when set, each day's events are archived
+//	                              there and spatial queries run in the
+//	                              database
+//	MAPTHENS_PARQUET_DIR          directory each scrape is exported to as
+//	                              Parquet, partitioned by day and category
+//	MAPTHENS_PARQUET_LOCATION     URL the Parquet directory is synced to, for
+//	                              its Athena catalog, e.g. "s3://bucket/path"
+func loadConfig() (Config, error) {
+	var file fileConfig
+	path := os.Getenv("MAPTHENS_CONFIG")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+
+	cfg := Config{
+		Port:        envOr("PORT", file.Port),
+		PublicURL:   envOr("MAPTHENS_PUBLIC_URL", file.PublicURL),
+		CacheDir:    envOr("MAPTHENS_CACHE_DIR", file.CacheDir),
+		MapboxToken: os.Getenv("MAPBOX_ACCESS_TOKEN"),
+
+		AdminToken:         os.Getenv("MAPTHENS_ADMIN_TOKEN"),
+		GoogleClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+		GoogleClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		StrictToken:        envBool("MAPTHENS_STRICT_TOKEN", file.StrictToken),
+		StorageFormat:      strings.ToLower(envOr("MAPTHENS_STORAGE_FORMAT", file.StorageFormat)),
+		CompressCache:      envBool("MAPTHENS_COMPRESS_CACHE", file.CompressCache),
+		GeocodingMode:      strings.ToLower(envOr("MAPBOX_GEOCODING_MODE", file.GeocodingMode)),
+		BatchGeocode:       envBool("MAPBOX_BATCH_GEOCODING", file.BatchGeocode),
+		ConfigFile:         path,
+		VenuesFile:         envOr("MAPTHENS_VENUES_FILE", file.VenuesFile),
+		OverridesFile:      envOr("MAPTHENS_OVERRIDES_FILE", file.OverridesFile),
+		DatabaseURL:        envOr("MAPTHENS_DATABASE_URL", file.DatabaseURL),
+		ParquetDir:         envOr("MAPTHENS_PARQUET_DIR", file.ParquetDir),
+		ParquetLocation:    envOr("MAPTHENS_PARQUET_LOCATION", file.ParquetLocation),
+	}
+
+	if cfg.Port == "" {
+		cfg.Port = "8080"
+	}
+
+	if cfg.StorageFormat == "" {
+		cfg.StorageFormat = formatJSON
+	}
+	if !validStorageFormat(cfg.StorageFormat) {
+		return Config{}, fmt.Errorf("invalid storage format %q: must be %q or %q", cfg.StorageFormat, formatJSON, formatNDJSON)
+	}
+
+	if cfg.GeocodingMode == "" {
+		cfg.GeocodingMode = geocodingPermanent
+	}
+	if cfg.GeocodingMode != geocodingPermanent && cfg.GeocodingMode != geocodingTemporary {
+		return Config{}, fmt.Errorf("invalid geocoding mode %q: must be %q or %q", cfg.GeocodingMode, geocodingPermanent, geocodingTemporary)
+	}
+
+	cfg.MonthlyGeocodeBudget = file.MonthlyBudget
+	if value := os.Getenv("MAPBOX_MONTHLY_BUDGET"); value != "" {
+		budget, err := strconv.Atoi(value)
+		if err != nil || budget < 0 {
+			return Config{}, fmt.Errorf("invalid monthly geocoding budget %q", value)
+		}
+		cfg.MonthlyGeocodeBudget = budget
+	}
+
+	ttl, err := parseDuration(envOr("MAPTHENS_CACHE_TTL", file.CacheTTL), 6*time.Hour)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid cache TTL: %v", err)
+	}
+	cfg.CacheTTL = ttl
+	if cfg.GeocodeCacheTTL, err = parseDuration(envOr("MAPTHENS_GEOCODE_CACHE_TTL", file.GeocodeCacheTTL), defaultGeocodeCacheTTL); err != nil {
+		return Config{}, fmt.Errorf("invalid geocode cache TTL: %v", err)
+	}
+
+	cfg.DescriptionLimit = 280
+	if file.DescriptionLimit != nil {
+		cfg.DescriptionLimit = *file.DescriptionLimit
+	}
+	if value := os.Getenv("MAPTHENS_DESCRIPTION_LIMIT"); value != "" {
+		if cfg.DescriptionLimit, err = strconv.Atoi(value); err != nil {
+			return Config{}, fmt.Errorf("invalid description limit %q: %v", value, err)
+		}
+	}
+	if cfg.DescriptionLimit < 0 {
+		return Config{}, fmt.Errorf("invalid description limit %d: must not be negative", cfg.DescriptionLimit)
+	}
+	for _, setting := range []struct {
+		env      string
+		file     *int
+		fallback int
+		target   *int
+	}{
+		{"MAPTHENS_SNAPSHOT_DAYS", file.SnapshotDays, defaultSnapshotDays, &cfg.SnapshotDays},
+		{"MAPTHENS_ARCHIVE_MONTHS", file.ArchiveMonths, defaultArchiveMonths, &cfg.ArchiveMonths},
+		{"MAPTHENS_GEOCODE_CACHE_SIZE", file.GeocodeCacheSize, defaultGeocodeCacheSize, &cfg.GeocodeCacheSize},
+		{"MAPTHENS_SOURCE_FAILURE_LIMIT", file.SourceFailureLimit, defaultSourceFailureLimit, &cfg.SourceFailureLimit},
+		{"MAPTHENS_RAW_PAGE_DAYS", file.RawPageDays, 0, &cfg.RawPageDays},
+	} {
+		*setting.target = setting.fallback
+		if setting.file != nil {
+			*setting.target = *setting.file
+		}
+		if value := os.Getenv(setting.env); value != "" {
+			if *setting.target, err = strconv.Atoi(value); err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: %v", setting.env, value, err)
+			}
+		}
+		if *setting.target < 0 {
+			return Config{}, fmt.Errorf("invalid %s %d: must not be negative", setting.env, *setting.target)
+		}
+	}
+	cfg.ArchiveFormat = envOr("MAPTHENS_ARCHIVE_FORMAT", file.ArchiveFormat)
+	if cfg.ArchiveFormat == "" {
+		cfg.ArchiveFormat = archiveNDJSON
+	}
+	if cfg.ArchiveFormat != archiveNDJSON && cfg.ArchiveFormat != archiveParquet {
+		return Config{}, fmt.Errorf("invalid archive format %q: must be %q or %q", cfg.ArchiveFormat, archiveNDJSON, archiveParquet)
+	}
+	scrapeTimeout := envOr("MAPTHENS_SCRAPE_TIMEOUT", file.ScrapeTimeout)
+	if scrapeTimeout != "0" {
+		if cfg.ScrapeTimeout, err = parseDuration(scrapeTimeout, 10*time.Minute); err != nil {
+			return Config{}, fmt.Errorf("invalid scrape timeout: %v", err)
+		}
+	}
+
+	if cfg.RefreshSchedule, err = parseRefreshSchedule(envOr("MAPTHENS_REFRESH_SCHEDULE", file.RefreshSchedule)); err != nil {
+		return Config{}, fmt.Errorf("invalid refresh schedule: %v", err)
+	}
+	sourceSchedules := file.SourceSchedules
+	if value := os.Getenv("MAPTHENS_SOURCE_SCHEDULES"); value != "" {
+		if sourceSchedules, err = parseSourceSchedules(value); err != nil {
+			return Config{}, fmt.Errorf("invalid source schedules: %v", err)
+		}
+	}
+	if cfg.SourceSchedules, err = validateSourceSchedules(sourceSchedules); err != nil {
+		return Config{}, fmt.Errorf("invalid source schedules: %v", err)
+	}
+	cfg.RefreshMode = strings.ToLower(envOr("MAPTHENS_REFRESH_MODE", file.RefreshMode))
+	if cfg.RefreshMode == "" {
+		cfg.RefreshMode = refreshFull
+	}
+	if cfg.RefreshMode != refreshFull && cfg.RefreshMode != refreshIncremental {
+		return Config{}, fmt.Errorf("invalid refresh mode %q: must be %q or %q", cfg.RefreshMode, refreshFull, refreshIncremental)
+	}
+	cfg.DeploymentMode = strings.ToLower(envOr("MAPTHENS_DEPLOYMENT_MODE", file.DeploymentMode))
+	if cfg.DeploymentMode == "" {
+		cfg.DeploymentMode = deploymentServer
+	}
+	switch {
+	case cfg.DeploymentMode != deploymentServer && cfg.DeploymentMode != deploymentSelfHosted:
+		return Config{}, fmt.Errorf("invalid deployment mode %q: must be %q or %q", cfg.DeploymentMode, deploymentServer, deploymentSelfHosted)
+	case cfg.DeploymentMode != deploymentSelfHosted:
+	case cfg.DatabaseURL != "":
+		return Config{}, fmt.Errorf("deployment mode %q stores events locally and can't use a database URL", deploymentSelfHosted)
+	case cfg.SnapshotDays == 0:
+		return Config{}, fmt.Errorf("deployment mode %q archives daily snapshots and needs snapshot days", deploymentSelfHosted)
+	case len(cfg.RefreshSchedule) == 0 && len(cfg.SourceSchedules) == 0:
+		cfg.RefreshSchedule, _ = parseRefreshSchedule(defaultSelfHostedSchedule)
+	}
+
+	cfg.ListingSource = strings.ToLower(envOr("MAPTHENS_LISTING_SOURCE", file.ListingSource))
+	if cfg.ListingSource == "" {
+		cfg.ListingSource = listingAPI
+	}
+	if cfg.ListingSource != listingAPI && cfg.ListingSource != listingHTML && cfg.ListingSource != listingShadow {
+		return Config{}, fmt.Errorf("invalid listing source %q: must be %q, %q, or %q", cfg.ListingSource, listingAPI, listingHTML, listingShadow)
+	}
+
+	cfg.MapStyle = envOr("MAPTHENS_MAP_STYLE", file.MapStyle)
+	if cfg.MapStyle == "" {
+		cfg.MapStyle = defaultMapStyle
+	}
+	center := envOr("MAPTHENS_MAP_CENTER", file.MapCenter)
+	if center == "" {
+		center = defaultMapCenter
+	}
+	if cfg.MapCenter, err = parseOrigin(center); err != nil {
+		return Config{}, fmt.Errorf("invalid map center %q: %v", center, err)
+	}
+	cfg.MapZoom = file.MapZoom
+	if value := os.Getenv("MAPTHENS_MAP_ZOOM"); value != "" {
+		if cfg.MapZoom, err = strconv.ParseFloat(value, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid map zoom %q: %v", value, err)
+		}
+	}
+	if cfg.MapZoom == 0 {
+		cfg.MapZoom = defaultMapZoom
+	}
+	if cfg.MapZoom < 0 || cfg.MapZoom > 22 {
+		return Config{}, fmt.Errorf("invalid map zoom %v: must be between 0 and 22", cfg.MapZoom)
+	}
+	cfg.MapProxyURL = strings.TrimSuffix(envOr("MAPTHENS_MAP_PROXY_URL", file.MapProxyURL), "/")
+
+	linkCheck := envOr("MAPTHENS_LINK_CHECK_INTERVAL", file.LinkCheck)
+	if linkCheck != "0" {
+		if cfg.LinkCheckInterval, err = parseDuration(linkCheck, 6*time.Hour); err != nil {
+			return Config{}, fmt.Errorf("invalid link check interval: %v", err)
+		}
+	}
+	ticketCheck := envOr("MAPTHENS_TICKETS_INTERVAL", file.TicketCheck)
+	if ticketCheck != "0" {
+		if cfg.TicketCheckInterval, err = parseDuration(ticketCheck, 2*time.Hour); err != nil {
+			return Config{}, fmt.Errorf("invalid ticket check interval: %v", err)
+		}
+	}
+	cfg.Metrics = strings.ToLower(envOr("MAPTHENS_METRICS", file.Metrics))
+	cfg.PushgatewayURL = envOr("MAPTHENS_PUSHGATEWAY_URL", file.Pushgateway)
+	cfg.AlertWebhookURL = envOr("MAPTHENS_ALERT_WEBHOOK_URL", file.AlertWebhookURL)
+	if cfg.AlertWebhookURL != "" && !strings.HasPrefix(cfg.AlertWebhookURL, "https://") && !strings.HasPrefix(cfg.AlertWebhookURL, "http://") {
+		return Config{}, fmt.Errorf("invalid MAPTHENS_ALERT_WEBHOOK_URL %q: must be an http(s) URL", cfg.AlertWebhookURL)
+	}
+	cfg.DigestWebhookURL = envOr("MAPTHENS_DIGEST_WEBHOOK_URL", file.DigestWebhookURL)
+	if cfg.DigestWebhookURL != "" && !strings.HasPrefix(cfg.DigestWebhookURL, "https://") && !strings.HasPrefix(cfg.DigestWebhookURL, "http://") {
+		return Config{}, fmt.Errorf("invalid MAPTHENS_DIGEST_WEBHOOK_URL %q: must be an http(s) URL", cfg.DigestWebhookURL)
+	}
+	switch {
+	case cfg.Metrics != "" && cfg.Metrics != metricsEMF && cfg.Metrics != metricsPrometheus:
+		return Config{}, fmt.Errorf("invalid metrics mode %q: must be %q or %q", cfg.Metrics, metricsEMF, metricsPrometheus)
+	case cfg.Metrics == metricsPrometheus && cfg.PushgatewayURL == "":
+		return Config{}, fmt.Errorf("metrics mode %q needs a Pushgateway URL", metricsPrometheus)
+	}
+
+	cfg.LinkFallback = envBool("MAPTHENS_LINK_FALLBACK", file.LinkFallback)
+
+	cfg.OIDCIssuer = envOr("MAPTHENS_OIDC_ISSUER", file.OIDCIssuer)
+	cfg.OIDCAudience = envOr("MAPTHENS_OIDC_AUDIENCE", file.OIDCAudience)
+	cfg.OIDCGroups = parseGroups(envOr("MAPTHENS_OIDC_GROUPS", file.OIDCGroups))
+	cfg.OIDCGroupsClaim = envOr("MAPTHENS_OIDC_GROUPS_CLAIM", file.OIDCGroupsClaim)
+	if cfg.OIDCGroupsClaim == "" {
+		cfg.OIDCGroupsClaim = oidcDefaultClaims
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCAudience == "" {
+		return Config{}, fmt.Errorf("OIDC issuer %q needs an audience", cfg.OIDCIssuer)
+	}
+
+	cfg.DetailWorkers = file.DetailWorkers
+	if value := os.Getenv("MAPTHENS_DETAIL_WORKERS"); value != "" {
+		if cfg.DetailWorkers, err = strconv.Atoi(value); err != nil {
+			return Config{}, fmt.Errorf("invalid detail workers %q: %v", value, err)
+		}
+	}
+	if cfg.DetailWorkers == 0 {
+		cfg.DetailWorkers = 4
+	}
+	if cfg.DetailWorkers < 0 {
+		return Config{}, fmt.Errorf("invalid detail workers %d: must be positive", cfg.DetailWorkers)
+	}
+	if cfg.DetailHostDelay, err = parseDuration(envOr("MAPTHENS_DETAIL_HOST_DELAY", file.DetailDelay), 500*time.Millisecond); err != nil {
+		return Config{}, fmt.Errorf("invalid detail host delay: %v", err)
+	}
+	if cfg.DetailBudget, err = parseDuration(envOr("MAPTHENS_DETAIL_BUDGET", file.DetailBudget), 30*time.Second); err != nil {
+		return Config{}, fmt.Errorf("invalid detail budget: %v", err)
+	}
+
+	cfg.FetchProxy = envOr("MAPTHENS_FETCH_PROXY", file.FetchProxy)
+	if err := validateFetchProxy(cfg.FetchProxy); err != nil {
+		return Config{}, fmt.Errorf("invalid fetch proxy: %v", err)
+	}
+	cfg.FetchDNS = envOr("MAPTHENS_FETCH_DNS", file.FetchDNS)
+	if cfg.FetchDNS != "" {
+		if _, _, err := net.SplitHostPort(cfg.FetchDNS); err != nil {
+			return Config{}, fmt.Errorf("invalid fetch DNS server %q: must be host:port", cfg.FetchDNS)
+		}
+	}
+	if cfg.MaxPageSize, err = parseSize(envOr("MAPTHENS_MAX_PAGE_SIZE", file.MaxPageSize), defaultMaxPageSize); err != nil {
+		return Config{}, fmt.Errorf("invalid max page size: %v", err)
+	}
+	cfg.FetchRoutes = file.FetchRoutes
+	for host, route := range cfg.FetchRoutes {
+		if err := validateFetchProxy(route.Proxy); err != nil {
+			return Config{}, fmt.Errorf("invalid fetch route for %s: %v", host, err)
+		}
+		if route.IP != "" && net.ParseIP(route.IP) == nil {
+			return Config{}, fmt.Errorf("invalid fetch route for %s: %q is not an IP address", host, route.IP)
+		}
+	}
+
+	if cfg.Flags, err = loadFlagConfig(file.Flags); err != nil {
+		return Config{}, err
+	}
+
+	cfg.ModerationURL = envOr("MAPTHENS_MODERATION_URL", file.ModerationURL)
+	cfg.ModerationToken = os.Getenv("MAPTHENS_MODERATION_TOKEN")
+	// Zero is a meaningful threshold, so unset is told apart from 0
+	for _, threshold := range []struct {
+		env      string
+		file     *float64
+		fallback float64
+		target   *float64
+	}{
+		{"MAPTHENS_AUTO_APPROVE_SCORE", file.ApproveScore, defaultApproveScore, &cfg.ApproveScore},
+		{"MAPTHENS_AUTO_REJECT_SCORE", file.RejectScore, defaultRejectScore, &cfg.RejectScore},
+	} {
+		*threshold.target = threshold.fallback
+		if threshold.file != nil {
+			*threshold.target = *threshold.file
+		}
+		if value := os.Getenv(threshold.env); value != "" {
+			if *threshold.target, err = strconv.ParseFloat(value, 64); err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: %v", threshold.env, value, err)
+			}
+		}
+	}
+	if cfg.ApproveScore < 0 || cfg.ApproveScore > cfg.RejectScore || cfg.RejectScore > 1 {
+		return Config{}, fmt.Errorf("invalid moderation thresholds %v and %v: need 0 <= approve <= reject <= 1", cfg.ApproveScore, cfg.RejectScore)
+	}
+
+	cfg.WebhookSecrets = file.WebhookSecrets
+	if value := os.Getenv("MAPTHENS_WEBHOOK_SECRETS"); value != "" {
+		if cfg.WebhookSecrets, err = parseWebhookSecrets(value); err != nil {
+			return Config{}, fmt.Errorf("invalid webhook secrets: %v", err)
+		}
+	}
+	if err := validateWebhookSecrets(cfg.WebhookSecrets); err != nil {
+		return Config{}, fmt.Errorf("invalid webhook secrets: %v", err)
+	}
+
+	if cfg.Family, err = compileFamilyRules(file.Family); err != nil {
+		return Config{}, fmt.Errorf("invalid family rules: %v", err)
+	}
+
+	if value := os.Getenv("MAPTHENS_SIGNING_KEY"); value != "" {
+		if cfg.SigningKey, err = parseSigningKey(value); err != nil {
+			return Config{}, fmt.Errorf("invalid MAPTHENS_SIGNING_KEY: %v", err)
+		}
+	}
+
+	// An explicitly empty picks_url in the file disables featured events
+	cfg.PicksURL = defaultPicksURL
+	if file.PicksURL != nil {
+		cfg.PicksURL = *file.PicksURL
+	}
+	if value, ok := os.LookupEnv("MAPTHENS_PICKS_URL"); ok {
+		cfg.PicksURL = value
+	}
+
+	cfg.WeatherURL = defaultWeatherURL
+	if file.WeatherURL != nil {
+		cfg.WeatherURL = *file.WeatherURL
+	}
+	if value, ok := os.LookupEnv("MAPTHENS_WEATHER_URL"); ok {
+		cfg.WeatherURL = value
+	}
+	cfg.WeatherURL = strings.TrimSuffix(cfg.WeatherURL, "/")
+
+	cfg.UGACalendarURL = envOr("MAPTHENS_UGA_CALENDAR_URL", file.UGACalendarURL)
+	if cfg.SourcePriority, err = parseSourcePriority(envOr("MAPTHENS_SOURCE_PRIORITY", file.SourcePriority)); err != nil {
+		return Config{}, fmt.Errorf("invalid source priority: %v", err)
+	}
+
+	timezone := envOr("MAPTHENS_TIMEZONE", file.Timezone)
+	if timezone == "" {
+		timezone = "America/New_York"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid timezone: %v", err)
+	}
+	cfg.Location = loc
+
+	if cfg.CacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cfg.CacheDir = filepath.Join(dir, "mapthens")
+		} else {
+			log.Printf("Warning: No user cache directory, using working directory: %v", err)
+			cfg.CacheDir = "."
+		}
+	}
+
+	return cfg, nil
+}
+
+func getConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+func setConfig(cfg Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config = cfg
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envBool(name string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func parseDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q must be a positive duration like \"30m\"", value)
+	}
+	return d, nil
+}
+
+// parseSize parses a positive byte count like "1048576", "512KB", or "10MB".
+func parseSize(value string, fallback int64) (int64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	number, unit := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for suffix, size := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(number, suffix); ok {
+			number, unit = strings.TrimSpace(trimmed), size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q must be a positive size like \"10MB\"", value)
+	}
+	return n * unit, nil
+}
+
+func cachePath(name string) string {
+	return filepath.Join(getConfig().CacheDir, name)
+}
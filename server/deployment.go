@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"mapthens-server/pkg/archive"
+)
+
+// MAPTHENS_DEPLOYMENT_MODE picks how the pipeline is driven:
+//
+//   - "server", the default, refreshes whenever a request finds the events
+//     older than MAPTHENS_CACHE_TTL, and on any MAPTHENS_REFRESH_SCHEDULE
+//   - "self-hosted" runs scrape, geocode, store, archive, and digest as a
+//     single process with nothing outside its cache directory. Refreshes
+//     only run on the schedule, which defaults to 06:00 and 18:00, so
+//     visitors are always served the last scheduled refresh. Events are
+//     stored on local disk, so MAPTHENS_DATABASE_URL is refused, and daily
+//     snapshots can't be turned off.
+//
+// Every daily snapshot also moves snapshots/latest.json to point at it (see
+// pkg/archive). A self-hosted server whose events file is missing, e.g. a
+// cache directory restored from a backup of its snapshots, starts from the
+// latest snapshot instead of scraping on its first request.
+
+const (
+	deploymentServer     = "server"
+	deploymentSelfHosted = "self-hosted"
+
+	defaultSelfHostedSchedule = "06:00,18:00"
+)
+
+// Helper Functions
+
+// loadLatestSnapshot returns the events of the snapshot latest.json points
+// at, minus those that have ended, with the time the snapshot was taken.
+func loadLatestSnapshot() ([]Event, time.Time, error) {
+	latest, err := archive.ReadLatest(snapshotDir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	events, err := readEventsFile(filepath.Join(snapshotDir, latest.File))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	current := dropPastEvents(events, today())
+	log.Printf("Loaded %d events from the %s snapshot.", len(current), latest.Date)
+	sortEvents(current)
+	return current, latest.UpdatedAt, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mapthens-server/pkg/archive"
+)
+
+func TestDeploymentModeConfig(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		schedule []int
+		wantErr  string
+	}{
+		{name: "server", env: map[string]string{}},
+		{name: "self-hosted", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "self-hosted"}, schedule: []int{6 * 60, 18 * 60}},
+		{name: "self-hosted schedule", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "Self-Hosted", "MAPTHENS_REFRESH_SCHEDULE": "07:30"}, schedule: []int{7*60 + 30}},
+		// A source schedule alone doesn't get the default full refreshes
+		{name: "self-hosted source schedule", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "self-hosted", "MAPTHENS_SOURCE_SCHEDULES": "flagpole=0 6 * * *"}},
+
+		{name: "unknown", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "lambda"}, wantErr: "invalid deployment mode"},
+		{name: "database", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "self-hosted", "MAPTHENS_DATABASE_URL": "postgres://localhost/mapthens"}, wantErr: "database URL"},
+		{name: "no snapshots", env: map[string]string{"MAPTHENS_DEPLOYMENT_MODE": "self-hosted", "MAPTHENS_SNAPSHOT_DAYS": "0"}, wantErr: "snapshot days"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"MAPTHENS_CONFIG", "MAPTHENS_DEPLOYMENT_MODE", "MAPTHENS_REFRESH_SCHEDULE", "MAPTHENS_SOURCE_SCHEDULES", "MAPTHENS_DATABASE_URL", "MAPTHENS_SNAPSHOT_DAYS"} {
+				t.Setenv(name, test.env[name])
+			}
+			cfg, err := loadConfig()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.RefreshSchedule) != len(test.schedule) || (len(test.schedule) > 0 && cfg.RefreshSchedule[0] != test.schedule[0]) {
+				t.Errorf("RefreshSchedule = %v, want %v", cfg.RefreshSchedule, test.schedule)
+			}
+		})
+	}
+}
+
+func TestLoadLatestSnapshot(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	cfg := getConfig()
+	cfg.DeploymentMode = deploymentSelfHosted
+	setConfig(cfg)
+	previousDir, previousFile := snapshotDir, dataFile
+	t.Cleanup(func() { snapshotDir, dataFile = previousDir, previousFile })
+	snapshotDir = t.TempDir()
+	dataFile = filepath.Join(snapshotDir, "events.json")
+
+	if _, _, err := loadEventsFromFile(); err == nil {
+		t.Fatal("loaded events without an events file or a snapshot")
+	}
+
+	events := []Event{
+		{ID: "over", Date: "2026-10-14", StartDate: "2026-10-14", EndDate: "2026-10-14", Title: "Yesterday"},
+		{ID: "on", Date: "2026-10-15", StartDate: "2026-10-15", EndDate: "2026-10-15", Title: "Tonight"},
+	}
+	if err := saveDailySnapshot("2026-10-14", events); err != nil {
+		t.Fatal(err)
+	}
+	latest := archive.Latest{Date: "2026-10-14", File: archive.SnapshotName("2026-10-14"), Events: 2, UpdatedAt: at.Add(-20 * time.Hour)}
+	if err := archive.WriteLatest(snapshotDir, latest); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier day doesn't move the pointer back
+	if err := archive.WriteLatest(snapshotDir, archive.Latest{Date: "2026-10-13", File: "2026-10-13.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, loadedAt, err := loadEventsFromFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].ID != "on" {
+		t.Errorf("loaded %v, want only the event still on", loaded)
+	}
+	if !loadedAt.Equal(latest.UpdatedAt) {
+		t.Errorf("loaded at %s, want the snapshot's time %s", loadedAt, latest.UpdatedAt)
+	}
+
+	// Servers other than self-hosted ones scrape instead
+	cfg.DeploymentMode = deploymentServer
+	setConfig(cfg)
+	if _, _, err := loadEventsFromFile(); err == nil {
+		t.Error("a server loaded the latest snapshot")
+	}
+}
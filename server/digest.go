@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mapthens-server/pkg/archive"
+)
+
+// The first scheduled full refresh of each day also writes that day's
+// digest, snapshots/digests/2026-10-15.json: the day's counts (see
+// summary.go) and its events in start order. Digests are served at
+// /api/digest?date=, and each new one is POSTed to
+// MAPTHENS_DIGEST_WEBHOOK_URL when it's set, e.g. for a mailing list's
+// automation. A digest isn't rewritten by later refreshes that day, so
+// it's sent once, and it's deleted along with its day's snapshot (see
+// retention.go).
+
+// Data Structures
+
+// Digest is one day's events, for a newsletter or a chat post.
+type Digest struct {
+	Date        string        `json:"date"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Summary     EventSummary  `json:"summary"`
+	Events      []DigestEvent `json:"events"`
+}
+
+// DigestEvent is an event as the digest lists it.
+type DigestEvent struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Datetime  string `json:"datetime"`
+	Venue     string `json:"venue"`
+	Category  string `json:"category"`
+	EventLink string `json:"event_link"`
+}
+
+// Helper Functions
+
+func digestFile(day string) string {
+	return filepath.Join(snapshotDir, "digests", archive.SnapshotName(day))
+}
+
+func buildDigest(events []Event, date string) Digest {
+	digest := Digest{Date: date, GeneratedAt: now(), Summary: summarizeEvents(events, date), Events: []DigestEvent{}}
+	for _, e := range events {
+		if date < e.StartDate || date > e.EndDate {
+			continue
+		}
+		digest.Events = append(digest.Events, DigestEvent{
+			ID:        e.ID,
+			Title:     e.Title,
+			Datetime:  e.Datetime,
+			Venue:     e.Venue,
+			Category:  e.Category,
+			EventLink: e.EventLink,
+		})
+	}
+	return digest
+}
+
+// writeDailyDigest writes and sends today's digest of events, unless it's
+// already been written.
+func writeDailyDigest(events []Event) {
+	day := today()
+	path := digestFile(day)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	digest := buildDigest(events, day)
+	data, err := json.MarshalIndent(digest, "", "  ")
+	if err != nil {
+		log.Printf("Warning: Failed to encode the digest: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Warning: Failed to save the digest: %v", err)
+		return
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		log.Printf("Warning: Failed to save the digest: %v", err)
+		return
+	}
+	log.Printf("Wrote the %s digest of %d events.", day, len(digest.Events))
+	if err := postJSON(getConfig().DigestWebhookURL, digest); err != nil {
+		log.Printf("Warning: Failed to send the digest: %v", err)
+	}
+}
+
+// HTTP Handlers
+
+// digestHandler serves GET /api/digest, the digest for ?date= (default
+// today).
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = today()
+	} else if _, err := archive.ParseDateKey(date); err != nil {
+		http.Error(w, "Invalid date parameter", http.StatusBadRequest)
+		return
+	}
+
+	data, err := os.ReadFile(digestFile(date))
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("No digest for %s", date), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading digest: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
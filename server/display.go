@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// Venues like the 40 Watt often have several events a night, all geocoded
+// to the same point, and their markers stack so only the top one can be
+// seen or clicked. ?expand=display gives each geocoded event a
+// display_latitude and display_longitude to draw its marker at: its true
+// coordinates, or, when another event in the response shares them exactly,
+// a point nudged a few meters away. The nudge's direction and distance come
+// from a hash of the event's ID, so a marker stays put across refreshes and
+// filters. latitude and longitude always keep the true location.
+
+const (
+	// How far, in degrees of latitude, a stacked marker is moved: about
+	// 9 to 18 meters
+	displayOffsetMin = 0.00008
+	displayOffsetMax = 0.00016
+)
+
+// Helper Functions
+
+// displayOffset is where e's marker is drawn when it's stacked with others.
+func displayOffset(e Event) (float64, float64) {
+	sum := sha256.Sum256([]byte(e.ID))
+	angle := float64(binary.BigEndian.Uint32(sum[0:4])) / math.MaxUint32 * 2 * math.Pi
+	radius := displayOffsetMin + (displayOffsetMax-displayOffsetMin)*float64(binary.BigEndian.Uint16(sum[4:6]))/math.MaxUint16
+	// A degree of longitude shrinks away from the equator
+	latitude := e.Latitude + radius*math.Sin(angle)
+	longitude := e.Longitude + radius*math.Cos(angle)/math.Cos(e.Latitude*math.Pi/180)
+	return latitude, longitude
+}
+
+func expandDisplay(events []Event) {
+	stacked := map[[2]float64]int{}
+	for _, e := range events {
+		if e.Latitude != 0 || e.Longitude != 0 {
+			stacked[[2]float64{e.Latitude, e.Longitude}]++
+		}
+	}
+	for i, e := range events {
+		if e.Latitude == 0 && e.Longitude == 0 {
+			continue
+		}
+		latitude, longitude := e.Latitude, e.Longitude
+		if stacked[[2]float64{e.Latitude, e.Longitude}] > 1 {
+			latitude, longitude = displayOffset(e)
+		}
+		events[i].DisplayLatitude, events[i].DisplayLongitude = &latitude, &longitude
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admins correct scraped mistakes with PATCH /api/events/{id}. Edits are
+// kept by event ID in edits.json in the cache directory and laid over the
+// scraped event whenever it is normalized, so they survive re-scrapes. Every
+// edit is appended to audit.ndjson along with who made it.
+
+const maxAuditEntries = 1000
+
+var errInvalidEdit = errors.New("invalid edit")
+
+// Data Structures
+
+// EventEdit holds the corrected fields of one event. A PATCH body is decoded
+// onto the current edit, so fields it leaves out are kept and fields it
+// sets to null are dropped, as in a JSON merge patch.
+type EventEdit struct {
+	Title       *string  `json:"title,omitempty"`
+	Datetime    *string  `json:"datetime,omitempty"`
+	StartDate   *string  `json:"start_date,omitempty"`
+	EndDate     *string  `json:"end_date,omitempty"`
+	Category    *string  `json:"category,omitempty"`
+	EventLink   *string  `json:"event_link,omitempty"`
+	Venue       *string  `json:"venue,omitempty"`
+	Address     *string  `json:"address,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+}
+
+type AuditEntry struct {
+	Time    time.Time       `json:"time"`
+	Editor  string          `json:"editor"`
+	EventID string          `json:"event_id"`
+	Changes json.RawMessage `json:"changes"`
+}
+
+// Global Variables
+var (
+	eventEdits = map[string]EventEdit{}
+	editsMutex sync.RWMutex
+	editsFile  = "edits.json"
+	auditFile  = "audit.ndjson"
+	auditMutex sync.Mutex
+)
+
+// Helper Functions
+
+func (edit EventEdit) validate() error {
+	for _, date := range []*string{edit.StartDate, edit.EndDate} {
+		if date == nil {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", *date); err != nil {
+			return fmt.Errorf("dates must be YYYY-MM-DD, got %q", *date)
+		}
+	}
+	if edit.StartDate != nil && edit.EndDate != nil && *edit.EndDate < *edit.StartDate {
+		return fmt.Errorf("end_date is before start_date")
+	}
+	if (edit.Latitude == nil) != (edit.Longitude == nil) {
+		return fmt.Errorf("latitude and longitude must be edited together")
+	}
+	if edit.Latitude != nil && (*edit.Latitude < -90 || *edit.Latitude > 90 || *edit.Longitude < -180 || *edit.Longitude > 180) {
+		return fmt.Errorf("coordinates out of range")
+	}
+	return nil
+}
+
+func (edit EventEdit) empty() bool {
+	return edit == EventEdit{}
+}
+
+// applyEdit lays an event's edit over it, reporting whether its coordinates
+// were edited.
+func applyEdit(e *Event) bool {
+	editsMutex.RLock()
+	edit, ok := eventEdits[e.ID]
+	editsMutex.RUnlock()
+	if !ok {
+		return false
+	}
+
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{
+		{edit.Title, &e.Title},
+		{edit.Datetime, &e.Datetime},
+		{edit.StartDate, &e.StartDate},
+		{edit.EndDate, &e.EndDate},
+		{edit.Category, &e.Category},
+		{edit.EventLink, &e.EventLink},
+		{edit.Venue, &e.Venue},
+		{edit.Address, &e.Address},
+		{edit.Description, &e.Description},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	if edit.Latitude != nil {
+		e.Latitude, e.Longitude = *edit.Latitude, *edit.Longitude
+		e.GeocodeProvider = geocodeEdit
+		return true
+	}
+	return false
+}
+
+func loadEventEdits() {
+	data, err := os.ReadFile(editsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read event edits file: %v", err)
+		}
+		return
+	}
+
+	edits := map[string]EventEdit{}
+	if err := json.Unmarshal(data, &edits); err != nil {
+		log.Printf("Warning: Failed to parse event edits file: %v", err)
+		return
+	}
+
+	editsMutex.Lock()
+	eventEdits = edits
+	editsMutex.Unlock()
+}
+
+// patchEventEdit decodes patch onto the event's current edit and saves it.
+// The edits file is re-read under its lock first, so edits saved by other
+// processes sharing the cache directory are patched and kept.
+func patchEventEdit(id string, patch []byte) error {
+	editsMutex.Lock()
+	defer editsMutex.Unlock()
+
+	edits := map[string]EventEdit{}
+	err := updateJSONFile(editsFile, &edits, func() error {
+		edit := edits[id]
+		decoder := json.NewDecoder(bytes.NewReader(patch))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&edit); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidEdit, err)
+		}
+		if err := edit.validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidEdit, err)
+		}
+		if edit.empty() {
+			delete(edits, id)
+		} else {
+			edits[id] = edit
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	eventEdits = edits
+	return nil
+}
+
+func appendAudit(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	// Other processes sharing the cache directory append to it too
+	unlock, err := lockFile(auditFile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readAudit returns the last n audit entries, newest first.
+func readAudit(n int) ([]AuditEntry, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	entries := []AuditEntry{}
+	f, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, scanner.Err()
+}
+
+// HTTP Handlers
+
+// eventEditHandler serves PATCH /api/events/{id}. The body is a merge patch
+// of EventEdit fields, e.g. {"datetime": "Friday, October 16 @ 8:00 pm"}.
+// The editor is the holder of an OIDC token, or else named by the X-Editor
+// header.
+func eventEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	editor := adminEditor(r)
+	if editor == "" {
+		http.Error(w, "Missing X-Editor header", http.StatusBadRequest)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/events/")
+	if _, ok := findEvent(id); !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var patch json.RawMessage
+	if !decodeJSONBody(w, r, &patch) {
+		return
+	}
+	if err := patchEventEdit(id, patch); errors.Is(err, errInvalidEdit) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving edit: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := appendAudit(AuditEntry{Time: now(), Editor: editor, EventID: id, Changes: patch}); err != nil {
+		log.Printf("Warning: Failed to write audit log: %v", err)
+	}
+	log.Printf("Event %s edited by %s.", id, editor)
+
+	renormalizeCache()
+	e, _ := findEvent(id)
+	writeJSON(w, e)
+}
+
+// auditHandler serves GET /api/admin/audit?limit=, the most recent edits.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxAuditEntries {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxAuditEntries), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := readAudit(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
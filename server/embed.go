@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Embeddable widgets for third-party sites. Both endpoints are read-only and
+// accept ?category=MUSIC,ART to narrow the listing.
+
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]{0,63}$`)
+
+var embedListTemplate = template.Must(template.New("embed-list").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Today in Athens | Mapthens</title>
+<style>
+  body { margin: 0; padding: 12px; font-family: 'Inter', sans-serif; background: #2f2f2f; color: #e0e0e0; }
+  .event { border-bottom: 1px solid #444; padding: 8px 0; }
+  .event h3 { margin: 0; font-size: 1rem; }
+  .event p { margin: 4px 0; color: #b0b0b0; font-size: 0.85rem; }
+  a { color: #5dade2; text-decoration: none; }
+</style>
+</head>
+<body>
+{{- range .}}
+<div class="event">
+  <h3><a href="{{.EventLink}}" target="_blank" rel="noopener">{{.Title}}</a></h3>
+  <p>{{.Datetime}} &middot; {{.Venue}}</p>
+  <p>{{.Category}}</p>
+</div>
+{{- else}}
+<p>No events found for today.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+const embedScript = `(function () {
+  var events = %s;
+  var script = document.currentScript;
+  var container = document.createElement('div');
+  container.className = 'mapthens-embed';
+  if (events.length === 0) {
+    container.textContent = 'No events found for today.';
+  }
+  events.forEach(function (event) {
+    var item = document.createElement('div');
+    item.className = 'mapthens-event';
+    var link = document.createElement('a');
+    link.href = event.event_link;
+    link.target = '_blank';
+    link.rel = 'noopener';
+    link.textContent = event.title;
+    var details = document.createElement('div');
+    details.textContent = event.datetime + ' · ' + event.venue;
+    item.appendChild(link);
+    item.appendChild(details);
+    container.appendChild(item);
+  });
+  script.parentNode.insertBefore(container, script.nextSibling);
+})();
+`
+
+// Helper Functions
+
+// parseCategories reads the category filter from the query string, accepting
+// both repeated and comma-separated values.
+func parseCategories(r *http.Request) []string {
+	var categories []string
+	for _, value := range r.URL.Query()["category"] {
+		for _, c := range strings.Split(value, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				categories = append(categories, c)
+			}
+		}
+	}
+	return categories
+}
+
+func filterByCategory(events []Event, categories []string) []Event {
+	if len(categories) == 0 {
+		return events
+	}
+	filtered := []Event{}
+	for _, e := range events {
+		for _, c := range categories {
+			if strings.EqualFold(e.Category, c) {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func embedEvents(w http.ResponseWriter, r *http.Request) ([]Event, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	events, err := getEvents()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	return filterByCategory(events, parseCategories(r)), true
+}
+
+// HTTP Handlers
+
+func embedListHandler(w http.ResponseWriter, r *http.Request) {
+	events, ok := embedEvents(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	embedListTemplate.Execute(w, events)
+}
+
+// embedScriptHandler serves either a JSONP payload (when ?callback= is given)
+// or a script that renders the listing right after its own <script> tag.
+func embedScriptHandler(w http.ResponseWriter, r *http.Request) {
+	events, ok := embedEvents(w, r)
+	if !ok {
+		return
+	}
+
+	if events == nil {
+		events = []Event{}
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, "Error encoding events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+
+	if callback := r.URL.Query().Get("callback"); callback != "" {
+		if !jsonpCallbackPattern.MatchString(callback) {
+			http.Error(w, "Invalid callback name", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "/**/%s(%s);\n", callback, data)
+		return
+	}
+
+	fmt.Fprintf(w, embedScript, data)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Detail pages are fetched by a small worker pool. Requests to the same host
+// are spaced at least DetailHostDelay apart, so flagpole and the ticketing
+// sites events link to each see a polite request rate however many workers
+// run. Whatever hasn't been fetched when DetailBudget runs out, or when the
+// refresh is cancelled, is skipped.
+
+// Data Structures
+
+type hostLimiter struct {
+	delay time.Duration
+	mu    sync.Mutex
+	next  map[string]time.Time
+}
+
+// Helper Functions
+
+// reserve books the next request slot for host and returns when it starts.
+func (l *hostLimiter) reserve(host string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot := now()
+	if next := l.next[host]; next.After(slot) {
+		slot = next
+	}
+	l.next[host] = slot.Add(l.delay)
+	return slot
+}
+
+// sleepContext waits for d, returning early with ctx's error if it's
+// cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchDetails calls fetch for each link from a pool of workers and returns
+// the results by link. Links not reached within the budget, or before ctx is
+// cancelled, are left out.
+func fetchDetails[T any](ctx context.Context, links []string, fetch func(link string) T) map[string]T {
+	cfg := getConfig()
+	results := map[string]T{}
+	if len(links) == 0 {
+		return results
+	}
+
+	limiter := &hostLimiter{delay: cfg.DetailHostDelay, next: map[string]time.Time{}}
+	deadline := now().Add(cfg.DetailBudget)
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	skipped, cancelled := 0, 0
+
+	for i := 0; i < min(cfg.DetailWorkers, len(links)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range jobs {
+				host := ""
+				if u, err := url.Parse(link); err == nil {
+					host = u.Host
+				}
+				slot := limiter.reserve(host)
+				if slot.After(deadline) {
+					mu.Lock()
+					skipped++
+					mu.Unlock()
+					continue
+				}
+				if err := sleepContext(ctx, slot.Sub(now())); err != nil {
+					mu.Lock()
+					cancelled++
+					mu.Unlock()
+					continue
+				}
+
+				result := fetch(link)
+				mu.Lock()
+				results[link] = result
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, link := range links {
+		jobs <- link
+	}
+	close(jobs)
+	wg.Wait()
+
+	if cancelled > 0 {
+		log.Printf("Warning: Stopped fetching detail pages with %d of %d left: %v", cancelled, len(links), ctx.Err())
+	}
+	if skipped > 0 {
+		log.Printf("Warning: Detail fetch budget of %v ran out, skipped %d of %d pages.", cfg.DetailBudget, skipped, len(links))
+	}
+	return results
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Flagpole's datetime text looks like "Wednesday, December 10 @ 7:00 pm",
+// optionally followed by " - 10:00 pm". The date itself comes from the
+// machine-readable Date field. Listings without a time are all-day events,
+// or say so ("@ All Day"), or leave it to be announced ("@ TBA").
+var (
+	clockTimePattern = regexp.MustCompile(`(?i)(\d{1,2}:\d{2})\s*([ap]m)`)
+	allDayPattern    = regexp.MustCompile(`(?i)\ball[- ]day\b`)
+	timeTBAPattern   = regexp.MustCompile(`(?i)@\s*(time\s+)?tb[ad]\b`)
+)
+
+// defaultEventDuration is assumed for listings without an end time.
+const defaultEventDuration = 2 * time.Hour
+
+// eventTimes works out when an event starts and ends in the configured
+// timezone. Listings without a start time, that say they're all day, or
+// whose time is TBA are treated as all-day events, spanning through the end
+// date for multi-day events. A clock time after "All Day", such as a
+// closing time, doesn't make the event timed.
+func eventTimes(e Event) (start, end time.Time, allDay bool, err error) {
+	loc := getConfig().Location
+	day, err := time.ParseInLocation("2006-01-02", e.Date[:min(len(e.Date), 10)], loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("invalid event date %q: %v", e.Date, err)
+	}
+	lastDay := day
+	if e.EndDate > e.Date {
+		if lastDay, err = time.ParseInLocation("2006-01-02", e.EndDate, loc); err != nil {
+			return time.Time{}, time.Time{}, false, fmt.Errorf("invalid event end date %q: %v", e.EndDate, err)
+		}
+	}
+
+	matches := clockTimePattern.FindAllStringSubmatch(e.Datetime, 2)
+	if len(matches) == 0 || allDayPattern.MatchString(e.Datetime) || timeTBAPattern.MatchString(e.Datetime) {
+		return day, lastDay.AddDate(0, 0, 1), true, nil
+	}
+
+	start, err = atClockTime(day, matches[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	end = start.Add(defaultEventDuration)
+	if len(matches) > 1 {
+		if end, err = atClockTime(lastDay, matches[1]); err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		// "10:00 pm - 1:00 am" runs past midnight
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+	return start, end, false, nil
+}
+
+// setStartTime fills in AllDay and StartTime from the event's date and
+// datetime text. Events whose date can't be read get neither.
+func setStartTime(e *Event) {
+	e.AllDay, e.StartTime = false, nil
+	start, _, allDay, err := eventTimes(*e)
+	if err != nil {
+		return
+	}
+	e.AllDay = allDay
+	if !allDay {
+		e.StartTime = &start
+	}
+}
+
+func atClockTime(day time.Time, match []string) (time.Time, error) {
+	t, err := time.Parse("3:04pm", match[1]+strings.ToLower(match[2]))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+}
+
+// eventOrder is the order events are stored and served in: by start time,
+// then venue, then title, with the ID breaking any remaining tie so the
+// order never depends on how flagpole happened to list them.
+const eventOrder = "start_time,venue,title"
+
+func sortEvents(events []Event) {
+	type keyed struct {
+		start time.Time
+		event Event
+	}
+	sorted := make([]keyed, len(events))
+	for i, e := range events {
+		// Unparseable dates sort first, as the zero time
+		start, _, _, _ := eventTimes(e)
+		sorted[i] = keyed{start, e}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		if a.event.Venue != b.event.Venue {
+			return a.event.Venue < b.event.Venue
+		}
+		if a.event.Title != b.event.Title {
+			return a.event.Title < b.event.Title
+		}
+		return eventID(a.event) < eventID(b.event)
+	})
+	for i, k := range sorted {
+		events[i] = k.event
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ?expand=venue,weather,series,tickets attaches optional sub-resources to
+// each event in a response, so the base payload stays slim and the heavier
+// enrichments are only worked out when asked for:
+//
+//	description  the whole description, which list responses shorten
+//	tickets      what was read from the event's ticketing page
+//	venue        the venue's gazetteer entry, as venue_detail
+//	series       other listings of the same event, today's and recent ones
+//	weather      the forecast for when the event starts; see weather.go
+//	display      where to draw the event's marker, moved off any others at
+//	             the same point; see display.go
+//
+// List responses leave out tickets and shorten descriptions unless they're
+// expanded; the single-event endpoint always has both. Each expansion is an
+// entry in expanders, so a new one only needs adding there (and to
+// eventFields, for ?fields=).
+
+// Data Structures
+
+type expander struct {
+	// expand attaches the expansion to events, a copy it may modify
+	expand func(events []Event)
+	// slim cuts list responses down when the expansion wasn't asked for
+	slim func(events []Event)
+}
+
+// VenueDetail is a venue's gazetteer entry with its ID. Venues missing
+// from the gazetteer only have a name.
+type VenueDetail struct {
+	ID string `json:"id"`
+	VenueInfo
+	Calendar string `json:"calendar"`
+}
+
+// SeriesOccurrence is another listing of an event with the same title.
+type SeriesOccurrence struct {
+	ID        string `json:"id"`
+	StartDate string `json:"start_date"`
+	Datetime  string `json:"datetime"`
+	Venue     string `json:"venue"`
+}
+
+// Global Variables
+var expanders = map[string]expander{
+	"description": {slim: func(events []Event) {
+		limit := getConfig().DescriptionLimit
+		for i := range events {
+			events[i].Description, events[i].DescriptionTruncated = truncateDescription(events[i].Description, limit)
+		}
+	}},
+	"tickets": {slim: func(events []Event) {
+		for i := range events {
+			events[i].Tickets = nil
+		}
+	}},
+	"venue":   {expand: expandVenues},
+	"series":  {expand: expandSeries},
+	"weather": {expand: expandWeather},
+	"display": {expand: expandDisplay},
+}
+
+// Helper Functions
+
+// parseExpand returns the expansions named by ?expand=.
+func parseExpand(r *http.Request) (map[string]bool, error) {
+	expand := map[string]bool{}
+	for _, name := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := expanders[name]; !ok {
+			return nil, fmt.Errorf("unknown expansion %q", name)
+		}
+		expand[name] = true
+	}
+	return expand, nil
+}
+
+// applyExpansions returns a copy of events with the expansions attached,
+// leaving events, which may be the cache, untouched. List responses are
+// also slimmed of what wasn't expanded.
+func applyExpansions(events []Event, expand map[string]bool, list bool) []Event {
+	expanded := make([]Event, len(events))
+	copy(expanded, events)
+	names := make([]string, 0, len(expanders))
+	for name := range expanders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := expanders[name]
+		if expand[name] && e.expand != nil {
+			e.expand(expanded)
+		} else if !expand[name] && list && e.slim != nil {
+			e.slim(expanded)
+		}
+	}
+	return expanded
+}
+
+// expandEvents applies ?expand= to a list response's events. It writes a
+// 400 and returns false when the parameter is invalid.
+func expandEvents(w http.ResponseWriter, r *http.Request, events []Event) ([]Event, bool) {
+	expand, err := parseExpand(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid expand parameter: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	return applyExpansions(events, expand, true), true
+}
+
+func venueDetail(name string) VenueDetail {
+	info, ok := lookupVenue(name)
+	if !ok {
+		info = VenueInfo{Name: name}
+	}
+	id := venueID(name)
+	return VenueDetail{ID: id, VenueInfo: info, Calendar: "/api/venues/" + id + "/events.ics"}
+}
+
+func expandVenues(events []Event) {
+	for i, e := range events {
+		if e.Venue == "" {
+			continue
+		}
+		detail := venueDetail(e.Venue)
+		events[i].VenueDetail = &detail
+	}
+}
+
+// expandSeries lists, for each event, the other current and recent events
+// with the same title, earliest first.
+func expandSeries(events []Event) {
+	byTitle := map[string][]Event{}
+	seen := map[string]bool{}
+	add := func(e Event) {
+		if title := normalizeTitle(e.Title); title != "" && !seen[e.ID] {
+			seen[e.ID] = true
+			byTitle[title] = append(byTitle[title], e)
+		}
+	}
+	if current, err := getEvents(); err == nil {
+		for _, e := range current {
+			add(e)
+		}
+	}
+	recentMutex.RLock()
+	for _, r := range recentEvents {
+		add(r.Event)
+	}
+	recentMutex.RUnlock()
+	for _, e := range events {
+		add(e)
+	}
+	for _, listings := range byTitle {
+		sort.SliceStable(listings, func(i, j int) bool {
+			if listings[i].StartDate != listings[j].StartDate {
+				return listings[i].StartDate < listings[j].StartDate
+			}
+			return listings[i].ID < listings[j].ID
+		})
+	}
+
+	for i, e := range events {
+		var series []SeriesOccurrence
+		for _, other := range byTitle[normalizeTitle(e.Title)] {
+			if other.ID != e.ID {
+				series = append(series, SeriesOccurrence{ID: other.ID, StartDate: other.StartDate, Datetime: other.Datetime, Venue: other.Venue})
+			}
+		}
+		events[i].Series = series
+	}
+}
+
+func expandWeather(events []Event) {
+	hours := hourlyForecast()
+	if len(hours) == 0 {
+		return
+	}
+	for i, e := range events {
+		if at, ok := weatherTime(e); ok {
+			events[i].Weather = weatherAt(hours, at)
+		}
+	}
+}
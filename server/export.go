@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// With MAPTHENS_PARQUET_DIR set, each scrape's events are also written out
+// as Parquet for analytics, partitioned Hive-style by the day they were
+// listed and their category:
+//
+//	<dir>/listed_on=2026-10-15/category=live-music/events.parquet
+//
+// Categories are slugified for the path, and uncategorized events go under
+// category=none. A re-scrape replaces the day's partitions as a whole. The
+// directory can be synced to object storage and read by Athena, Spark, or
+// pandas.read_parquet(dir) as is.
+
+// Data Structures
+
+// parquetEvent is an Event's row. The partition keys are left out, as Hive
+// layouts expect.
+type parquetEvent struct {
+	ID              string    `parquet:"id"`
+	Date            string    `parquet:"date"`
+	StartDate       string    `parquet:"start_date"`
+	EndDate         string    `parquet:"end_date"`
+	Datetime        string    `parquet:"datetime"`
+	Title           string    `parquet:"title"`
+	EventLink       string    `parquet:"event_link"`
+	Venue           string    `parquet:"venue"`
+	Address         string    `parquet:"address"`
+	Description     string    `parquet:"description"`
+	Outdoor         bool      `parquet:"outdoor"`
+	FamilyFriendly  bool      `parquet:"family_friendly"`
+	Featured        bool      `parquet:"featured"`
+	LinkBroken      bool      `parquet:"link_broken"`
+	Latitude        float64   `parquet:"latitude,optional"`
+	Longitude       float64   `parquet:"longitude,optional"`
+	VenueType       string    `parquet:"venue_type,optional"`
+	VenueCapacity   int32     `parquet:"venue_capacity,optional"`
+	SourceName      string    `parquet:"source_name"`
+	GeocodeProvider string    `parquet:"geocode_provider,optional"`
+	ScrapedAt       time.Time `parquet:"scraped_at,timestamp(millisecond)"`
+}
+
+// Helper Functions
+
+func toParquetEvent(e Event) parquetEvent {
+	return parquetEvent{
+		ID:              e.ID,
+		Date:            e.Date,
+		StartDate:       e.StartDate,
+		EndDate:         e.EndDate,
+		Datetime:        e.Datetime,
+		Title:           e.Title,
+		EventLink:       e.EventLink,
+		Venue:           e.Venue,
+		Address:         e.Address,
+		Description:     e.Description,
+		Outdoor:         e.Outdoor,
+		FamilyFriendly:  e.FamilyFriendly,
+		Featured:        e.Featured,
+		LinkBroken:      e.LinkBroken,
+		Latitude:        e.Latitude,
+		Longitude:       e.Longitude,
+		VenueType:       e.VenueType,
+		VenueCapacity:   int32(e.VenueCapacity),
+		SourceName:      e.SourceName,
+		GeocodeProvider: e.GeocodeProvider,
+		ScrapedAt:       e.ScrapedAt.UTC(),
+	}
+}
+
+// fromParquetEvent reads a row back, for archives written as Parquet.
+// Fields the export leaves out stay empty.
+func fromParquetEvent(row parquetEvent, category string) Event {
+	return Event{
+		ID:              row.ID,
+		Date:            row.Date,
+		StartDate:       row.StartDate,
+		EndDate:         row.EndDate,
+		Datetime:        row.Datetime,
+		Category:        category,
+		Title:           row.Title,
+		EventLink:       row.EventLink,
+		Venue:           row.Venue,
+		Address:         row.Address,
+		Description:     row.Description,
+		Outdoor:         row.Outdoor,
+		FamilyFriendly:  row.FamilyFriendly,
+		Featured:        row.Featured,
+		LinkBroken:      row.LinkBroken,
+		Latitude:        row.Latitude,
+		Longitude:       row.Longitude,
+		VenueType:       row.VenueType,
+		VenueCapacity:   int(row.VenueCapacity),
+		SourceName:      row.SourceName,
+		GeocodeProvider: row.GeocodeProvider,
+		ScrapedAt:       row.ScrapedAt,
+	}
+}
+
+// exportParquet writes day's events under dir, replacing whatever was
+// exported for day before. The new partitions are written beside the old
+// ones and swapped in with renames, so readers never see half a day.
+func exportParquet(dir, day string, events []Event) error {
+	byCategory := map[string][]parquetEvent{}
+	for _, e := range events {
+		category := slugify(e.Category)
+		if category == "" {
+			category = "none"
+		}
+		byCategory[category] = append(byCategory[category], toParquetEvent(e))
+	}
+
+	final := filepath.Join(dir, "listed_on="+day)
+	staging := final + ".tmp"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	for category, rows := range byCategory {
+		partition := filepath.Join(staging, "category="+category)
+		if err := os.MkdirAll(partition, 0755); err != nil {
+			return err
+		}
+		if err := parquet.WriteFile(filepath.Join(partition, "events.parquet"), rows, parquet.Compression(&parquet.Zstd)); err != nil {
+			return fmt.Errorf("writing %s: %v", partition, err)
+		}
+	}
+	if len(byCategory) == 0 {
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+	}
+
+	previous := final + ".old"
+	if err := os.RemoveAll(previous); err != nil {
+		return err
+	}
+	if err := os.Rename(final, previous); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(staging, final); err != nil {
+		return err
+	}
+	return os.RemoveAll(previous)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Events are tagged family_friendly for parents using the map. The rules,
+// checked in order:
+//
+//  1. an exclude keyword in the title or description (e.g. "21+", "happy
+//     hour") means it isn't
+//  2. a category listed in the category map decides it outright
+//  3. an include keyword in the title or description (e.g. "kids",
+//     "storytime") means it is
+//  4. otherwise libraries and parks are, and everywhere else isn't
+//
+// Each part can be replaced with the "family" key of the config file, e.g.
+//
+//	"family": {"categories": {"Kidstuff": true, "Comedy": false},
+//	           "include": ["kids", "all ages"], "exclude": ["21+"]}
+
+// Data Structures
+
+// FamilyRules is the "family" section of the config file. A nil field keeps
+// the default rules for that part.
+type FamilyRules struct {
+	Categories map[string]bool `json:"categories"`
+	Include    []string        `json:"include"`
+	Exclude    []string        `json:"exclude"`
+}
+
+// familyClassifier is FamilyRules compiled for matching.
+type familyClassifier struct {
+	categories map[string]bool // by lowercased category
+	include    *regexp.Regexp  // nil matches nothing
+	exclude    *regexp.Regexp
+}
+
+// Global Variables
+var (
+	defaultFamilyCategories = map[string]bool{
+		"Kidstuff":           true,
+		"Karaoke & Open Mic": false,
+		"Nightlife":          false,
+	}
+	defaultFamilyInclude = []string{
+		"kids", "kid", "children", "children's", "family", "families", "toddler", "toddlers",
+		"storytime", "story time", "all ages", "all-ages", "puppet", "puppets", "youth", "teens",
+		"babies", "preschool", "homeschool",
+	}
+	defaultFamilyExclude = []string{
+		"21+", "18+", "adults only", "happy hour", "bar crawl", "pub crawl",
+		"burlesque", "wine tasting", "beer tasting", "cocktail", "cocktails",
+	}
+	familyVenueTypes = []string{"library", "park"}
+)
+
+// Helper Functions
+
+// keywordPattern matches any of keywords as whole words, case-insensitively.
+// Keywords may contain punctuation ("21+"), so word boundaries are spelled
+// out rather than left to \b.
+func keywordPattern(keywords []string) (*regexp.Regexp, error) {
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+	quoted := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		if k = strings.TrimSpace(k); k == "" {
+			return nil, fmt.Errorf("empty keyword")
+		}
+		quoted = append(quoted, regexp.QuoteMeta(k))
+	}
+	return regexp.Compile(`(?i)(?:^|[^\pL\pN])(?:` + strings.Join(quoted, "|") + `)(?:$|[^\pL\pN])`)
+}
+
+// compileFamilyRules lays rules over the defaults.
+func compileFamilyRules(rules *FamilyRules) (*familyClassifier, error) {
+	categories, include, exclude := defaultFamilyCategories, defaultFamilyInclude, defaultFamilyExclude
+	if rules != nil {
+		if rules.Categories != nil {
+			categories = rules.Categories
+		}
+		if rules.Include != nil {
+			include = rules.Include
+		}
+		if rules.Exclude != nil {
+			exclude = rules.Exclude
+		}
+	}
+
+	c := &familyClassifier{categories: make(map[string]bool, len(categories))}
+	for category, friendly := range categories {
+		c.categories[strings.ToLower(strings.TrimSpace(category))] = friendly
+	}
+	var err error
+	if c.include, err = keywordPattern(include); err != nil {
+		return nil, fmt.Errorf("include: %v", err)
+	}
+	if c.exclude, err = keywordPattern(exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %v", err)
+	}
+	return c, nil
+}
+
+func (c *familyClassifier) classify(e Event) bool {
+	text := e.Title + "\n" + e.Description
+	if c.exclude != nil && c.exclude.MatchString(text) {
+		return false
+	}
+	if friendly, ok := c.categories[strings.ToLower(strings.TrimSpace(e.Category))]; ok {
+		return friendly
+	}
+	if c.include != nil && c.include.MatchString(text) {
+		return true
+	}
+	return containsFold(familyVenueTypes, e.VenueType)
+}
+
+// isFamilyFriendly classifies e with the configured rules. It needs e's
+// venue type, so it runs after the venue table is applied.
+func isFamilyFriendly(e Event) bool {
+	c := getConfig().Family
+	if c == nil {
+		return false
+	}
+	return c.classify(e)
+}
+
+func filterByFamily(events []Event, family bool) []Event {
+	filtered := []Event{}
+	for _, e := range events {
+		if e.FamilyFriendly == family {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,218 @@
+//go:build chaos
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault injection for resilience testing. It's only compiled into binaries
+// built with -tags chaos, so a production build can't have it switched on
+// by a stray variable. In such a build,
+//
+//	MAPTHENS_FAULTS=flagpole=0.3,mapbox=0.1
+//
+// fails that share of requests to each upstream, and
+//
+//	MAPTHENS_FAULT_DELAYS=flagpole=0.5 MAPTHENS_FAULT_DELAY=20s
+//
+// holds that share of them for MAPTHENS_FAULT_DELAY (default 5s) first, long
+// enough to trip the stall and scrape timeouts. A failure is, in equal
+// shares, a dropped connection, a 503, or a 429 with a one-second
+// Retry-After, so the stale-serving, quarantine, and Mapbox rate-limit paths
+// all get exercised. Upstreams are flagpole, uga (the UGA calendar), mapbox
+// (geocoding and walking times), weather (the National Weather Service), and
+// * for any other outbound request. MAPTHENS_FAULT_SEED makes the rolls
+// repeatable. There's no S3 client in the server to fault.
+
+const defaultFaultDelay = 5 * time.Second
+
+// Data Structures
+
+type faultRule struct {
+	upstream  string
+	failRate  float64
+	delayRate float64
+}
+
+type faultTransport struct {
+	next  http.RoundTripper
+	rules map[string]faultRule
+	delay time.Duration
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Helper Functions
+
+// parseFaultRates parses upstream=rate pairs.
+func parseFaultRates(name, value string, rules map[string]faultRule, delay bool) error {
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		upstream, rateText, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		rate, err := strconv.ParseFloat(rateText, 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid %s entry %q: must be upstream=rate, with rate from 0 to 1", name, pair)
+		}
+		switch upstream {
+		case "flagpole", "uga", "mapbox", "weather", "*":
+		default:
+			return fmt.Errorf("invalid %s upstream %q: must be flagpole, uga, mapbox, weather, or *", name, upstream)
+		}
+		rule := rules[upstream]
+		rule.upstream = upstream
+		if delay {
+			rule.delayRate = rate
+		} else {
+			rule.failRate = rate
+		}
+		rules[upstream] = rule
+	}
+	return nil
+}
+
+// upstreamOf names the upstream a request to host goes to.
+func upstreamOf(host string) string {
+	switch {
+	case host == "flagpole.com" || strings.HasSuffix(host, ".flagpole.com"):
+		return "flagpole"
+	case host == "mapbox.com" || strings.HasSuffix(host, ".mapbox.com"):
+		return "mapbox"
+	case host == "api.weather.gov":
+		return "weather"
+	}
+	if u, err := url.Parse(getConfig().UGACalendarURL); err == nil && u.Hostname() == host {
+		return "uga"
+	}
+	return ""
+}
+
+func (t *faultTransport) roll() float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.rand.Float64()
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule, ok := t.rules[upstreamOf(req.URL.Hostname())]
+	if !ok {
+		if rule, ok = t.rules["*"]; !ok {
+			return t.next.RoundTrip(req)
+		}
+	}
+
+	if t.roll() < rule.delayRate {
+		log.Printf("Fault injected: delaying %s by %v", req.URL.Host, t.delay)
+		select {
+		case <-time.After(t.delay):
+		case <-req.Context().Done():
+			closeRequestBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+	if t.roll() >= rule.failRate {
+		return t.next.RoundTrip(req)
+	}
+
+	closeRequestBody(req)
+	kind := t.roll()
+	switch {
+	case kind < 1.0/3:
+		log.Printf("Fault injected: dropping the connection to %s", req.URL.Host)
+		return nil, fmt.Errorf("injected fault: connection to %s reset", req.URL.Host)
+	case kind < 2.0/3:
+		log.Printf("Fault injected: %s answers 503", req.URL.Host)
+		return faultResponse(req, http.StatusServiceUnavailable, nil), nil
+	default:
+		log.Printf("Fault injected: %s answers 429", req.URL.Host)
+		return faultResponse(req, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}), nil
+	}
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func faultResponse(req *http.Request, status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	body := "injected fault\n"
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// installFaults wraps the clients of the upstream APIs when MAPTHENS_FAULTS
+// or MAPTHENS_FAULT_DELAYS is set.
+func installFaults() error {
+	rules := map[string]faultRule{}
+	if err := parseFaultRates("MAPTHENS_FAULTS", os.Getenv("MAPTHENS_FAULTS"), rules, false); err != nil {
+		return err
+	}
+	if err := parseFaultRates("MAPTHENS_FAULT_DELAYS", os.Getenv("MAPTHENS_FAULT_DELAYS"), rules, true); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	delay := defaultFaultDelay
+	if value := os.Getenv("MAPTHENS_FAULT_DELAY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid MAPTHENS_FAULT_DELAY %q: must be a positive duration like 5s", value)
+		}
+		delay = d
+	}
+	seed := time.Now().UnixNano()
+	if value := os.Getenv("MAPTHENS_FAULT_SEED"); value != "" {
+		s, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MAPTHENS_FAULT_SEED %q: must be an integer", value)
+		}
+		seed = s
+	}
+
+	wrap := func(next http.RoundTripper) http.RoundTripper {
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		return &faultTransport{next: next, rules: rules, delay: delay, rand: rand.New(rand.NewSource(seed))}
+	}
+	// http.Get and friends, which the Mapbox calls use, go through
+	// http.DefaultClient
+	http.DefaultClient.Transport = wrap(http.DefaultClient.Transport)
+	fetchClient.Transport = wrap(fetchClient.Transport)
+	linkCheckClient.Transport = wrap(linkCheckClient.Transport)
+	weatherClient.Transport = wrap(weatherClient.Transport)
+	matrixClient.Transport = wrap(matrixClient.Transport)
+
+	for _, rule := range rules {
+		log.Printf("Warning: Injecting faults into %s: %.0f%% failed, %.0f%% delayed by %v.", rule.upstream, 100*rule.failRate, 100*rule.delayRate, delay)
+	}
+	return nil
+}
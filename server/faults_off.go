@@ -0,0 +1,16 @@
+//go:build !chaos
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// installFaults is a no-op outside chaos builds; see faults.go.
+func installFaults() error {
+	if os.Getenv("MAPTHENS_FAULTS") != "" || os.Getenv("MAPTHENS_FAULT_DELAYS") != "" {
+		log.Println("Warning: Fault injection is only available in builds with -tags chaos; ignoring MAPTHENS_FAULTS.")
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// Every scraped page goes through openPage, which streams the body through
+// gzip/deflate decoding and conversion to UTF-8, fails once it grows past
+// MAPTHENS_MAX_PAGE_SIZE, and records per-host timing for /api/status.
+// fetchPage reads it whole; fetchDocument parses it as it's read.
+//
+// A page is never quietly cut short. Servers get fetchTimeout to answer,
+// and then the body may take as long as it needs (up to fetchMaxDuration)
+// so long as it never stalls for fetchStallTimeout, which lets a large
+// listing trickle in over a slow chunked response. A body that ends early,
+// a stall, or a Content-Encoding that can't be decoded fails the fetch with
+// an error saying so, rather than handing the parser part of a page.
+//
+// Scraping requests leave through fetchTransport, which routes them per
+// host: through an HTTP(S) or SOCKS5 proxy, directly, or to a pinned IP
+// address, and resolves names with a custom DNS server when one is set.
+// Routes are read from the config on every new connection, so reloads
+// apply without a restart.
+
+const (
+	defaultMaxPageSize = 10 << 20
+	fetchTimeout       = 30 * time.Second
+	fetchStallTimeout  = 20 * time.Second
+	fetchMaxDuration   = 5 * time.Minute
+)
+
+// Data Structures
+
+// FetchRoute says how scraping requests to one host leave the server.
+type FetchRoute struct {
+	// Proxy is an http://, https://, or socks5:// URL, or "direct" to skip
+	// the default proxy
+	Proxy string `json:"proxy,omitempty"`
+	// IP pins the host to this address instead of resolving it
+	IP string `json:"ip,omitempty"`
+}
+
+type FetchStats struct {
+	Requests      int     `json:"requests"`
+	Errors        int     `json:"errors"`
+	Bytes         int64   `json:"bytes"`
+	AverageMillis float64 `json:"average_ms"`
+	totalTime     time.Duration
+}
+
+// Global Variables
+var (
+	fetchTransport = newFetchTransport()
+	fetchClient    = &http.Client{Transport: fetchTransport}
+	fetchCounts    = map[string]*FetchStats{}
+	fetchMutex     sync.Mutex
+
+	errPageTooLarge = errors.New("page too large")
+)
+
+// Helper Functions
+
+func newFetchTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = fetchProxy
+	transport.DialContext = fetchDial
+	transport.ResponseHeaderTimeout = fetchTimeout
+	return transport
+}
+
+// fetchProxy picks the proxy for a request: its host's route, then
+// MAPTHENS_FETCH_PROXY, then the standard HTTP_PROXY, HTTPS_PROXY, and
+// NO_PROXY variables.
+func fetchProxy(req *http.Request) (*url.URL, error) {
+	cfg := getConfig()
+	proxy := cfg.FetchProxy
+	if route, ok := cfg.FetchRoutes[req.URL.Hostname()]; ok && route.Proxy != "" {
+		proxy = route.Proxy
+	}
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment(req)
+	case "direct":
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+// fetchDial connects to addr, or to its host's pinned IP, resolving names
+// with MAPTHENS_FETCH_DNS when set. Through a proxy, addr is the proxy's.
+func fetchDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	cfg := getConfig()
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if route, ok := cfg.FetchRoutes[host]; ok && route.IP != "" {
+			addr = net.JoinHostPort(route.IP, port)
+		}
+	}
+	if server := cfg.FetchDNS; server != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, server)
+			},
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// validateFetchProxy checks a proxy setting when the config is loaded, so
+// a typo isn't only noticed by the next scrape.
+func validateFetchProxy(proxy string) error {
+	if proxy == "" || proxy == "direct" {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" || u.Host == "" {
+		return fmt.Errorf("%q must be an http://, https://, or socks5:// URL", proxy)
+	}
+	return nil
+}
+
+func recordFetch(host string, elapsed time.Duration, size int, err error) {
+	fetchMutex.Lock()
+	defer fetchMutex.Unlock()
+
+	stats, ok := fetchCounts[host]
+	if !ok {
+		stats = &FetchStats{}
+		fetchCounts[host] = stats
+	}
+	stats.Requests++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.Bytes += int64(size)
+	stats.totalTime += elapsed
+	stats.AverageMillis = float64(stats.totalTime) / float64(time.Millisecond) / float64(stats.Requests)
+}
+
+func fetchStatus() map[string]FetchStats {
+	fetchMutex.Lock()
+	defer fetchMutex.Unlock()
+
+	status := make(map[string]FetchStats, len(fetchCounts))
+	for host, stats := range fetchCounts {
+		status[host] = *stats
+	}
+	return status
+}
+
+// fetchPage GETs pageURL and returns its body as UTF-8.
+func fetchPage(ctx context.Context, pageURL string) ([]byte, error) {
+	page, err := openPage(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(page)
+	page.fail(err)
+	page.Close()
+	return body, err
+}
+
+// pageReader streams a page's body as UTF-8, recording the fetch when it's
+// closed.
+type pageReader struct {
+	io.Reader
+	host    string
+	started time.Time
+	size    int
+	err     error
+	closers []io.Closer
+	// raw copies the body for the scrape's raw page archive; see rawpages.go
+	raw *rawCapture
+}
+
+func (p *pageReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	p.size += n
+	return n, err
+}
+
+// fail marks the fetch as failed when err isn't nil, e.g. when the body
+// couldn't be parsed.
+func (p *pageReader) fail(err error) {
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *pageReader) Close() error {
+	for i := len(p.closers) - 1; i >= 0; i-- {
+		p.closers[i].Close()
+	}
+	if p.raw != nil {
+		p.raw.save(p.err)
+	}
+	recordFetch(p.host, since(p.started), p.size, p.err)
+	return nil
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+// stallReader reads a response body, cancelling the request when no bytes
+// arrive for timeout, and explains the errors of a body that stalled or
+// ended early.
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+	stalled atomic.Bool
+	read    int64
+	pageURL string
+}
+
+func newStallReader(r io.Reader, timeout time.Duration, cancel func(), pageURL string) *stallReader {
+	s := &stallReader{r: r, timeout: timeout, pageURL: pageURL}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		cancel()
+	})
+	return s
+}
+
+func (s *stallReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	s.read += int64(n)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	switch {
+	case err == nil || err == io.EOF:
+	case s.stalled.Load():
+		err = fmt.Errorf("%s stalled for %s after %d bytes", s.pageURL, s.timeout, s.read)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = fmt.Errorf("%s was cut off after %d bytes: %w", s.pageURL, s.read, err)
+	}
+	return n, err
+}
+
+func (s *stallReader) Close() error {
+	s.timer.Stop()
+	return nil
+}
+
+// decodeBody undoes a response's Content-Encoding. HTTP's "deflate" is
+// meant to be zlib-wrapped but some servers send raw DEFLATE, so the zlib
+// header is checked for.
+func decodeBody(body io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		buffered := bufio.NewReader(body)
+		header, _ := buffered.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
+
+// sizeLimitedReader fails with errPageTooLarge once more than limit bytes
+// have been read, so a small compressed body can't expand without bound.
+type sizeLimitedReader struct {
+	r       io.Reader
+	limit   int64
+	read    int64
+	pageURL string
+}
+
+func (l *sizeLimitedReader) Read(b []byte) (int, error) {
+	if int64(len(b)) > l.limit-l.read+1 {
+		b = b[:l.limit-l.read+1]
+	}
+	n, err := l.r.Read(b)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf("%w: %s exceeds %d bytes (MAPTHENS_MAX_PAGE_SIZE)", errPageTooLarge, l.pageURL, l.limit)
+	}
+	return n, err
+}
+
+// openPage GETs pageURL and returns a reader of its body, decoded and
+// converted to UTF-8 as it's read. The request is cancelled with ctx, e.g.
+// by the scrape watchdog. The caller must close the reader.
+func openPage(ctx context.Context, pageURL string) (*pageReader, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %v", pageURL, err)
+	}
+	page := &pageReader{host: u.Host, started: now()}
+	fail := func(err error) (*pageReader, error) {
+		page.fail(err)
+		page.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchMaxDuration)
+	page.closers = append(page.closers, closerFunc(cancel))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return fail(err)
+	}
+	// Setting Accept-Encoding ourselves turns off the transport's transparent
+	// gzip handling, so both encodings are decoded, and checked for an early
+	// end, by decodeBody.
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return fail(fmt.Errorf("failed to fetch %s: %v", pageURL, err))
+	}
+	page.closers = append(page.closers, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("received non-200 status code from %s: %d", pageURL, resp.StatusCode))
+	}
+
+	stall := newStallReader(resp.Body, fetchStallTimeout, cancel, pageURL)
+	page.closers = append(page.closers, stall)
+	decoded, err := decodeBody(stall, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return fail(fmt.Errorf("failed to decode response from %s: %v", pageURL, err))
+	}
+	page.closers = append(page.closers, decoded)
+	var source io.Reader = decoded
+	if page.raw = captureRawPage(pageURL, u.Hostname(), resp.Header.Get("Content-Type")); page.raw != nil {
+		source = io.TeeReader(decoded, &page.raw.body)
+	}
+	body := &sizeLimitedReader{r: source, limit: getConfig().MaxPageSize, pageURL: pageURL}
+
+	utf8, err := charset.NewReader(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fail(fmt.Errorf("failed to detect charset of %s: %v", pageURL, err))
+	}
+	page.Reader = utf8
+	return page, nil
+}
+
+func fetchDocument(ctx context.Context, pageURL string) (*goquery.Document, error) {
+	page, err := openPage(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer page.Close()
+
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		page.fail(err)
+		if errors.Is(err, errPageTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to parse HTML: %v", err)
+	}
+	return doc, nil
+}
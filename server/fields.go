@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sparse fieldsets: ?fields=title,venue,latitude,longitude returns only the
+// named fields of each event, which is all a map client needs for its
+// markers. Fields are picked with a table of accessors rather than
+// reflection, so a field added to Event must also be added to eventFields
+// to be selectable.
+
+// Data Structures
+
+// sparseResponse is APIResponse with its events cut down to the selected
+// fields. The outer Events field shadows the embedded one when encoded.
+type sparseResponse struct {
+	APIResponse
+	Events []map[string]interface{} `json:"events"`
+}
+
+// Global Variables
+var eventFields = map[string]func(Event) interface{}{
+	"id":                    func(e Event) interface{} { return e.ID },
+	"date":                  func(e Event) interface{} { return e.Date },
+	"start_date":            func(e Event) interface{} { return e.StartDate },
+	"end_date":              func(e Event) interface{} { return e.EndDate },
+	"datetime":              func(e Event) interface{} { return e.Datetime },
+	"category":              func(e Event) interface{} { return e.Category },
+	"title":                 func(e Event) interface{} { return e.Title },
+	"event_link":            func(e Event) interface{} { return e.EventLink },
+	"venue":                 func(e Event) interface{} { return e.Venue },
+	"address":               func(e Event) interface{} { return e.Address },
+	"description":           func(e Event) interface{} { return e.Description },
+	"description_truncated": func(e Event) interface{} { return e.DescriptionTruncated },
+	"outdoor":               func(e Event) interface{} { return e.Outdoor },
+	"family_friendly":       func(e Event) interface{} { return e.FamilyFriendly },
+	"tickets":               func(e Event) interface{} { return e.Tickets },
+	"support_acts":          func(e Event) interface{} { return e.SupportActs },
+	"door_time":             func(e Event) interface{} { return e.DoorTime },
+	"featured":              func(e Event) interface{} { return e.Featured },
+	"link_broken":           func(e Event) interface{} { return e.LinkBroken },
+	"latitude":              func(e Event) interface{} { return e.Latitude },
+	"longitude":             func(e Event) interface{} { return e.Longitude },
+	"all_day":               func(e Event) interface{} { return e.AllDay },
+	"start_time":            func(e Event) interface{} { return e.StartTime },
+	"source_name":           func(e Event) interface{} { return e.SourceName },
+	"source_url":            func(e Event) interface{} { return e.SourceURL },
+	"scraped_at":            func(e Event) interface{} { return e.ScrapedAt },
+	"geocode_provider":      func(e Event) interface{} { return e.GeocodeProvider },
+	"field_sources":         func(e Event) interface{} { return e.FieldSources },
+	"added":                 func(e Event) interface{} { return e.Added },
+	"short_code":            func(e Event) interface{} { return e.ShortCode },
+	"venue_type":            func(e Event) interface{} { return e.VenueType },
+	"venue_capacity":        func(e Event) interface{} { return e.VenueCapacity },
+	"walking_minutes":       func(e Event) interface{} { return e.WalkingMinutes },
+	"distance_meters":       func(e Event) interface{} { return e.DistanceMeters },
+	"venue_detail":          func(e Event) interface{} { return e.VenueDetail },
+	"series":                func(e Event) interface{} { return e.Series },
+	"weather":               func(e Event) interface{} { return e.Weather },
+	"display_latitude":      func(e Event) interface{} { return e.DisplayLatitude },
+	"display_longitude":     func(e Event) interface{} { return e.DisplayLongitude },
+}
+
+// Helper Functions
+
+// parseFields returns the fields named by ?fields=, or nil when every field
+// was asked for.
+func parseFields(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := eventFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// selectFields cuts events down to fields. Unset pointer fields, such as
+// walking_minutes without ?from=, are null.
+func selectFields(events []Event, fields []string) []map[string]interface{} {
+	selected := make([]map[string]interface{}, len(events))
+	for i, e := range events {
+		values := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			values[field] = eventFields[field](e)
+		}
+		selected[i] = values
+	}
+	return selected
+}
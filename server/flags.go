@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature flags gate behaviors that may need to be switched off in a hurry.
+// A flag's value comes from, in order of precedence: a runtime override set
+// through the admin API (persisted in the cache directory), the
+// MAPTHENS_FLAG_<NAME> environment variable, the "flags" object in the
+// config file, and the flag's default.
+
+const (
+	flagDetailEnrichment = "detail_enrichment"
+	flagWalkingTimes     = "walking_times"
+	flagFeaturedPicks    = "featured_picks"
+	flagStreamingListing = "streaming_listing"
+)
+
+// Data Structures
+
+type featureFlag struct {
+	Description string
+	Default     bool
+}
+
+type FlagState struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// Source is "default", "config" (file or environment), or "override"
+	Source string `json:"source"`
+}
+
+// Global Variables
+var (
+	featureFlags = map[string]featureFlag{
+		flagDetailEnrichment: {"Fetch event detail pages for end dates missing from the listing", true},
+		flagWalkingTimes:     {"Add walking times from Mapbox's Matrix API to ?from= requests", true},
+		flagFeaturedPicks:    {"Scrape the Calendar Picks column to mark featured events", true},
+		flagStreamingListing: {"Parse the HTML event list as it downloads instead of building a document tree", false},
+	}
+	flagOverrides = map[string]bool{}
+	flagsMutex    sync.RWMutex
+	flagsFile     = "flags.json"
+)
+
+// Helper Functions
+
+// loadFlagConfig resolves the configured value of each flag from the config
+// file's flags and the environment, rejecting unknown flags.
+func loadFlagConfig(file map[string]bool) (map[string]bool, error) {
+	flags := map[string]bool{}
+	for name, enabled := range file {
+		if _, ok := featureFlags[name]; !ok {
+			return nil, fmt.Errorf("unknown feature flag %q", name)
+		}
+		flags[name] = enabled
+	}
+	for name := range featureFlags {
+		env := "MAPTHENS_FLAG_" + strings.ToUpper(name)
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", env, value)
+		}
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+func flagState(name string) FlagState {
+	flag := featureFlags[name]
+	state := FlagState{Name: name, Description: flag.Description, Enabled: flag.Default, Source: "default"}
+	if enabled, ok := getConfig().Flags[name]; ok {
+		state.Enabled, state.Source = enabled, "config"
+	}
+
+	flagsMutex.RLock()
+	defer flagsMutex.RUnlock()
+	if enabled, ok := flagOverrides[name]; ok {
+		state.Enabled, state.Source = enabled, "override"
+	}
+	return state
+}
+
+func flagEnabled(name string) bool {
+	return flagState(name).Enabled
+}
+
+func loadFlagOverrides() {
+	data, err := os.ReadFile(flagsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read feature flags file: %v", err)
+		}
+		return
+	}
+
+	overrides := map[string]bool{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		log.Printf("Warning: Failed to parse feature flags file: %v", err)
+		return
+	}
+	for name := range overrides {
+		if _, ok := featureFlags[name]; !ok {
+			log.Printf("Warning: Ignoring override of unknown feature flag %q.", name)
+			delete(overrides, name)
+		}
+	}
+
+	flagsMutex.Lock()
+	flagOverrides = overrides
+	flagsMutex.Unlock()
+}
+
+// setFlagOverride overrides a flag, or clears its override when enabled is
+// nil, and saves the overrides. The file is re-read under its lock, so
+// overrides other processes sharing the cache directory saved are kept.
+// The override applies to this process even when it can't be saved.
+func setFlagOverride(name string, enabled *bool) error {
+	flagsMutex.Lock()
+	defer flagsMutex.Unlock()
+
+	override := func(overrides map[string]bool) {
+		if enabled == nil {
+			delete(overrides, name)
+		} else {
+			overrides[name] = *enabled
+		}
+	}
+	saved := map[string]bool{}
+	err := updateJSONFile(flagsFile, &saved, func() error {
+		override(saved)
+		return nil
+	})
+	if err != nil {
+		override(flagOverrides)
+		return err
+	}
+	flagOverrides = saved
+	return nil
+}
+
+// authorizeAdmin checks the request's bearer token against
+// MAPTHENS_ADMIN_TOKEN, or as an OIDC token when an issuer is configured,
+// answering the request itself when it isn't accepted. The admin API is
+// disabled when neither is configured.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	cfg := getConfig()
+	if cfg.AdminToken == "" && cfg.OIDCIssuer == "" {
+		http.Error(w, "Admin API is not configured", http.StatusNotFound)
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok && cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(given), []byte(cfg.AdminToken)) == 1 {
+		return true
+	}
+	if ok && cfg.OIDCIssuer != "" {
+		_, err := verifyOIDCToken(given)
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, errOIDCForbidden) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return false
+		}
+		log.Printf("Warning: Rejected admin token: %v", err)
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// adminEditor names who made an admin request: the holder of its OIDC
+// token, or else the X-Editor header.
+func adminEditor(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && getConfig().OIDCIssuer != "" {
+		if claims, err := verifyOIDCToken(token); err == nil && claims.subject() != "" {
+			return claims.subject()
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-Editor"))
+}
+
+// HTTP Handlers
+
+// flagsHandler serves the admin API for feature flags:
+//
+//	GET    /api/admin/flags         list flags and their current values
+//	PUT    /api/admin/flags/{name}  override a flag, e.g. {"enabled": false}
+//	DELETE /api/admin/flags/{name}  clear the override
+func flagsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/flags"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		names := make([]string, 0, len(featureFlags))
+		for name := range featureFlags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		states := make([]FlagState, 0, len(names))
+		for _, name := range names {
+			states = append(states, flagState(name))
+		}
+		writeJSON(w, states)
+		return
+	}
+
+	if _, ok := featureFlags[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var enabled *bool
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		if body.Enabled == nil {
+			http.Error(w, "Missing enabled field", http.StatusBadRequest)
+			return
+		}
+		enabled = body.Enabled
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := setFlagOverride(name, enabled); err != nil {
+		log.Printf("Warning: Failed to save feature flags: %v", err)
+	}
+	state := flagState(name)
+	log.Printf("Feature flag %s is now %t (%s).", name, state.Enabled, state.Source)
+	writeJSON(w, state)
+}
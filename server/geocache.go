@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Geocoded addresses are remembered in memory so the same address isn't
+// paid for again on the next refresh or submission. The cache is an LRU
+// capped at MAPTHENS_GEOCODE_CACHE_SIZE entries, each kept for at most
+// MAPTHENS_GEOCODE_CACHE_TTL, so a long-running server's memory stays
+// bounded however many addresses pass through it. Hits, misses, and
+// evictions are reported by /api/status.
+
+const (
+	defaultGeocodeCacheSize = 10000
+	defaultGeocodeCacheTTL  = 30 * 24 * time.Hour
+)
+
+// Data Structures
+
+type geocodeCacheEntry struct {
+	address  string
+	coords   coordinates
+	storedAt time.Time
+}
+
+// GeocodeCacheStats is reported as part of /api/status.
+type GeocodeCacheStats struct {
+	Entries   int `json:"entries"`
+	Capacity  int `json:"capacity"`
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Evictions int `json:"evictions"`
+}
+
+// geocodeLRU holds the most recently used entries at the front of order.
+type geocodeLRU struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	stats   GeocodeCacheStats
+}
+
+// Global Variables
+var geocodeCache = &geocodeLRU{order: list.New(), entries: map[string]*list.Element{}}
+
+// Helper Functions
+
+// get returns an address's cached coordinates, dropping them once they're
+// older than ttl.
+func (c *geocodeLRU) get(address string, ttl time.Duration) (coordinates, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[address]
+	if ok && since(element.Value.(*geocodeCacheEntry).storedAt) > ttl {
+		c.remove(element)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return coordinates{}, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*geocodeCacheEntry).coords, true
+}
+
+// put stores an address's coordinates, evicting the least recently used
+// entries beyond size. A size of 0 turns the cache off.
+func (c *geocodeLRU) put(address string, coords coordinates, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[address]; ok {
+		entry := element.Value.(*geocodeCacheEntry)
+		entry.coords, entry.storedAt = coords, now()
+		c.order.MoveToFront(element)
+	} else if size > 0 {
+		c.entries[address] = c.order.PushFront(&geocodeCacheEntry{address: address, coords: coords, storedAt: now()})
+	}
+	// The size may have shrunk since a config reload
+	for c.order.Len() > size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an entry. Callers must hold c.mu.
+func (c *geocodeLRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*geocodeCacheEntry).address)
+}
+
+func (c *geocodeLRU) snapshot(size int) GeocodeCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries, stats.Capacity = c.order.Len(), size
+	return stats
+}
+
+// cachedGeocodes splits addresses into the coordinates already cached and
+// the addresses still to geocode.
+func cachedGeocodes(addresses []string) (map[string]coordinates, []string) {
+	cfg := getConfig()
+	found := map[string]coordinates{}
+	var missing []string
+	for _, address := range addresses {
+		if c, ok := geocodeCache.get(address, cfg.GeocodeCacheTTL); ok {
+			found[address] = c
+		} else {
+			missing = append(missing, address)
+		}
+	}
+	return found, missing
+}
+
+// cacheGeocodes remembers freshly geocoded addresses.
+func cacheGeocodes(results map[string]coordinates) {
+	size := getConfig().GeocodeCacheSize
+	for address, c := range results {
+		geocodeCache.put(address, c, size)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// When Mapbox answers 429, geocoding pauses for as long as its Retry-After
+// header asks (a minute when it doesn't say) and then retries, so a
+// temporary throttle costs a run some time rather than its coordinates. A
+// throttle longer than maxRetryAfter, or one that outlasts
+// maxRateLimitRetries retries, ends the run's geocoding instead.
+
+// Data Structures
+
+type mapboxBatchQuery struct {
+	Q     string `json:"q"`
+	Limit int    `json:"limit"`
+}
+
+type mapboxBatchResponse struct {
+	Batch []MapboxResponse `json:"batch"`
+}
+
+type coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Mapbox accepts at most this many queries in one batch request.
+const maxBatchSize = 1000
+
+const (
+	defaultRetryAfter   = time.Minute
+	maxRetryAfter       = 10 * time.Minute
+	maxRateLimitRetries = 3
+)
+
+// Where an event's coordinates came from, reported as its geocode_provider.
+const (
+	geocodeListing   = "flagpole"
+	geocodeUGA       = "uga"
+	geocodeMapbox    = "mapbox"
+	geocodeGazetteer = "gazetteer"
+	geocodeOverride  = "override"
+	geocodeEdit      = "edit"
+)
+
+// Global Variables
+var (
+	// mapboxPausedUntil holds off geocoding requests after a 429
+	mapboxPausedUntil time.Time
+	mapboxPauseMutex  sync.Mutex
+
+	errRateLimited = errors.New("mapbox rate limit reached")
+)
+
+// Helper Functions
+
+// parseRetryAfter reads a Retry-After header, which gives either seconds or
+// an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now()), 0)
+	}
+	return defaultRetryAfter
+}
+
+// pauseMapbox pauses geocoding for a 429 response's Retry-After and
+// returns the error the request fails with.
+func pauseMapbox(resp *http.Response) error {
+	wait := parseRetryAfter(resp.Header.Get("Retry-After"))
+	mapboxPauseMutex.Lock()
+	if until := now().Add(wait); until.After(mapboxPausedUntil) {
+		mapboxPausedUntil = until
+	}
+	mapboxPauseMutex.Unlock()
+	log.Printf("Warning: Mapbox rate limit reached, pausing geocoding for %v.", wait)
+	return fmt.Errorf("%w: retry after %v", errRateLimited, wait)
+}
+
+// waitForMapbox blocks until geocoding is no longer paused. It returns false
+// without waiting when the pause is longer than maxRetryAfter, and early
+// when ctx is cancelled.
+func waitForMapbox(ctx context.Context) bool {
+	mapboxPauseMutex.Lock()
+	wait := mapboxPausedUntil.Sub(now())
+	mapboxPauseMutex.Unlock()
+	if wait <= 0 {
+		return true
+	}
+	if wait > maxRetryAfter {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryRateLimited calls request once any pause is over, waiting out and
+// retrying rate limits up to maxRateLimitRetries times.
+func retryRateLimited(ctx context.Context, request func() error) error {
+	var err error
+	for attempt := 0; attempt <= maxRateLimitRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !waitForMapbox(ctx) {
+			return fmt.Errorf("%w: paused for longer than %v", errRateLimited, maxRetryAfter)
+		}
+		if err = request(); !errors.Is(err, errRateLimited) {
+			return err
+		}
+	}
+	return err
+}
+
+// geocodeEvents fills in coordinates for scraped events. Each distinct
+// address is geocoded once. Events that already have coordinates (from the
+// events API) and venues with gazetteer coordinates are skipped, since
+// normalizeEvent fills the latter in. Geocoding stops when ctx is cancelled,
+// leaving the remaining addresses without coordinates.
+func geocodeEvents(ctx context.Context, events []Event) {
+	if !tokenUsable() {
+		log.Println("Warning: Mapbox token unavailable, events will not be geocoded.")
+		return
+	}
+
+	seen := map[string]bool{}
+	var addresses []string
+	for _, e := range events {
+		if e.Latitude != 0 || e.Longitude != 0 {
+			continue
+		}
+		if info, ok := lookupVenue(e.Venue); ok && info.hasCoordinates() {
+			continue
+		}
+		if e.Address == "" || seen[e.Address] {
+			continue
+		}
+		seen[e.Address] = true
+		addresses = append(addresses, e.Address)
+	}
+
+	results, addresses := cachedGeocodes(addresses)
+	if remaining, limited := geocodeBudgetRemaining(); limited && len(addresses) > remaining {
+		log.Printf("Warning: Mapbox monthly budget reached, geocoding %d of %d addresses.", remaining, len(addresses))
+		addresses = addresses[:remaining]
+	}
+
+	for address, c := range geocodeAddresses(ctx, addresses) {
+		results[address] = c
+	}
+	if err := saveGeocodeUsage(); err != nil {
+		log.Printf("Warning: Failed to save geocoding usage: %v", err)
+	}
+	for i := range events {
+		if events[i].Latitude != 0 || events[i].Longitude != 0 {
+			continue
+		}
+		if c, ok := results[events[i].Address]; ok {
+			events[i].Latitude = c.Latitude
+			events[i].Longitude = c.Longitude
+			events[i].GeocodeProvider = geocodeMapbox
+		}
+	}
+}
+
+// geocodeAddresses geocodes addresses with the batch API when enabled,
+// falling back to one request per address for any batch that fails. The
+// results are added to the geocode cache; see geocache.go.
+func geocodeAddresses(ctx context.Context, addresses []string) map[string]coordinates {
+	results := map[string]coordinates{}
+	defer cacheGeocodes(results)
+	if !getConfig().BatchGeocode {
+		geocodeEach(ctx, addresses, results)
+		return results
+	}
+
+	for start := 0; start < len(addresses); start += maxBatchSize {
+		if ctx.Err() != nil {
+			log.Printf("Warning: Stopped geocoding with %d addresses left: %v", len(addresses)-start, ctx.Err())
+			break
+		}
+		end := start + maxBatchSize
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+		chunk := addresses[start:end]
+
+		var batch map[string]coordinates
+		err := retryRateLimited(ctx, func() (err error) {
+			batch, err = geocodeBatch(ctx, chunk)
+			return err
+		})
+		// Single requests would only be throttled too
+		if errors.Is(err, errRateLimited) || ctx.Err() != nil {
+			log.Printf("Warning: Stopped geocoding with %d addresses left: %v", len(addresses)-start, err)
+			break
+		}
+		if err != nil {
+			log.Printf("Warning: Batch geocoding failed, falling back to single requests: %v", err)
+			geocodeEach(ctx, chunk, results)
+			continue
+		}
+		for address, c := range batch {
+			results[address] = c
+		}
+	}
+	return results
+}
+
+func geocodeEach(ctx context.Context, addresses []string, results map[string]coordinates) {
+	for i, address := range addresses {
+		if ctx.Err() != nil {
+			log.Printf("Warning: Stopped geocoding with %d addresses left: %v", len(addresses)-i, ctx.Err())
+			return
+		}
+		// Stop as soon as the token is known to be bad, rather than failing
+		// once per address
+		if !tokenUsable() {
+			return
+		}
+
+		var longitude, latitude float64
+		err := retryRateLimited(ctx, func() (err error) {
+			longitude, latitude, err = geocodeAddress(ctx, address)
+			return err
+		})
+		if errors.Is(err, errRateLimited) {
+			log.Printf("Warning: Stopped geocoding with %d addresses left: %v", len(addresses)-i, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Error geocoding address '%s': %v", address, err)
+			continue
+		}
+		results[address] = coordinates{Latitude: latitude, Longitude: longitude}
+
+		// Small delay to be nice to the API if processing many
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// geocodeBatch geocodes up to maxBatchSize addresses in a single request to
+// the Mapbox v6 batch endpoint. Addresses without a match are left out of the
+// result.
+func geocodeBatch(ctx context.Context, addresses []string) (map[string]coordinates, error) {
+	cfg := getConfig()
+	if cfg.MapboxToken == "" {
+		return nil, errTokenMissing
+	}
+
+	queries := make([]mapboxBatchQuery, len(addresses))
+	for i, address := range addresses {
+		queries[i] = mapboxBatchQuery{Q: address, Limit: 1}
+	}
+	body, err := json.Marshal(queries)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("access_token", cfg.MapboxToken)
+	if cfg.GeocodingMode == geocodingPermanent {
+		params.Add("permanent", "true")
+	}
+	requestURL := "https://api.mapbox.com/search/geocode/v6/batch?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		setTokenHealth(tokenInvalid)
+		return nil, fmt.Errorf("%w: status code %d", errTokenRejected, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, pauseMapbox(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	}
+	// Only accepted requests are billed, so throttled attempts that are
+	// retried aren't counted
+	countGeocodeRequests(cfg.GeocodingMode, len(addresses))
+
+	var result mapboxBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding json response: %v", err)
+	}
+	if len(result.Batch) != len(addresses) {
+		return nil, fmt.Errorf("expected %d batch results, got %d", len(addresses), len(result.Batch))
+	}
+
+	coords := make(map[string]coordinates, len(addresses))
+	for i, r := range result.Batch {
+		if len(r.Features) == 0 {
+			log.Printf("Error geocoding address '%s': number of features returned was zero", addresses[i])
+			continue
+		}
+		coords[addresses[i]] = coordinates{
+			Latitude:  r.Features[0].Geometry.Coordinates[1],
+			Longitude: r.Features[0].Geometry.Coordinates[0],
+		}
+	}
+	return coords, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google Calendar export. Users authorize through the OAuth device flow:
+//
+//  1. POST /api/integrations/google/device starts the flow and returns a
+//     user code to enter at Google's verification URL.
+//  2. POST /api/integrations/google/poll is called until the user approves.
+//  3. POST /api/integrations/google/export pushes events to their calendar.
+//
+// Tokens only live in memory for the lifetime of the session.
+
+const (
+	googleDeviceCodeURL = "https://oauth2.googleapis.com/device/code"
+	googleTokenURL      = "https://oauth2.googleapis.com/token"
+	googleCalendarAPI   = "https://www.googleapis.com/calendar/v3"
+	googleCalendarScope = "https://www.googleapis.com/auth/calendar.events"
+	googleSessionTTL    = time.Hour
+)
+
+// Data Structures
+
+type googleSession struct {
+	deviceCode  string
+	accessToken string
+	expiresAt   time.Time
+}
+
+type GoogleDeviceResponse struct {
+	Session         string `json:"session"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	Interval        int    `json:"interval"`
+	ExpiresIn       int    `json:"expires_in"`
+}
+
+type GoogleSessionRequest struct {
+	Session string `json:"session"`
+}
+
+type GoogleExportRequest struct {
+	Session    string   `json:"session"`
+	CalendarID string   `json:"calendar_id"`
+	EventIDs   []string `json:"event_ids"`
+}
+
+type GoogleExportResponse struct {
+	Exported int      `json:"exported"`
+	Failed   []string `json:"failed"`
+}
+
+type googleEventTime struct {
+	Date     string `json:"date,omitempty"`
+	DateTime string `json:"dateTime,omitempty"`
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+type googleEvent struct {
+	ICalUID     string          `json:"iCalUID"`
+	Summary     string          `json:"summary"`
+	Location    string          `json:"location,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Start       googleEventTime `json:"start"`
+	End         googleEventTime `json:"end"`
+}
+
+// Global Variables
+var (
+	googleSessions = map[string]*googleSession{}
+	googleMutex    sync.Mutex
+)
+
+// Helper Functions
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func getGoogleSession(id string) (*googleSession, bool) {
+	googleMutex.Lock()
+	defer googleMutex.Unlock()
+
+	for key, s := range googleSessions {
+		if now().After(s.expiresAt) {
+			delete(googleSessions, key)
+		}
+	}
+	s, ok := googleSessions[id]
+	return s, ok
+}
+
+func postGoogleForm(endpoint string, form url.Values, v interface{}) (int, error) {
+	resp, err := http.PostForm(endpoint, form)
+	if err != nil {
+		return 0, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("error decoding json response: %v", err)
+	}
+	return resp.StatusCode, nil
+}
+
+func toGoogleEvent(e Event) (googleEvent, error) {
+	start, end, allDay, err := eventTimes(e)
+	if err != nil {
+		return googleEvent{}, err
+	}
+
+	ge := googleEvent{
+		ICalUID:     e.ID + "@mapthens",
+		Summary:     e.Title,
+		Location:    strings.Trim(e.Venue+", "+e.Address, ", "),
+		Description: strings.TrimSpace(e.Description + "\n\n" + e.EventLink),
+	}
+	if allDay {
+		ge.Start = googleEventTime{Date: start.Format("2006-01-02")}
+		ge.End = googleEventTime{Date: end.Format("2006-01-02")}
+	} else {
+		zone := getConfig().Location.String()
+		ge.Start = googleEventTime{DateTime: start.Format(time.RFC3339), TimeZone: zone}
+		ge.End = googleEventTime{DateTime: end.Format(time.RFC3339), TimeZone: zone}
+	}
+	return ge, nil
+}
+
+// insertGoogleEvent uses the import endpoint, which dedupes on iCalUID, so
+// exporting the same event twice doesn't create a duplicate.
+func insertGoogleEvent(accessToken, calendarID string, e Event) error {
+	ge, err := toGoogleEvent(e)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ge)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/calendars/%s/events/import", googleCalendarAPI, url.PathEscape(calendarID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// HTTP Handlers
+
+func googleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := getConfig()
+	if cfg.GoogleClientID == "" {
+		http.Error(w, "Google Calendar integration is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/integrations/google/") {
+	case "device":
+		googleDeviceHandler(w, r, cfg)
+	case "poll":
+		googlePollHandler(w, r, cfg)
+	case "export":
+		googleExportHandler(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func googleDeviceHandler(w http.ResponseWriter, r *http.Request, cfg Config) {
+	var result struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	status, err := postGoogleForm(googleDeviceCodeURL, url.Values{
+		"client_id": {cfg.GoogleClientID},
+		"scope":     {googleCalendarScope},
+	}, &result)
+	if err != nil || status != http.StatusOK {
+		log.Printf("Error starting Google device flow (status %d): %v", status, err)
+		http.Error(w, "Failed to start Google authorization", http.StatusBadGateway)
+		return
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	googleMutex.Lock()
+	googleSessions[id] = &googleSession{
+		deviceCode: result.DeviceCode,
+		expiresAt:  now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}
+	googleMutex.Unlock()
+
+	writeJSON(w, GoogleDeviceResponse{
+		Session:         id,
+		UserCode:        result.UserCode,
+		VerificationURL: result.VerificationURL,
+		Interval:        result.Interval,
+		ExpiresIn:       result.ExpiresIn,
+	})
+}
+
+// googlePollHandler checks whether the user has approved the device code.
+// It answers {"status": "pending"} until they have.
+func googlePollHandler(w http.ResponseWriter, r *http.Request, cfg Config) {
+	var req GoogleSessionRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	session, ok := getGoogleSession(req.Session)
+	if !ok {
+		http.Error(w, "Unknown or expired session", http.StatusNotFound)
+		return
+	}
+
+	googleMutex.Lock()
+	authorized := session.accessToken != ""
+	deviceCode := session.deviceCode
+	googleMutex.Unlock()
+	if authorized {
+		writeJSON(w, map[string]string{"status": "authorized"})
+		return
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	_, err := postGoogleForm(googleTokenURL, url.Values{
+		"client_id":     {cfg.GoogleClientID},
+		"client_secret": {cfg.GoogleClientSecret},
+		"device_code":   {deviceCode},
+		"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+	}, &result)
+	if err != nil {
+		log.Printf("Error polling Google token endpoint: %v", err)
+		http.Error(w, "Failed to reach Google", http.StatusBadGateway)
+		return
+	}
+
+	switch result.Error {
+	case "":
+		googleMutex.Lock()
+		session.accessToken = result.AccessToken
+		session.expiresAt = now().Add(min(time.Duration(result.ExpiresIn)*time.Second, googleSessionTTL))
+		googleMutex.Unlock()
+		writeJSON(w, map[string]string{"status": "authorized"})
+	case "authorization_pending", "slow_down":
+		writeJSON(w, map[string]string{"status": "pending"})
+	default:
+		// access_denied or expired_token: the session can't recover
+		googleMutex.Lock()
+		delete(googleSessions, req.Session)
+		googleMutex.Unlock()
+		http.Error(w, "Google authorization failed: "+result.Error, http.StatusForbidden)
+	}
+}
+
+// googleExportHandler pushes the requested events, or all of today's events
+// when event_ids is empty, to the user's calendar (primary by default).
+func googleExportHandler(w http.ResponseWriter, r *http.Request) {
+	var req GoogleExportRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	session, ok := getGoogleSession(req.Session)
+	if !ok {
+		http.Error(w, "Unknown or expired session", http.StatusNotFound)
+		return
+	}
+	googleMutex.Lock()
+	accessToken := session.accessToken
+	googleMutex.Unlock()
+	if accessToken == "" {
+		http.Error(w, "Session is not authorized yet", http.StatusConflict)
+		return
+	}
+
+	events, err := getEvents()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(req.EventIDs) > 0 {
+		wanted := map[string]bool{}
+		for _, id := range req.EventIDs {
+			wanted[id] = true
+		}
+		selected := []Event{}
+		for _, e := range events {
+			if wanted[e.ID] {
+				selected = append(selected, e)
+			}
+		}
+		events = selected
+	}
+
+	calendarID := req.CalendarID
+	if calendarID == "" {
+		calendarID = "primary"
+	}
+
+	response := GoogleExportResponse{Failed: []string{}}
+	for _, e := range events {
+		if err := insertGoogleEvent(accessToken, calendarID, e); err != nil {
+			log.Printf("Error exporting event %s to Google Calendar: %v", e.ID, err)
+			response.Failed = append(response.Failed, e.ID)
+			continue
+		}
+		response.Exported++
+	}
+	writeJSON(w, response)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mapbox token health. The token is checked once at startup and downgraded
+// whenever Mapbox rejects it during geocoding. While the token is unusable
+// the server runs in degraded mode: cached events are still served, but new
+// scrapes skip geocoding.
+
+const (
+	tokenUnknown = "unknown"
+	tokenOK      = "ok"
+	tokenMissing = "missing"
+	tokenInvalid = "invalid"
+)
+
+var (
+	errTokenMissing  = errors.New("MAPBOX_ACCESS_TOKEN not set")
+	errTokenRejected = errors.New("mapbox rejected access token")
+)
+
+type ReadyResponse struct {
+	Status      string    `json:"status"`
+	MapboxToken string    `json:"mapbox_token"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// Global Variables
+var (
+	tokenHealth    = tokenUnknown
+	tokenCheckedAt time.Time
+	tokenMutex     sync.RWMutex
+)
+
+// Helper Functions
+
+func setTokenHealth(status string) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	tokenHealth = status
+	tokenCheckedAt = time.Now()
+}
+
+func getTokenHealth() (string, time.Time) {
+	tokenMutex.RLock()
+	defer tokenMutex.RUnlock()
+	return tokenHealth, tokenCheckedAt
+}
+
+// tokenUsable reports whether geocoding should be attempted. An unknown
+// status (e.g. Mapbox was unreachable at startup) still allows geocoding.
+func tokenUsable() bool {
+	status, _ := getTokenHealth()
+	return status == tokenOK || status == tokenUnknown
+}
+
+// mapboxTokenURL is Mapbox's token API, which reports whether a token is
+// valid without costing a geocode.
+var mapboxTokenURL = "https://api.mapbox.com/tokens/v2"
+
+// validateMapboxToken asks the token API about the configured token.
+func validateMapboxToken() error {
+	token := getConfig().MapboxToken
+	if token == "" {
+		return errTokenMissing
+	}
+	resp, err := http.Get(mapboxTokenURL + "?" + url.Values{"access_token": {token}}.Encode())
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code string `json:"code"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case result.Code == "TokenValid":
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: status code %d", errTokenRejected, resp.StatusCode)
+	case strings.HasPrefix(result.Code, "Token"):
+		// TokenInvalid, TokenExpired, TokenRevoked, or TokenMalformed
+		return fmt.Errorf("%w: %s", errTokenRejected, result.Code)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	case decodeErr != nil:
+		return fmt.Errorf("error decoding json response: %v", decodeErr)
+	}
+	return fmt.Errorf("unexpected token API code %q", result.Code)
+}
+
+// checkMapboxToken validates the token with the token API, so restarts
+// don't count against the geocoding budget. With MAPTHENS_STRICT_TOKEN set,
+// a missing or rejected token stops the server.
+func checkMapboxToken() {
+	err := validateMapboxToken()
+	switch {
+	case err == nil:
+		setTokenHealth(tokenOK)
+		log.Println("Mapbox access token validated.")
+		return
+	case errors.Is(err, errTokenMissing):
+		setTokenHealth(tokenMissing)
+	case errors.Is(err, errTokenRejected):
+		setTokenHealth(tokenInvalid)
+	default:
+		// Network trouble or an unexpected response says nothing about the
+		// token itself, so don't degrade on it.
+		setTokenHealth(tokenUnknown)
+		log.Printf("Warning: Could not validate Mapbox access token: %v", err)
+		return
+	}
+
+	if getConfig().StrictToken {
+		log.Fatalf("Mapbox access token check failed: %v", err)
+	}
+	log.Printf("Warning: Mapbox access token check failed (%v); running in degraded mode without geocoding.", err)
+}
+
+// HTTP Handlers
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status, checkedAt := getTokenHealth()
+
+	response := ReadyResponse{
+		Status:      "ok",
+		MapboxToken: status,
+		CheckedAt:   checkedAt,
+	}
+
+	// The frontend uses the same token for map tiles, so a bad token means
+	// the app cannot render a map even though the API still answers.
+	code := http.StatusOK
+	if status == tokenMissing || status == tokenInvalid {
+		response.Status = "degraded"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// /api/events/heatmap?date= aggregates a day's events into grid cells for a
+// Mapbox heatmap layer, so the client gets one point per cell instead of
+// every event's coordinates. Each cell is a GeoJSON point at its center
+// with the number of events in it and their combined popularity (tracked
+// popup opens and link clicks that day, weighted as in /api/popular).
+// ?cell= sets the cell size in degrees, and the /api/events filters apply.
+
+const (
+	defaultHeatmapCell = 0.005 // about 550m by 460m in Athens
+	minHeatmapCell     = 0.001
+	maxHeatmapCell     = 0.1
+)
+
+// Data Structures
+
+type HeatmapResponse struct {
+	Type          string           `json:"type"` // FeatureCollection
+	Date          string           `json:"date"`
+	CellDegrees   float64          `json:"cell_degrees"`
+	Total         int              `json:"total"`
+	MaxCount      int              `json:"max_count"`
+	MaxPopularity int              `json:"max_popularity"`
+	Features      []HeatmapFeature `json:"features"`
+}
+
+type HeatmapFeature struct {
+	Type       string            `json:"type"` // Feature
+	Geometry   HeatmapPoint      `json:"geometry"`
+	Properties HeatmapProperties `json:"properties"`
+}
+
+type HeatmapPoint struct {
+	Type        string     `json:"type"` // Point
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type HeatmapProperties struct {
+	Count      int `json:"count"`
+	Popularity int `json:"popularity"`
+}
+
+// Helper Functions
+
+// buildHeatmap bins the geocoded events into cells size degrees on a side.
+// Cells are ordered south to north, then west to east.
+func buildHeatmap(events []Event, counts map[string]EventStats, size float64) []HeatmapFeature {
+	type cell struct{ lat, lng int }
+	cells := map[cell]*HeatmapProperties{}
+	for _, e := range events {
+		if e.Latitude == 0 && e.Longitude == 0 {
+			continue
+		}
+		c := cell{int(math.Floor(e.Latitude / size)), int(math.Floor(e.Longitude / size))}
+		props, ok := cells[c]
+		if !ok {
+			props = &HeatmapProperties{}
+			cells[c] = props
+		}
+		props.Count++
+		props.Popularity += popularityScore(counts[e.ID])
+	}
+
+	keys := make([]cell, 0, len(cells))
+	for c := range cells {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].lat != keys[j].lat {
+			return keys[i].lat < keys[j].lat
+		}
+		return keys[i].lng < keys[j].lng
+	})
+
+	features := make([]HeatmapFeature, 0, len(keys))
+	for _, c := range keys {
+		// Rounded so float noise doesn't show up in the JSON
+		lng := math.Round((float64(c.lng)+0.5)*size*1e6) / 1e6
+		lat := math.Round((float64(c.lat)+0.5)*size*1e6) / 1e6
+		features = append(features, HeatmapFeature{
+			Type:       "Feature",
+			Geometry:   HeatmapPoint{Type: "Point", Coordinates: [2]float64{lng, lat}},
+			Properties: *cells[c],
+		})
+	}
+	return features
+}
+
+// HTTP Handlers
+
+// heatmapHandler serves /api/events/heatmap?date=2026-10-15&cell=0.005.
+// Dates other than today come from the event store, as with ?dates=.
+func heatmapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	day := query.Get("date")
+	if day == "" {
+		day = today()
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "Invalid date parameter", http.StatusBadRequest)
+		return
+	}
+	size := defaultHeatmapCell
+	if value := query.Get("cell"); value != "" {
+		var err error
+		if size, err = strconv.ParseFloat(value, 64); err != nil || size < minHeatmapCell || size > maxHeatmapCell {
+			http.Error(w, fmt.Sprintf("Invalid cell parameter: must be between %g and %g degrees", minHeatmapCell, maxHeatmapCell), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if day != today() {
+		listed, err := eventStore.Listed([]string{day})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error querying events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		events = listed[day]
+	}
+	events, ok := filterEvents(w, r, events)
+	if !ok {
+		return
+	}
+	counts, err := eventStore.Analytics(day)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading analytics: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := HeatmapResponse{
+		Type:        "FeatureCollection",
+		Date:        day,
+		CellDegrees: size,
+		Features:    buildHeatmap(events, counts, size),
+	}
+	for _, f := range response.Features {
+		response.Total += f.Properties.Count
+		response.MaxCount = max(response.MaxCount, f.Properties.Count)
+		response.MaxPopularity = max(response.MaxPopularity, f.Properties.Popularity)
+	}
+
+	w.Header().Set("Cache-Status", info.Status)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, response)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeJSONBody decodes a small JSON request body into v, answering 400
+// itself when the body is malformed.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	zw io.Writer
+}
+
+func (w compressedResponseWriter) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+// withCompression compresses responses with Brotli or gzip, whichever the
+// client accepts, preferring Brotli. Event lists for multi-day festivals get
+// large, and JSON shrinks several times over; Brotli shaves off another
+// fifth or so, which adds up on mobile connections.
+func withCompression(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		var zw io.WriteCloser
+		switch {
+		case acceptsEncoding(r, "br"):
+			w.Header().Set("Content-Encoding", "br")
+			// Level 5 compresses about as fast as gzip's default
+			zw = brotli.NewWriterLevel(w, 5)
+		case acceptsEncoding(r, "gzip"):
+			w.Header().Set("Content-Encoding", "gzip")
+			zw = gzip.NewWriter(w)
+		default:
+			h(w, r)
+			return
+		}
+		defer zw.Close()
+		h(compressedResponseWriter{ResponseWriter: w, zw: zw}, r)
+	}
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), encoding) && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// iCalendar feeds. /api/events.ics lists the current events, optionally
+// narrowed with ?category= (repeatable, or comma-separated), and
+// /api/venues/{id}/events.ics lists one venue's. A venue's ID is its
+// gazetteer name, or its listed name for venues the gazetteer doesn't know,
+// lowercased with runs of other characters turned into dashes:
+// "40 Watt Club" is 40-watt-club.
+//
+// /api/events/ical/{id}.ics downloads a single event, with a reminder an
+// hour before it starts, for the frontend's "Add to Calendar" links.
+
+const (
+	icsLineLimit = 75 // octets, not counting the CRLF
+	icsAlarm     = time.Hour
+)
+
+// Helper Functions
+
+// venueID derives the ID used in venue feed URLs from a venue name, using
+// the gazetteer's name so aliases share a feed.
+func venueID(name string) string {
+	if venue, ok := lookupVenue(name); ok {
+		name = venue.Name
+	}
+	return slugify(name)
+}
+
+// slugify lowercases name, drops apostrophes, and turns runs of anything
+// else that isn't a letter or digit into single dashes.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		case r == '\'' || r == '’':
+			// "Hendershot's" is hendershots
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// icsEscape escapes a TEXT value.
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsLine writes a content line, folding it so no line is longer than
+// icsLineLimit octets. Lines are only broken between UTF-8 sequences.
+func icsLine(b *strings.Builder, line string) {
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space counts toward the continuation's length
+		limit = icsLineLimit - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// buildICS renders events as a calendar named name. Timed events are given
+// in UTC, so the feed needs no VTIMEZONE; events whose times can't be read
+// are left out. A nonzero alarm adds a reminder that long before each
+// timed event.
+func buildICS(name string, events []Event, alarm time.Duration) string {
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//mapthens//events//EN")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	icsLine(&b, "METHOD:PUBLISH")
+	icsLine(&b, "X-WR-CALNAME:"+icsEscape(name))
+	icsLine(&b, "X-WR-TIMEZONE:"+getConfig().Location.String())
+
+	stamp := now().UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		start, end, allDay, err := eventTimes(e)
+		if err != nil {
+			log.Printf("Warning: Leaving event %s out of calendar feed: %v", e.ID, err)
+			continue
+		}
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, "UID:"+e.ID+"@mapthens")
+		icsLine(&b, "DTSTAMP:"+stamp)
+		if allDay {
+			icsLine(&b, "DTSTART;VALUE=DATE:"+start.Format("20060102"))
+			icsLine(&b, "DTEND;VALUE=DATE:"+end.Format("20060102"))
+		} else {
+			icsLine(&b, "DTSTART:"+start.UTC().Format("20060102T150405Z"))
+			icsLine(&b, "DTEND:"+end.UTC().Format("20060102T150405Z"))
+		}
+		icsLine(&b, "SUMMARY:"+icsEscape(e.Title))
+		if location := strings.Trim(e.Venue+", "+e.Address, ", "); location != "" {
+			icsLine(&b, "LOCATION:"+icsEscape(location))
+		}
+		if e.Latitude != 0 || e.Longitude != 0 {
+			icsLine(&b, fmt.Sprintf("GEO:%.6f;%.6f", e.Latitude, e.Longitude))
+		}
+		if e.Category != "" {
+			icsLine(&b, "CATEGORIES:"+icsEscape(e.Category))
+		}
+		if e.Description != "" {
+			icsLine(&b, "DESCRIPTION:"+icsEscape(e.Description))
+		}
+		if e.EventLink != "" {
+			icsLine(&b, "URL:"+e.EventLink)
+		}
+		// An alarm before midnight would go off the evening before an
+		// all-day event
+		if alarm > 0 && !allDay {
+			icsLine(&b, "BEGIN:VALARM")
+			icsLine(&b, "ACTION:DISPLAY")
+			icsLine(&b, fmt.Sprintf("TRIGGER:-PT%dM", int(alarm.Minutes())))
+			icsLine(&b, "DESCRIPTION:"+icsEscape(e.Title))
+			icsLine(&b, "END:VALARM")
+		}
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func writeICS(w http.ResponseWriter, name string, events []Event) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte(buildICS(name, events, 0)))
+}
+
+// HTTP Handlers
+
+// icsHandler serves /api/events.ics?category=Live+Music.
+func icsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, _, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var categories []string
+	for _, value := range r.URL.Query()["category"] {
+		for _, c := range strings.Split(value, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				categories = append(categories, c)
+			}
+		}
+	}
+	name := "Athens Events"
+	if len(categories) > 0 {
+		matching := []Event{}
+		for _, e := range events {
+			if containsFold(categories, e.Category) {
+				matching = append(matching, e)
+			}
+		}
+		events = matching
+		name += ": " + strings.Join(categories, ", ")
+	}
+	writeICS(w, name, events)
+}
+
+// venueICSHandler serves /api/venues/{id}/events.ics.
+func venueICSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/venues/"), "/events.ics")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	events, _, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Venues aren't stored anywhere but the gazetteer, so an ID with no
+	// events today still gets an empty feed for calendars to keep polling
+	name := id
+	matching := []Event{}
+	for _, e := range events {
+		if venueID(e.Venue) == id {
+			matching = append(matching, e)
+			name = e.Venue
+		}
+	}
+	if venue, ok := lookupVenue(name); ok {
+		name = venue.Name
+	}
+	writeICS(w, "Athens Events at "+name, matching)
+}
+
+// eventICSHandler serves /api/events/ical/{id}.ics, one event as a file to
+// open in a calendar app.
+func eventICSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/events/ical/"), ".ics")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	e, ok := findEvent(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, _, _, err := eventTimes(e); err != nil {
+		http.Error(w, fmt.Sprintf("Event has no usable time: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	filename := slugify(e.Title)
+	if filename == "" {
+		filename = "event"
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, filename))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte(buildICS(e.Title, []Event{e}, icsAlarm)))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// unfoldICS joins folded content lines, as a calendar client reads them.
+func unfoldICS(s string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func TestICSLine(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		line string
+		// Octets in each physical line, not counting the CRLF
+		want []int
+	}{
+		{"short", "SUMMARY:Open mic", []int{16}},
+		{"empty", "", []int{0}},
+		{"at the limit", strings.Repeat("a", 75), []int{75}},
+		{"one over", strings.Repeat("a", 76), []int{75, 2}},
+		{"continuations count their space", strings.Repeat("a", 75+74+1), []int{75, 75, 2}},
+		// A 2-octet é straddling octet 75 moves to the next line whole
+		{"two-octet sequence", strings.Repeat("a", 74) + "é" + "b", []int{74, 4}},
+		{"four-octet sequence", strings.Repeat("a", 73) + "🎸" + "b", []int{73, 6}},
+		{"all multibyte", strings.Repeat("é", 60), []int{74, 47}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			icsLine(&b, test.line)
+			out := b.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("%q doesn't end with CRLF", out)
+			}
+			physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			if len(physical) != len(test.want) {
+				t.Fatalf("folded into %d lines, want %d: %q", len(physical), len(test.want), out)
+			}
+			for i, line := range physical {
+				if len(line) != test.want[i] {
+					t.Errorf("line %d is %d octets, want %d: %q", i, len(line), test.want[i], line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation %d doesn't start with a space: %q", i, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+			}
+			if got := unfoldICS(out); len(got) != 1 || got[0] != test.line {
+				t.Errorf("unfolds to %q, want %q", got, test.line)
+			}
+		})
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"Open mic", "Open mic"},
+		{"Athens, GA", `Athens\, GA`},
+		{"Doors 7; show 8", `Doors 7\; show 8`},
+		{`C:\path`, `C:\\path`},
+		{"one\ntwo\r\nthree", `one\ntwo\nthree`},
+		{`\,`, `\\\,`},
+	} {
+		if got := icsEscape(test.in); got != test.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"40 Watt Club", "40-watt-club"},
+		{"Hendershot's", "hendershots"},
+		{"Hendershot’s Coffee", "hendershots-coffee"},
+		{"  The Foundry -- Athens ", "the-foundry-athens"},
+		{"Café Racer", "café-racer"},
+		{"!!!", ""},
+	} {
+		if got := slugify(test.name); got != test.want {
+			t.Errorf("slugify(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBuildICS(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	events := []Event{
+		{
+			ID: "timed", Date: "2026-10-15", Datetime: "Thursday, October 15 @ 8:00 pm",
+			Title: "Drive-By Truckers, live", Venue: "Georgia Theatre", Address: "215 N Lumpkin St",
+			Description: strings.Repeat("A long night of rock; ", 10) + "\nDoors at 7.",
+			Latitude:    33.9596, Longitude: -83.3764,
+		},
+		{ID: "allday", Date: "2026-10-15", Datetime: "Thursday, October 15 @ All Day", Title: "Market"},
+		{ID: "unreadable", Date: "someday", Datetime: "whenever", Title: "Mystery"},
+	}
+	out := buildICS("Athens Events", events, time.Hour)
+
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > icsLineLimit {
+			t.Errorf("line %d is %d octets: %q", i, len(line), line)
+		}
+	}
+	lines := unfoldICS(out)
+	has := func(want string) bool {
+		for _, line := range lines {
+			if line == want {
+				return true
+			}
+		}
+		return false
+	}
+	for _, want := range []string{
+		"BEGIN:VCALENDAR",
+		"X-WR-CALNAME:Athens Events",
+		"X-WR-TIMEZONE:America/New_York",
+		"UID:timed@mapthens",
+		"DTSTAMP:20261015T160000Z",
+		"DTSTART:20261016T000000Z",
+		`SUMMARY:Drive-By Truckers\, live`,
+		`LOCATION:Georgia Theatre\, 215 N Lumpkin St`,
+		"GEO:33.959600;-83.376400",
+		"DESCRIPTION:" + strings.Repeat(`A long night of rock\; `, 10) + `\nDoors at 7.`,
+		"TRIGGER:-PT60M",
+		"UID:allday@mapthens",
+		"DTSTART;VALUE=DATE:20261015",
+		"END:VCALENDAR",
+	} {
+		if !has(want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	if strings.Count(out, "BEGIN:VEVENT") != 2 || strings.Contains(out, "unreadable") {
+		t.Errorf("want the two readable events only:\n%s", out)
+	}
+	// Only the timed event gets an alarm
+	if n := strings.Count(out, "BEGIN:VALARM"); n != 1 {
+		t.Errorf("%d alarms, want 1", n)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Past events collected by hand (an old flagpole print calendar, a venue's
+// records) can be backfilled into the archive with
+//
+//	mapthens-server import history.csv
+//
+// or POST /api/admin/import. Files are CSV, with a header row naming the
+// columns, or a JSON array, using the fields submissions use:
+//
+//	title,starts_at,ends_at,venue,address,category,event_link,description
+//	Drive-By Truckers,2019-12-28 20:00,,40 Watt Club,"285 W Washington St",Music,,
+//
+// CSV times may also be "2006-01-02 15:04" or a bare date, in
+// MAPTHENS_TIMEZONE. Every record is validated before anything is written,
+// and problems are reported by JSON Pointer (/{row}/{column}, counting rows
+// after the header from 0). Imported events are geocoded and normalized like
+// scraped ones, then merged into the daily snapshot of the day they start,
+// starting from whatever the snapshot, archive, or database already has for
+// that day; a listing of the same event (same start date and title) that's
+// already there wins, and importing a file again adds nothing. Retention
+// then compacts the days into their monthly archives as usual. Only days
+// before today can be imported, since today's snapshot is the scraper's.
+
+const (
+	sourceImport  = "import"
+	maxImportBody = 8 << 20
+)
+
+// Data Structures
+
+// ImportRecord is an event in an import file.
+type ImportRecord struct {
+	Title       string     `json:"title"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Venue       string     `json:"venue"`
+	Address     string     `json:"address,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	EventLink   string     `json:"event_link,omitempty"`
+	Description string     `json:"description,omitempty"`
+	// Known coordinates skip geocoding
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+type ImportReport struct {
+	Records    int `json:"records"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	// Days lists the days that gained events
+	Days []string `json:"days"`
+}
+
+// Helper Functions
+
+func (r ImportRecord) submission() Submission {
+	return Submission{
+		Title:       r.Title,
+		StartsAt:    r.StartsAt,
+		EndsAt:      r.EndsAt,
+		Category:    r.Category,
+		EventLink:   r.EventLink,
+		Venue:       r.Venue,
+		Address:     r.Address,
+		Description: r.Description,
+		Latitude:    r.Latitude,
+		Longitude:   r.Longitude,
+	}
+}
+
+func (r ImportRecord) toEvent(importedAt time.Time) Event {
+	e := r.submission().toEvent()
+	e.SourceName = sourceImport
+	e.ScrapedAt = importedAt
+	e.ID = eventID(e)
+	return e
+}
+
+// parseImport reads and validates an import file, as CSV or JSON.
+func parseImport(data []byte, format string) ([]ImportRecord, fieldErrors) {
+	var records []ImportRecord
+	var errs fieldErrors
+	if format == "csv" {
+		records, errs = parseImportCSV(data)
+	} else {
+		errs = decodeChecked(data, &records)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(records) == 0 {
+		return nil, fieldErrors{{Path: "", Message: "has no events"}}
+	}
+
+	day := today()
+	for i, r := range records {
+		path := pointer("", i)
+		errs = append(errs, r.submission().validate(path)...)
+		if !r.StartsAt.IsZero() && r.StartsAt.In(getConfig().Location).Format("2006-01-02") >= day {
+			errs.add(pointer(path, "starts_at"), "must be before today")
+		}
+	}
+	return records, errs
+}
+
+func parseImportCSV(data []byte) ([]ImportRecord, fieldErrors) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fieldErrors{{Path: "", Message: "is not valid CSV: " + err.Error()}}
+	}
+	if len(rows) == 0 {
+		return nil, fieldErrors{{Path: "", Message: "has no header row"}}
+	}
+
+	known := map[string]bool{}
+	for _, field := range jsonFields(reflect.TypeOf(ImportRecord{})) {
+		known[field.name] = true
+	}
+	var errs fieldErrors
+	header := rows[0]
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !known[header[i]] {
+			errs.add(pointer("", header[i]), "is not a known column")
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	records := make([]ImportRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		var r ImportRecord
+		for j, value := range row {
+			value = strings.TrimSpace(value)
+			if j >= len(header) || value == "" {
+				continue
+			}
+			path := pointer(pointer("", i), header[j])
+			switch header[j] {
+			case "title":
+				r.Title = value
+			case "starts_at", "ends_at":
+				t, err := parseImportTime(value)
+				if err != nil {
+					errs.add(path, "must be a date-time like \"2019-12-28 20:00\"")
+				} else if header[j] == "starts_at" {
+					r.StartsAt = t
+				} else {
+					r.EndsAt = &t
+				}
+			case "venue":
+				r.Venue = value
+			case "address":
+				r.Address = value
+			case "category":
+				r.Category = value
+			case "event_link":
+				r.EventLink = value
+			case "description":
+				r.Description = value
+			case "latitude", "longitude":
+				var f float64
+				if _, err := fmt.Sscan(value, &f); err != nil {
+					errs.add(path, "must be a number")
+				} else if header[j] == "latitude" {
+					r.Latitude = f
+				} else {
+					r.Longitude = f
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	return records, errs
+}
+
+// parseImportTime reads an RFC 3339 time, or a local "2006-01-02 15:04"
+// or date.
+func parseImportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, getConfig().Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+// listedEvents returns what's already recorded as listed on day: its
+// snapshot, or its events in the month's archive, plus what the database
+// has.
+func listedEvents(day string, index map[string]ArchiveInfo) ([]Event, error) {
+	events, _, err := dayListing(day, index)
+	if err != nil {
+		return nil, err
+	}
+
+	if getConfig().DatabaseURL != "" {
+		stored, err := eventStore.Listed([]string{day})
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, e := range events {
+			seen[e.ID] = true
+		}
+		for _, e := range stored[day] {
+			if !seen[e.ID] {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+// importEvents geocodes and normalizes records and merges them into the
+// snapshots of the days they start on, then applies retention so past days
+// are compacted into the archive.
+func importEvents(records []ImportRecord) (ImportReport, error) {
+	report := ImportReport{Records: len(records), Days: []string{}}
+	unlock, err := lockFile(cachePath(lockName))
+	if err != nil {
+		log.Printf("Warning: Failed to take refresh lock: %v", err)
+	} else {
+		defer unlock()
+	}
+
+	importedAt := now()
+	events := make([]Event, len(records))
+	for i, r := range records {
+		events[i] = r.toEvent(importedAt)
+	}
+	geocodeEvents(context.Background(), events)
+	events = normalizeEvents(events)
+
+	byDay := map[string][]Event{}
+	for _, e := range events {
+		byDay[e.StartDate] = append(byDay[e.StartDate], e)
+	}
+	days := make([]string, 0, len(byDay))
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	index, err := loadArchiveIndex()
+	if err != nil {
+		return report, err
+	}
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		return report, err
+	}
+	for _, day := range days {
+		existing, err := listedEvents(day, index)
+		if err != nil {
+			return report, fmt.Errorf("reading the events listed on %s: %v", day, err)
+		}
+		seen := map[string]bool{}
+		before := 0
+		for _, e := range existing {
+			seen[e.ID] = true
+			if e.SourceName == sourceImport {
+				before++
+			}
+		}
+		combined := append([]Event{}, existing...)
+		for _, e := range byDay[day] {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				combined = append(combined, e)
+			}
+		}
+		// Listings already there rank ahead of the import, so an imported
+		// event only keeps its provenance when nothing matched it
+		priority := append(append([]string{}, getConfig().SourcePriority...), sourceImport)
+		merged, _ := mergeSources(combined, priority)
+		added := -before
+		for _, e := range merged {
+			if e.SourceName == sourceImport {
+				added++
+			}
+		}
+		report.Imported += added
+		report.Duplicates += len(byDay[day]) - added
+		if added == 0 {
+			continue
+		}
+
+		sortEvents(merged)
+		if err := saveDailySnapshot(day, merged); err != nil {
+			return report, fmt.Errorf("saving the %s snapshot: %v", day, err)
+		}
+		if err := eventStore.SaveEvents(day, merged, importedAt); err != nil {
+			return report, fmt.Errorf("saving the events listed on %s: %v", day, err)
+		}
+		report.Days = append(report.Days, day)
+	}
+
+	if run := applyRetention(); run.Error != "" {
+		return report, fmt.Errorf("compacting imported days: %s", run.Error)
+	}
+	log.Printf("Imported %d of %d events into %d days (%d duplicates).", report.Imported, report.Records, len(report.Days), report.Duplicates)
+	return report, nil
+}
+
+// importFormat picks CSV or JSON from a file name or Content-Type.
+func importFormat(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".csv") || strings.Contains(name, "text/csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// importCommand runs `mapthens-server import [-format csv|json] <file>`,
+// reading the file from stdin when it's "-".
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or json (default from the file name)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-format csv|json] <file>")
+	}
+	name := flags.Arg(0)
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = importFormat(name)
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("invalid -format %q: must be csv or json", *format)
+	}
+
+	cfg := getConfig()
+	if err := loadTables(cfg); err != nil {
+		return err
+	}
+	usageFile = cachePath(usageFile)
+	snapshotDir = cachePath(snapshotDir)
+	checkMapboxToken()
+	if cfg.DatabaseURL != "" {
+		store, err := openPostgresStore(cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		eventStore = store
+	}
+	loadGeocodeUsage()
+	defer func() {
+		if err := saveGeocodeUsage(); err != nil {
+			log.Printf("Warning: Failed to save geocoding usage: %v", err)
+		}
+	}()
+
+	records, errs := parseImport(data, *format)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s %s\n", e.Path, e.Message)
+		}
+		return fmt.Errorf("%d problems in %s, nothing was imported", len(errs), name)
+	}
+	report, err := importEvents(records)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d of %d events (%d duplicates) into %d days\n", report.Imported, report.Records, report.Duplicates, len(report.Days))
+	return nil
+}
+
+// HTTP Handlers
+
+// importHandler serves POST /api/admin/import, taking a CSV body when it's
+// sent as text/csv and JSON otherwise.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, ok := readBody(w, r, maxImportBody)
+	if !ok {
+		return
+	}
+	records, errs := parseImport(body, importFormat(r.Header.Get("Content-Type")))
+	if len(errs) > 0 {
+		writeValidationErrors(w, "Invalid import", errs)
+		return
+	}
+	report, err := importEvents(records)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error importing events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
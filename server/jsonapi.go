@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Requests sent with Accept: application/vnd.api+json get JSON:API
+// documents instead of the usual envelope, with links a hypermedia client
+// can follow rather than building URLs itself. Each event is a resource
+// with a self link and venue, series, and related relationships; the
+// envelope's other fields move to meta. List responses can be paged with
+// page[limit] and page[offset] (JSON:API responses only), which adds
+// first, prev, and next links. ?fields= and ?expand= apply as usual.
+
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// Data Structures
+
+type jsonAPIDocument struct {
+	Data    interface{}            `json:"data"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Links   map[string]string      `json:"links,omitempty"`
+	JSONAPI map[string]string      `json:"jsonapi"`
+}
+
+type jsonAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    map[string]interface{}         `json:"attributes"`
+	Relationships map[string]jsonAPIRelationship `json:"relationships,omitempty"`
+	Links         map[string]string              `json:"links"`
+}
+
+type jsonAPIRelationship struct {
+	Links map[string]string   `json:"links"`
+	Data  *jsonAPIResourceRef `json:"data,omitempty"`
+}
+
+type jsonAPIResourceRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Helper Functions
+
+// wantsJSONAPI reports whether the request's Accept header asks for
+// JSON:API.
+func wantsJSONAPI(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) == jsonAPIMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSONAPI(w http.ResponseWriter, doc jsonAPIDocument) {
+	doc.JSONAPI = map[string]string{"version": "1.1"}
+	w.Header().Set("Content-Type", jsonAPIMediaType)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// parsePage reads page[limit] and page[offset]. A limit of 0 means no
+// paging.
+func parsePage(r *http.Request) (limit, offset int, err error) {
+	query := r.URL.Query()
+	if value := query.Get("page[limit]"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("page[limit] must be a positive number")
+		}
+	}
+	if value := query.Get("page[offset]"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("page[offset] must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+// pageLink is the request's own URL with page[offset] set to offset.
+func pageLink(r *http.Request, offset int) string {
+	query := r.URL.Query()
+	query.Set("page[offset]", strconv.Itoa(offset))
+	return baseURL(r) + r.URL.Path + "?" + query.Encode()
+}
+
+// eventAttributes is e as it's usually encoded, less its ID, or only the
+// selected fields when ?fields= was given.
+func eventAttributes(e Event, fields []string) map[string]interface{} {
+	if fields != nil {
+		attributes := selectFields([]Event{e}, fields)[0]
+		delete(attributes, "id")
+		return attributes
+	}
+	var attributes map[string]interface{}
+	data, _ := json.Marshal(e)
+	json.Unmarshal(data, &attributes)
+	delete(attributes, "id")
+	return attributes
+}
+
+func eventResource(r *http.Request, e Event, fields []string) jsonAPIResource {
+	base := baseURL(r)
+	self := base + "/api/events/" + url.PathEscape(e.ID)
+	resource := jsonAPIResource{
+		Type:       "events",
+		ID:         e.ID,
+		Attributes: eventAttributes(e, fields),
+		Relationships: map[string]jsonAPIRelationship{
+			"series":  {Links: map[string]string{"related": self + "?expand=series"}},
+			"related": {Links: map[string]string{"related": self + "/related"}},
+		},
+		Links: map[string]string{
+			"self":     self,
+			"calendar": base + "/api/events/ical/" + url.PathEscape(e.ID) + ".ics",
+			"page":     base + "/events/" + url.PathEscape(e.ID),
+		},
+	}
+	if short := shortURL(r, e); short != "" {
+		resource.Links["short"] = short
+	}
+	if e.Venue != "" {
+		id := venueID(e.Venue)
+		resource.Relationships["venue"] = jsonAPIRelationship{
+			Links: map[string]string{"related": base + "/api/venues/" + id},
+			Data:  &jsonAPIResourceRef{Type: "venues", ID: id},
+		}
+	}
+	return resource
+}
+
+func venueResource(r *http.Request, venue VenueDetail) jsonAPIResource {
+	base := baseURL(r)
+	attributes := map[string]interface{}{}
+	data, _ := json.Marshal(venue.VenueInfo)
+	json.Unmarshal(data, &attributes)
+	return jsonAPIResource{
+		Type:       "venues",
+		ID:         venue.ID,
+		Attributes: attributes,
+		Links: map[string]string{
+			"self":     base + "/api/venues/" + venue.ID,
+			"calendar": base + venue.Calendar,
+		},
+	}
+}
+
+// writeJSONAPIEvents writes a list response as a JSON:API document, paged
+// when asked.
+func writeJSONAPIEvents(w http.ResponseWriter, r *http.Request, response APIResponse, fields []string) {
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid page parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	events := response.Events
+	links := map[string]string{"self": baseURL(r) + r.URL.RequestURI()}
+	if limit > 0 {
+		links["first"] = pageLink(r, 0)
+		if offset > 0 {
+			links["prev"] = pageLink(r, max(offset-limit, 0))
+		}
+		if offset+limit < len(events) {
+			links["next"] = pageLink(r, offset+limit)
+		}
+		events = events[min(offset, len(events)):min(offset+limit, len(events))]
+	}
+
+	data := make([]jsonAPIResource, len(events))
+	for i, e := range events {
+		data[i] = eventResource(r, e, fields)
+	}
+	meta := map[string]interface{}{
+		"version":          response.Version,
+		"total":            response.Total,
+		"order":            response.Order,
+		"scraped_at":       response.ScrapedAt,
+		"data_age_seconds": response.DataAgeSeconds,
+	}
+	if response.Bounds != nil {
+		meta["bounds"], meta["centroid"] = response.Bounds, response.Centroid
+	}
+	if response.MapboxToken != "" {
+		meta["mapbox_token"] = response.MapboxToken
+	}
+	writeJSONAPI(w, jsonAPIDocument{Data: data, Meta: meta, Links: links})
+}
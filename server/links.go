@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Dead-link checking. Event links are checked periodically so popups don't
+// send people to a 404 for a cancelled event. Broken links are flagged on
+// the event and, if enabled, replaced with the venue's homepage from the
+// gazetteer.
+
+// Data Structures
+
+type linkResult struct {
+	Broken    bool
+	CheckedAt time.Time
+}
+
+// Global Variables
+var (
+	linkResults     = map[string]linkResult{}
+	linkMutex       sync.RWMutex
+	linkCheckClient = &http.Client{Timeout: 10 * time.Second, Transport: fetchTransport}
+	linkCheckDelay  = 200 * time.Millisecond
+)
+
+// Helper Functions
+
+// checkLink reports whether url is definitely gone. Only 404 and 410 count:
+// timeouts and server errors are usually temporary and shouldn't hide a
+// working link. Some sites reject HEAD, so fall back to GET.
+func checkLink(url string) (bool, error) {
+	status, err := linkStatus(http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = linkStatus(http.MethodGet, url)
+	}
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusNotFound || status == http.StatusGone, nil
+}
+
+func linkStatus(method, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "mapthens-link-checker")
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func isLinkBroken(url string) bool {
+	linkMutex.RLock()
+	defer linkMutex.RUnlock()
+	return linkResults[url].Broken
+}
+
+// checkEventLinks checks every distinct link among the cached events and
+// re-applies the results to the cache.
+func checkEventLinks() {
+	mutex.RLock()
+	seen := map[string]bool{}
+	var links []string
+	for _, e := range scrapedEvents {
+		if e.EventLink != "" && !seen[e.EventLink] {
+			seen[e.EventLink] = true
+			links = append(links, e.EventLink)
+		}
+	}
+	mutex.RUnlock()
+
+	results := make(map[string]linkResult, len(links))
+	broken := 0
+	for _, link := range links {
+		isBroken, err := checkLink(link)
+		if err != nil {
+			log.Printf("Warning: Could not check link %s: %v", link, err)
+			// Keep the previous verdict rather than guessing
+			linkMutex.RLock()
+			if previous, ok := linkResults[link]; ok {
+				results[link] = previous
+			}
+			linkMutex.RUnlock()
+			continue
+		}
+		if isBroken {
+			broken++
+		}
+		results[link] = linkResult{Broken: isBroken, CheckedAt: now()}
+		time.Sleep(linkCheckDelay)
+	}
+
+	linkMutex.Lock()
+	linkResults = results
+	linkMutex.Unlock()
+
+	renormalizeCache()
+	log.Printf("Checked %d event links, %d broken.", len(links), broken)
+}
+
+func checkLinksPeriodically() {
+	// Give the cache a moment to load before the first pass
+	time.Sleep(time.Minute)
+	for {
+		interval := getConfig().LinkCheckInterval
+		if interval == 0 {
+			// Disabled; look again later in case the config is reloaded
+			time.Sleep(time.Minute)
+			continue
+		}
+		checkEventLinks()
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Flagpole's list view only renders the first page of upcoming events; the
+// rest are behind "Next Events" links (which the calendar's JavaScript loads
+// over AJAX). Pages are followed until they start after the day being
+// scraped.
+
+const (
+	sourceListing     = "flagpole-html"
+	flagpoleEventsURL = "https://flagpole.com/events/"
+	maxListingPages   = 20
+)
+
+// Helper Functions
+
+// scrapeListing collects the listed events from the first listing page
+// through the last one with events starting on or before day.
+func scrapeListing(ctx context.Context, pageURL, day string) ([]Event, error) {
+	var listed []Event
+	seen := map[string]bool{}
+
+	for page := 1; pageURL != ""; page++ {
+		events, next, err := fetchListingPage(ctx, pageURL)
+		if err != nil {
+			if page == 1 {
+				return nil, fmt.Errorf("failed to fetch events page: %v", err)
+			}
+			log.Printf("Warning: Failed to fetch events page %d, keeping the first %d: %v", page, page-1, err)
+			break
+		}
+
+		pastDay := len(events) == 0
+		for _, e := range events {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				e.SourceName, e.SourceURL = sourceListing, pageURL
+				listed = append(listed, e)
+			}
+			pastDay = e.StartDate > day
+		}
+		if pastDay {
+			break
+		}
+		if page == maxListingPages {
+			log.Printf("Warning: Stopped after %d events pages.", maxListingPages)
+			break
+		}
+		pageURL = next
+	}
+	return listed, nil
+}
+
+// fetchListingPage returns a listing page's events and the URL of the page
+// after it.
+func fetchListingPage(ctx context.Context, pageURL string) ([]Event, string, error) {
+	var events []Event
+	var next string
+	if flagEnabled(flagStreamingListing) {
+		var err error
+		if events, next, err = streamListingPage(ctx, pageURL); err != nil {
+			return nil, "", err
+		}
+	} else {
+		doc, err := fetchDocument(ctx, pageURL)
+		if err != nil {
+			return nil, "", err
+		}
+		events, next = parseListingPage(doc), nextListingPage(doc)
+	}
+	if next != "" {
+		next = resolveLink(pageURL, next)
+	}
+	return events, next, nil
+}
+
+// resolveLink resolves an href found on the page at base, which may be
+// relative to it.
+func resolveLink(base, href string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return href
+	}
+	resolved, err := u.Parse(href)
+	if err != nil {
+		return href
+	}
+	return resolved.String()
+}
+
+// nextListingPage returns the href of the following page of events, or "" on
+// the last page.
+func nextListingPage(doc *goquery.Document) string {
+	if href, ok := doc.Find("a.tribe-events-c-nav__next").Attr("href"); ok {
+		return href
+	}
+	if href, ok := doc.Find(`link[rel="next"]`).Attr("href"); ok {
+		return href
+	}
+	return ""
+}
+
+func parseListingPage(doc *goquery.Document) []Event {
+	var listed []Event
+
+	doc.Find(".tribe-common-g-row.tribe-events-calendar-list__event-row").Each(func(index int, event *goquery.Selection) {
+		dateAttr, exists := event.Find("time.tribe-events-calendar-list__event-datetime").Attr("datetime")
+		if !exists {
+			return
+		}
+
+		datetime := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-datetime").Text())
+		eventLink, _ := event.Find(".tribe-events-calendar-list__event-title-link").Attr("href")
+
+		startDate := dateAttr[:min(len(dateAttr), 10)]
+		category := strings.TrimSpace(event.Find(".tribe-events-event-categories a").Text())
+		title := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-title").Text())
+		venue := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-venue-title").Text())
+		address := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-venue-address").Text())
+		description := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-description p").Text())
+
+		e := Event{
+			Date:        dateAttr,
+			StartDate:   startDate,
+			EndDate:     parseEndDate(datetime, startDate),
+			Datetime:    datetime,
+			Category:    category,
+			Title:       title,
+			EventLink:   eventLink,
+			Venue:       venue,
+			Address:     address,
+			Description: description,
+		}
+		e.ID = eventID(e)
+		listed = append(listed, e)
+	})
+
+	return listed
+}
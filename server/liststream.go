@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// With the streaming_listing flag on, listing pages are parsed token by
+// token as they download instead of being built into a goquery document,
+// so a scrape holds one event row at a time rather than the whole page.
+// It reads the same elements parseListingPage selects, by tag and class.
+
+// Data Structures
+
+type listingField int
+
+const (
+	fieldDatetime listingField = iota
+	fieldTitle
+	fieldVenue
+	fieldAddress
+	fieldCategory
+	fieldDescription
+	listingFieldCount
+)
+
+// listingElement is an open element and what it started.
+type listingElement struct {
+	tag         string
+	fields      []listingField
+	row         bool
+	categories  bool
+	description bool
+}
+
+// listingStream holds the parser's state between tokens.
+type listingStream struct {
+	stack []listingElement
+	// How many open elements capture each field's text
+	capturing [listingFieldCount]int
+	text      [listingFieldCount]strings.Builder
+	// Open .tribe-events-event-categories and description containers
+	inCategories, inDescription int
+
+	inRow               bool
+	dateAttr, eventLink string
+	haveDate, haveLink  bool
+
+	events           []Event
+	navNext, relNext string
+	haveNav, haveRel bool
+}
+
+// Global Variables
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// Helper Functions
+
+func hasClass(attrs map[string]string, class string) bool {
+	for _, c := range strings.Fields(attrs["class"]) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func tagAttrs(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, value, more := z.TagAttr()
+		if _, ok := attrs[string(key)]; !ok {
+			attrs[string(key)] = string(value)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// open handles a start tag, returning what the element starts. Void
+// elements are handled the same way but never pushed.
+func (s *listingStream) open(tag string, attrs map[string]string) listingElement {
+	el := listingElement{tag: tag}
+
+	if !s.inRow {
+		if hasClass(attrs, "tribe-common-g-row") && hasClass(attrs, "tribe-events-calendar-list__event-row") {
+			el.row = true
+			s.inRow = true
+			s.dateAttr, s.eventLink, s.haveDate, s.haveLink = "", "", false, false
+			for i := range s.text {
+				s.text[i].Reset()
+			}
+			return el
+		}
+		if href, ok := attrs["href"]; ok {
+			if tag == "a" && hasClass(attrs, "tribe-events-c-nav__next") && !s.haveNav {
+				s.navNext, s.haveNav = href, true
+			}
+			if tag == "link" && attrs["rel"] == "next" && !s.haveRel {
+				s.relNext, s.haveRel = href, true
+			}
+		}
+		return el
+	}
+
+	if hasClass(attrs, "tribe-events-calendar-list__event-datetime") {
+		el.fields = append(el.fields, fieldDatetime)
+		if value, ok := attrs["datetime"]; ok && tag == "time" && !s.haveDate {
+			s.dateAttr, s.haveDate = value, true
+		}
+	}
+	if hasClass(attrs, "tribe-events-calendar-list__event-title-link") && !s.haveLink {
+		if href, ok := attrs["href"]; ok {
+			s.eventLink, s.haveLink = href, true
+		}
+	}
+	if hasClass(attrs, "tribe-events-calendar-list__event-title") {
+		el.fields = append(el.fields, fieldTitle)
+	}
+	if hasClass(attrs, "tribe-events-calendar-list__event-venue-title") {
+		el.fields = append(el.fields, fieldVenue)
+	}
+	if hasClass(attrs, "tribe-events-calendar-list__event-venue-address") {
+		el.fields = append(el.fields, fieldAddress)
+	}
+	if tag == "a" && s.inCategories > 0 {
+		el.fields = append(el.fields, fieldCategory)
+	}
+	if tag == "p" && s.inDescription > 0 {
+		el.fields = append(el.fields, fieldDescription)
+	}
+	if hasClass(attrs, "tribe-events-event-categories") {
+		el.categories = true
+		s.inCategories++
+	}
+	if hasClass(attrs, "tribe-events-calendar-list__event-description") {
+		el.description = true
+		s.inDescription++
+	}
+	for _, f := range el.fields {
+		s.capturing[f]++
+	}
+	return el
+}
+
+// close undoes what el started, finishing the event when el is its row.
+func (s *listingStream) close(el listingElement) {
+	for _, f := range el.fields {
+		s.capturing[f]--
+	}
+	if el.categories {
+		s.inCategories--
+	}
+	if el.description {
+		s.inDescription--
+	}
+	if !el.row {
+		return
+	}
+	s.inRow = false
+	if !s.haveDate {
+		return
+	}
+
+	datetime := strings.TrimSpace(s.text[fieldDatetime].String())
+	startDate := s.dateAttr[:min(len(s.dateAttr), 10)]
+	e := Event{
+		Date:        s.dateAttr,
+		StartDate:   startDate,
+		EndDate:     parseEndDate(datetime, startDate),
+		Datetime:    datetime,
+		Category:    strings.TrimSpace(s.text[fieldCategory].String()),
+		Title:       strings.TrimSpace(s.text[fieldTitle].String()),
+		EventLink:   s.eventLink,
+		Venue:       strings.TrimSpace(s.text[fieldVenue].String()),
+		Address:     strings.TrimSpace(s.text[fieldAddress].String()),
+		Description: strings.TrimSpace(s.text[fieldDescription].String()),
+	}
+	e.ID = eventID(e)
+	s.events = append(s.events, e)
+}
+
+// parseListingStream reads a listing page from r, returning its events and
+// the next page's URL, as parseListingPage and nextListingPage would.
+func parseListingStream(r io.Reader) ([]Event, string, error) {
+	s := &listingStream{}
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, "", err
+			}
+			for len(s.stack) > 0 {
+				s.close(s.stack[len(s.stack)-1])
+				s.stack = s.stack[:len(s.stack)-1]
+			}
+			next := s.navNext
+			if !s.haveNav {
+				next = s.relNext
+			}
+			return s.events, next, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			attrs := map[string]string{}
+			if hasAttr {
+				attrs = tagAttrs(z)
+			}
+			el := s.open(tag, attrs)
+			if voidElements[tag] {
+				s.close(el)
+			} else {
+				s.stack = append(s.stack, el)
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			for i := len(s.stack) - 1; i >= 0; i-- {
+				if s.stack[i].tag != tag {
+					continue
+				}
+				for len(s.stack) > i {
+					s.close(s.stack[len(s.stack)-1])
+					s.stack = s.stack[:len(s.stack)-1]
+				}
+				break
+			}
+		case html.TextToken:
+			if !s.inRow {
+				continue
+			}
+			text := z.Text()
+			for f := range s.capturing {
+				if s.capturing[f] > 0 {
+					s.text[f].Write(text)
+				}
+			}
+		}
+	}
+}
+
+// streamListingPage fetches and parses a listing page with
+// parseListingStream.
+func streamListingPage(ctx context.Context, pageURL string) ([]Event, string, error) {
+	page, err := openPage(ctx, pageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer page.Close()
+
+	events, next, err := parseListingStream(page)
+	page.fail(err)
+	return events, next, err
+}
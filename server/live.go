@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Live map clients connect to /ws and are sent a snapshot of today's events,
+// then a diff whenever the cached events change. A client narrows what it
+// receives by subscribing with a filter in the same format as POST
+// /api/events/query:
+//
+//	{"type": "subscribe", "filter": {"bbox": [-83.39, 33.95, -83.37, 33.97], "categories": ["Music"]}}
+//
+// The server pings every liveInterval, and answers {"type": "ping"} messages
+// with {"type": "pong"} for clients that can't see protocol-level pings.
+
+const (
+	liveInterval     = 30 * time.Second
+	liveWriteTimeout = 10 * time.Second
+	maxLiveMessage   = 64 << 10
+)
+
+// Data Structures
+
+type liveRequest struct {
+	Type   string      `json:"type"`
+	Filter EventFilter `json:"filter"`
+}
+
+type liveMessage struct {
+	Type      string     `json:"type"`
+	Events    []Event    `json:"events,omitempty"`
+	Added     []Event    `json:"added,omitempty"`
+	Updated   []Event    `json:"updated,omitempty"`
+	Removed   []string   `json:"removed,omitempty"`
+	ScrapedAt *time.Time `json:"scraped_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type liveClient struct {
+	updates chan struct{}
+}
+
+// Global Variables
+var (
+	liveClients = map[*liveClient]bool{}
+	liveMutex   sync.Mutex
+)
+
+// Helper Functions
+
+// notifyLiveClients tells every connected client that the cached events
+// changed. Clients that haven't caught up with a previous change only get
+// one diff for both.
+func notifyLiveClients() {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+	for client := range liveClients {
+		select {
+		case client.updates <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func cachedEvents() ([]Event, time.Time) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return eventsCache, cacheTime
+}
+
+// diffEvents compares the events a client was last sent with the current
+// ones matching its filter.
+func diffEvents(sent map[string]Event, current []Event) (added, updated []Event, removed []string) {
+	seen := map[string]bool{}
+	for _, e := range current {
+		seen[e.ID] = true
+		old, ok := sent[e.ID]
+		switch {
+		case !ok:
+			added = append(added, e)
+		case !reflect.DeepEqual(old, e):
+			updated = append(updated, e)
+		}
+	}
+	for id := range sent {
+		if !seen[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, updated, removed
+}
+
+func matchingEvents(events []Event, filter EventFilter) []Event {
+	matched := []Event{}
+	for _, e := range events {
+		if filter.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+func sendLive(ws *websocket.Conn, message liveMessage) error {
+	ws.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	return websocket.JSON.Send(ws, message)
+}
+
+func pingLive(ws *websocket.Conn) error {
+	ws.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	ws.PayloadType = websocket.PingFrame
+	defer func() { ws.PayloadType = websocket.TextFrame }()
+	_, err := ws.Write(nil)
+	return err
+}
+
+// serveLive runs one client connection. Only this goroutine writes to ws;
+// a second one reads the client's messages.
+func serveLive(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = maxLiveMessage
+
+	client := &liveClient{updates: make(chan struct{}, 1)}
+	liveMutex.Lock()
+	liveClients[client] = true
+	liveMutex.Unlock()
+	defer func() {
+		liveMutex.Lock()
+		delete(liveClients, client)
+		liveMutex.Unlock()
+	}()
+
+	requests := make(chan liveRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(requests)
+		for {
+			var request liveRequest
+			if err := websocket.JSON.Receive(ws, &request); err != nil {
+				return
+			}
+			select {
+			case requests <- request:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if _, err := getEvents(); err != nil {
+		sendLive(ws, liveMessage{Type: "error", Error: fmt.Sprintf("Error fetching events: %v", err)})
+		return
+	}
+
+	var filter EventFilter
+	sent := map[string]Event{}
+	sendSnapshot := func() error {
+		events, scrapedAt := cachedEvents()
+		matched := matchingEvents(events, filter)
+		sent = make(map[string]Event, len(matched))
+		for _, e := range matched {
+			sent[e.ID] = e
+		}
+		return sendLive(ws, liveMessage{Type: "snapshot", Events: matched, ScrapedAt: &scrapedAt})
+	}
+	if err := sendSnapshot(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(liveInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				return
+			}
+			switch request.Type {
+			case "subscribe":
+				var verrs fieldErrors
+				if request.Filter.validate("/filter", 1, &verrs); len(verrs) > 0 {
+					err = sendLive(ws, liveMessage{Type: "error", Error: fmt.Sprintf("Invalid filter: %v", verrs)})
+					break
+				}
+				filter = request.Filter
+				err = sendSnapshot()
+			case "ping":
+				err = sendLive(ws, liveMessage{Type: "pong"})
+			default:
+				err = sendLive(ws, liveMessage{Type: "error", Error: fmt.Sprintf("Unknown message type %q", request.Type)})
+			}
+		case <-client.updates:
+			events, scrapedAt := cachedEvents()
+			added, updated, removed := diffEvents(sent, matchingEvents(events, filter))
+			if len(added)+len(updated)+len(removed) == 0 {
+				continue
+			}
+			for _, e := range append(added, updated...) {
+				sent[e.ID] = e
+			}
+			for _, id := range removed {
+				delete(sent, id)
+			}
+			err = sendLive(ws, liveMessage{Type: "diff", Added: added, Updated: updated, Removed: removed, ScrapedAt: &scrapedAt})
+		case <-ticker.C:
+			err = pingLive(ws)
+		}
+		if err != nil {
+			log.Printf("Warning: Closing live connection: %v", err)
+			return
+		}
+	}
+}
+
+// HTTP Handlers
+
+// liveHandler upgrades /ws requests to WebSocket connections. Any origin may
+// connect, like the CORS-enabled JSON endpoints.
+var liveHandler = websocket.Server{
+	Handshake: func(*websocket.Config, *http.Request) error { return nil },
+	Handler:   serveLive,
+}
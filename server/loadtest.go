@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// The loadtest command measures how fast the API filters and encodes
+// events, e.g.
+//
+//	mapthens-server loadtest -events 2000 -duration 30s -concurrency 16
+//
+// By default it seeds an in-process server with synthetic events spread
+// over the gazetteer's venues, so runs are repeatable and need neither
+// network access nor a scrape; -url points it at a running server instead.
+// Each worker sends the requests in loadScenarios in turn, and the command
+// reports requests per second and latency percentiles for each one. Runs
+// with the same -seed see the same events, so their numbers can be compared
+// before and after a change to find regressions.
+
+// Data Structures
+
+type loadScenario struct {
+	name   string
+	method string
+	path   string
+	body   string
+	header map[string]string
+}
+
+type loadResult struct {
+	latencies []time.Duration
+	errors    int
+	bytes     int64
+}
+
+// Global Variables
+var loadScenarios = []loadScenario{
+	{name: "events", method: http.MethodGet, path: "/api/events"},
+	{name: "events brotli", method: http.MethodGet, path: "/api/events", header: map[string]string{"Accept-Encoding": "br"}},
+	{name: "events v2 fields", method: http.MethodGet, path: "/api/events?v=2&fields=id,title,latitude,longitude"},
+	{name: "events filtered", method: http.MethodGet, path: "/api/events?outdoor=false&venue_type=bar,theatre&size=small"},
+	{name: "events expanded", method: http.MethodGet, path: "/api/events?expand=description,venue,series"},
+	{name: "events json:api", method: http.MethodGet, path: "/api/events?page[limit]=50", header: map[string]string{"Accept": jsonAPIMediaType}},
+	{name: "query", method: http.MethodPost, path: "/api/events/query",
+		body: `{"filter": {"any": [{"categories": ["Music"]}, {"text": "jazz"}], "bbox": [-83.45, 33.90, -83.30, 34.00]}, "limit": 50}`},
+	{name: "heatmap", method: http.MethodGet, path: "/api/events/heatmap"},
+	{name: "ics export", method: http.MethodGet, path: "/api/events.ics"},
+}
+
+var (
+	loadCategories = []string{"Music", "Art", "Theatre", "Film", "Comedy", "Food & Drink", "Community", "Sports"}
+	loadTitleWords = []string{"Jazz", "Night", "Open", "Mic", "Trivia", "Gallery", "Opening", "Market", "Screening", "Showcase", "Workshop", "Festival", "Reading", "Karaoke", "Improv"}
+)
+
+// Helper Functions
+
+// seedLoadEvents makes n synthetic events for today, spread over the
+// gazetteer's venues, with the same events for the same seed.
+func seedLoadEvents(n int, seed int64) []Event {
+	random := rand.New(rand.NewSource(seed))
+	// Sorted, and without aliases, so the same seed picks the same venues
+	tablesMutex.RLock()
+	byName := map[string]VenueInfo{}
+	for _, info := range venueTable {
+		byName[info.Name] = info
+	}
+	tablesMutex.RUnlock()
+	venues := make([]VenueInfo, 0, len(byName))
+	for _, info := range byName {
+		venues = append(venues, info)
+	}
+	sort.Slice(venues, func(i, j int) bool { return venues[i].Name < venues[j].Name })
+	day := localNow()
+	date := day.Format("2006-01-02")
+	center := getConfig().MapCenter
+	scrapedAt := now()
+
+	events := make([]Event, n)
+	for i := range events {
+		venue := venues[random.Intn(len(venues))]
+		words := make([]string, 2+random.Intn(3))
+		for j := range words {
+			words[j] = loadTitleWords[random.Intn(len(loadTitleWords))]
+		}
+		e := Event{
+			Date:        date,
+			Category:    loadCategories[random.Intn(len(loadCategories))],
+			Title:       fmt.Sprintf("%s #%d", strings.Join(words, " "), i),
+			EventLink:   fmt.Sprintf("https://example.com/events/%s/%d", date, i),
+			Venue:       venue.Name,
+			Address:     venue.Name + ", Athens, GA",
+			Description: strings.Repeat(strings.Join(words, " ")+" with friends. ", 5+random.Intn(40)),
+			SourceName:  originFlagpole,
+			ScrapedAt:   scrapedAt,
+		}
+		if random.Intn(10) == 0 {
+			e.Datetime = day.Format("Monday, January 2") + " @ All Day"
+		} else {
+			hour, minute := 10+random.Intn(13), 15*random.Intn(4)
+			e.Datetime = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location()).Format("Monday, January 2 @ 3:04 pm")
+		}
+		// Venues without gazetteer coordinates get some near the map center
+		if !venue.hasCoordinates() {
+			e.Latitude = center.Latitude + (random.Float64()-0.5)*0.05
+			e.Longitude = center.Longitude + (random.Float64()-0.5)*0.05
+		}
+		e.ID = eventID(e)
+		events[i] = e
+	}
+	return events
+}
+
+// startLoadServer serves seeded events from an in-process server, returning
+// its URL and a function that stops it.
+func startLoadServer(n int, seed int64) (string, func(), error) {
+	if err := loadTables(getConfig()); err != nil {
+		return "", nil, err
+	}
+	events := seedLoadEvents(n, seed)
+	mutex.Lock()
+	setEventsCache(events, now())
+	mutex.Unlock()
+
+	registerRoutes()
+	server := httptest.NewServer(http.DefaultServeMux)
+	return server.URL, server.Close, nil
+}
+
+// runLoad sends the scenarios' requests from concurrency workers until
+// duration has passed.
+func runLoad(baseURL string, scenarios []loadScenario, concurrency int, duration time.Duration) []loadResult {
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: concurrency, DisableCompression: true},
+	}
+	results := make([]loadResult, len(scenarios))
+	var resultsMutex sync.Mutex
+	deadline := time.Now().Add(duration)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; time.Now().Before(deadline); i++ {
+				index := i % len(scenarios)
+				latency, n, err := sendLoadRequest(client, baseURL, scenarios[index])
+				resultsMutex.Lock()
+				if err != nil {
+					results[index].errors++
+				} else {
+					results[index].latencies = append(results[index].latencies, latency)
+					results[index].bytes += n
+				}
+				resultsMutex.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+	return results
+}
+
+// sendLoadRequest times one request until its whole body is read.
+func sendLoadRequest(client *http.Client, baseURL string, s loadScenario) (time.Duration, int64, error) {
+	req, err := http.NewRequest(s.method, baseURL+s.path, strings.NewReader(s.body))
+	if err != nil {
+		return 0, 0, err
+	}
+	for name, value := range s.header {
+		req.Header.Set(name, value)
+	}
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s: status code %d", s.name, resp.StatusCode)
+	}
+	return time.Since(started), n, nil
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(float64(len(sorted))*p/100), len(sorted)-1)]
+}
+
+func writeLoadReport(w io.Writer, scenarios []loadScenario, results []loadResult, duration time.Duration) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "scenario\treqs\treq/s\terrors\tavg KB\tp50\tp90\tp99\tmax\t")
+	round := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	for i, s := range scenarios {
+		r := results[i]
+		sort.Slice(r.latencies, func(a, b int) bool { return r.latencies[a] < r.latencies[b] })
+		avgKB := 0.0
+		if len(r.latencies) > 0 {
+			avgKB = float64(r.bytes) / float64(len(r.latencies)) / 1024
+		}
+		fmt.Fprintf(table, "%s\t%d\t%.1f\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n", s.name, len(r.latencies),
+			float64(len(r.latencies))/duration.Seconds(), r.errors, avgKB,
+			round(percentile(r.latencies, 50)), round(percentile(r.latencies, 90)),
+			round(percentile(r.latencies, 99)), round(percentile(r.latencies, 100)))
+	}
+	table.Flush()
+}
+
+func loadTest(args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := flags.String("url", "", "base URL of a running server to load (default an in-process server with seeded events)")
+	events := flags.Int("events", 1000, "events to seed the in-process server with")
+	seed := flags.Int64("seed", 1, "seed for the synthetic events")
+	concurrency := flags.Int("concurrency", 8, "requests in flight at once")
+	duration := flags.Duration("duration", 10*time.Second, "how long to send requests for")
+	only := flags.String("scenarios", "", "comma-separated scenarios to run (default all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 || *events < 1 {
+		return fmt.Errorf("-concurrency and -events must be positive")
+	}
+
+	scenarios := loadScenarios
+	if *only != "" {
+		scenarios = nil
+		for _, name := range strings.Split(*only, ",") {
+			found := false
+			for _, s := range loadScenarios {
+				if s.name == strings.TrimSpace(name) {
+					scenarios, found = append(scenarios, s), true
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown scenario %q", name)
+			}
+		}
+	}
+
+	baseURL := strings.TrimSuffix(*target, "/")
+	if baseURL == "" {
+		url, stop, err := startLoadServer(*events, *seed)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		baseURL = url
+		fmt.Printf("Seeded an in-process server with %d events\n", *events)
+	}
+	// One request per scenario first, so a misconfigured target fails fast
+	// rather than after the whole run
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, s := range scenarios {
+		if _, _, err := sendLoadRequest(client, baseURL, s); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Loading %s with %d workers for %v\n\n", baseURL, *concurrency, *duration)
+	results := runLoad(baseURL, scenarios, *concurrency, *duration)
+	writeLoadReport(os.Stdout, scenarios, results, *duration)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The benchmarks run the handlers' hot paths over the same seeded events as
+// the loadtest command, so a regression found with one can be narrowed down
+// with the other, e.g.
+//
+//	go test -run '^$' -bench . -benchmem
+
+const benchmarkEventCount = 1000
+
+// seedBenchmark caches the loadtest command's events for the default seed,
+// returning them.
+func seedBenchmark(b *testing.B) []Event {
+	b.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	previous := getConfig()
+	b.Cleanup(func() { setConfig(previous) })
+	setConfig(cfg)
+	if err := loadTables(cfg); err != nil {
+		b.Fatal(err)
+	}
+
+	events := seedLoadEvents(benchmarkEventCount, 1)
+	mutex.Lock()
+	setEventsCache(events, now())
+	cached := eventsCache
+	mutex.Unlock()
+	return cached
+}
+
+func BenchmarkFilterEvents(b *testing.B) {
+	events := seedBenchmark(b)
+	r := httptest.NewRequest(http.MethodGet, "/api/events?outdoor=false&venue_type=bar,theatre&size=small&featured=false&family=false", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := filterEvents(httptest.NewRecorder(), r, events); !ok {
+			b.Fatal("filterEvents rejected the request")
+		}
+	}
+}
+
+func BenchmarkWriteEventsResponse(b *testing.B) {
+	events := seedBenchmark(b)
+	info := CacheInfo{ScrapedAt: now()}
+	for _, bench := range []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{"v1", "/api/events", ""},
+		{"v2 fields", "/api/events?v=2&fields=id,title,latitude,longitude", ""},
+		{"expanded", "/api/events?expand=description,venue,series", ""},
+		{"json:api", "/api/events?page[limit]=50", jsonAPIMediaType},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, bench.path, nil)
+			if bench.accept != "" {
+				r.Header.Set("Accept", bench.accept)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// Expansions and ?tz= rewrite events in place
+				page := append([]Event(nil), events...)
+				rec := httptest.NewRecorder()
+				writeEventsResponse(rec, r, page, len(page), eventOrder, info)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("%s: status code %d: %s", bench.path, rec.Code, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkICSExport(b *testing.B) {
+	seedBenchmark(b)
+	r := httptest.NewRequest(http.MethodGet, "/api/events.ics", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		icsHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status code %d: %s", rec.Code, rec.Body)
+		}
+	}
+}
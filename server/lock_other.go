@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockFile is a no-op on platforms without flock; atomic writes still keep
+// the cache file consistent, but concurrent refreshes may both scrape.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
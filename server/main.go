@@ -1,23 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"mapthens-server/pkg/archive"
 )
 
 // Data Structures
 
 type Event struct {
+	ID          string  `json:"id"`
 	Date        string  `json:"date"`
+	StartDate   string  `json:"start_date"`
+	EndDate     string  `json:"end_date"`
 	Datetime    string  `json:"datetime"`
 	Category    string  `json:"category"`
 	Title       string  `json:"title"`
@@ -25,8 +33,55 @@ type Event struct {
 	Venue       string  `json:"venue"`
 	Address     string  `json:"address"`
 	Description string  `json:"description"`
+	Outdoor     bool    `json:"outdoor"`
+	Featured    bool    `json:"featured"`
+	LinkBroken  bool    `json:"link_broken"`
 	Latitude    float64 `json:"latitude"`
 	Longitude   float64 `json:"longitude"`
+	// Worked out from Date and Datetime by eventTimes. StartTime is nil for
+	// all-day events, which include listings whose time is TBA.
+	AllDay    bool       `json:"all_day"`
+	StartTime *time.Time `json:"start_time"`
+	// Provenance: the listing the event was read from, when it was scraped,
+	// and where its coordinates came from (one of the geocode* constants)
+	SourceName      string    `json:"source_name"`
+	SourceURL       string    `json:"source_url"`
+	ScrapedAt       time.Time `json:"scraped_at"`
+	GeocodeProvider string    `json:"geocode_provider,omitempty"`
+	// Merged fields taken from a listing other than SourceName's, by JSON
+	// field name, with the source each came from; see sources.go
+	FieldSources map[string]string `json:"field_sources,omitempty"`
+	// Set on events that first appeared in an incremental re-scrape, i.e.
+	// were listed after the day's first scrape
+	Added bool `json:"added,omitempty"`
+	// From the venue gazetteer; empty or zero for unknown venues
+	VenueType     string `json:"venue_type,omitempty"`
+	VenueCapacity int    `json:"venue_capacity,omitempty"`
+	// Classified by the rules in family.go
+	FamilyFriendly bool `json:"family_friendly"`
+	// From the event's ticketing page, for ticketed events; see tickets.go
+	Tickets *Tickets `json:"tickets,omitempty"`
+	// From the venue's own calendar, for venues the gazetteer gives an
+	// events page; see venuecal.go
+	SupportActs []string   `json:"support_acts,omitempty"`
+	DoorTime    *time.Time `json:"door_time,omitempty"`
+	// Set when Description was shortened for a list response; see
+	// truncate.go
+	DescriptionTruncated bool `json:"description_truncated,omitempty"`
+	// Only set on responses to requests that pass ?from=lat,lng
+	WalkingMinutes *int `json:"walking_minutes,omitempty"`
+	// Only set on /api/events/nearby responses
+	DistanceMeters *float64 `json:"distance_meters,omitempty"`
+	// Links to the event's share page as /s/{code}; see shortlink.go
+	ShortCode string `json:"short_code,omitempty"`
+	// Only set on responses that ask for them with ?expand=; see expand.go
+	VenueDetail *VenueDetail       `json:"venue_detail,omitempty"`
+	Series      []SeriesOccurrence `json:"series,omitempty"`
+	Weather     *Weather           `json:"weather,omitempty"`
+	// Where to draw the event's marker, moved off others at the same
+	// point; see display.go
+	DisplayLatitude  *float64 `json:"display_latitude,omitempty"`
+	DisplayLongitude *float64 `json:"display_longitude,omitempty"`
 }
 
 type MapboxResponse struct {
@@ -38,42 +93,197 @@ type MapboxResponse struct {
 }
 
 type APIResponse struct {
-	Events      []Event `json:"events"`
-	MapboxToken string  `json:"mapbox_token"`
+	// Version is 1, or 2 when the client asked for ?v=2
+	Version int     `json:"version"`
+	Events  []Event `json:"events"`
+	// Left out of the v2 envelope; clients read it from /api/config
+	MapboxToken    string    `json:"mapbox_token,omitempty"`
+	ScrapedAt      time.Time `json:"scraped_at"`
+	DataAgeSeconds int64     `json:"data_age_seconds"`
+	// Total counts every matching event, including any left out by a limit
+	// or offset
+	Total int `json:"total"`
+	// Order names the sort keys of Events: eventOrder, "distance" for
+	// nearby queries, or "boost," and eventOrder when ?boost_venues= or
+	// ?boost_categories= reordered them
+	Order string `json:"order"`
+	// Bounds ([min lng, min lat, max lng, max lat]) and Centroid ([lng, lat])
+	// cover the geocoded events in Events; both are left out when none are
+	Bounds   *[4]float64 `json:"bounds,omitempty"`
+	Centroid *[2]float64 `json:"centroid,omitempty"`
+	// AsOf says which publication ?as_of= was answered with; see asof.go
+	AsOf *AsOfInfo `json:"as_of,omitempty"`
+	// TimeZone names the zone timestamps were converted to, when ?tz= or
+	// Accept-Language asked for one; see tz.go
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// CacheInfo describes where a response's events came from. Status is one of
+// cacheHit, cacheMiss, or cacheStale and is reported in the Cache-Status
+// header.
+type CacheInfo struct {
+	Status    string
+	ScrapedAt time.Time
+	// AsOf is set for ?as_of= requests; see asof.go
+	AsOf *AsOfInfo
 }
 
 // Global Variables
 var (
-	eventsCache []Event
-	cacheTime   time.Time
-	mutex       sync.RWMutex
-	dataFile    = "events.json"
+	scrapedEvents []Event // as scraped, before venue tables are applied
+	eventsCache   []Event
+	cacheTime     time.Time
+	mutex         sync.RWMutex
+	dataFile      = "events"
+	lockName      = "refresh.lock"
+
+	lastRefreshFailure time.Time
+	lastRefreshError   error
+	refreshRetryDelay  = time.Minute
+	// refreshing is closed when the in-flight refresh finishes, and is nil
+	// when none is running
+	refreshing chan struct{}
+)
+
+const (
+	cacheHit   = "hit"   // served from memory
+	cacheMiss  = "miss"  // loaded from disk or freshly scraped
+	cacheStale = "stale" // past its TTL, and the refresh failed or is still running
 )
 
 // Helper Functions
 
-func geocodeAddress(address string) (float64, float64, error) {
-	accessToken := os.Getenv("MAPBOX_ACCESS_TOKEN")
+// eventID derives a stable identifier for an event. Flagpole event links
+// already include the occurrence date, so they are unique per listing.
+func eventID(e Event) string {
+	key := e.EventLink
+	if key == "" {
+		key = e.Date + "|" + e.Venue + "|" + e.Title
+	}
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// normalizeEvent fills in the fields derived from scraped data and the venue
+// tables, after laying any admin edit over it. Edited coordinates win over
+// coordinate overrides, which win over gazetteer coordinates, which in turn
+// win over geocoded ones.
+func normalizeEvent(e *Event) {
+	if e.ID == "" {
+		e.ID = eventID(*e)
+	}
+	coordinatesEdited := applyEdit(e)
+	// Older cache files predate start and end dates
+	if e.StartDate == "" {
+		e.StartDate = e.Date[:min(len(e.Date), 10)]
+	}
+	if e.EndDate == "" {
+		e.EndDate = e.StartDate
+	}
+	e.Outdoor = isOutdoor(*e)
+	e.VenueType, e.VenueCapacity = "", 0
+	if venue, ok := lookupVenue(e.Venue); ok {
+		e.VenueType, e.VenueCapacity = venue.Type, venue.Capacity
+	}
+	e.FamilyFriendly = isFamilyFriendly(*e)
+	setStartTime(e)
+
+	if coordinatesEdited {
+		// Edited coordinates win over everything else
+	} else if lat, lng, ok := overrideCoordinates(*e); ok {
+		e.Latitude, e.Longitude = lat, lng
+		e.GeocodeProvider = geocodeOverride
+	} else if venue, ok := lookupVenue(e.Venue); ok && venue.hasCoordinates() {
+		e.Latitude, e.Longitude = venue.Latitude, venue.Longitude
+		e.GeocodeProvider = geocodeGazetteer
+	}
+
+	e.LinkBroken = isLinkBroken(e.EventLink)
+	e.Tickets = ticketsFor(e.EventLink)
+	if e.LinkBroken && getConfig().LinkFallback {
+		if venue, ok := lookupVenue(e.Venue); ok && venue.Website != "" {
+			e.EventLink = venue.Website
+		}
+	}
+}
+
+func normalizeEvents(events []Event) []Event {
+	normalized := make([]Event, len(events))
+	for i, e := range events {
+		normalizeEvent(&e)
+		normalized[i] = e
+	}
+	return normalized
+}
+
+// setEventsCache stores freshly scraped or loaded events. Callers must hold
+// mutex.
+func setEventsCache(events []Event, scrapedAt time.Time) {
+	// Files saved before events recorded their own scrape time
+	for i := range events {
+		if events[i].ScrapedAt.IsZero() {
+			events[i].ScrapedAt = scrapedAt
+		}
+	}
+	scrapedEvents = events
+	eventsCache = normalizeEvents(withSubmissions(withPushedEvents(events, today()), today()))
+	// Edits may have moved events
+	sortEvents(eventsCache)
+	cacheIndex = buildSpatialIndex(eventsCache)
+	cacheTime = scrapedAt
+	notifyLiveClients()
+}
+
+// renormalizeCache re-applies the venue tables to the cached events, e.g.
+// after they were reloaded, and picks up newly approved submissions and
+// pushed events.
+func renormalizeCache() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	eventsCache = normalizeEvents(withSubmissions(withPushedEvents(scrapedEvents, today()), today()))
+	sortEvents(eventsCache)
+	cacheIndex = buildSpatialIndex(eventsCache)
+	notifyLiveClients()
+}
+
+func geocodeAddress(ctx context.Context, address string) (float64, float64, error) {
+	cfg := getConfig()
+	accessToken := cfg.MapboxToken
 	if accessToken == "" {
-		return 0, 0, fmt.Errorf("MAPBOX_ACCESS_TOKEN not set")
+		return 0, 0, errTokenMissing
 	}
 
 	baseURL := "https://api.mapbox.com/search/geocode/v6/forward"
 	params := url.Values{}
 	params.Add("q", address)
 	params.Add("access_token", accessToken)
+	if cfg.GeocodingMode == geocodingPermanent {
+		params.Add("permanent", "true")
+	}
 
 	requestURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
-	resp, err := http.Get(requestURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error making request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		setTokenHealth(tokenInvalid)
+		return 0, 0, fmt.Errorf("%w: status code %d", errTokenRejected, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return 0, 0, pauseMapbox(resp)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return 0, 0, fmt.Errorf("non-200 status code: %d", resp.StatusCode)
 	}
+	countGeocodeRequests(cfg.GeocodingMode, 1)
 
 	var result MapboxResponse
 	decoder := json.NewDecoder(resp.Body)
@@ -91,122 +301,325 @@ func geocodeAddress(address string) (float64, float64, error) {
 	return longitude, latitude, nil
 }
 
-func scrapeEvents() ([]Event, error) {
-	log.Println("Scraping events from flagpole.com...")
-	resp, err := http.Get("https://flagpole.com/events/")
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch events page: %v", err)
+// scrapeEvents scrapes today's events from origins, or from every source
+// when origins is nil; the other sources' events come from their last
+// listing today (see sources.go). With previous events from earlier today,
+// the scrape is merged into them in incremental mode, or takes their
+// coordinates when only some sources were scraped, so only new addresses
+// are geocoded.
+func scrapeEvents(ctx context.Context, previous []Event, origins []string) ([]Event, scrapeFindings, error) {
+	cfg := getConfig()
+	day := today()
+	var findings scrapeFindings
+	// Sources without a listing today are scraped whether or not they're
+	// due, unless they're quarantined
+	scrape := func(origin string) bool {
+		if sourceQuarantined(origin) {
+			log.Printf("Skipping quarantined source %s.", origin)
+			return false
+		}
+		_, listed := lastSourceListing(origin, day)
+		return origins == nil || containsString(origins, origin) || !listed
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	if scrape(originFlagpole) {
+		log.Println("Scraping events from flagpole.com...")
+		var listed []Event
+		var err error
+		if cfg.ListingSource == listingShadow {
+			listed, findings.Shadow, err = shadowListEvents(ctx, day)
+		} else {
+			listed, err = listEvents(ctx, day)
+		}
+		recordSourceResult(originFlagpole, err)
+		if err != nil {
+			return nil, findings, err
+		}
+		recordSourceListing(originFlagpole, day, listed)
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %v", err)
+	if cfg.UGACalendarURL != "" && scrape(originUGA) {
+		uga, err := fetchUGAEvents(ctx, cfg.UGACalendarURL, day)
+		recordSourceResult(originUGA, err)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch the UGA calendar: %v", err)
+		} else {
+			recordSourceListing(originUGA, day, uga)
+		}
 	}
-
-	today := time.Now().Format("2006-01-02")
-	var eventList []Event
-
-	doc.Find(".tribe-common-g-row.tribe-events-calendar-list__event-row").Each(func(index int, event *goquery.Selection) {
-		dateAttr, exists := event.Find("time.tribe-events-calendar-list__event-datetime").Attr("datetime")
-		if !exists || !strings.HasPrefix(dateAttr, today) {
-			return
+	if len(venueCalendars()) > 0 && scrape(originVenueCalendar) {
+		calendar, err := fetchVenueCalendars(ctx, day)
+		recordSourceResult(originVenueCalendar, err)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch the venue calendars: %v", err)
+		} else {
+			recordSourceListing(originVenueCalendar, day, calendar)
 		}
+	}
 
-		datetime := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-datetime").Text())
-		category := strings.TrimSpace(event.Find(".tribe-events-event-categories a").Text())
-		title := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-title").Text())
-		eventLink, _ := event.Find(".tribe-events-calendar-list__event-title-link").Attr("href")
-		venue := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-venue-title").Text())
-		address := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-venue-address").Text())
-		description := strings.TrimSpace(event.Find(".tribe-events-calendar-list__event-description p").Text())
+	listed, _ := lastSourceListing(originFlagpole, day)
+	if uga, ok := lastSourceListing(originUGA, day); ok && cfg.UGACalendarURL != "" {
+		listed, findings.Conflicts = mergeSources(append(listed, uga...), cfg.SourcePriority)
+	}
+	if calendar, ok := lastSourceListing(originVenueCalendar, day); ok && len(venueCalendars()) > 0 {
+		var conflicts []SourceConflict
+		listed, conflicts = mergeSources(append(listed, matchVenueCalendar(listed, calendar)...), cfg.SourcePriority)
+		findings.Conflicts = append(findings.Conflicts, conflicts...)
+	}
+
+	// Multi-day events are included on every day they run
+	eventList := runningOn(ctx, listed, day)
 
-		longitude, latitude, err := geocodeAddress(address)
-		if err != nil {
-			log.Printf("Error geocoding address '%s': %v", address, err)
-			// Keep going even if geocoding fails, maybe set to 0,0 or omit
-			latitude = 0
-			longitude = 0
-		} else {
-			// Small delay to be nice to the API if processing many
-			time.Sleep(100 * time.Millisecond)
-		}
-
-		eventList = append(eventList, Event{
-			Date:        dateAttr,
-			Datetime:    datetime,
-			Category:    category,
-			Title:       title,
-			EventLink:   eventLink,
-			Venue:       venue,
-			Address:     address,
-			Description: description,
-			Latitude:    latitude,
-			Longitude:   longitude,
-		})
-	})
-	
 	log.Printf("Scraped %d events.", len(eventList))
-	return eventList, nil
+	markFeatured(ctx, eventList)
+	switch {
+	case len(previous) == 0:
+	case cfg.RefreshMode == refreshIncremental:
+		eventList = mergeEvents(previous, eventList)
+	default:
+		reuseCoordinates(previous, eventList)
+	}
+	geocodeEvents(ctx, eventList)
+	return eventList, findings, nil
 }
 
 func saveEventsToFile(events []Event) error {
-	data, err := json.MarshalIndent(events, "", "  ")
+	return writeEventsFile(dataFile, events)
+}
+
+// loadEventsFromFile returns the cached events along with the time they were
+// scraped, taken from the file's modification time. Events that ended before
+// today are dropped, so a file left over from a previous day comes back
+// empty and gets re-scraped.
+func loadEventsFromFile() ([]Event, time.Time, error) {
+	info, err := os.Stat(dataFile)
+	if os.IsNotExist(err) && getConfig().DeploymentMode == deploymentSelfHosted {
+		return loadLatestSnapshot()
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	events, err := readEventsFile(dataFile)
 	if err != nil {
-		return err
+		return nil, time.Time{}, err
+	}
+	current := dropPastEvents(events, today())
+	if dropped := len(events) - len(current); dropped > 0 {
+		log.Printf("Dropped %d past events from the events file.", dropped)
+	}
+	// Files saved before events were sorted on save
+	sortEvents(current)
+	return current, info.ModTime(), nil
+}
+
+// dropPastEvents filters out events that ended before day.
+func dropPastEvents(events []Event, day string) []Event {
+	current := []Event{}
+	for _, e := range events {
+		// Older cache files predate end dates
+		end := e.EndDate
+		if end == "" {
+			end = e.Date[:min(len(e.Date), 10)]
+		}
+		if end >= day {
+			current = append(current, e)
+		}
 	}
-	return os.WriteFile(dataFile, data, 0644)
+	return current
 }
 
-func loadEventsFromFile() ([]Event, error) {
-	data, err := os.ReadFile(dataFile)
+// refreshEvents scrapes and persists a fresh set of events while holding the
+// cache directory's refresh lock, so only one server process scrapes at a
+// time. Events another process saved after freshSince are used instead.
+// With origins, only those sources are scraped; see scrapeEvents.
+func refreshEvents(freshSince time.Time, origins []string) ([]Event, time.Time, error) {
+	unlock, err := lockFile(cachePath(lockName))
+	if err != nil {
+		log.Printf("Warning: Failed to take refresh lock: %v", err)
+	} else {
+		defer unlock()
+	}
+
+	// Another process may have finished a refresh while we waited for the
+	// lock, or refreshed a source since this one last did
+	loadSourceListings()
+	cfg := getConfig()
+	previous, previousAt, err := loadEventsFromFile()
+	if err == nil && len(previous) > 0 && previousAt.After(freshSince) {
+		log.Println("Loaded events refreshed by another process.")
+		return previous, previousAt, nil
+	}
+	if (cfg.RefreshMode != refreshIncremental && origins == nil) || archive.DateKey(previousAt.In(cfg.Location)) != today() {
+		previous = nil
+	}
+
+	started := now()
+	raw := startRawRun()
+	events, findings, err := watchedScrape(previous, origins)
+	finishRawRun(raw)
+	if err != nil {
+		m := collectRunMetrics(nil, started, err)
+		m.Shadow = findings.Shadow
+		m.TimedOut = errors.Is(err, errScrapeTimeout)
+		emitRunMetrics(m)
+		return nil, time.Time{}, err
+	}
+	scrapedAt := now()
+	for i := range events {
+		// Merged events that weren't re-listed keep their original time
+		if events[i].ScrapedAt.IsZero() {
+			events[i].ScrapedAt = scrapedAt
+		}
+	}
+	sortEvents(events)
+	assignShortCodes(events)
+
+	hash, err := snapshotHash(events)
 	if err != nil {
-		return nil, err
+		log.Printf("Warning: Failed to hash events: %v", err)
+	}
+	unchanged := hash != "" && snapshotUnchanged(hash)
+	if unchanged {
+		log.Println("Events unchanged since the last scrape, skipping save.")
+		if err := os.Chtimes(dataFile, scrapedAt, scrapedAt); err != nil {
+			log.Printf("Warning: Failed to mark events file fresh: %v", err)
+		}
+	} else if err := saveEventsToFile(events); err != nil {
+		log.Printf("Warning: Failed to save events to file: %v", err)
+	} else {
+		if hash != "" {
+			if err := saveSnapshotHash(hash); err != nil {
+				log.Printf("Warning: Failed to save snapshot hash: %v", err)
+			}
+		}
+		normalized := normalizeEvents(events)
+		if err := eventStore.SaveEvents(today(), normalized, scrapedAt); err != nil {
+			log.Printf("Warning: Failed to save events to the event store: %v", err)
+		}
+		if err := updateRecentEvents(normalized, scrapedAt); err != nil {
+			log.Printf("Warning: Failed to save recent events: %v", err)
+		}
+		if cfg := getConfig(); cfg.ParquetDir != "" {
+			if err := exportParquet(cfg.ParquetDir, today(), normalized); err != nil {
+				log.Printf("Warning: Failed to export events to Parquet: %v", err)
+			} else if err := writeCatalog(cfg.ParquetDir, cfg.ParquetLocation); err != nil {
+				log.Printf("Warning: Failed to write the Parquet catalog: %v", err)
+			}
+		}
+	}
+	// An unchanged scrape may still be the first of a new day
+	if _, err := os.Stat(snapshotFile(today())); getConfig().SnapshotDays > 0 && (!unchanged || err != nil) {
+		snapshot := normalizeEvents(events)
+		if err := saveDailySnapshot(today(), snapshot); err != nil {
+			log.Printf("Warning: Failed to save the daily snapshot: %v", err)
+		} else {
+			if err := saveSnapshotDiff(today(), snapshot); err != nil {
+				log.Printf("Warning: Failed to save the daily snapshot diff: %v", err)
+			}
+			if err := recordPublication(today(), snapshot, scrapedAt, hash); err != nil {
+				log.Printf("Warning: Failed to record the publication: %v", err)
+			}
+			latest := archive.Latest{Date: today(), File: archive.SnapshotName(today()), Events: len(snapshot), UpdatedAt: scrapedAt}
+			if err := archive.WriteLatest(snapshotDir, latest); err != nil {
+				log.Printf("Warning: Failed to point at the latest snapshot: %v", err)
+			}
+		}
 	}
-	var events []Event
-	if err := json.Unmarshal(data, &events); err != nil {
-		return nil, err
+	applyRetention()
+
+	m := collectRunMetrics(events, started, nil)
+	m.Conflicts, m.Shadow = findings.Conflicts, findings.Shadow
+	if unchanged {
+		m.BytesWritten = 0
 	}
-	return events, nil
+	emitRunMetrics(m)
+	return events, scrapedAt, nil
 }
 
 func getEvents() ([]Event, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	events, _, err := getEventsWithInfo()
+	return events, err
+}
+
+// getEventsWithInfo returns the current events, reloading or re-scraping them
+// once they are older than the configured cache TTL. If a refresh fails the
+// previous events are served as stale rather than failing the request.
+//
+// mutex only guards reading and swapping the cache; file I/O and scraping
+// happen outside it. Only one refresh runs at a time: while it does, other
+// requests are served the stale events, or wait for it if there are none.
+func getEventsWithInfo() ([]Event, CacheInfo, error) {
+	mutex.RLock()
+	events, scrapedAt := eventsCache, cacheTime
+	mutex.RUnlock()
+
+	status := cacheHit
 
-	// Check if we need to scrape (e.g., file doesn't exist or is old)
-	// For simplicity, let's just check if it exists and scrape if not.
-	// You might want to add logic to re-scrape daily.
-	
 	// If in-memory cache is empty, try loading from file
-	if len(eventsCache) == 0 {
-		if _, err := os.Stat(dataFile); err == nil {
-			// File exists, load it
-			events, err := loadEventsFromFile()
-			if err == nil {
-				eventsCache = events
+	if len(events) == 0 {
+		status = cacheMiss
+		if loaded, loadedAt, err := loadEventsFromFile(); err == nil && len(loaded) > 0 {
+			mutex.Lock()
+			if len(eventsCache) == 0 {
+				setEventsCache(loaded, loadedAt)
 				log.Println("Loaded events from local file.")
 			}
+			events, scrapedAt = eventsCache, cacheTime
+			mutex.Unlock()
 		}
 	}
 
-	// If still empty (file didn't exist or error), scrape
-	if len(eventsCache) == 0 {
-		events, err := scrapeEvents()
-		if err != nil {
-			return nil, err
+	// Self-hosted servers only refresh on their schedule; see deployment.go
+	if cfg := getConfig(); len(events) > 0 && (since(scrapedAt) <= cfg.CacheTTL || cfg.DeploymentMode == deploymentSelfHosted) {
+		return events, CacheInfo{Status: status, ScrapedAt: scrapedAt}, nil
+	}
+
+	// Empty or too old. After a failed refresh, keep serving stale events for
+	// a while instead of retrying on every request.
+	mutex.Lock()
+	if len(eventsCache) > 0 && (refreshing != nil || since(lastRefreshFailure) < refreshRetryDelay) {
+		defer mutex.Unlock()
+		return eventsCache, CacheInfo{Status: cacheStale, ScrapedAt: cacheTime}, nil
+	}
+	if done := refreshing; done != nil {
+		mutex.Unlock()
+		<-done
+
+		mutex.RLock()
+		defer mutex.RUnlock()
+		if len(eventsCache) == 0 {
+			return nil, CacheInfo{}, lastRefreshError
 		}
-		eventsCache = events
-		if err := saveEventsToFile(events); err != nil {
-			log.Printf("Warning: Failed to save events to file: %v", err)
+		return eventsCache, CacheInfo{Status: cacheMiss, ScrapedAt: cacheTime}, nil
+	}
+	done := make(chan struct{})
+	refreshing = done
+	mutex.Unlock()
+
+	fresh, freshAt, err := refreshEvents(now().Add(-getConfig().CacheTTL), nil)
+
+	mutex.Lock()
+	refreshing = nil
+	lastRefreshError = err
+	if err != nil {
+		lastRefreshFailure = now()
+		if errors.Is(err, errScrapeTimeout) {
+			scheduleRefreshRetry()
 		}
+	} else {
+		setEventsCache(fresh, freshAt)
 	}
+	events, scrapedAt = eventsCache, cacheTime
+	mutex.Unlock()
+	close(done)
 
-	return eventsCache, nil
+	if err != nil {
+		if len(events) == 0 {
+			return nil, CacheInfo{}, err
+		}
+		log.Printf("Warning: Failed to refresh events, serving stale cache: %v", err)
+		return events, CacheInfo{Status: cacheStale, ScrapedAt: scrapedAt}, nil
+	}
+	return events, CacheInfo{Status: cacheMiss, ScrapedAt: scrapedAt}, nil
 }
 
 // HTTP Handlers
@@ -216,36 +629,328 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if r.URL.Query().Has("dates") {
+		batchEventsHandler(w, r)
+		return
+	}
+	if r.URL.Query().Has("as_of") {
+		asOfEventsHandler(w, r)
+		return
+	}
 
-	events, err := getEvents()
+	events, info, err := getEventsWithInfo()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	events, ok := filterEvents(w, r, events)
+	if !ok {
+		return
+	}
+
+	if value := r.URL.Query().Get("from"); value != "" {
+		origin, err := parseOrigin(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid from parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := checkWalkingOrigin(origin); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid from parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		if tokenUsable() && flagEnabled(flagWalkingTimes) {
+			events = withWalkingTimes(events, origin)
+		}
+	}
+
+	order := eventOrder
+	boost, err := parseBoost(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid boost parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	if boost != nil {
+		events, order = boostEvents(events, boost), "boost,"+eventOrder
+	}
+
+	writeEventsResponse(w, r, events, len(events), order, info)
+}
+
+// filterEvents applies the ?outdoor=, ?venue_type=, ?size=, ?featured=, and
+// ?family= filters, answering 400 itself when one is invalid.
+func filterEvents(w http.ResponseWriter, r *http.Request, events []Event) ([]Event, bool) {
+	if value := r.URL.Query().Get("outdoor"); value != "" {
+		outdoor, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid outdoor parameter", http.StatusBadRequest)
+			return nil, false
+		}
+		events = filterByOutdoor(events, outdoor)
+	}
+
+	if types := r.URL.Query().Get("venue_type"); types != "" {
+		events = filterByVenueType(events, strings.Split(types, ","))
+	}
+
+	if size := r.URL.Query().Get("size"); size != "" {
+		if size != "small" && size != "medium" && size != "large" {
+			http.Error(w, "Invalid size parameter: must be small, medium, or large", http.StatusBadRequest)
+			return nil, false
+		}
+		events = filterByVenueSize(events, size)
+	}
+
+	if value := r.URL.Query().Get("featured"); value != "" {
+		featured, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid featured parameter", http.StatusBadRequest)
+			return nil, false
+		}
+		events = filterByFeatured(events, featured)
+	}
+
+	if value := r.URL.Query().Get("family"); value != "" {
+		family, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid family parameter", http.StatusBadRequest)
+			return nil, false
+		}
+		events = filterByFamily(events, family)
+	}
+	return events, true
+}
+
+// eventHandler serves GET /api/events/{id}, a single event from today's
+// events or the recent archive, with any ?expand= expansions, and passes
+// PATCH requests on to eventEditHandler.
+func eventHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/related") {
+		relatedHandler(w, r)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		eventEditHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	expand, err := parseExpand(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid expand parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	e, ok := findEvent(strings.TrimPrefix(r.URL.Path, "/api/events/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	expanded := applyExpansions([]Event{e}, expand, false)
+	if _, ok := convertTimes(w, r, expanded); !ok {
+		return
+	}
+	e = expanded[0]
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Vary", "Accept")
+	if wantsJSONAPI(r) {
+		writeJSONAPI(w, jsonAPIDocument{Data: eventResource(r, e, nil)})
+		return
+	}
+	writeJSON(w, e)
+}
+
+// writeEventsResponse writes events in the envelope version requested with
+// ?v=, which defaults to 1, keeping only the fields requested with ?fields=
+// and with timestamps in the zone requested with ?tz=.
+// JSON:API clients get a JSON:API document instead; see jsonapi.go.
+func writeEventsResponse(w http.ResponseWriter, r *http.Request, events []Event, total int, order string, info CacheInfo) {
+	fields, err := parseFields(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid fields parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	events, ok := expandEvents(w, r, events)
+	if !ok {
+		return
+	}
+	loc, ok := convertTimes(w, r, events)
+	if !ok {
+		return
+	}
+
 	response := APIResponse{
-		Events:      events,
-		MapboxToken: os.Getenv("MAPBOX_ACCESS_TOKEN"),
+		Version:        1,
+		Events:         events,
+		ScrapedAt:      info.ScrapedAt,
+		DataAgeSeconds: int64(since(info.ScrapedAt).Seconds()),
+		Total:          total,
+		Order:          order,
+		AsOf:           info.AsOf,
+	}
+	response.Bounds, response.Centroid = eventBounds(events)
+	if loc != nil {
+		response.ScrapedAt, response.TimeZone = response.ScrapedAt.In(loc), loc.String()
+		if info.AsOf != nil {
+			asOf := *info.AsOf
+			asOf.Requested, asOf.PublishedAt = asOf.Requested.In(loc), inZone(asOf.PublishedAt, loc)
+			response.AsOf = &asOf
+		}
+	}
+	switch r.URL.Query().Get("v") {
+	case "", "1":
+		response.MapboxToken = clientConfig(getConfig()).MapboxToken
+	case "2":
+		response.Version = 2
+	default:
+		http.Error(w, "Invalid v parameter: must be 1 or 2", http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Status", info.Status)
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS if running separately, harmless otherwise
+	w.Header().Add("Vary", "Accept")
+	if wantsJSONAPI(r) {
+		writeJSONAPIEvents(w, r, response, fields)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if fields != nil {
+		json.NewEncoder(w).Encode(sparseResponse{APIResponse: response, Events: selectFields(events, fields)})
+		return
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// registerRoutes adds the server's handlers to http.DefaultServeMux.
+func registerRoutes() {
+	// Serve static files
+	http.Handle("/", staticHandler("../public"))
+
+	// API endpoint
+	http.HandleFunc("/api/config", configHandler)
+	http.HandleFunc("/api/events", withCompression(withSignature(apiHandler)))
+	http.HandleFunc("/api/events/summary", summaryHandler)
+	http.HandleFunc("/api/digest", digestHandler)
+	http.HandleFunc("/api/events/query", withCompression(queryHandler))
+	http.HandleFunc("/api/events/nearby", withCompression(nearbyHandler))
+	http.HandleFunc("/api/events/random", randomHandler)
+	http.HandleFunc("/api/events/heatmap", withCompression(heatmapHandler))
+	http.HandleFunc("/api/events/changes", changesHandler)
+	http.HandleFunc("/api/events/", eventHandler)
+	http.HandleFunc("/api/events/ical/", eventICSHandler)
+	http.HandleFunc("/api/events.ics", withCompression(icsHandler))
+	http.HandleFunc("/api/venues/", withCompression(venueHandler))
+	http.Handle("/ws", liveHandler)
+	http.HandleFunc("/api/schema/", schemaHandler)
+	http.HandleFunc("/api/status", statusHandler)
+	http.HandleFunc("/api/version", versionHandler)
+	http.HandleFunc("/api/status/retention", retentionStatusHandler)
+	http.HandleFunc("/api/status/sources", sourceStatusHandler)
+	http.HandleFunc("/api/status/slo", sloStatusHandler)
+	http.HandleFunc("/api/signing-key", signingKeyHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/api/track", trackHandler)
+	http.HandleFunc("/api/popular", popularHandler)
+	http.HandleFunc("/api/analytics/events/", analyticsEventHandler)
+	http.HandleFunc("/api/analytics/top", analyticsTopHandler)
+	http.HandleFunc("/api/integrations/google/", googleHandler)
+	http.HandleFunc("/api/admin/flags", flagsHandler)
+	http.HandleFunc("/api/admin/flags/", flagsHandler)
+	http.HandleFunc("/api/admin/audit", auditHandler)
+	http.HandleFunc("/api/admin/sources/", sourcesAdminHandler)
+	http.HandleFunc("/api/admin/import", importHandler)
+	http.HandleFunc("/api/submissions", submitHandler)
+	http.HandleFunc("/api/admin/submissions", submissionsHandler)
+	http.HandleFunc("/api/admin/submissions/", submissionsHandler)
+	http.HandleFunc("/api/ingest/webhook", webhookHandler)
+
+	// Share pages
+	http.HandleFunc("/events/", sharePageHandler)
+	http.HandleFunc("/s/", shortLinkHandler)
+	http.HandleFunc("/today", withCompression(todayHandler))
+	http.HandleFunc("/sitemap.xml", withCompression(sitemapHandler))
+
+	// Embeddable widgets
+	http.HandleFunc("/embed/list", embedListHandler)
+	http.HandleFunc("/embed/events.js", embedScriptHandler)
+}
+
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	setConfig(cfg)
+	if err := installFaults(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		log.Fatalf("Failed to create cache directory %s: %v", cfg.CacheDir, err)
+	}
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+	if cfg.GeocodingMode == geocodingTemporary {
+		log.Println("Warning: Temporary geocoding results may not be stored; cached coordinates are not covered by Mapbox's terms.")
 	}
 
-	// Serve static files
-	fs := http.FileServer(http.Dir("../public"))
-	http.Handle("/", fs)
+	if err := loadTables(cfg); err != nil {
+		log.Fatalf("Failed to load venue tables: %v", err)
+	}
+	go watchConfig()
 
-	// API endpoint
-	http.HandleFunc("/api/events", apiHandler)
+	dataFile = cachePath(dataFile + "." + cfg.StorageFormat)
+	if cfg.CompressCache {
+		dataFile += gzipSuffix
+	}
+	trackFile = cachePath(trackFile)
+	recentFile = cachePath(recentFile)
+	usageFile = cachePath(usageFile)
+	editsFile = cachePath(editsFile)
+	auditFile = cachePath(auditFile)
+	flagsFile = cachePath(flagsFile)
+	submissionsFile = cachePath(submissionsFile)
+	analyticsFile = cachePath(analyticsFile)
+	pushedFile = cachePath(pushedFile)
+	sourcesFile = cachePath(sourcesFile)
+	shortLinksFile = cachePath(shortLinksFile)
+	sourceHealthFile = cachePath(sourceHealthFile)
+	snapshotDir = cachePath(snapshotDir)
+	migrateDataFile()
+
+	checkMapboxToken()
+
+	if cfg.DatabaseURL != "" {
+		store, err := openPostgresStore(cfg.DatabaseURL)
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		eventStore = store
+	}
 
-	fmt.Printf("Server starting on http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	registerRoutes()
+
+	loadTrackingFromFile()
+	loadRecentEvents()
+	loadGeocodeUsage()
+	loadFlagOverrides()
+	loadEventEdits()
+	loadSubmissions()
+	loadPushedEvents()
+	loadSourceListings()
+	loadShortLinks()
+	loadSourceHealth()
+	go flushTrackingPeriodically()
+	go checkLinksPeriodically()
+	go checkTicketsPeriodically()
+	go refreshOnSchedule()
+	go evaluateSLOsPeriodically()
+
+	log.Printf("Running mapthens %s", buildVersion().describe())
+	fmt.Printf("Server starting on http://localhost:%s\n", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, withLatency(http.DefaultServeMux)))
 }
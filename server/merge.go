@@ -0,0 +1,72 @@
+package main
+
+import "log"
+
+// Flagpole keeps adding listings during the day. In incremental mode, a
+// re-scrape on the same day as the cached events is merged into them by
+// event ID instead of replacing them: events already known keep their
+// coordinates, so only new listings are geocoded, and events that have
+// dropped off the listing stay until the next day's first scrape.
+
+const (
+	refreshFull        = "full"
+	refreshIncremental = "incremental"
+)
+
+// Helper Functions
+
+// mergeEvents merges freshly scraped events into previous. Scraped events
+// replace their previous versions but keep the previous coordinates when
+// the address hasn't changed; events not seen before are flagged as added.
+func mergeEvents(previous, scraped []Event) []Event {
+	byID := make(map[string]Event, len(previous))
+	for _, e := range previous {
+		byID[e.ID] = e
+	}
+
+	merged := make([]Event, 0, len(previous)+len(scraped))
+	seen := map[string]bool{}
+	added := 0
+	for _, e := range scraped {
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+
+		if old, ok := byID[e.ID]; ok {
+			e.Added = old.Added
+			if e.Latitude == 0 && e.Longitude == 0 && e.Address == old.Address {
+				e.Latitude, e.Longitude = old.Latitude, old.Longitude
+				e.GeocodeProvider = old.GeocodeProvider
+			}
+		} else {
+			e.Added = true
+			added++
+		}
+		merged = append(merged, e)
+	}
+	for _, e := range previous {
+		if !seen[e.ID] {
+			merged = append(merged, e)
+		}
+	}
+
+	log.Printf("Merged re-scrape: %d new events, %d events in total.", added, len(merged))
+	return merged
+}
+
+// reuseCoordinates gives scraped events the coordinates they had in
+// previous when their address hasn't changed, without keeping previous
+// events that are no longer listed.
+func reuseCoordinates(previous, scraped []Event) {
+	byID := make(map[string]Event, len(previous))
+	for _, e := range previous {
+		byID[e.ID] = e
+	}
+	for i, e := range scraped {
+		if old, ok := byID[e.ID]; ok && e.Latitude == 0 && e.Longitude == 0 && e.Address == old.Address {
+			scraped[i].Latitude, scraped[i].Longitude = old.Latitude, old.Longitude
+			scraped[i].GeocodeProvider = old.GeocodeProvider
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scrape run metrics. With MAPTHENS_METRICS=emf each run is printed to
+// stdout in CloudWatch Embedded Metric Format, which the CloudWatch agent
+// turns into metrics without any log parsing. With MAPTHENS_METRICS=prometheus
+// the same values are pushed to a Prometheus Pushgateway.
+
+const (
+	metricsEMF        = "emf"
+	metricsPrometheus = "prometheus"
+	metricsNamespace  = "Mapthens"
+)
+
+// Data Structures
+
+type metricValue struct {
+	name  string
+	unit  string
+	value float64
+}
+
+// runMetrics doubles as the run report shown by /api/status.
+type runMetrics struct {
+	Finished        time.Time `json:"finished"`
+	EventsScraped   int       `json:"events_scraped"`
+	GeocodeFailures int       `json:"geocode_failures"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	BytesWritten    int64     `json:"bytes_written"`
+	Failed          bool      `json:"failed"`
+	// TimedOut is set when the watchdog cancelled the scrape; see watchdog.go
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Conflicts lists the events whose sources disagreed; see sources.go
+	Conflicts []SourceConflict `json:"conflicts,omitempty"`
+	// Shadow compares the events API with the HTML list; see shadow.go
+	Shadow *ShadowReport `json:"shadow,omitempty"`
+	// Quarantined lists the sources being skipped; see quarantine.go
+	Quarantined []string `json:"quarantined,omitempty"`
+}
+
+// scrapeFindings is what a scrape reports besides its events.
+type scrapeFindings struct {
+	Conflicts []SourceConflict
+	Shadow    *ShadowReport
+}
+
+// Global Variables
+var (
+	lastRun      *runMetrics
+	lastRunMutex sync.Mutex
+)
+
+// Helper Functions
+
+// collectRunMetrics summarizes a finished scrape. Geocode failures are the
+// events left without coordinates that the gazetteer doesn't cover either.
+func collectRunMetrics(events []Event, started time.Time, err error) runMetrics {
+	m := runMetrics{
+		Finished:        now(),
+		EventsScraped:   len(events),
+		DurationSeconds: since(started).Seconds(),
+		Failed:          err != nil,
+		Quarantined:     quarantinedSources(),
+	}
+	for _, e := range events {
+		if e.Latitude != 0 || e.Longitude != 0 || e.Address == "" {
+			continue
+		}
+		if venue, ok := lookupVenue(e.Venue); ok && venue.hasCoordinates() {
+			continue
+		}
+		m.GeocodeFailures++
+	}
+	if info, statErr := os.Stat(dataFile); statErr == nil && err == nil {
+		m.BytesWritten = info.Size()
+	}
+	return m
+}
+
+func (m runMetrics) values() []metricValue {
+	failed, timedOut := 0.0, 0.0
+	if m.Failed {
+		failed = 1
+	}
+	if m.TimedOut {
+		timedOut = 1
+	}
+	return []metricValue{
+		{"EventsScraped", "Count", float64(m.EventsScraped)},
+		{"GeocodeFailures", "Count", float64(m.GeocodeFailures)},
+		{"RunDuration", "Seconds", m.DurationSeconds},
+		{"BytesWritten", "Bytes", float64(m.BytesWritten)},
+		{"ScrapeFailed", "Count", failed},
+		{"ScrapeTimedOut", "Count", timedOut},
+		{"SourceConflicts", "Count", float64(len(m.Conflicts))},
+		{"ShadowDiscrepancies", "Count", float64(m.Shadow.discrepancies())},
+		{"SourcesQuarantined", "Count", float64(len(m.Quarantined))},
+	}
+}
+
+func emitRunMetrics(m runMetrics) {
+	lastRunMutex.Lock()
+	lastRun = &m
+	lastRunMutex.Unlock()
+
+	cfg := getConfig()
+	switch cfg.Metrics {
+	case metricsEMF:
+		if err := writeEMF(m); err != nil {
+			log.Printf("Warning: Failed to write EMF metrics: %v", err)
+		}
+	case metricsPrometheus:
+		if err := pushPrometheus(cfg.PushgatewayURL, m); err != nil {
+			log.Printf("Warning: Failed to push metrics: %v", err)
+		}
+	}
+}
+
+func lastRunReport() *runMetrics {
+	lastRunMutex.Lock()
+	defer lastRunMutex.Unlock()
+	return lastRun
+}
+
+func writeEMF(m runMetrics) error {
+	definitions := []map[string]string{}
+	record := map[string]interface{}{"Service": "mapthens-server"}
+	for _, v := range m.values() {
+		definitions = append(definitions, map[string]string{"Name": v.name, "Unit": v.unit})
+		record[v.name] = v.value
+	}
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  metricsNamespace,
+			"Dimensions": [][]string{{"Service"}},
+			"Metrics":    definitions,
+		}},
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(data))
+	return err
+}
+
+// pushPrometheus replaces the mapthens job's metrics on the Pushgateway.
+func pushPrometheus(gatewayURL string, m runMetrics) error {
+	var body bytes.Buffer
+	for _, v := range m.values() {
+		name := "mapthens_" + toSnakeCase(v.name)
+		if v.unit == "Seconds" || v.unit == "Bytes" {
+			name += "_" + strings.ToLower(v.unit)
+		}
+		fmt.Fprintf(&body, "# TYPE %s gauge\n%s %g\n", name, name, v.value)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(gatewayURL, "/")+"/metrics/job/mapthens", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("non-2xx status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Submissions are scored from 0 (clean) to 1 (abusive or spam) before
+// anyone sees them. Submissions scoring at or below the approve threshold
+// are published straight away, those at or above the reject threshold are
+// turned away, and the rest wait in the review queue for an admin. The
+// local checks only catch the obvious; MAPTHENS_MODERATION_URL adds an
+// external moderation service, whose score is used when it is higher.
+
+const (
+	defaultApproveScore = 0.2
+	defaultRejectScore  = 0.8
+	// More links than this outside event_link reject a submission outright
+	maxSubmissionLinks = 2
+)
+
+// Data Structures
+
+type ModerationResult struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// moderationRequest and moderationResponse are the external service's API:
+// the submission's text in, a score between 0 and 1 out.
+type moderationRequest struct {
+	Text string `json:"text"`
+}
+
+type moderationResponse struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// Global Variables
+var (
+	profanityWords = regexp.MustCompile(`(?i)\b(fuck\w*|shit\w*|bitch\w*|cunt\w*|asshole\w*|dickhead\w*|motherfuck\w*)\b`)
+	spamPhrases    = regexp.MustCompile(`(?i)\b(casino|viagra|cialis|crypto ?currency|bitcoin|forex|payday loans?|click here|free money|work from home|100% free|buy followers)\b`)
+	linkPattern    = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+	moderationClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Helper Functions
+
+func (m *ModerationResult) add(score float64, reason string) {
+	m.Score = min(m.Score+score, 1)
+	m.Reasons = append(m.Reasons, reason)
+}
+
+// scoreText runs the local checks.
+func scoreText(text string) ModerationResult {
+	var m ModerationResult
+	if words := profanityWords.FindAllString(text, -1); len(words) > 0 {
+		m.add(0.5*float64(len(words)), "profanity")
+	}
+	if phrases := spamPhrases.FindAllString(text, -1); len(phrases) > 0 {
+		m.add(0.4*float64(len(phrases)), "spam phrases")
+	}
+	if links := len(linkPattern.FindAllString(text, -1)); links > maxSubmissionLinks {
+		m.add(1, fmt.Sprintf("%d links", links))
+	}
+	if shouting(text) {
+		m.add(0.2, "all caps")
+	}
+	if repeatsChar(text, 6) {
+		m.add(0.1, "repeated characters")
+	}
+	return m
+}
+
+// shouting reports whether most of a longer text's letters are capitals.
+func shouting(text string) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 20 && upper*2 > letters
+}
+
+// repeatsChar reports whether text has n or more of the same character in
+// a row, as in "!!!!!!" or "soooooo".
+func repeatsChar(text string, n int) bool {
+	var last rune
+	run := 0
+	for _, r := range text {
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run >= n {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreExternal asks the configured moderation service for a score.
+func scoreExternal(serviceURL, token, text string) (ModerationResult, error) {
+	body, err := json.Marshal(moderationRequest{Text: text})
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, serviceURL, bytes.NewReader(body))
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := moderationClient.Do(req)
+	if err != nil {
+		return ModerationResult{}, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ModerationResult{}, fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	}
+
+	var result moderationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ModerationResult{}, fmt.Errorf("error decoding json response: %v", err)
+	}
+	if result.Score < 0 || result.Score > 1 {
+		return ModerationResult{}, fmt.Errorf("score %v out of range", result.Score)
+	}
+	return ModerationResult{Score: result.Score, Reasons: result.Reasons}, nil
+}
+
+// moderate scores a submission and decides its status. When the external
+// service can't be reached, a submission that would have been approved is
+// queued for review instead.
+func moderate(s Submission) (ModerationResult, string) {
+	cfg := getConfig()
+	text := strings.Join([]string{s.Title, s.Venue, s.Address, s.Description}, "\n")
+	m := scoreText(text)
+
+	externalFailed := false
+	if cfg.ModerationURL != "" {
+		if external, err := scoreExternal(cfg.ModerationURL, cfg.ModerationToken, text); err != nil {
+			log.Printf("Warning: Moderation service failed for submission %s: %v", s.ID, err)
+			externalFailed = true
+		} else if external.Score > m.Score {
+			m.Score = external.Score
+			m.Reasons = append(m.Reasons, external.Reasons...)
+		}
+	}
+
+	switch {
+	case m.Score >= cfg.RejectScore:
+		return m, submissionRejected
+	case m.Score <= cfg.ApproveScore && !externalFailed:
+		return m, submissionApproved
+	default:
+		return m, submissionPending
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Multi-day events (festivals, exhibitions) are listed by flagpole as
+// "December 9 @ 10:00 am - December 14 @ 5:00 pm", or without times as
+// "December 9 - December 14". The end date is parsed from that text, or
+// from the event's detail page when the listing doesn't say.
+
+var rangeEndDatePattern = regexp.MustCompile(`(?i)[-–—]\s*(?:[a-z]+,\s*)?(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?\s+(\d{1,2})`)
+
+// parseEndDate returns the YYYY-MM-DD end date given in a listing's datetime
+// text, or "" if it doesn't name one. The year is taken from the start date,
+// rolling over for ranges that cross New Year.
+func parseEndDate(datetime, startDate string) string {
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return ""
+	}
+
+	match := rangeEndDatePattern.FindStringSubmatch(datetime)
+	if match == nil {
+		return ""
+	}
+	month := strings.ToUpper(match[1][:1]) + strings.ToLower(match[1][1:])
+	end, err := time.Parse("Jan 2 2006", month+" "+match[2]+" "+start.Format("2006"))
+	if err != nil {
+		return ""
+	}
+	if end.Before(start) {
+		end = end.AddDate(1, 0, 0)
+	}
+	return end.Format("2006-01-02")
+}
+
+// detailEndDate reads the endDate from the schema.org JSON-LD that The
+// Events Calendar embeds in each event's page.
+func detailEndDate(ctx context.Context, eventLink string) string {
+	doc, err := fetchDocument(ctx, eventLink)
+	if err != nil {
+		return ""
+	}
+
+	endDate := ""
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, script *goquery.Selection) bool {
+		var items []struct {
+			Type    string `json:"@type"`
+			EndDate string `json:"endDate"`
+		}
+		text := strings.TrimSpace(script.Text())
+		if !strings.HasPrefix(text, "[") {
+			text = "[" + text + "]"
+		}
+		if json.Unmarshal([]byte(text), &items) != nil {
+			return true
+		}
+		for _, item := range items {
+			if item.Type == "Event" && len(item.EndDate) >= 10 {
+				endDate = item.EndDate[:10]
+				return false
+			}
+		}
+		return true
+	})
+	return endDate
+}
+
+// runningOn completes the end dates of listed events and returns those that
+// run on day. Events that started before day but are still listed are
+// ongoing, so their detail pages are consulted, in parallel, when the
+// listing doesn't give an end date.
+func runningOn(ctx context.Context, listed []Event, day string) []Event {
+	var links []string
+	if flagEnabled(flagDetailEnrichment) {
+		for _, e := range listed {
+			if e.EndDate == "" && e.StartDate < day && e.EventLink != "" {
+				links = append(links, e.EventLink)
+			}
+		}
+	}
+	detailEnds := fetchDetails(ctx, links, func(link string) string { return detailEndDate(ctx, link) })
+
+	var running []Event
+	for _, e := range listed {
+		if e.EndDate == "" {
+			e.EndDate = detailEnds[e.EventLink]
+		}
+		if e.EndDate == "" || e.EndDate < e.StartDate {
+			e.EndDate = e.StartDate
+		}
+		if day < e.StartDate || day > e.EndDate {
+			continue
+		}
+		running = append(running, e)
+	}
+	return running
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Besides MAPTHENS_ADMIN_TOKEN, the admin API and event edits accept tokens
+// from the operator's OpenID Connect provider. With MAPTHENS_OIDC_ISSUER
+// set, a bearer JWT is accepted when it's signed by one of the issuer's
+// keys (found through its /.well-known/openid-configuration), names the
+// issuer as "iss" and MAPTHENS_OIDC_AUDIENCE among its "aud", and hasn't
+// expired. With MAPTHENS_OIDC_GROUPS set, its groups claim
+// (MAPTHENS_OIDC_GROUPS_CLAIM, "groups" by default) must also list one of
+// those groups; valid tokens for anyone else get 403.
+//
+// The issuer's keys are cached for an hour, and fetched again early when a
+// token names a key that isn't cached, at most once a minute.
+
+const (
+	oidcKeysTTL       = time.Hour
+	oidcRefetchDelay  = time.Minute
+	oidcClockLeeway   = time.Minute
+	oidcDefaultClaims = "groups"
+)
+
+// Data Structures
+
+// oidcKeySet caches one issuer's signing keys by key ID.
+type oidcKeySet struct {
+	issuer  string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcClaims are the verified claims of an admin token.
+type oidcClaims map[string]interface{}
+
+// Global Variables
+var (
+	errOIDCForbidden = errors.New("not in an allowed group")
+
+	oidcKeys      *oidcKeySet
+	oidcKeysMutex sync.Mutex
+	oidcClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Helper Functions
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus: %v", err)
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("exponent: %v", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %v", err)
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %v", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on %s", k.Crv)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchOIDCKeys reads the issuer's discovery document and then its JWKS.
+// Keys that can't be used for signatures are skipped.
+func fetchOIDCKeys(issuer string) (*oidcKeySet, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("discovery names issuer %q, not %q", discovery.Issuer, issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery has no jwks_uri")
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("keys: %v", err)
+	}
+	set := &oidcKeySet{issuer: issuer, keys: map[string]crypto.PublicKey{}, fetched: now()}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		set.keys[k.Kid] = key
+	}
+	return set, nil
+}
+
+// oidcKey returns the issuer's key with ID kid, fetching the keys when the
+// cache is stale or doesn't have it.
+func oidcKey(issuer, kid string) (crypto.PublicKey, error) {
+	oidcKeysMutex.Lock()
+	defer oidcKeysMutex.Unlock()
+
+	set := oidcKeys
+	if set != nil && set.issuer != issuer {
+		set = nil
+	}
+	if set != nil {
+		if key, ok := set.keys[kid]; ok && since(set.fetched) < oidcKeysTTL {
+			return key, nil
+		}
+	}
+	if set == nil || since(set.fetched) >= oidcRefetchDelay {
+		fetched, err := fetchOIDCKeys(issuer)
+		if err != nil {
+			return nil, fmt.Errorf("fetching keys for %s: %v", issuer, err)
+		}
+		oidcKeys, set = fetched, fetched
+	}
+	if key, ok := set.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// verifySignature checks a JWS signature made with alg.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hashID, digest, signature)
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("signature is %d bytes, want %d", len(signature), 2*size)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// claimStrings reads a claim that may be a string or a list of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func claimTime(claims oidcClaims, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// verifyOIDCToken checks token against the configured issuer, audience, and
+// groups, returning its claims. A valid token for someone outside the
+// allowed groups fails with errOIDCForbidden.
+func verifyOIDCToken(token string) (oidcClaims, error) {
+	cfg := getConfig()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	raw, err := decodeSegment(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return nil, fmt.Errorf("malformed header")
+	}
+	switch header.Alg {
+	case "RS256", "RS384", "RS512", "ES256", "ES384":
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	key, err := oidcKey(cfg.OIDCIssuer, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("signature: %v", err)
+	}
+
+	var claims oidcClaims
+	if raw, err = decodeSegment(parts[1]); err != nil || json.Unmarshal(raw, &claims) != nil {
+		return nil, fmt.Errorf("malformed claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != cfg.OIDCIssuer {
+		return nil, fmt.Errorf("issued by %q", iss)
+	}
+	if !containsString(claimStrings(claims["aud"]), cfg.OIDCAudience) {
+		return nil, fmt.Errorf("not issued for %q", cfg.OIDCAudience)
+	}
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now().After(exp.Add(oidcClockLeeway)) {
+		return nil, fmt.Errorf("expired")
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now().Add(oidcClockLeeway).Before(nbf) {
+		return nil, fmt.Errorf("not valid yet")
+	}
+
+	if len(cfg.OIDCGroups) > 0 {
+		allowed := false
+		for _, group := range claimStrings(claims[cfg.OIDCGroupsClaim]) {
+			if containsString(cfg.OIDCGroups, group) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return claims, errOIDCForbidden
+		}
+	}
+	return claims, nil
+}
+
+// subject names the token's holder for the audit log: their email, else
+// their username, else the subject ID.
+func (c oidcClaims) subject() string {
+	for _, name := range []string{"email", "preferred_username", "sub"} {
+		if s, _ := c[name].(string); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGroups splits a comma-separated list of group names.
+func parseGroups(value string) []string {
+	var groups []string
+	for _, group := range strings.Split(value, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
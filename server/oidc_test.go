@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID Connect provider serving discovery and a JWKS
+// with one RSA and one P-256 key.
+type testIssuer struct {
+	url         string
+	rsaKey      *rsa.PrivateKey
+	ecKey       *ecdsa.PrivateKey
+	discoveries int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			issuer.discoveries++
+			writeJSON(w, map[string]string{"issuer": issuer.url, "jwks_uri": issuer.url + "/keys"})
+		case "/keys":
+			writeJSON(w, map[string][]jsonWebKey{"keys": {
+				{Kty: "RSA", Kid: "rsa", Use: "sig", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{Kty: "EC", Kid: "ec", Crv: "P-256", X: encode(ecKey.X.FillBytes(make([]byte, 32))), Y: encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+				{Kty: "RSA", Kid: "enc", Use: "enc", N: encode(rsaKey.N.Bytes()), E: "AQAB"},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	issuer.url = server.URL
+
+	previousKeys := oidcKeys
+	t.Cleanup(func() { oidcKeys = previousKeys })
+	oidcKeys = nil
+	return issuer
+}
+
+// sign makes a JWT of claims signed with alg, by the key named kid.
+func (i *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var digest []byte
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "384":
+		sum := sha512.Sum384([]byte(signed))
+		digest, hashID = sum[:], crypto.SHA384
+	default:
+		sum := sha256.Sum256([]byte(signed))
+		digest, hashID = sum[:], crypto.SHA256
+	}
+	var signature []byte
+	var err error
+	switch alg[:2] {
+	case "RS":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, hashID, digest)
+	case "ES":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest)
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		signature = []byte("unsigned")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyOIDCToken(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	issuer := newTestIssuer(t)
+	cfg := getConfig()
+	cfg.OIDCIssuer, cfg.OIDCAudience = issuer.url, "mapthens"
+	cfg.OIDCGroups, cfg.OIDCGroupsClaim = []string{"editors", "admins"}, "groups"
+	setConfig(cfg)
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer.url, "aud": "mapthens", "sub": "1234", "email": "editor@example.com",
+			"exp": at.Add(time.Hour).Unix(), "groups": []string{"editors"},
+		}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	for _, test := range []struct {
+		name    string
+		token   func() string
+		wantErr string
+	}{
+		{"RS256", func() string { return issuer.sign(t, "RS256", "rsa", valid()) }, ""},
+		{"RS384", func() string { return issuer.sign(t, "RS384", "rsa", valid()) }, ""},
+		{"ES256", func() string { return issuer.sign(t, "ES256", "ec", valid()) }, ""},
+		{"audience in a list", func() string {
+			return issuer.sign(t, "RS256", "rsa", with("aud", []string{"other", "mapthens"}))
+		}, ""},
+		{"groups as a string", func() string { return issuer.sign(t, "RS256", "rsa", with("groups", "admins")) }, ""},
+		{"expired within the leeway", func() string {
+			return issuer.sign(t, "RS256", "rsa", with("exp", at.Add(-30*time.Second).Unix()))
+		}, ""},
+		{"not before within the leeway", func() string {
+			return issuer.sign(t, "RS256", "rsa", with("nbf", at.Add(30*time.Second).Unix()))
+		}, ""},
+
+		{"expired", func() string { return issuer.sign(t, "RS256", "rsa", with("exp", at.Add(-2*time.Minute).Unix())) }, "expired"},
+		{"no expiry", func() string { return issuer.sign(t, "RS256", "rsa", with("exp", nil)) }, "expired"},
+		{"not valid yet", func() string { return issuer.sign(t, "RS256", "rsa", with("nbf", at.Add(2*time.Minute).Unix())) }, "not valid yet"},
+		{"other issuer", func() string { return issuer.sign(t, "RS256", "rsa", with("iss", "https://evil.example.com")) }, "issued by"},
+		{"other audience", func() string { return issuer.sign(t, "RS256", "rsa", with("aud", "other")) }, "not issued for"},
+		{"no groups", func() string { return issuer.sign(t, "RS256", "rsa", with("groups", nil)) }, errOIDCForbidden.Error()},
+		{"other group", func() string { return issuer.sign(t, "RS256", "rsa", with("groups", []string{"viewers"})) }, errOIDCForbidden.Error()},
+
+		{"unsigned", func() string { return issuer.sign(t, "none", "rsa", valid()) }, "unsupported algorithm"},
+		{"HMAC", func() string { return issuer.sign(t, "HS256", "rsa", valid()) }, "unsupported algorithm"},
+		{"tampered claims", func() string {
+			parts := strings.Split(issuer.sign(t, "RS256", "rsa", valid()), ".")
+			forged, _ := json.Marshal(with("groups", []string{"admins"}))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+		}, "signature"},
+		{"RSA algorithm with the EC key", func() string {
+			parts := strings.Split(issuer.sign(t, "ES256", "ec", valid()), ".")
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"ec"}`))
+			return header + "." + parts[1] + "." + parts[2]
+		}, "needs an RSA key"},
+		{"encryption key", func() string { return issuer.sign(t, "RS256", "enc", valid()) }, "unknown key"},
+		{"unknown key", func() string { return issuer.sign(t, "RS256", "rotated", valid()) }, "unknown key"},
+		{"two segments", func() string { return "a.b" }, "not a JWT"},
+		{"malformed header", func() string { return "!!!.e30.sig" }, "malformed header"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifyOIDCToken(test.token())
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyOIDCToken() error = %v", err)
+				}
+				if got := claims.subject(); got != "editor@example.com" {
+					t.Errorf("subject() = %q, want editor@example.com", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("verifyOIDCToken() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyCache(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	issuer := newTestIssuer(t)
+
+	if _, err := oidcKey(issuer.url, "rsa"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oidcKey(issuer.url, "ec"); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.discoveries != 1 {
+		t.Errorf("%d discoveries for cached keys, want 1", issuer.discoveries)
+	}
+
+	// An unknown key is only looked for again once a minute
+	if _, err := oidcKey(issuer.url, "rotated"); err == nil {
+		t.Error("found an unknown key")
+	}
+	if issuer.discoveries != 1 {
+		t.Errorf("%d discoveries within a minute, want 1", issuer.discoveries)
+	}
+	now = func() time.Time { return at.Add(2 * time.Minute) }
+	oidcKey(issuer.url, "rotated")
+	if issuer.discoveries != 2 {
+		t.Errorf("%d discoveries after a minute, want 2", issuer.discoveries)
+	}
+
+	// Known keys are fetched again once they're an hour old
+	now = func() time.Time { return at.Add(2*time.Minute + oidcKeysTTL) }
+	if _, err := oidcKey(issuer.url, "rsa"); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.discoveries != 3 {
+		t.Errorf("%d discoveries after an hour, want 3", issuer.discoveries)
+	}
+
+	if _, err := oidcKey("http://127.0.0.1:1", "rsa"); err == nil || errors.Is(err, errOIDCForbidden) {
+		t.Errorf("oidcKey() for an unreachable issuer = %v, want a fetch error", err)
+	}
+}
+
+func TestJSONWebKey(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		key     jsonWebKey
+		wantErr bool
+	}{
+		{"RSA", jsonWebKey{Kty: "RSA", N: "AQAB", E: "AQAB"}, false},
+		{"RSA exponent too large", jsonWebKey{Kty: "RSA", N: "AQAB", E: "AQAAAAAB"}, true},
+		{"RSA bad modulus", jsonWebKey{Kty: "RSA", N: "!", E: "AQAB"}, true},
+		{"EC point not on the curve", jsonWebKey{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}, true},
+		{"EC unsupported curve", jsonWebKey{Kty: "EC", Crv: "P-521", X: "AQ", Y: "AQ"}, true},
+		{"symmetric", jsonWebKey{Kty: "oct"}, true},
+	} {
+		if _, err := test.key.publicKey(); (err != nil) != test.wantErr {
+			t.Errorf("%s: publicKey() error = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
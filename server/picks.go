@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Flagpole's weekly print edition has an editor-curated "Calendar Picks"
+// column. Events it mentions are marked Featured so the map can highlight
+// them.
+
+// eventDateSuffix matches the occurrence date flagpole appends to recurring
+// event links, e.g. /event/tour-at-two/2025-12-10/.
+var eventDateSuffix = regexp.MustCompile(`/\d{4}-\d{2}-\d{2}/?$`)
+
+// Data Structures
+
+type calendarPicks struct {
+	links  map[string]bool
+	titles map[string]bool
+}
+
+// Helper Functions
+
+// eventLinkKey reduces an event link to its path without the occurrence
+// date, so a pick links to every date of a recurring event.
+func eventLinkKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(eventDateSuffix.ReplaceAllString(u.Path, ""), "/")
+}
+
+func normalizeTitle(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), " "))
+}
+
+// scrapeCalendarPicks reads the latest Calendar Picks column. The configured
+// URL may point at the column itself or at its archive page, in which case
+// the newest article is followed.
+func scrapeCalendarPicks(ctx context.Context, pageURL string) (calendarPicks, error) {
+	picks := calendarPicks{links: map[string]bool{}, titles: map[string]bool{}}
+
+	doc, err := fetchDocument(ctx, pageURL)
+	if err != nil {
+		return picks, err
+	}
+
+	if doc.Find(".entry-content").Length() == 0 {
+		latest, ok := doc.Find("article .entry-title a").First().Attr("href")
+		if !ok {
+			return picks, fmt.Errorf("no Calendar Picks article found at %s", pageURL)
+		}
+		if doc, err = fetchDocument(ctx, resolveLink(pageURL, latest)); err != nil {
+			return picks, err
+		}
+	}
+
+	content := doc.Find(".entry-content")
+	content.Find("a[href*='/event/']").Each(func(_ int, link *goquery.Selection) {
+		if href, ok := link.Attr("href"); ok {
+			if key := eventLinkKey(href); key != "" {
+				picks.links[key] = true
+			}
+		}
+	})
+	// Picks are written as a bolded event name followed by a blurb
+	content.Find("strong, b, h2, h3, h4").Each(func(_ int, heading *goquery.Selection) {
+		if title := normalizeTitle(heading.Text()); title != "" {
+			picks.titles[title] = true
+		}
+	})
+
+	return picks, nil
+}
+
+func (p calendarPicks) includes(e Event) bool {
+	if key := eventLinkKey(e.EventLink); key != "" && p.links[key] {
+		return true
+	}
+	return p.titles[normalizeTitle(e.Title)]
+}
+
+// markFeatured flags scraped events that appear in the Calendar Picks. A
+// failure here is logged and leaves every event unfeatured.
+func markFeatured(ctx context.Context, events []Event) {
+	pageURL := getConfig().PicksURL
+	if pageURL == "" || !flagEnabled(flagFeaturedPicks) {
+		return
+	}
+
+	picks, err := scrapeCalendarPicks(ctx, pageURL)
+	if err != nil {
+		log.Printf("Warning: Failed to scrape Calendar Picks: %v", err)
+		return
+	}
+
+	featured := 0
+	for i := range events {
+		if picks.includes(events[i]) {
+			events[i].Featured = true
+			featured++
+		}
+	}
+	log.Printf("Marked %d events as Calendar Picks.", featured)
+}
+
+func filterByFeatured(events []Event, featured bool) []Event {
+	filtered := []Event{}
+	for _, e := range events {
+		if e.Featured == featured {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,115 @@
+// Package archive names and points at the daily event snapshots a mapthens
+// server keeps, for the server and for tools that read or sync its cache
+// directory:
+//
+//	latest, err := archive.ReadLatest("cache/snapshots")
+//	events := filepath.Join("cache/snapshots", latest.File)
+//
+// Snapshots are keyed by their listing day in the server's timezone, e.g.
+// 2026-10-15.json, and latest.json points at the newest one. Files are
+// replaced by renaming a complete copy over them, so a reader never sees a
+// partial file.
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DateLayout is the layout of date keys.
+const DateLayout = "2006-01-02"
+
+// LatestFile is the name of the pointer to the newest snapshot.
+const LatestFile = "latest.json"
+
+// Data Structures
+
+// Latest points at the newest daily snapshot.
+type Latest struct {
+	Date string `json:"date"`
+	// File is the snapshot's name, relative to the snapshot directory
+	File      string    `json:"file"`
+	Events    int       `json:"events"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Helper Functions
+
+// DateKey returns the key of the day t falls on in its own location, so
+// callers pass t in the server's timezone.
+func DateKey(t time.Time) string {
+	return t.Format(DateLayout)
+}
+
+// ParseDateKey reads a date key as midnight UTC.
+func ParseDateKey(key string) (time.Time, error) {
+	return time.Parse(DateLayout, key)
+}
+
+// SnapshotName returns the name of the snapshot for the day with key.
+func SnapshotName(key string) string {
+	return key + ".json"
+}
+
+// ReadLatest reads the pointer in dir.
+func ReadLatest(dir string) (Latest, error) {
+	var latest Latest
+	data, err := os.ReadFile(filepath.Join(dir, LatestFile))
+	if err != nil {
+		return latest, err
+	}
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return latest, fmt.Errorf("parsing %s: %v", LatestFile, err)
+	}
+	if _, err := ParseDateKey(latest.Date); err != nil {
+		return latest, fmt.Errorf("%s has an invalid date %q", LatestFile, latest.Date)
+	}
+	return latest, nil
+}
+
+// WriteLatest points dir's pointer at latest, unless it already points at a
+// later day.
+func WriteLatest(dir string, latest Latest) error {
+	if current, err := ReadLatest(dir); err == nil && current.Date > latest.Date {
+		return nil
+	}
+	data, err := json.MarshalIndent(latest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, LatestFile), append(data, '\n'), 0644)
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so concurrent readers (including other processes
+// sharing the directory) never observe a partial file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,356 @@
+// Package client is a Go client for the mapthens API, for services and bots
+// that consume today's Athens events:
+//
+//	c := client.New("https://mapthens.com")
+//	resp, err := c.ListEvents(ctx, client.ListOptions{Outdoor: &yes})
+//
+// Requests that fail with a network error, 429, or a 5xx status are retried
+// with exponential backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 500 * time.Millisecond
+	maxBackoff        = 10 * time.Second
+	// The server caps POST /api/events/query at this many events per page
+	maxPageSize = 500
+)
+
+// ErrNotFound is returned by GetEvent for unknown event IDs.
+var ErrNotFound = errors.New("event not found")
+
+// Data Structures
+
+type Client struct {
+	// BaseURL is the server's origin, e.g. "https://mapthens.com"
+	BaseURL    string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed request is retried; 0 disables
+	// retries
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubled for each one after
+	Backoff time.Duration
+}
+
+// Event mirrors the server's event JSON.
+type Event struct {
+	ID              string    `json:"id"`
+	Date            string    `json:"date"`
+	StartDate       string    `json:"start_date"`
+	EndDate         string    `json:"end_date"`
+	Datetime        string    `json:"datetime"`
+	Category        string    `json:"category"`
+	Title           string    `json:"title"`
+	EventLink       string    `json:"event_link"`
+	Venue           string    `json:"venue"`
+	Address         string    `json:"address"`
+	Description     string    `json:"description"`
+	Outdoor         bool      `json:"outdoor"`
+	Featured        bool      `json:"featured"`
+	LinkBroken      bool      `json:"link_broken"`
+	Latitude        float64   `json:"latitude"`
+	Longitude       float64   `json:"longitude"`
+	SourceName      string    `json:"source_name"`
+	SourceURL       string    `json:"source_url"`
+	ScrapedAt       time.Time `json:"scraped_at"`
+	GeocodeProvider string    `json:"geocode_provider,omitempty"`
+	Added           bool      `json:"added,omitempty"`
+	VenueType       string    `json:"venue_type,omitempty"`
+	VenueCapacity   int       `json:"venue_capacity,omitempty"`
+	FamilyFriendly  bool      `json:"family_friendly"`
+	Tickets         *Tickets  `json:"tickets,omitempty"`
+	WalkingMinutes  *int      `json:"walking_minutes,omitempty"`
+	DistanceMeters  *float64  `json:"distance_meters,omitempty"`
+	// ShortCode links to the event's share page, BaseURL + "/s/" + ShortCode
+	ShortCode string `json:"short_code,omitempty"`
+
+	// Merged fields taken from a listing other than SourceName's, by JSON
+	// field name, with the source each came from
+	FieldSources map[string]string `json:"field_sources,omitempty"`
+
+	// From the venue's own calendar, for the venues the server reads one for
+	SupportActs []string   `json:"support_acts,omitempty"`
+	DoorTime    *time.Time `json:"door_time,omitempty"`
+
+	// DescriptionTruncated is set when Description was shortened; list
+	// with FullDescriptions or use GetEvent for the whole text
+	DescriptionTruncated bool `json:"description_truncated,omitempty"`
+
+	// StartTime is nil for all-day events, including those whose time is
+	// to be announced
+	AllDay    bool       `json:"all_day"`
+	StartTime *time.Time `json:"start_time"`
+
+	// Only set when asked for with ListOptions.Expand
+	VenueDetail *VenueDetail       `json:"venue_detail,omitempty"`
+	Series      []SeriesOccurrence `json:"series,omitempty"`
+	Weather     *Weather           `json:"weather,omitempty"`
+
+	// Where to draw the event's marker, moved off other events at the same
+	// point
+	DisplayLatitude  *float64 `json:"display_latitude,omitempty"`
+	DisplayLongitude *float64 `json:"display_longitude,omitempty"`
+}
+
+// VenueDetail is the server's gazetteer entry for an event's venue.
+type VenueDetail struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Outdoor   bool     `json:"outdoor"`
+	Type      string   `json:"type,omitempty"`
+	Capacity  int      `json:"capacity,omitempty"`
+	Website   string   `json:"website,omitempty"`
+	Latitude  float64  `json:"latitude,omitempty"`
+	Longitude float64  `json:"longitude,omitempty"`
+	// EventsPage is the venue's own calendar, read for support acts and
+	// door times
+	EventsPage string `json:"events_page,omitempty"`
+	Calendar   string `json:"calendar"`
+}
+
+// SeriesOccurrence is another listing of an event with the same title.
+type SeriesOccurrence struct {
+	ID        string `json:"id"`
+	StartDate string `json:"start_date"`
+	Datetime  string `json:"datetime"`
+	Venue     string `json:"venue"`
+}
+
+// Weather is the forecast for when an event starts.
+type Weather struct {
+	Time                time.Time `json:"time"`
+	Forecast            string    `json:"forecast"`
+	Temperature         int       `json:"temperature"`
+	TemperatureUnit     string    `json:"temperature_unit"`
+	PrecipitationChance *int      `json:"precipitation_chance,omitempty"`
+	WindSpeed           string    `json:"wind_speed,omitempty"`
+}
+
+// Tickets is what the server last read from a ticketed event's ticketing
+// page.
+type Tickets struct {
+	URL       string      `json:"url"`
+	Provider  string      `json:"provider"`
+	OnSaleAt  *time.Time  `json:"on_sale_at,omitempty"`
+	Tiers     []PriceTier `json:"tiers,omitempty"`
+	SoldOut   bool        `json:"sold_out"`
+	CheckedAt time.Time   `json:"checked_at"`
+}
+
+type PriceTier struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// EventsResponse is the v2 envelope the server wraps events in.
+type EventsResponse struct {
+	Version        int       `json:"version"`
+	Events         []Event   `json:"events"`
+	ScrapedAt      time.Time `json:"scraped_at"`
+	DataAgeSeconds int64     `json:"data_age_seconds"`
+	Total          int       `json:"total"`
+	Order          string    `json:"order"`
+}
+
+// ListOptions narrows ListEvents; zero values don't filter.
+type ListOptions struct {
+	Outdoor    *bool
+	Featured   *bool
+	Family     *bool
+	VenueTypes []string
+	Size       string // small, medium, or large
+	// From adds walking minutes from this latitude and longitude
+	From *[2]float64
+	// FullDescriptions turns off the server's truncation of descriptions
+	FullDescriptions bool
+	// Expand names expansions to attach: "tickets", "venue", "series", or
+	// "weather"
+	Expand []string
+}
+
+// Filter is a POST /api/events/query filter. Every set field must match.
+type Filter struct {
+	All          []Filter   `json:"all,omitempty"`
+	Any          []Filter   `json:"any,omitempty"`
+	Categories   []string   `json:"categories,omitempty"`
+	Venues       []string   `json:"venues,omitempty"`
+	VenueTypes   []string   `json:"venue_types,omitempty"`
+	Size         string     `json:"size,omitempty"`
+	BBox         []float64  `json:"bbox,omitempty"` // min lng, min lat, max lng, max lat
+	StartsAfter  *time.Time `json:"starts_after,omitempty"`
+	StartsBefore *time.Time `json:"starts_before,omitempty"`
+	Text         string     `json:"text,omitempty"`
+	Outdoor      *bool      `json:"outdoor,omitempty"`
+	Featured     *bool      `json:"featured,omitempty"`
+	Family       *bool      `json:"family,omitempty"`
+}
+
+type query struct {
+	Filter Filter `json:"filter"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// APIError is returned for responses with an error status that retries
+// didn't fix.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mapthens: %d %s", e.StatusCode, e.Message)
+}
+
+// Helper Functions
+
+// New returns a client for the server at baseURL with default retries.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: defaultMaxRetries,
+		Backoff:    defaultBackoff,
+	}
+}
+
+// ListEvents returns today's events.
+func (c *Client) ListEvents(ctx context.Context, opts ListOptions) (*EventsResponse, error) {
+	params := url.Values{}
+	params.Set("v", "2")
+	if opts.Outdoor != nil {
+		params.Set("outdoor", strconv.FormatBool(*opts.Outdoor))
+	}
+	if opts.Featured != nil {
+		params.Set("featured", strconv.FormatBool(*opts.Featured))
+	}
+	if opts.Family != nil {
+		params.Set("family", strconv.FormatBool(*opts.Family))
+	}
+	if len(opts.VenueTypes) > 0 {
+		params.Set("venue_type", strings.Join(opts.VenueTypes, ","))
+	}
+	if opts.Size != "" {
+		params.Set("size", opts.Size)
+	}
+	if opts.From != nil {
+		params.Set("from", fmt.Sprintf("%v,%v", opts.From[0], opts.From[1]))
+	}
+	expand := opts.Expand
+	if opts.FullDescriptions {
+		expand = append([]string{"description"}, expand...)
+	}
+	if len(expand) > 0 {
+		params.Set("expand", strings.Join(expand, ","))
+	}
+
+	var resp EventsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/events?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetEvent returns one of today's events, or one that ended recently.
+func (c *Client) GetEvent(ctx context.Context, id string) (*Event, error) {
+	var e Event
+	err := c.do(ctx, http.MethodGet, "/api/events/"+url.PathEscape(id), nil, &e)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Search returns one page of the events matching filter. A limit of 0
+// returns every match.
+func (c *Client) Search(ctx context.Context, filter Filter, limit, offset int) (*EventsResponse, error) {
+	body, err := json.Marshal(query{Filter: filter, Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	var resp EventsResponse
+	if err := c.do(ctx, http.MethodPost, "/api/events/query?v=2", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do sends a request, retrying failures that may be temporary, and decodes
+// the JSON response into v.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, v interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		wait := backoff << attempt
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				defer resp.Body.Close()
+				if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+					return fmt.Errorf("mapthens: decoding response: %v", err)
+				}
+				return nil
+			}
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+			err = &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+			if !retryable(resp.StatusCode) {
+				return err
+			}
+			if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		if ctx.Err() != nil || attempt >= c.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(min(wait, maxBackoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
@@ -0,0 +1,81 @@
+package client
+
+import "context"
+
+// Data Structures
+
+// EventIterator pages through the results of SearchAll:
+//
+//	it := c.SearchAll(ctx, client.Filter{Categories: []string{"Music"}}, 100)
+//	for it.Next() {
+//		fmt.Println(it.Event().Title)
+//	}
+//	if err := it.Err(); err != nil { ... }
+type EventIterator struct {
+	ctx      context.Context
+	client   *Client
+	filter   Filter
+	pageSize int
+
+	page    []Event
+	index   int
+	offset  int
+	total   int
+	event   Event
+	err     error
+	fetched bool
+}
+
+// Helper Functions
+
+// SearchAll iterates over every event matching filter, fetching pageSize
+// events at a time (at most 500).
+func (c *Client) SearchAll(ctx context.Context, filter Filter, pageSize int) *EventIterator {
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return &EventIterator{ctx: ctx, client: c, filter: filter, pageSize: pageSize}
+}
+
+// Next advances to the next event, fetching the next page when needed. It
+// returns false once every event was seen or a request failed.
+func (it *EventIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.index >= len(it.page) {
+		if it.fetched && it.offset >= it.total {
+			return false
+		}
+		resp, err := it.client.Search(it.ctx, it.filter, it.pageSize, it.offset)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.fetched = true
+		it.page, it.index, it.total = resp.Events, 0, resp.Total
+		it.offset += len(resp.Events)
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.event = it.page[it.index]
+	it.index++
+	return true
+}
+
+// Event returns the event Next advanced to.
+func (it *EventIterator) Event() Event {
+	return it.event
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// Total returns the number of matching events reported by the server, once
+// the first page has been fetched.
+func (it *EventIterator) Total() int {
+	return it.total
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Data Structures
+
+// Change is a message from the server's /ws feed. The first one is a
+// "snapshot" holding every matching event in Events; later ones are
+// "diff"s listing the events Added, Updated, and Removed (by ID) since.
+type Change struct {
+	Type      string     `json:"type"`
+	Events    []Event    `json:"events,omitempty"`
+	Added     []Event    `json:"added,omitempty"`
+	Updated   []Event    `json:"updated,omitempty"`
+	Removed   []string   `json:"removed,omitempty"`
+	ScrapedAt *time.Time `json:"scraped_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type subscribe struct {
+	Type   string `json:"type"`
+	Filter Filter `json:"filter"`
+}
+
+// Helper Functions
+
+// StreamChanges connects to the live feed and calls fn with each snapshot
+// and diff of the events matching filter, until ctx is done, fn returns an
+// error, or the connection drops. The feed isn't retried; callers that
+// reconnect get a fresh snapshot.
+func (c *Client) StreamChanges(ctx context.Context, filter Filter, fn func(Change) error) error {
+	origin, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return err
+	}
+	location := *origin
+	location.Scheme = strings.Replace(origin.Scheme, "http", "ws", 1)
+	location.Path = strings.TrimSuffix(origin.Path, "/") + "/ws"
+
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	config.Dialer = &net.Dialer{Timeout: 30 * time.Second}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return fmt.Errorf("mapthens: connecting to live feed: %v", err)
+	}
+	defer ws.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	if err := websocket.JSON.Send(ws, subscribe{Type: "subscribe", Filter: filter}); err != nil {
+		return err
+	}
+	// The server sends an unfiltered snapshot on connect, before it reads
+	// the subscription; everything up to the snapshot that follows it is
+	// skipped
+	snapshots := 0
+	for {
+		var change Change
+		if err := websocket.JSON.Receive(ws, &change); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch change.Type {
+		case "error":
+			return fmt.Errorf("mapthens: live feed: %s", change.Error)
+		case "snapshot":
+			if snapshots++; snapshots < 2 {
+				continue
+			}
+		case "diff":
+			if snapshots < 2 {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresStore archives each day's events in Postgres with a PostGIS point
+// per event. The geography index serves radius and nearest-neighbour
+// queries in meters; the geometry index serves bounding boxes. The columns
+// hold the fields queries filter on, and the event column the whole event
+// as served, so past days come back in the same shape as today. Rows saved
+// before it was added are normalized like freshly loaded events.
+
+const postgresSchema = `
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE IF NOT EXISTS events (
+	listed_on   date        NOT NULL,
+	id          text        NOT NULL,
+	date        text        NOT NULL,
+	start_date  date        NOT NULL,
+	end_date    date        NOT NULL,
+	datetime    text        NOT NULL,
+	category    text        NOT NULL,
+	title       text        NOT NULL,
+	event_link  text        NOT NULL,
+	venue       text        NOT NULL,
+	address     text        NOT NULL,
+	description text        NOT NULL,
+	outdoor     boolean     NOT NULL,
+	featured    boolean     NOT NULL,
+	link_broken boolean     NOT NULL,
+	geom        geometry(Point, 4326),
+	scraped_at  timestamptz NOT NULL,
+	PRIMARY KEY (listed_on, id)
+);
+
+ALTER TABLE events
+	ADD COLUMN IF NOT EXISTS source_name      text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS source_url       text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS geocode_provider text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS event            jsonb;
+
+CREATE INDEX IF NOT EXISTS events_geom_idx ON events USING GIST (geom);
+CREATE INDEX IF NOT EXISTS events_geog_idx ON events USING GIST ((geom::geography));
+
+CREATE TABLE IF NOT EXISTS event_analytics (
+	day         date    NOT NULL,
+	event_id    text    NOT NULL,
+	popup_opens integer NOT NULL,
+	link_clicks integer NOT NULL,
+	PRIMARY KEY (day, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS event_analytics_event_idx ON event_analytics (event_id);
+
+CREATE TABLE IF NOT EXISTS short_links (
+	code       text        PRIMARY KEY,
+	event_id   text        NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS geocode_usage (
+	day      date    PRIMARY KEY,
+	requests integer NOT NULL
+);
+`
+
+const postgresEventColumns = `id, date, start_date::text, end_date::text, datetime, category, title,
+	event_link, venue, address, description, outdoor, featured, link_broken,
+	source_name, source_url, scraped_at, geocode_provider, event, ST_Y(geom), ST_X(geom)`
+
+// Data Structures
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+// Helper Functions
+
+func openPostgresStore(databaseURL string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	if _, err := db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating schema: %v", err)
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) SaveEvents(day string, events []Event, scrapedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM events WHERE listed_on = $1`, day); err != nil {
+		return fmt.Errorf("error clearing %s: %v", day, err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO events (listed_on, id, date, start_date, end_date, datetime,
+		category, title, event_link, venue, address, description, outdoor, featured, link_broken,
+		geom, scraped_at, source_name, source_url, geocode_provider, event)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+		CASE WHEN $16::float8 = 0 AND $17::float8 = 0 THEN NULL
+		ELSE ST_SetSRID(ST_MakePoint($16::float8, $17::float8), 4326) END, $18, $19, $20, $21, $22)
+	ON CONFLICT (listed_on, id) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		full, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("error encoding event %s: %v", e.ID, err)
+		}
+		_, err = stmt.Exec(day, e.ID, e.Date, e.StartDate, e.EndDate, e.Datetime,
+			e.Category, e.Title, e.EventLink, e.Venue, e.Address, e.Description,
+			e.Outdoor, e.Featured, e.LinkBroken, e.Longitude, e.Latitude, scrapedAt,
+			e.SourceName, e.SourceURL, e.GeocodeProvider, full)
+		if err != nil {
+			return fmt.Errorf("error saving event %s: %v", e.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) Within(day string, bbox [4]float64) ([]Event, error) {
+	rows, err := s.db.Query(`SELECT `+postgresEventColumns+`, NULL::float8
+		FROM events
+		WHERE listed_on = $1 AND geom && ST_MakeEnvelope($2::float8, $3::float8, $4::float8, $5::float8, 4326)`,
+		day, bbox[0], bbox[1], bbox[2], bbox[3])
+	if err != nil {
+		return nil, err
+	}
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+func (s *postgresStore) Nearby(day string, origin coordinates, radius float64, limit int) ([]Event, error) {
+	if limit <= 0 {
+		limit = maxQueryLimit
+	}
+	rows, err := s.db.Query(`SELECT `+postgresEventColumns+`, ST_Distance(geom::geography, origin)
+		FROM events, (SELECT ST_SetSRID(ST_MakePoint($2::float8, $3::float8), 4326)::geography AS origin) o
+		WHERE listed_on = $1 AND geom IS NOT NULL
+			AND ($4::float8 = 0 OR ST_DWithin(geom::geography, origin, $4::float8))
+		ORDER BY geom::geography <-> origin
+		LIMIT $5`,
+		day, origin.Longitude, origin.Latitude, radius, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// Listed reads all of days' partitions in one query.
+func (s *postgresStore) Listed(days []string) (map[string][]Event, error) {
+	rows, err := s.db.Query(`SELECT `+postgresEventColumns+`, NULL::float8, listed_on::text
+		FROM events
+		WHERE listed_on = ANY($1::date[])`,
+		pq.Array(days))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	listed := make(map[string][]Event, len(days))
+	for _, day := range days {
+		listed[day] = []Event{}
+	}
+	for rows.Next() {
+		var day string
+		e, err := scanEvent(rows, &day)
+		if err != nil {
+			return nil, err
+		}
+		listed[day] = append(listed[day], e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, events := range listed {
+		sortEvents(events)
+	}
+	return listed, nil
+}
+
+func (s *postgresStore) AddAnalytics(day string, counts map[string]EventStats) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO event_analytics (day, event_id, popup_opens, link_clicks)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (day, event_id) DO UPDATE
+		SET popup_opens = event_analytics.popup_opens + EXCLUDED.popup_opens,
+			link_clicks = event_analytics.link_clicks + EXCLUDED.link_clicks`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, stats := range counts {
+		if _, err := stmt.Exec(day, id, stats.PopupOpens, stats.LinkClicks); err != nil {
+			return fmt.Errorf("error saving analytics for %s: %v", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) Analytics(day string) (map[string]EventStats, error) {
+	return s.queryAnalytics(`SELECT event_id, popup_opens, link_clicks
+		FROM event_analytics WHERE day = $1`, day)
+}
+
+func (s *postgresStore) EventAnalytics(id string) (map[string]EventStats, error) {
+	return s.queryAnalytics(`SELECT day::text, popup_opens, link_clicks
+		FROM event_analytics WHERE event_id = $1`, id)
+}
+
+// queryAnalytics reads rows of a key and its two counts into a map.
+func (s *postgresStore) queryAnalytics(query string, arg interface{}) (map[string]EventStats, error) {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]EventStats{}
+	for rows.Next() {
+		var key string
+		var stats EventStats
+		if err := rows.Scan(&key, &stats.PopupOpens, &stats.LinkClicks); err != nil {
+			return nil, err
+		}
+		counts[key] = stats
+	}
+	return counts, rows.Err()
+}
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *postgresStore) SaveShortLinks(links map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO short_links (code, event_id) VALUES ($1, $2)
+	ON CONFLICT (code) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for code, id := range links {
+		if _, err := stmt.Exec(code, id); err != nil {
+			return fmt.Errorf("error saving short link %s: %v", code, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) ShortLinks(codes []string) (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT code, event_id FROM short_links WHERE code = ANY($1)`, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := map[string]string{}
+	for rows.Next() {
+		var code, id string
+		if err := rows.Scan(&code, &id); err != nil {
+			return nil, err
+		}
+		links[code] = id
+	}
+	return links, rows.Err()
+}
+
+// AddGeocodeUsage increments day's row in place, so server processes
+// sharing the database all count against one budget.
+func (s *postgresStore) AddGeocodeUsage(day string, n int) error {
+	if _, err := s.db.Exec(`INSERT INTO geocode_usage (day, requests) VALUES ($1, $2)
+	ON CONFLICT (day) DO UPDATE SET requests = geocode_usage.requests + EXCLUDED.requests`, day, n); err != nil {
+		return err
+	}
+	oldest := localNow().AddDate(0, -usageMonthsKept, 0).Format("2006-01") + "-01"
+	_, err := s.db.Exec(`DELETE FROM geocode_usage WHERE day < $1`, oldest)
+	return err
+}
+
+func (s *postgresStore) GeocodeUsage() (GeocodeUsage, error) {
+	usage := newGeocodeUsage()
+	t := localNow()
+	oldestDay := t.AddDate(0, 0, -usageDaysKept).Format("2006-01-02")
+	rows, err := s.db.Query(`SELECT day::text, requests FROM geocode_usage WHERE day >= $1`,
+		t.AddDate(0, -usageMonthsKept, 0).Format("2006-01")+"-01")
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			return usage, err
+		}
+		usage.Months[day[:7]] += n
+		if day >= oldestDay {
+			usage.Days[day] = n
+		}
+	}
+	return usage, rows.Err()
+}
+
+// scanEvent scans a row of postgresEventColumns and a distance, followed by
+// any extra columns into extra.
+func scanEvent(rows *sql.Rows, extra ...interface{}) (Event, error) {
+	var e Event
+	var full []byte
+	var lat, lng, distance sql.NullFloat64
+	dest := []interface{}{&e.ID, &e.Date, &e.StartDate, &e.EndDate, &e.Datetime, &e.Category, &e.Title,
+		&e.EventLink, &e.Venue, &e.Address, &e.Description, &e.Outdoor, &e.Featured, &e.LinkBroken,
+		&e.SourceName, &e.SourceURL, &e.ScrapedAt, &e.GeocodeProvider, &full, &lat, &lng, &distance}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return Event{}, err
+	}
+	if full != nil {
+		e = Event{}
+		if err := json.Unmarshal(full, &e); err != nil {
+			return Event{}, fmt.Errorf("error decoding event: %v", err)
+		}
+	} else {
+		e.Latitude, e.Longitude = lat.Float64, lng.Float64
+		normalizeEvent(&e)
+	}
+	if distance.Valid {
+		e.DistanceMeters = &distance.Float64
+	}
+	return e, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A source whose scraper breaks (a redesigned page, an API that starts
+// answering 500s) would otherwise fail and log on every run until someone
+// notices. Each source's successes and failures are counted across runs, and
+// after MAPTHENS_SOURCE_FAILURE_LIMIT failures in a row the source is
+// quarantined: it's skipped by scrapes, with its last listing of the day
+// still merged if it has one. Every sourceProbeInterval one scrape tries it
+// again as a probe, and a probe that succeeds releases it; an admin can
+// release it sooner. flagpole, the primary source, is never quarantined
+// automatically, since without it every scrape would come back empty; it's
+// only alerted on. Quarantining, and flagpole reaching the limit, logs a
+// warning and POSTs an alert to MAPTHENS_ALERT_WEBHOOK_URL (see slo.go), as
+// does the source's recovery; quarantines are also reported in the run
+// metrics (SourcesQuarantined). /api/status/sources reports each source's
+// record, kept in source_health.json in the cache directory.
+
+const (
+	defaultSourceFailureLimit = 5
+	sourceProbeInterval       = 6 * time.Hour
+)
+
+// Data Structures
+
+type SourceHealth struct {
+	Source              string     `json:"source"`
+	Runs                int        `json:"runs"`
+	Failures            int        `json:"failures"`
+	FailureRate         float64    `json:"failure_rate"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	Quarantined         bool       `json:"quarantined"`
+	QuarantinedAt       *time.Time `json:"quarantined_at,omitempty"`
+	// QuarantinedBy names the admin who quarantined the source by hand;
+	// such quarantines aren't probed
+	QuarantinedBy string `json:"quarantined_by,omitempty"`
+	// NextProbe is when a scrape next tries an automatically quarantined
+	// source
+	NextProbe *time.Time `json:"next_probe,omitempty"`
+	// ReleasedBy names the admin who last took the source out of quarantine
+	ReleasedBy string `json:"released_by,omitempty"`
+}
+
+type sourceRelease struct {
+	Quarantined *bool `json:"quarantined"`
+}
+
+type sourceAlert struct {
+	Source              string    `json:"source"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Error               string    `json:"error,omitempty"`
+	At                  time.Time `json:"at"`
+	Text                string    `json:"text"`
+}
+
+// Global Variables
+var (
+	sourceHealth      = map[string]SourceHealth{}
+	sourceHealthMutex sync.RWMutex
+	sourceHealthFile  = "source_health.json"
+)
+
+// Helper Functions
+
+// scrapedSources are the sources scrapeEvents fetches.
+func scrapedSources() []string {
+	sources := []string{originFlagpole}
+	if getConfig().UGACalendarURL != "" {
+		sources = append(sources, originUGA)
+	}
+	if len(venueCalendars()) > 0 {
+		sources = append(sources, originVenueCalendar)
+	}
+	return sources
+}
+
+// sourceQuarantined reports whether scrapes should skip origin: it's
+// quarantined, and not due a probe.
+func sourceQuarantined(origin string) bool {
+	sourceHealthMutex.RLock()
+	defer sourceHealthMutex.RUnlock()
+	h := sourceHealth[origin]
+	return h.Quarantined && (h.NextProbe == nil || now().Before(*h.NextProbe))
+}
+
+// recordSourceResult counts a scrape of origin, quarantining it once it has
+// failed MAPTHENS_SOURCE_FAILURE_LIMIT times in a row, unless it's flagpole,
+// and releasing it when a probe succeeds.
+func recordSourceResult(origin string, err error) {
+	sourceHealthMutex.Lock()
+	h := sourceHealth[origin]
+	h.Source = origin
+	h.Runs++
+	at := now()
+	limit := getConfig().SourceFailureLimit
+	var alert *sourceAlert
+	if err == nil {
+		if h.Quarantined && h.NextProbe != nil {
+			h.Quarantined, h.QuarantinedAt, h.NextProbe = false, nil, nil
+			log.Printf("Source %s released from quarantine after a successful probe.", origin)
+			alert = newSourceAlert(h, "recovered", at)
+		} else if origin == originFlagpole && limit > 0 && h.ConsecutiveFailures >= limit {
+			alert = newSourceAlert(h, "recovered", at)
+		}
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = &at
+	} else {
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.LastError = err.Error()
+		h.LastFailure = &at
+		switch {
+		case h.Quarantined:
+			// A failed probe waits for the next one
+			if h.NextProbe != nil {
+				next := at.Add(sourceProbeInterval)
+				h.NextProbe = &next
+			}
+		case limit <= 0 || h.ConsecutiveFailures < limit:
+		case origin == originFlagpole:
+			// Alerted once, when it reached the limit
+			if h.ConsecutiveFailures == limit {
+				log.Printf("Warning: Source %s has failed %d times in a row: %v", origin, h.ConsecutiveFailures, err)
+				alert = newSourceAlert(h, "failing", at)
+			}
+		default:
+			next := at.Add(sourceProbeInterval)
+			h.Quarantined, h.QuarantinedAt, h.QuarantinedBy, h.NextProbe = true, &at, "", &next
+			log.Printf("Warning: Quarantined source %s after %d failures in a row: %v", origin, h.ConsecutiveFailures, err)
+			alert = newSourceAlert(h, "quarantined", at)
+		}
+	}
+	h.FailureRate = float64(h.Failures) / float64(h.Runs)
+	sourceHealth[origin] = h
+	saveSourceHealthLocked(origin)
+	sourceHealthMutex.Unlock()
+
+	if alert != nil {
+		if err := sendAlert(alert); err != nil {
+			log.Printf("Warning: Failed to send source alert: %v", err)
+		}
+	}
+}
+
+func newSourceAlert(h SourceHealth, state string, at time.Time) *sourceAlert {
+	alert := &sourceAlert{Source: h.Source, State: state, ConsecutiveFailures: h.ConsecutiveFailures, At: at}
+	switch state {
+	case "quarantined":
+		alert.Error = h.LastError
+		alert.Text = fmt.Sprintf("Mapthens source %s quarantined after %d failures in a row: %s", h.Source, h.ConsecutiveFailures, h.LastError)
+	case "failing":
+		alert.Error = h.LastError
+		alert.Text = fmt.Sprintf("Mapthens source %s has failed %d scrapes in a row: %s", h.Source, h.ConsecutiveFailures, h.LastError)
+	default:
+		alert.Text = fmt.Sprintf("Mapthens source %s recovered after %d failures in a row", h.Source, h.ConsecutiveFailures)
+	}
+	return alert
+}
+
+// setSourceQuarantine quarantines or releases origin by hand. A source
+// quarantined by hand stays quarantined until it's released. Releasing it
+// clears its failure streak, so it gets a full MAPTHENS_SOURCE_FAILURE_LIMIT
+// runs again.
+func setSourceQuarantine(origin string, quarantined bool, editor string) SourceHealth {
+	sourceHealthMutex.Lock()
+	defer sourceHealthMutex.Unlock()
+
+	h := sourceHealth[origin]
+	h.Source = origin
+	if quarantined {
+		if !h.Quarantined {
+			at := now()
+			h.Quarantined, h.QuarantinedAt = true, &at
+		}
+		h.QuarantinedBy, h.NextProbe = editor, nil
+		if h.QuarantinedBy == "" {
+			h.QuarantinedBy = "admin"
+		}
+	} else {
+		h.Quarantined, h.QuarantinedAt, h.QuarantinedBy, h.NextProbe = false, nil, "", nil
+		h.ConsecutiveFailures = 0
+		h.ReleasedBy = editor
+	}
+	sourceHealth[origin] = h
+	saveSourceHealthLocked(origin)
+	return h
+}
+
+// saveSourceHealthLocked saves origin's record. The file is re-read under
+// its lock, so the records other processes sharing the cache directory saved
+// for other sources are kept, and picked up here. The caller holds
+// sourceHealthMutex.
+func saveSourceHealthLocked(origin string) {
+	saved := map[string]SourceHealth{}
+	err := updateJSONFile(sourceHealthFile, &saved, func() error {
+		saved[origin] = sourceHealth[origin]
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to save source health: %v", err)
+		return
+	}
+	sourceHealth = saved
+}
+
+func loadSourceHealth() {
+	loaded := map[string]SourceHealth{}
+	if err := readJSONFile(sourceHealthFile, &loaded); err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read source health file: %v", err)
+		}
+		return
+	}
+	// Files saved before quarantines were probed: probe every source
+	// quarantined automatically on the next scrape
+	at := now()
+	for origin, h := range loaded {
+		if h.Quarantined && h.QuarantinedBy == "" && h.NextProbe == nil {
+			h.NextProbe = &at
+			loaded[origin] = h
+		}
+	}
+	sourceHealthMutex.Lock()
+	sourceHealth = loaded
+	sourceHealthMutex.Unlock()
+}
+
+// sourceHealthReport lists the record of every scraped source, and of any
+// other source with one, by name.
+func sourceHealthReport() []SourceHealth {
+	sourceHealthMutex.RLock()
+	defer sourceHealthMutex.RUnlock()
+	report := map[string]SourceHealth{}
+	for _, origin := range scrapedSources() {
+		report[origin] = SourceHealth{Source: origin}
+	}
+	for origin, h := range sourceHealth {
+		report[origin] = h
+	}
+	list := make([]SourceHealth, 0, len(report))
+	for _, h := range report {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Source < list[j].Source })
+	return list
+}
+
+// quarantinedSources lists the quarantined sources by name.
+func quarantinedSources() []string {
+	sourceHealthMutex.RLock()
+	defer sourceHealthMutex.RUnlock()
+	var names []string
+	for origin, h := range sourceHealth {
+		if h.Quarantined {
+			names = append(names, origin)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HTTP Handlers
+
+// sourceStatusHandler serves GET /api/status/sources.
+func sourceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, sourceHealthReport())
+}
+
+// sourcesAdminHandler serves
+//
+//	POST /api/admin/sources/{name}  quarantine or release a source, e.g.
+//	                                {"quarantined": false}
+func sourcesAdminHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	origin := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/sources"), "/")
+	if origin != originFlagpole && origin != originUGA && origin != originVenueCalendar {
+		http.NotFound(w, r)
+		return
+	}
+	var body sourceRelease
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if body.Quarantined == nil {
+		http.Error(w, "Missing quarantined field", http.StatusBadRequest)
+		return
+	}
+
+	editor := adminEditor(r)
+	h := setSourceQuarantine(origin, *body.Quarantined, editor)
+	who := editor
+	if who == "" {
+		who = "an admin"
+	}
+	if h.Quarantined {
+		log.Printf("Source %s quarantined by %s.", origin, who)
+	} else {
+		log.Printf("Source %s released from quarantine by %s.", origin, who)
+	}
+	writeJSON(w, h)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// POST /api/events/query takes a structured filter document for searches
+// that don't fit in a query string, e.g.
+//
+//	{
+//	  "filter": {
+//	    "any": [{"categories": ["Music"]}, {"text": "jazz"}],
+//	    "bbox": [-83.39, 33.95, -83.37, 33.97],
+//	    "starts_after": "2025-12-10T18:00:00-05:00"
+//	  },
+//	  "limit": 20
+//	}
+//
+// Conditions within one filter are ANDed together, "all" groups must all
+// match, and at least one filter in an "any" group must match.
+
+const (
+	maxFilterDepth = 8
+	maxQueryLimit  = 500
+)
+
+// Data Structures
+
+type EventFilter struct {
+	All          []EventFilter `json:"all,omitempty"`
+	Any          []EventFilter `json:"any,omitempty"`
+	Categories   []string      `json:"categories,omitempty"`
+	Venues       []string      `json:"venues,omitempty"`
+	VenueTypes   []string      `json:"venue_types,omitempty"`
+	Size         string        `json:"size,omitempty"` // small, medium, or large
+	BBox         []float64     `json:"bbox,omitempty"` // min lng, min lat, max lng, max lat
+	StartsAfter  *time.Time    `json:"starts_after,omitempty"`
+	StartsBefore *time.Time    `json:"starts_before,omitempty"`
+	Text         string        `json:"text,omitempty"`
+	Outdoor      *bool         `json:"outdoor,omitempty"`
+	Featured     *bool         `json:"featured,omitempty"`
+	Family       *bool         `json:"family,omitempty"`
+}
+
+type EventQuery struct {
+	Filter EventFilter `json:"filter"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// Helper Functions
+
+func (q EventQuery) validate() fieldErrors {
+	var errs fieldErrors
+	if q.Limit < 0 || q.Limit > maxQueryLimit {
+		errs.add("/limit", "must be between 0 and %d", maxQueryLimit)
+	}
+	if q.Offset < 0 {
+		errs.add("/offset", "must not be negative")
+	}
+	q.Filter.validate("/filter", 1, &errs)
+	return errs
+}
+
+func (f EventFilter) validate(path string, depth int, errs *fieldErrors) {
+	if depth > maxFilterDepth {
+		errs.add(path, "is nested more than %d deep", maxFilterDepth)
+		return
+	}
+	if f.BBox != nil {
+		if len(f.BBox) != 4 {
+			errs.add(pointer(path, "bbox"), "must have 4 values")
+		} else if f.BBox[0] > f.BBox[2] || f.BBox[1] > f.BBox[3] {
+			errs.add(pointer(path, "bbox"), "must be [min lng, min lat, max lng, max lat]")
+		}
+	}
+	switch f.Size {
+	case "", "small", "medium", "large":
+	default:
+		errs.add(pointer(path, "size"), "must be small, medium, or large")
+	}
+	if f.StartsAfter != nil && f.StartsBefore != nil && f.StartsBefore.Before(*f.StartsAfter) {
+		errs.add(pointer(path, "starts_before"), "is earlier than starts_after")
+	}
+	for i, sub := range f.All {
+		sub.validate(pointer(pointer(path, "all"), i), depth+1, errs)
+	}
+	for i, sub := range f.Any {
+		sub.validate(pointer(pointer(path, "any"), i), depth+1, errs)
+	}
+}
+
+func (f EventFilter) matches(e Event) bool {
+	if len(f.Categories) > 0 && !containsFold(f.Categories, e.Category) {
+		return false
+	}
+	if len(f.Venues) > 0 && !containsFold(f.Venues, e.Venue) {
+		return false
+	}
+	if len(f.VenueTypes) > 0 && !containsFold(f.VenueTypes, e.VenueType) {
+		return false
+	}
+	if f.Size != "" && venueSize(e.VenueCapacity) != f.Size {
+		return false
+	}
+	if f.BBox != nil && (e.Longitude < f.BBox[0] || e.Latitude < f.BBox[1] ||
+		e.Longitude > f.BBox[2] || e.Latitude > f.BBox[3]) {
+		return false
+	}
+	if f.StartsAfter != nil || f.StartsBefore != nil {
+		start, end, allDay, err := eventTimes(e)
+		if err != nil {
+			return false
+		}
+		// All-day events have no start time, so they match any window
+		// that overlaps one of their days
+		if f.StartsAfter != nil {
+			if allDay && !end.After(*f.StartsAfter) || !allDay && start.Before(*f.StartsAfter) {
+				return false
+			}
+		}
+		if f.StartsBefore != nil && !start.Before(*f.StartsBefore) {
+			return false
+		}
+	}
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		found := false
+		for _, field := range []string{e.Title, e.Description, e.Venue} {
+			if strings.Contains(strings.ToLower(field), text) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Outdoor != nil && e.Outdoor != *f.Outdoor {
+		return false
+	}
+	if f.Featured != nil && e.Featured != *f.Featured {
+		return false
+	}
+	if f.Family != nil && e.FamilyFriendly != *f.Family {
+		return false
+	}
+
+	for _, sub := range f.All {
+		if !sub.matches(e) {
+			return false
+		}
+	}
+	if len(f.Any) == 0 {
+		return true
+	}
+	for _, sub := range f.Any {
+		if sub.matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTP Handlers
+
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var query EventQuery
+	if !decodeValidated(w, r, &query, "Invalid query") {
+		return
+	}
+	if errs := query.validate(); len(errs) > 0 {
+		writeValidationErrors(w, "Invalid query", errs)
+		return
+	}
+
+	events, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	matched := []Event{}
+	for _, e := range events {
+		if query.Filter.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	total := len(matched)
+	matched = matched[min(query.Offset, total):]
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	writeEventsResponse(w, r, matched, total, eventOrder, info)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mapbox usage is tallied per local day and month and persisted through the
+// event store, so restarts don't reset the count and server processes
+// sharing a database or cache directory share one budget. Walking-time
+// matrix elements (see walking.go) count alongside geocodes. When
+// MAPBOX_MONTHLY_BUDGET is set and this month's usage reaches it, geocoding
+// and walking times stop until the next month and events rely on cached and
+// gazetteer coordinates.
+//
+// Requests are counted in memory as they're made and added to the store's
+// totals by saveGeocodeUsage, after each batch of geocodes. The totals,
+// which include other processes' requests, are re-read at most once a
+// minute for budget checks.
+
+const (
+	usageDaysKept   = 62
+	usageMonthsKept = 13
+
+	usageRefreshInterval = time.Minute
+)
+
+// Data Structures
+
+type GeocodeUsage struct {
+	Days   map[string]int `json:"days"`
+	Months map[string]int `json:"months"`
+}
+
+// Global Variables
+var (
+	// geocodeUsage is the store's totals as last read, and unsavedUsage the
+	// requests made since that haven't been added to them
+	geocodeUsage   = newGeocodeUsage()
+	unsavedUsage   int
+	usageLoadedAt  time.Time
+	usageFile      = "geocode_usage.json"
+	usageFileMutex sync.Mutex
+)
+
+// Helper Functions
+
+func newGeocodeUsage() GeocodeUsage {
+	return GeocodeUsage{Days: map[string]int{}, Months: map[string]int{}}
+}
+
+// add adds n requests to day's and its month's totals, dropping days and
+// months too old to keep.
+func (u GeocodeUsage) add(day string, n int) {
+	u.Days[day] += n
+	u.Months[day[:7]] += n
+
+	t := localNow()
+	oldestDay := t.AddDate(0, 0, -usageDaysKept).Format("2006-01-02")
+	for day := range u.Days {
+		if day < oldestDay {
+			delete(u.Days, day)
+		}
+	}
+	oldestMonth := t.AddDate(0, -usageMonthsKept, 0).Format("2006-01")
+	for month := range u.Months {
+		if month < oldestMonth {
+			delete(u.Months, month)
+		}
+	}
+}
+
+// recordGeocodeUsage counts n geocodes made today. Callers must hold
+// geocodeMutex.
+func recordGeocodeUsage(n int) {
+	unsavedUsage += n
+}
+
+// currentUsage returns today's and this month's usage, counting requests
+// not saved yet. Callers must hold geocodeMutex.
+func currentUsage() (day, month int) {
+	t := localNow()
+	return geocodeUsage.Days[t.Format("2006-01-02")] + unsavedUsage, geocodeUsage.Months[t.Format("2006-01")] + unsavedUsage
+}
+
+// geocodeBudgetRemaining returns how many geocodes are left this month, and
+// false when no budget is configured.
+func geocodeBudgetRemaining() (int, bool) {
+	budget := getConfig().MonthlyGeocodeBudget
+	if budget <= 0 {
+		return 0, false
+	}
+
+	geocodeMutex.Lock()
+	stale := since(usageLoadedAt) >= usageRefreshInterval
+	geocodeMutex.Unlock()
+	if stale {
+		loadGeocodeUsage()
+	}
+
+	geocodeMutex.Lock()
+	defer geocodeMutex.Unlock()
+	_, used := currentUsage()
+	return max(budget-used, 0), true
+}
+
+// loadGeocodeUsage reads the store's totals. On failure the last totals
+// read are kept.
+func loadGeocodeUsage() {
+	usage, err := eventStore.GeocodeUsage()
+	if err != nil {
+		log.Printf("Warning: Failed to read geocoding usage: %v", err)
+		return
+	}
+	geocodeMutex.Lock()
+	geocodeUsage, usageLoadedAt = usage, now()
+	geocodeMutex.Unlock()
+}
+
+// saveGeocodeUsage adds the requests counted since the last save to the
+// store's totals, and reads the totals back.
+func saveGeocodeUsage() error {
+	geocodeMutex.Lock()
+	n := unsavedUsage
+	unsavedUsage = 0
+	geocodeMutex.Unlock()
+
+	if n > 0 {
+		if err := eventStore.AddGeocodeUsage(today(), n); err != nil {
+			// Kept to be added by the next save
+			geocodeMutex.Lock()
+			unsavedUsage += n
+			geocodeMutex.Unlock()
+			return err
+		}
+	}
+	loadGeocodeUsage()
+	return nil
+}
+
+// readUsageFile reads the cache directory's usage file, which is empty
+// until the first geocode. A file that can't be parsed is started over.
+func readUsageFile() (GeocodeUsage, error) {
+	usage := newGeocodeUsage()
+	data, err := os.ReadFile(usageFile)
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		log.Printf("Warning: Failed to parse geocoding usage file: %v", err)
+		return newGeocodeUsage(), nil
+	}
+	if usage.Days == nil {
+		usage.Days = map[string]int{}
+	}
+	if usage.Months == nil {
+		usage.Months = map[string]int{}
+	}
+	return usage, nil
+}
+
+func (memoryStore) GeocodeUsage() (GeocodeUsage, error) {
+	return readUsageFile()
+}
+
+// AddGeocodeUsage updates the usage file under a lock on it, so processes
+// sharing the cache directory don't overwrite each other's requests.
+func (memoryStore) AddGeocodeUsage(day string, n int) error {
+	usageFileMutex.Lock()
+	defer usageFileMutex.Unlock()
+	unlock, err := lockFile(usageFile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	usage, err := readUsageFile()
+	if err != nil {
+		return err
+	}
+	usage.add(day, n)
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(usageFile, data, 0644)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingUsageStore is a store whose usage can't be saved.
+type failingUsageStore struct{ memoryStore }
+
+func (failingUsageStore) AddGeocodeUsage(string, int) error {
+	return errors.New("database is down")
+}
+
+func withUsageFile(t *testing.T) {
+	t.Helper()
+	previousFile, previousUsage, previousUnsaved, previousStore := usageFile, geocodeUsage, unsavedUsage, eventStore
+	t.Cleanup(func() {
+		usageFile, geocodeUsage, unsavedUsage, eventStore = previousFile, previousUsage, previousUnsaved, previousStore
+		usageLoadedAt = time.Time{}
+	})
+	usageFile = filepath.Join(t.TempDir(), "geocode_usage.json")
+	geocodeUsage, unsavedUsage, usageLoadedAt, eventStore = newGeocodeUsage(), 0, time.Time{}, memoryStore{}
+}
+
+func TestMemoryStoreGeocodeUsage(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	withUsageFile(t)
+
+	// Older days and months are dropped as usage is added
+	os.WriteFile(usageFile, []byte(`{"days": {"2026-08-01": 7, "2026-10-14": 3}, "months": {"2025-01": 9, "2026-10": 3}}`), 0644)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := (memoryStore{}).AddGeocodeUsage("2026-10-15", 5); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	usage, err := memoryStore{}.GeocodeUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GeocodeUsage{
+		Days:   map[string]int{"2026-10-14": 3, "2026-10-15": 100},
+		Months: map[string]int{"2026-10": 103},
+	}
+	if len(usage.Days) != len(want.Days) || len(usage.Months) != len(want.Months) {
+		t.Fatalf("usage = %v, want %v", usage, want)
+	}
+	for day, n := range want.Days {
+		if usage.Days[day] != n {
+			t.Errorf("usage on %s = %d, want %d", day, usage.Days[day], n)
+		}
+	}
+	for month, n := range want.Months {
+		if usage.Months[month] != n {
+			t.Errorf("usage in %s = %d, want %d", month, usage.Months[month], n)
+		}
+	}
+}
+
+func TestGeocodeBudget(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	withUsageFile(t)
+	cfg := getConfig()
+	cfg.MonthlyGeocodeBudget = 100
+	setConfig(cfg)
+
+	// Another process sharing the cache directory used 60
+	if err := (memoryStore{}).AddGeocodeUsage("2026-10-02", 60); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := geocodeBudgetRemaining(); remaining != 40 {
+		t.Errorf("remaining = %d, want 40", remaining)
+	}
+
+	// Unsaved requests count before they're saved
+	countGeocodeRequests(geocodingPermanent, 15)
+	if remaining, _ := geocodeBudgetRemaining(); remaining != 25 {
+		t.Errorf("remaining with unsaved requests = %d, want 25", remaining)
+	}
+	if err := saveGeocodeUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if status := geocodingStatus(); status.Today != 15 || status.ThisMonth != 75 {
+		t.Errorf("today %d, this month %d, want 15 and 75", status.Today, status.ThisMonth)
+	}
+
+	// Requests that couldn't be saved are kept for the next save
+	eventStore = failingUsageStore{}
+	countGeocodeRequests(geocodingPermanent, 30)
+	if err := saveGeocodeUsage(); err == nil {
+		t.Fatal("saved usage to a failing store")
+	}
+	if remaining, _ := geocodeBudgetRemaining(); remaining != 0 {
+		t.Errorf("remaining after a failed save = %d, want 0", remaining)
+	}
+	eventStore = memoryStore{}
+	if err := saveGeocodeUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := (memoryStore{}).GeocodeUsage(); usage.Months["2026-10"] != 105 {
+		t.Errorf("saved %d this month, want 105", usage.Months["2026-10"])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// "Surprise me" picks. Events are shuffled by hashing each ID with a seed
+// made from the day and an optional client-chosen ?seed=, so the same
+// client keeps getting the same picks for the rest of the day instead of a
+// new set on every refresh.
+
+const maxRandomEvents = 50
+
+// Helper Functions
+
+func shuffleEvents(events []Event, seed string) []Event {
+	keys := make(map[string]string, len(events))
+	for _, e := range events {
+		sum := sha1.Sum([]byte(seed + "|" + e.ID))
+		keys[e.ID] = hex.EncodeToString(sum[:])
+	}
+
+	shuffled := make([]Event, len(events))
+	copy(shuffled, events)
+	sort.SliceStable(shuffled, func(i, j int) bool {
+		return keys[shuffled[i].ID] < keys[shuffled[j].ID]
+	})
+	return shuffled
+}
+
+// HTTP Handlers
+
+// randomHandler returns ?n= (default 1) random events for today, optionally
+// narrowed with ?category= like the embed endpoints.
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 1
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 1 || n > maxRandomEvents {
+			http.Error(w, fmt.Sprintf("Invalid n parameter: must be between 1 and %d", maxRandomEvents), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	events = filterByCategory(events, parseCategories(r))
+	events = shuffleEvents(events, today()+"|"+r.URL.Query().Get("seed"))
+	total := len(events)
+	if len(events) > n {
+		events = events[:n]
+	}
+	writeEventsResponse(w, r, events, total, "random", info)
+}
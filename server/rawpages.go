@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// With MAPTHENS_RAW_PAGE_DAYS set, every page a scrape fetches is kept as it
+// arrived (after Content-Encoding is undone, before conversion to UTF-8),
+// gzipped, in the cache directory:
+//
+//	raw/2026-10-15/140512/flagpole.com/001.gz
+//	raw/2026-10-15/140512/manifest.json
+//
+// one directory per day, run (its UTC start time), and source host. The
+// manifest records each page's URL, Content-Type, and fetch error. Days
+// older than the window are deleted after each run. When a page parses
+// wrongly,
+//
+//	mapthens-server replay raw/2026-10-15/140512/flagpole.com/001.gz
+//
+// runs the parser that read it against the archived copy and prints what it
+// found, so the bug can be reproduced exactly, even after the page changed.
+// The parser is picked from the page's URL, or with -parser: listing (the
+// HTML event list), stream (the same, with the streaming parser), api
+// (flagpole's events API), uga (the UGA calendar), or tickets.
+
+const (
+	rawPagesDir  = "raw"
+	rawManifest  = "manifest.json"
+	rawRunLayout = "150405"
+)
+
+// Data Structures
+
+type RawPage struct {
+	URL         string    `json:"url"`
+	File        string    `json:"file"`
+	ContentType string    `json:"content_type,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Bytes       int       `json:"bytes"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// rawRun collects the pages fetched by one scrape.
+type rawRun struct {
+	dir      string
+	mutex    sync.Mutex
+	pages    []RawPage
+	counts   map[string]int
+	finished bool
+}
+
+// rawCapture is a copy of one page's body as it's read.
+type rawCapture struct {
+	run         *rawRun
+	pageURL     string
+	host        string
+	contentType string
+	fetchedAt   time.Time
+	body        bytes.Buffer
+}
+
+type replayResult struct {
+	URL     string   `json:"url"`
+	Parser  string   `json:"parser"`
+	Events  []Event  `json:"events,omitempty"`
+	Next    string   `json:"next,omitempty"`
+	Tickets *Tickets `json:"tickets,omitempty"`
+}
+
+// Global Variables
+var (
+	currentRawRun      *rawRun
+	currentRawRunMutex sync.Mutex
+)
+
+// Helper Functions
+
+// startRawRun starts archiving the pages fetched until finishRawRun, when
+// MAPTHENS_RAW_PAGE_DAYS is set.
+func startRawRun() *rawRun {
+	if getConfig().RawPageDays == 0 {
+		return nil
+	}
+	started := now().UTC()
+	run := &rawRun{
+		dir:    cachePath(filepath.Join(rawPagesDir, localNow().Format("2006-01-02"), started.Format(rawRunLayout))),
+		counts: map[string]int{},
+	}
+	currentRawRunMutex.Lock()
+	currentRawRun = run
+	currentRawRunMutex.Unlock()
+	return run
+}
+
+// finishRawRun writes the run's manifest and deletes days past the window.
+// Pages from a scrape the watchdog gave up on aren't archived once it's
+// finished.
+func finishRawRun(run *rawRun) {
+	if run == nil {
+		return
+	}
+	currentRawRunMutex.Lock()
+	if currentRawRun == run {
+		currentRawRun = nil
+	}
+	currentRawRunMutex.Unlock()
+
+	run.mutex.Lock()
+	run.finished = true
+	pages := run.pages
+	run.mutex.Unlock()
+	if len(pages) > 0 {
+		sort.Slice(pages, func(i, j int) bool { return pages[i].File < pages[j].File })
+		data, err := json.MarshalIndent(pages, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(run.dir, rawManifest), data, 0644)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to write the raw page manifest: %v", err)
+		}
+	}
+	if err := pruneRawPages(getConfig().RawPageDays); err != nil {
+		log.Printf("Warning: Failed to prune raw pages: %v", err)
+	}
+}
+
+// captureRawPage starts copying a page's body for the running scrape's
+// archive, returning nil when there's nothing to archive to.
+func captureRawPage(pageURL, host, contentType string) *rawCapture {
+	currentRawRunMutex.Lock()
+	run := currentRawRun
+	currentRawRunMutex.Unlock()
+	if run == nil {
+		return nil
+	}
+	return &rawCapture{run: run, pageURL: pageURL, host: host, contentType: contentType, fetchedAt: now()}
+}
+
+// save writes the captured body, with the fetch's error if it failed.
+func (c *rawCapture) save(fetchErr error) {
+	run := c.run
+	run.mutex.Lock()
+	if run.finished {
+		run.mutex.Unlock()
+		return
+	}
+	run.counts[c.host]++
+	file := filepath.Join(c.host, fmt.Sprintf("%03d.gz", run.counts[c.host]))
+	page := RawPage{URL: c.pageURL, File: filepath.ToSlash(file), ContentType: c.contentType, FetchedAt: c.fetchedAt, Bytes: c.body.Len()}
+	if fetchErr != nil {
+		page.Error = fetchErr.Error()
+	}
+	run.pages = append(run.pages, page)
+	run.mutex.Unlock()
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(c.body.Bytes())
+	zw.Close()
+	path := filepath.Join(run.dir, file)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = writeFileAtomic(path, compressed.Bytes(), 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to archive raw page %s: %v", c.pageURL, err)
+	}
+}
+
+// pruneRawPages deletes the days of raw pages older than days.
+func pruneRawPages(days int) error {
+	paths, err := filepath.Glob(cachePath(filepath.Join(rawPagesDir, "????-??-??")))
+	if err != nil {
+		return err
+	}
+	cutoff := localNow().AddDate(0, 0, -days).Format("2006-01-02")
+	for _, path := range paths {
+		if filepath.Base(path) < cutoff {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayParser picks the parser for a page from its URL.
+func replayParser(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.Contains(u.Path, "/wp-json/tribe/"):
+		return "api"
+	case strings.Contains(u.Path, "/api/2/events"):
+		return "uga"
+	case strings.HasPrefix(pageURL, flagpoleEventsURL):
+		if flagEnabled(flagStreamingListing) {
+			return "stream"
+		}
+		return "listing"
+	}
+	return ""
+}
+
+// readRawPage reads an archived page and its manifest entry. The day it
+// was fetched is taken from its path.
+func readRawPage(path string) ([]byte, RawPage, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, RawPage{}, "", err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, RawPage{}, "", err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, RawPage{}, "", err
+	}
+
+	runDir := filepath.Dir(filepath.Dir(path))
+	day := filepath.Base(filepath.Dir(runDir))
+	var pages []RawPage
+	if err := readJSONFile(filepath.Join(runDir, rawManifest), &pages); err != nil {
+		return nil, RawPage{}, "", fmt.Errorf("reading the run's manifest: %v", err)
+	}
+	file := filepath.ToSlash(filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path)))
+	for _, page := range pages {
+		if page.File == file {
+			return data, page, day, nil
+		}
+	}
+	return nil, RawPage{}, "", fmt.Errorf("%s isn't in the run's manifest", file)
+}
+
+// replayPage runs parser against an archived page's body.
+func replayPage(data []byte, page RawPage, day, parser string) (replayResult, error) {
+	result := replayResult{URL: page.URL, Parser: parser}
+	r, err := charset.NewReader(bytes.NewReader(data), page.ContentType)
+	if err != nil {
+		return result, err
+	}
+
+	switch parser {
+	case "listing", "tickets":
+		doc, err := goquery.NewDocumentFromReader(r)
+		if err != nil {
+			return result, err
+		}
+		if parser == "tickets" {
+			tickets := parseTicketPage(doc, getConfig().Location)
+			result.Tickets = &tickets
+			return result, nil
+		}
+		result.Events, result.Next = parseListingPage(doc), nextListingPage(doc)
+	case "stream":
+		if result.Events, result.Next, err = parseListingStream(r); err != nil {
+			return result, err
+		}
+	case "api":
+		var response tribeResponse
+		if err := json.NewDecoder(r).Decode(&response); err != nil {
+			return result, err
+		}
+		for _, te := range response.Events {
+			e, err := te.toEvent()
+			if err != nil {
+				log.Printf("Warning: Skipping event %s: %v", te.URL, err)
+				continue
+			}
+			e.SourceName, e.SourceURL = sourceTribeAPI, page.URL
+			result.Events = append(result.Events, e)
+		}
+		result.Next = response.NextRestURL
+	case "uga":
+		var response localistResponse
+		if err := json.NewDecoder(r).Decode(&response); err != nil {
+			return result, err
+		}
+		for _, item := range response.Events {
+			e, err := item.Event.toEvent(day)
+			if err != nil {
+				log.Printf("Warning: Skipping event %s: %v", item.Event.LocalistURL, err)
+				continue
+			}
+			e.SourceName, e.SourceURL = sourceUGA, page.URL
+			result.Events = append(result.Events, e)
+		}
+	default:
+		return result, fmt.Errorf("unknown parser %q: must be listing, stream, api, uga, or tickets", parser)
+	}
+	return result, nil
+}
+
+// replayCommand runs `mapthens-server replay [-parser name] <page.gz>`.
+func replayCommand(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	parser := flags.String("parser", "", "listing, stream, api, uga, or tickets (default from the page's URL)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: replay [-parser name] <page.gz>")
+	}
+	data, page, day, err := readRawPage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *parser == "" {
+		if *parser = replayParser(page.URL); *parser == "" {
+			return fmt.Errorf("can't tell which parser read %s; pass -parser", page.URL)
+		}
+	}
+	if page.Error != "" {
+		log.Printf("Warning: The fetch of this page failed: %s", page.Error)
+	}
+
+	result, err := replayPage(data, page, day, *parser)
+	if err != nil {
+		return fmt.Errorf("%s parser failed: %v", *parser, err)
+	}
+	log.Printf("Replayed %s (%d bytes, fetched %s) with the %s parser: %d events.", page.URL, page.Bytes, page.FetchedAt.Format(time.RFC3339), *parser, len(result.Events))
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
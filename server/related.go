@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// /api/events/{id}/related suggests other current events for a "you might
+// also like" section. Each candidate is scored on what it shares with the
+// event:
+//
+//	same series (the same title)              4
+//	same venue                                3
+//	overlapping time                          2
+//	same category                             2
+//	related category                          up to 1
+//
+// Two categories are related in proportion to how many venues host both,
+// out of the venues hosting either, among the current events; a comedy
+// night at a music venue makes comedy and live music a little related.
+
+const (
+	defaultRelatedLimit = 10
+	maxRelatedLimit     = 50
+
+	relatedSeries   = 4.0
+	relatedVenue    = 3.0
+	relatedOverlap  = 2.0
+	relatedCategory = 2.0
+)
+
+// Data Structures
+
+type RelatedEvent struct {
+	Event
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+type RelatedResponse struct {
+	EventID string         `json:"event_id"`
+	Related []RelatedEvent `json:"related"`
+}
+
+// Helper Functions
+
+// categoryCooccurrence gives, for each pair of categories, the share of
+// venues hosting either that host both. Pairs are keyed "a|b" with a < b.
+func categoryCooccurrence(events []Event) map[string]float64 {
+	venues := map[string]map[string]bool{}
+	for _, e := range events {
+		if e.Category == "" || e.Venue == "" {
+			continue
+		}
+		if venues[e.Category] == nil {
+			venues[e.Category] = map[string]bool{}
+		}
+		venues[e.Category][venueID(e.Venue)] = true
+	}
+
+	categories := make([]string, 0, len(venues))
+	for category := range venues {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	related := map[string]float64{}
+	for i, a := range categories {
+		for _, b := range categories[i+1:] {
+			both := 0
+			for venue := range venues[a] {
+				if venues[b][venue] {
+					both++
+				}
+			}
+			if both > 0 {
+				related[a+"|"+b] = float64(both) / float64(len(venues[a])+len(venues[b])-both)
+			}
+		}
+	}
+	return related
+}
+
+func categoryRelatedness(related map[string]float64, a, b string) float64 {
+	if a > b {
+		a, b = b, a
+	}
+	return related[a+"|"+b]
+}
+
+// relatedEvents scores every other event against e, best first, leaving
+// out those with nothing in common.
+func relatedEvents(e Event, events []Event) []RelatedEvent {
+	cooccurrence := categoryCooccurrence(events)
+	start, end, _, timeErr := eventTimes(e)
+	series := normalizeTitle(e.Title)
+	venue := venueID(e.Venue)
+
+	var related []RelatedEvent
+	for _, other := range events {
+		if other.ID == e.ID {
+			continue
+		}
+		candidate := RelatedEvent{Event: other, Reasons: []string{}}
+		add := func(score float64, reason string) {
+			candidate.Score += score
+			candidate.Reasons = append(candidate.Reasons, reason)
+		}
+		if series != "" && normalizeTitle(other.Title) == series {
+			add(relatedSeries, "same_series")
+		}
+		if venue != "" && venueID(other.Venue) == venue {
+			add(relatedVenue, "same_venue")
+		}
+		if timeErr == nil {
+			if otherStart, otherEnd, _, err := eventTimes(other); err == nil && otherStart.Before(end) && start.Before(otherEnd) {
+				add(relatedOverlap, "overlapping_time")
+			}
+		}
+		if e.Category != "" && other.Category == e.Category {
+			add(relatedCategory, "same_category")
+		} else if score := categoryRelatedness(cooccurrence, e.Category, other.Category); score > 0 {
+			add(score, "related_category")
+		}
+		// Overlapping time alone isn't a recommendation
+		if candidate.Score > relatedOverlap {
+			related = append(related, candidate)
+		}
+	}
+
+	sort.SliceStable(related, func(i, j int) bool {
+		return related[i].Score > related[j].Score
+	})
+	return related
+}
+
+// HTTP Handlers
+
+// relatedHandler serves GET /api/events/{id}/related?limit=10.
+func relatedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultRelatedLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxRelatedLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit parameter: must be between 1 and %d", maxRelatedLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/events/"), "/related")
+	e, ok := findEvent(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	events, err := getEvents()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	related := relatedEvents(e, events)
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	limited := make([]Event, len(related))
+	for i, candidate := range related {
+		limited[i] = candidate.Event
+	}
+	if limited, ok = expandEvents(w, r, limited); !ok {
+		return
+	}
+	for i := range related {
+		related[i].Event = limited[i]
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, RelatedResponse{EventID: e.ID, Related: related})
+}
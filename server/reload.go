@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The config file, venue gazetteer, and coordinate overrides are reloaded
+// without a restart when the server receives SIGHUP or when one of the files
+// changes on disk.
+
+var reloadPollInterval = 5 * time.Second
+
+// reloadConfig re-reads the configuration and venue tables and re-applies
+// them to the cached events. Settings that are only read at startup (port,
+// cache directory, storage format) keep their current values.
+func reloadConfig() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Warning: Failed to reload config, keeping current settings: %v", err)
+		return
+	}
+
+	current := getConfig()
+	if cfg.Port != current.Port || cfg.CacheDir != current.CacheDir || cfg.StorageFormat != current.StorageFormat ||
+		cfg.CompressCache != current.CompressCache {
+		log.Println("Warning: Changes to port, cache_dir, storage_format, and compress_cache take effect after a restart.")
+		cfg.Port = current.Port
+		cfg.CacheDir = current.CacheDir
+		cfg.StorageFormat = current.StorageFormat
+		cfg.CompressCache = current.CompressCache
+	}
+
+	if err := loadTables(cfg); err != nil {
+		log.Printf("Warning: Failed to reload venue tables, keeping current tables: %v", err)
+		return
+	}
+	setConfig(cfg)
+	renormalizeCache()
+	log.Println("Reloaded configuration and venue tables.")
+}
+
+func watchedFiles(cfg Config) []string {
+	var files []string
+	for _, path := range []string{cfg.ConfigFile, cfg.VenuesFile, cfg.OverridesFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			times[path] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	files := watchedFiles(getConfig())
+	last := modTimes(files)
+	for {
+		select {
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration.")
+			reloadConfig()
+		case <-ticker.C:
+			current := modTimes(files)
+			changed := len(current) != len(last)
+			for path, t := range current {
+				if !t.Equal(last[path]) {
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			log.Println("Configuration files changed, reloading.")
+			reloadConfig()
+		}
+		files = watchedFiles(getConfig())
+		last = modTimes(files)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"mapthens-server/pkg/archive"
+)
+
+// Each scrape's normalized events are also kept as a daily snapshot,
+// snapshots/2026-10-15.json in the cache directory. After each scrape the
+// retention policy is applied:
+//
+//   - snapshots older than MAPTHENS_SNAPSHOT_DAYS are compacted into their
+//     month's archive, snapshots/2026-10.ndjson.gz (or 2026-10.parquet with
+//     MAPTHENS_ARCHIVE_FORMAT=parquet), with each event's listed_on day
+//   - archives older than MAPTHENS_ARCHIVE_MONTHS are deleted, as are
+//     Parquet export partitions (see export.go) for days before then
+//
+// Each snapshot's diff against the one before (see changes.go) and its
+// day's digest (see digest.go) go with it, and its day's published versions
+// (see asof.go) are deleted.
+// snapshots/archives.json records what each archive holds, and
+// /api/status/retention reports the policy and what's retained.
+
+const (
+	archiveNDJSON  = "ndjson"
+	archiveParquet = "parquet"
+
+	defaultSnapshotDays  = 30
+	defaultArchiveMonths = 24
+)
+
+// Data Structures
+
+// archivedEvent is an event in a monthly archive. Parquet archives hold
+// the columns of the Parquet export, plus its partition keys.
+type archivedEvent struct {
+	ListedOn string `json:"listed_on"`
+	Event
+}
+
+type archivedRow struct {
+	ListedOn string `parquet:"listed_on"`
+	Category string `parquet:"category"`
+	parquetEvent
+}
+
+type ArchiveInfo struct {
+	Month       string    `json:"month"`
+	Format      string    `json:"format"`
+	File        string    `json:"file"`
+	Days        []string  `json:"days"`
+	Events      int       `json:"events"`
+	Bytes       int64     `json:"bytes"`
+	CompactedAt time.Time `json:"compacted_at"`
+}
+
+type RetentionPolicy struct {
+	SnapshotDays  int    `json:"snapshot_days"`
+	ArchiveMonths int    `json:"archive_months"` // 0 keeps archives forever
+	ArchiveFormat string `json:"archive_format"`
+	// Fixed windows of the other stores
+	RecentDays    int `json:"recent_days"`
+	AnalyticsDays int `json:"analytics_days"`
+}
+
+type RetainedDays struct {
+	Days   int    `json:"days"`
+	Oldest string `json:"oldest,omitempty"`
+	Newest string `json:"newest,omitempty"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type RetentionRun struct {
+	At        time.Time `json:"at"`
+	Compacted int       `json:"compacted_days"`
+	Deleted   int       `json:"deleted_archives"`
+	Pruned    int       `json:"pruned_parquet_days"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type RetentionStatus struct {
+	Policy    RetentionPolicy `json:"policy"`
+	Snapshots RetainedDays    `json:"snapshots"`
+	Archives  []ArchiveInfo   `json:"archives"`
+	Parquet   *RetainedDays   `json:"parquet,omitempty"`
+	LastRun   *RetentionRun   `json:"last_run,omitempty"`
+}
+
+// Global Variables
+var (
+	snapshotDir        = "snapshots"
+	lastRetention      *RetentionRun
+	lastRetentionMutex sync.Mutex
+)
+
+// Helper Functions
+
+func snapshotFile(day string) string {
+	return filepath.Join(snapshotDir, archive.SnapshotName(day))
+}
+
+func archiveFile(month, format string) string {
+	if format == archiveParquet {
+		return filepath.Join(snapshotDir, month+".parquet")
+	}
+	return filepath.Join(snapshotDir, month+".ndjson"+gzipSuffix)
+}
+
+func archiveIndexFile() string {
+	return filepath.Join(snapshotDir, "archives.json")
+}
+
+// saveDailySnapshot writes day's events to its snapshot, replacing any
+// earlier one.
+func saveDailySnapshot(day string, events []Event) error {
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		return err
+	}
+	return writeEventsFile(snapshotFile(day), events)
+}
+
+// snapshotDays lists the days with a daily snapshot, oldest first.
+func snapshotDays() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(snapshotDir, "????-??-??.json"))
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, path := range paths {
+		day := strings.TrimSuffix(filepath.Base(path), ".json")
+		if _, err := archive.ParseDateKey(day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func loadArchiveIndex() (map[string]ArchiveInfo, error) {
+	index := map[string]ArchiveInfo{}
+	data, err := os.ReadFile(archiveIndexFile())
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", archiveIndexFile(), err)
+	}
+	return index, nil
+}
+
+func saveArchiveIndex(index map[string]ArchiveInfo) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(archiveIndexFile(), data, 0644)
+}
+
+// readArchive returns an archive's events. Parquet archives come back with
+// only the exported columns.
+func readArchive(path string) ([]archivedEvent, error) {
+	if strings.HasSuffix(path, ".parquet") {
+		rows, err := parquet.ReadFile[archivedRow](path)
+		if err != nil {
+			return nil, err
+		}
+		events := make([]archivedEvent, len(rows))
+		for i, row := range rows {
+			events[i] = archivedEvent{ListedOn: row.ListedOn, Event: fromParquetEvent(row.parquetEvent, row.Category)}
+		}
+		return events, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var events []archivedEvent
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	for {
+		var e archivedEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("event %d: %v", len(events)+1, err)
+		}
+		events = append(events, e)
+	}
+}
+
+func writeArchive(path string, events []archivedEvent) error {
+	if strings.HasSuffix(path, ".parquet") {
+		rows := make([]archivedRow, len(events))
+		for i, e := range events {
+			rows[i] = archivedRow{ListedOn: e.ListedOn, Category: e.Category, parquetEvent: toParquetEvent(e.Event)}
+		}
+		tmp := path + ".tmp"
+		if err := parquet.WriteFile(tmp, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	data, err := gzipBytes([]byte(b.String()))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// compactMonth moves the snapshots of days, all in month, into the
+// month's archive. The snapshots are only deleted once the archive and its
+// index entry are written.
+func compactMonth(index map[string]ArchiveInfo, month string, days []string, format string) error {
+	replaced := map[string]bool{}
+	for _, day := range days {
+		replaced[day] = true
+	}
+
+	var events []archivedEvent
+	previous, archived := index[month]
+	if archived {
+		existing, err := readArchive(filepath.Join(snapshotDir, previous.File))
+		if err != nil {
+			return fmt.Errorf("reading the %s archive: %v", month, err)
+		}
+		for _, e := range existing {
+			if !replaced[e.ListedOn] {
+				events = append(events, e)
+			}
+		}
+	}
+	for _, day := range days {
+		snapshot, err := readEventsFile(snapshotFile(day))
+		if err != nil {
+			return fmt.Errorf("reading the %s snapshot: %v", day, err)
+		}
+		for _, e := range snapshot {
+			events = append(events, archivedEvent{ListedOn: day, Event: e})
+		}
+	}
+	// Each day's events are already in eventOrder
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ListedOn < events[j].ListedOn })
+
+	path := archiveFile(month, format)
+	if err := writeArchive(path, events); err != nil {
+		return fmt.Errorf("writing the %s archive: %v", month, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Days with no events still count as archived
+	dayList := append([]string{}, days...)
+	for _, day := range previous.Days {
+		if !replaced[day] {
+			dayList = append(dayList, day)
+		}
+	}
+	sort.Strings(dayList)
+	index[month] = ArchiveInfo{
+		Month:       month,
+		Format:      format,
+		File:        filepath.Base(path),
+		Days:        dayList,
+		Events:      len(events),
+		Bytes:       info.Size(),
+		CompactedAt: now().UTC(),
+	}
+	if err := saveArchiveIndex(index); err != nil {
+		return err
+	}
+
+	// An archive written in the other format is retired
+	if archived && previous.File != filepath.Base(path) {
+		if err := os.Remove(filepath.Join(snapshotDir, previous.File)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove the old %s archive: %v", month, err)
+		}
+	}
+	for _, day := range days {
+		if err := os.Remove(snapshotFile(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(snapshotDiffFile(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(digestFile(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.RemoveAll(versionsDir(day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneParquet deletes the Parquet export's partitions for days before
+// oldest, and rewrites its catalog when any were deleted.
+func pruneParquet(dir, location, oldest string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "listed_on=*"))
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, path := range paths {
+		day := strings.TrimPrefix(filepath.Base(path), "listed_on=")
+		if _, err := time.Parse("2006-01-02", day); err != nil || day >= oldest {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		return pruned, writeCatalog(dir, location)
+	}
+	return 0, nil
+}
+
+// applyRetention compacts and deletes according to the configured policy.
+// It runs after each scrape, under the refresh lock.
+func applyRetention() RetentionRun {
+	cfg := getConfig()
+	run := RetentionRun{At: now().UTC()}
+	fail := func(err error) RetentionRun {
+		run.Error = err.Error()
+		log.Printf("Warning: Retention stopped: %v", err)
+		return run
+	}
+	defer func() {
+		lastRetentionMutex.Lock()
+		lastRetention = &run
+		lastRetentionMutex.Unlock()
+	}()
+
+	index, err := loadArchiveIndex()
+	if err != nil {
+		return fail(err)
+	}
+	days, err := snapshotDays()
+	if err != nil {
+		return fail(err)
+	}
+	cutoff := localNow().AddDate(0, 0, -cfg.SnapshotDays).Format("2006-01-02")
+	byMonth := map[string][]string{}
+	var months []string
+	for _, day := range days {
+		if day >= cutoff {
+			continue
+		}
+		month := day[:7]
+		if byMonth[month] == nil {
+			months = append(months, month)
+		}
+		byMonth[month] = append(byMonth[month], day)
+	}
+	for _, month := range months {
+		if err := compactMonth(index, month, byMonth[month], cfg.ArchiveFormat); err != nil {
+			return fail(err)
+		}
+		run.Compacted += len(byMonth[month])
+	}
+
+	if cfg.ArchiveMonths > 0 {
+		oldest := localNow().AddDate(0, -cfg.ArchiveMonths, 0).Format("2006-01")
+		for month, archive := range index {
+			if month >= oldest {
+				continue
+			}
+			if err := os.Remove(filepath.Join(snapshotDir, archive.File)); err != nil && !os.IsNotExist(err) {
+				return fail(err)
+			}
+			delete(index, month)
+			run.Deleted++
+		}
+		if run.Deleted > 0 {
+			if err := saveArchiveIndex(index); err != nil {
+				return fail(err)
+			}
+		}
+		if cfg.ParquetDir != "" {
+			if run.Pruned, err = pruneParquet(cfg.ParquetDir, cfg.ParquetLocation, oldest+"-01"); err != nil {
+				return fail(fmt.Errorf("pruning the Parquet export: %v", err))
+			}
+		}
+	}
+
+	if run.Compacted > 0 || run.Deleted > 0 || run.Pruned > 0 {
+		log.Printf("Retention compacted %d days of snapshots, deleted %d archives, and pruned %d days of Parquet.", run.Compacted, run.Deleted, run.Pruned)
+	}
+	return run
+}
+
+// retainedDays summarizes the day-named entries matching pattern, whose
+// base names are the day plus suffix.
+func retainedDays(pattern, prefix, suffix string) RetainedDays {
+	var retained RetainedDays
+	paths, _ := filepath.Glob(pattern)
+	for _, path := range paths {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), suffix)
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			continue
+		}
+		retained.Days++
+		if retained.Oldest == "" || day < retained.Oldest {
+			retained.Oldest = day
+		}
+		if day > retained.Newest {
+			retained.Newest = day
+		}
+		filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				retained.Bytes += info.Size()
+			}
+			return nil
+		})
+	}
+	return retained
+}
+
+// HTTP Handlers
+
+// retentionStatusHandler serves GET /api/status/retention.
+func retentionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := getConfig()
+	index, err := loadArchiveIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading archive index: %v", err), http.StatusInternalServerError)
+		return
+	}
+	status := RetentionStatus{
+		Policy: RetentionPolicy{
+			SnapshotDays:  cfg.SnapshotDays,
+			ArchiveMonths: cfg.ArchiveMonths,
+			ArchiveFormat: cfg.ArchiveFormat,
+			RecentDays:    recentRetention,
+			AnalyticsDays: analyticsRetention,
+		},
+		Snapshots: retainedDays(filepath.Join(snapshotDir, "????-??-??.json"), "", ".json"),
+		Archives:  []ArchiveInfo{},
+	}
+	for _, archive := range index {
+		status.Archives = append(status.Archives, archive)
+	}
+	sort.Slice(status.Archives, func(i, j int) bool { return status.Archives[i].Month < status.Archives[j].Month })
+	if cfg.ParquetDir != "" {
+		parquetDays := retainedDays(filepath.Join(cfg.ParquetDir, "listed_on=*"), "listed_on=", "")
+		status.Parquet = &parquetDays
+	}
+	lastRetentionMutex.Lock()
+	status.LastRun = lastRetention
+	lastRetentionMutex.Unlock()
+
+	writeJSON(w, status)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Without a schedule, events are only refreshed when a request finds them
+// older than MAPTHENS_CACHE_TTL, so a quiet server scrapes, geocodes, and
+// archives whenever someone happens to visit. MAPTHENS_REFRESH_SCHEDULE
+// names times of day, e.g. "06:00,12:00,18:00" in MAPTHENS_TIMEZONE, at
+// which the whole pipeline runs whether or not anyone is asking, the way a
+// cron job would. A scheduled refresh scrapes even when the cached events
+// are within the TTL; it takes the same lock as other refreshes, so when
+// several server processes share a cache directory only the first scrapes
+// and the rest load its events.
+//
+// Sources that change at different rates can also be refreshed on their
+// own cron expressions with MAPTHENS_SOURCE_SCHEDULES, e.g.
+// "uga=*/30 * * * *; flagpole=0 6 * * *". A source refresh scrapes only
+// that source, merges it with the other sources' last listings (see
+// sources.go), and saves the merged events like any other refresh.
+// Expressions have the usual five fields (minute, hour, day of month,
+// month, day of week) with *, lists, ranges, and steps, or are one of
+// @hourly and @daily.
+
+// Data Structures
+
+// cronSchedule holds the values each field of a cron expression allows as
+// bitsets.
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64
+	// As in cron, a day of month and a day of week that are both
+	// restricted match when either does
+	anyDay, anyWeekday bool
+}
+
+// Helper Functions
+
+// parseRefreshSchedule reads comma-separated "HH:MM" times, returning them
+// as minutes after midnight.
+func parseRefreshSchedule(value string) ([]int, error) {
+	var schedule []int
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		hours, minutes, ok := strings.Cut(part, ":")
+		h, err := strconv.Atoi(hours)
+		if !ok || err != nil || h < 0 || h > 23 || len(minutes) != 2 {
+			return nil, fmt.Errorf("%q is not a time like \"06:00\"", part)
+		}
+		m, err := strconv.Atoi(minutes)
+		if err != nil || m < 0 || m > 59 {
+			return nil, fmt.Errorf("%q is not a time like \"06:00\"", part)
+		}
+		schedule = append(schedule, h*60+m)
+	}
+	return schedule, nil
+}
+
+// parseCronField reads one field's comma-separated values, ranges, and
+// steps, each between low and high.
+func parseCronField(field string, low, high int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+		start, end := low, high
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				end = high
+			}
+		}
+		if start < low || end > high || start > end {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, low, high)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseCron(expr string) (cronSchedule, error) {
+	switch strings.TrimSpace(expr) {
+	case "@hourly":
+		expr = "0 * * * *"
+	case "@daily":
+		expr = "0 0 * * *"
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("%q must have five fields", expr)
+	}
+
+	var c cronSchedule
+	var err error
+	for i, f := range []struct {
+		bits      *uint64
+		low, high int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.day, 1, 31}, {&c.month, 1, 12}, {&c.weekday, 0, 7}} {
+		if *f.bits, err = parseCronField(fields[i], f.low, f.high); err != nil {
+			return cronSchedule{}, fmt.Errorf("%q: %v", expr, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if c.weekday&(1<<7) != 0 {
+		c.weekday |= 1
+	}
+	c.anyDay, c.anyWeekday = fields[2] == "*", fields[4] == "*"
+	if c.next(now()).IsZero() {
+		return cronSchedule{}, fmt.Errorf("%q never runs", expr)
+	}
+	return c, nil
+}
+
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	day := c.day&(1<<t.Day()) != 0
+	weekday := c.weekday&(1<<int(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// next returns the first time after t the schedule runs, in t's location,
+// or the zero time when it doesn't run in the next five years. Times are
+// stepped through on the wall clock, so each scheduled time runs once
+// across DST changes: one repeated when clocks fall back runs the first
+// time round, and one skipped when they spring forward runs an hour later.
+func (c cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	y, m, d := t.Date()
+	wall := time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	for limit := wall.AddDate(5, 0, 0); wall.Before(limit); {
+		y, m, d := wall.Date()
+		switch {
+		case c.month&(1<<int(m)) == 0:
+			wall = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(wall):
+			wall = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<wall.Hour()) == 0:
+			wall = time.Date(y, m, d, wall.Hour()+1, 0, 0, 0, time.UTC)
+		case c.minute&(1<<wall.Minute()) == 0:
+			wall = wall.Add(time.Minute)
+		default:
+			at := time.Date(y, m, d, wall.Hour(), wall.Minute(), 0, 0, loc)
+			if at.Hour() != wall.Hour() || at.Minute() != wall.Minute() {
+				// Skipped by clocks springing forward: placed with the
+				// offset in force before the change
+				_, offset := wall.Add(-12 * time.Hour).In(loc).Zone()
+				at = wall.Add(-time.Duration(offset) * time.Second).In(loc)
+			} else if earlier := at.Add(-time.Hour); earlier.Hour() == at.Hour() && earlier.Minute() == at.Minute() {
+				// Repeated by clocks falling back: the first time round
+				at = earlier
+			}
+			if at.After(t) {
+				return at
+			}
+			// Already past during the hour clocks fall back
+			wall = wall.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// parseSourceSchedules reads "origin=cron expression" pairs separated by
+// semicolons.
+func parseSourceSchedules(value string) (map[string]string, error) {
+	schedules := map[string]string{}
+	for _, pair := range strings.Split(value, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		origin, expr, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not source=expression", pair)
+		}
+		schedules[strings.ToLower(strings.TrimSpace(origin))] = expr
+	}
+	return schedules, nil
+}
+
+func validateSourceSchedules(exprs map[string]string) (map[string]cronSchedule, error) {
+	schedules := make(map[string]cronSchedule, len(exprs))
+	for origin, expr := range exprs {
+		if origin != originFlagpole && origin != originUGA && origin != originVenueCalendar {
+			return nil, fmt.Errorf("unknown source %q: must be %q, %q, or %q", origin, originFlagpole, originUGA, originVenueCalendar)
+		}
+		c, err := parseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		schedules[origin] = c
+	}
+	return schedules, nil
+}
+
+// nextScheduledRefresh returns the first scheduled time after t, in t's
+// location, or the zero time without a schedule.
+func nextScheduledRefresh(schedule []int, t time.Time) time.Time {
+	var next time.Time
+	for days := 0; days <= 1; days++ {
+		y, m, d := t.AddDate(0, 0, days).Date()
+		for _, minutes := range schedule {
+			at := time.Date(y, m, d, minutes/60, minutes%60, 0, 0, t.Location())
+			if at.After(t) && (next.IsZero() || at.Before(next)) {
+				next = at
+			}
+		}
+	}
+	return next
+}
+
+// nextRefresh returns the next scheduled refresh after t and the sources
+// it scrapes, nil meaning all of them. A full refresh due at the same time
+// as source refreshes covers them.
+func nextRefresh(cfg Config, t time.Time) (time.Time, []string) {
+	next := nextScheduledRefresh(cfg.RefreshSchedule, t)
+	full := !next.IsZero()
+	var origins []string
+	for origin, c := range cfg.SourceSchedules {
+		at := c.next(t)
+		switch {
+		case at.IsZero() || (!next.IsZero() && at.After(next)):
+		case next.IsZero() || at.Before(next):
+			next, full, origins = at, false, []string{origin}
+		case !full:
+			origins = append(origins, origin)
+		}
+	}
+	if full {
+		return next, nil
+	}
+	sort.Strings(origins)
+	return next, origins
+}
+
+// runScheduledRefresh refreshes the events, reusing only events saved
+// since the scheduled time at. It's skipped when another refresh is
+// already running, like getEventsWithInfo's, since that one scrapes too.
+func runScheduledRefresh(at time.Time, origins []string) {
+	mutex.Lock()
+	if refreshing != nil {
+		mutex.Unlock()
+		log.Printf("Skipping the refresh scheduled for %s: another refresh is running.", at.Format("15:04"))
+		return
+	}
+	done := make(chan struct{})
+	refreshing = done
+	mutex.Unlock()
+	defer close(done)
+
+	if origins == nil {
+		log.Printf("Running the refresh scheduled for %s...", at.Format("15:04"))
+	} else {
+		log.Printf("Refreshing %s as scheduled for %s...", strings.Join(origins, " and "), at.Format("15:04"))
+	}
+	events, scrapedAt, err := refreshEvents(at, origins)
+	mutex.Lock()
+	defer mutex.Unlock()
+	refreshing = nil
+	lastRefreshError = err
+	if err != nil {
+		lastRefreshFailure = now()
+		log.Printf("Warning: Scheduled refresh failed: %v", err)
+		return
+	}
+	setEventsCache(events, scrapedAt)
+	if origins == nil {
+		go writeDailyDigest(events)
+	}
+}
+
+func refreshOnSchedule() {
+	for {
+		// Looked at every minute, so schedule changes from a config reload
+		// apply
+		next, origins := nextRefresh(getConfig(), localNow())
+		if next.IsZero() || next.Sub(now()) > time.Minute {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(next.Sub(now()))
+		runScheduledRefresh(next, origins)
+	}
+}
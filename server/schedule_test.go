@@ -0,0 +1,217 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// bits sets the bits for values.
+func bits(values ...int) uint64 {
+	var b uint64
+	for _, v := range values {
+		b |= 1 << v
+	}
+	return b
+}
+
+func TestParseCronField(t *testing.T) {
+	for _, test := range []struct {
+		field     string
+		low, high int
+		want      uint64
+		wantErr   bool
+	}{
+		{field: "*", low: 0, high: 6, want: bits(0, 1, 2, 3, 4, 5, 6)},
+		{field: "5", low: 0, high: 59, want: bits(5)},
+		{field: "1,15,30", low: 0, high: 59, want: bits(1, 15, 30)},
+		{field: "9-12", low: 0, high: 23, want: bits(9, 10, 11, 12)},
+		{field: "*/15", low: 0, high: 59, want: bits(0, 15, 30, 45)},
+		{field: "*/5", low: 1, high: 12, want: bits(1, 6, 11)},
+		{field: "10/20", low: 0, high: 59, want: bits(10, 30, 50)},
+		{field: "8-18/4", low: 0, high: 23, want: bits(8, 12, 16)},
+		{field: "1-5,0", low: 0, high: 7, want: bits(0, 1, 2, 3, 4, 5)},
+
+		{field: "60", low: 0, high: 59, wantErr: true},
+		{field: "0", low: 1, high: 31, wantErr: true},
+		{field: "5-1", low: 0, high: 59, wantErr: true},
+		{field: "*/0", low: 0, high: 59, wantErr: true},
+		{field: "*/x", low: 0, high: 59, wantErr: true},
+		{field: "mon", low: 0, high: 7, wantErr: true},
+		{field: "1-", low: 0, high: 59, wantErr: true},
+		{field: "1,,2", low: 0, high: 59, wantErr: true},
+	} {
+		got, err := parseCronField(test.field, test.low, test.high)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q) = %b, want an error", test.field, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseCronField(%q) = %b, %v, want %b", test.field, got, err, test.want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, test := range []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "*/30 * * * *"},
+		{expr: "0 6 * * 1-5"},
+		{expr: "0 0 29 2 *"},
+		{expr: "@hourly"},
+		{expr: " @daily "},
+
+		{expr: "0 6 * *", wantErr: true},
+		{expr: "0 6 * * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 0 31 2 *", wantErr: true},
+		{expr: "@weekly", wantErr: true},
+		{expr: "", wantErr: true},
+	} {
+		if _, err := parseCron(test.expr); (err != nil) != test.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", test.expr, err, test.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"next step", "*/30 * * * *", "2026-10-15T10:07:00-04:00", "2026-10-15T10:30:00-04:00"},
+		{"strictly after", "*/30 * * * *", "2026-10-15T10:30:00-04:00", "2026-10-15T11:00:00-04:00"},
+		{"seconds ignored", "*/30 * * * *", "2026-10-15T10:29:59-04:00", "2026-10-15T10:30:00-04:00"},
+		{"tomorrow", "0 6 * * *", "2026-10-15T06:00:00-04:00", "2026-10-16T06:00:00-04:00"},
+		{"hourly", "@hourly", "2026-10-15T23:15:00-04:00", "2026-10-16T00:00:00-04:00"},
+		{"next month", "0 0 1 * *", "2026-10-15T12:00:00-04:00", "2026-11-01T00:00:00-04:00"},
+		{"next year", "0 0 1 1 *", "2026-10-15T12:00:00-04:00", "2027-01-01T00:00:00-05:00"},
+		{"leap day", "0 0 29 2 *", "2026-10-15T12:00:00-04:00", "2028-02-29T00:00:00-05:00"},
+		{"day of month skips short months", "0 12 31 * *", "2026-10-31T13:00:00-04:00", "2026-12-31T12:00:00-05:00"},
+
+		{"weekday", "0 9 * * 1", "2026-10-15T12:00:00-04:00", "2026-10-19T09:00:00-04:00"},
+		{"Sunday as 7", "0 9 * * 7", "2026-10-15T12:00:00-04:00", "2026-10-18T09:00:00-04:00"},
+		{"Sunday as 0", "0 9 * * 0", "2026-10-15T12:00:00-04:00", "2026-10-18T09:00:00-04:00"},
+		{"weekday range", "0 9 * * 1-5", "2026-10-16T10:00:00-04:00", "2026-10-19T09:00:00-04:00"},
+		// Restricting both day fields matches either: the 13th, or a Friday
+		{"day or weekday by weekday", "0 0 13 * 5", "2026-10-15T12:00:00-04:00", "2026-10-16T00:00:00-04:00"},
+		{"day or weekday by day", "0 0 13 * 5", "2026-11-06T12:00:00-05:00", "2026-11-13T00:00:00-05:00"},
+		{"day or weekday, not a Friday the 13th", "0 0 13 * 5", "2026-11-10T12:00:00-05:00", "2026-11-13T00:00:00-05:00"},
+		{"day and any weekday", "0 0 13 * *", "2026-10-15T12:00:00-04:00", "2026-11-13T00:00:00-05:00"},
+
+		// Clocks spring forward from 2:00 to 3:00 on March 8, 2026
+		{"spring forward skipped time", "30 2 * * *", "2026-03-08T00:00:00-05:00", "2026-03-08T03:30:00-04:00"},
+		{"spring forward runs once", "30 2 * * *", "2026-03-08T03:30:00-04:00", "2026-03-09T02:30:00-04:00"},
+		{"spring forward steps", "*/30 * * * *", "2026-03-08T01:45:00-05:00", "2026-03-08T03:00:00-04:00"},
+		// and fall back from 2:00 to 1:00 on November 1, 2026
+		{"fall back first time", "30 1 * * *", "2026-11-01T00:00:00-04:00", "2026-11-01T01:30:00-04:00"},
+		{"fall back runs once", "30 1 * * *", "2026-11-01T01:30:00-04:00", "2026-11-02T01:30:00-05:00"},
+		{"fall back from the repeated hour", "30 1 * * *", "2026-11-01T01:10:00-05:00", "2026-11-02T01:30:00-05:00"},
+		{"fall back steps", "0 * * * *", "2026-11-01T00:30:00-04:00", "2026-11-01T01:00:00-04:00"},
+		{"fall back steps past the repeated hour", "0 * * * *", "2026-11-01T01:00:00-04:00", "2026-11-01T02:00:00-05:00"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.Parse(time.RFC3339, test.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.next(from.In(loc)).Format(time.RFC3339); got != test.want {
+				t.Errorf("next(%s) = %s, want %s", test.from, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uga, err := parseCron("*/30 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagpole, err := parseCron("0 6 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		RefreshSchedule: []int{6 * 60, 18 * 60},
+		SourceSchedules: map[string]cronSchedule{originUGA: uga, originFlagpole: flagpole},
+	}
+	for _, test := range []struct {
+		from    string
+		want    string
+		origins []string
+	}{
+		{"2026-10-15T05:10:00-04:00", "2026-10-15T05:30:00-04:00", []string{originUGA}},
+		// A full refresh at 6:00 covers both sources due then
+		{"2026-10-15T05:30:00-04:00", "2026-10-15T06:00:00-04:00", nil},
+		{"2026-10-15T17:45:00-04:00", "2026-10-15T18:00:00-04:00", nil},
+		{"2026-10-15T18:00:00-04:00", "2026-10-15T18:30:00-04:00", []string{originUGA}},
+	} {
+		from, err := time.Parse(time.RFC3339, test.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, origins := nextRefresh(cfg, from.In(loc))
+		if got := next.Format(time.RFC3339); got != test.want || len(origins) != len(test.origins) || (len(origins) > 0 && origins[0] != test.origins[0]) {
+			t.Errorf("nextRefresh(%s) = %s %v, want %s %v", test.from, got, origins, test.want, test.origins)
+		}
+	}
+
+	cfg.RefreshSchedule = nil
+	cfg.SourceSchedules[originUGA] = flagpole
+	from, _ := time.Parse(time.RFC3339, "2026-10-15T05:00:00-04:00")
+	if _, origins := nextRefresh(cfg, from.In(loc)); len(origins) != 2 || origins[0] != originFlagpole || origins[1] != originUGA {
+		t.Errorf("nextRefresh() origins = %v, want both sources, sorted", origins)
+	}
+}
+
+func TestParseRefreshSchedule(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{value: "06:00,18:30", want: []int{360, 1110}},
+		{value: " 00:00 , ,23:59", want: []int{0, 1439}},
+		{value: "", want: nil},
+		{value: "6:00", want: []int{360}},
+
+		{value: "24:00", wantErr: true},
+		{value: "06:60", wantErr: true},
+		{value: "06:5", wantErr: true},
+		{value: "0600", wantErr: true},
+		{value: "six", wantErr: true},
+	} {
+		got, err := parseRefreshSchedule(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseRefreshSchedule(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("parseRefreshSchedule(%q) = %v, want %v", test.value, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("parseRefreshSchedule(%q) = %v, want %v", test.value, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSON Schemas for the API payloads, generated from the Go types so they
+// can't drift from what the server actually sends. Fields tagged omitempty
+// are optional; every other field is required, and pointers among them may
+// be null. schema_test.go validates marshaled payloads against them.
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Data Structures
+
+// jsonField is a struct field as encoding/json names it.
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// Helper Functions
+
+// jsonFields lists the fields of struct type t that encoding/json reads
+// and writes. Untagged embedded structs are flattened into t, their fields
+// shadowed by t's own of the same name.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields, promoted []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			promoted = append(promoted, jsonFields(field.Type)...)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{name: name, typ: field.Type, optional: strings.Contains(options, "omitempty")})
+	}
+	for _, p := range promoted {
+		shadowed := false
+		for _, f := range fields {
+			shadowed = shadowed || f.name == p.name
+		}
+		if !shadowed {
+			fields = append(fields, p)
+		}
+	}
+	return fields
+}
+
+func typeSchema(t reflect.Type, refs map[reflect.Type]string) map[string]interface{} {
+	if ref, ok := refs[t]; ok {
+		return map[string]interface{}{"$ref": ref}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), refs)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), refs)}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), refs), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), refs)}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for _, field := range jsonFields(t) {
+			properties[field.name] = typeSchema(field.typ, refs)
+			if !field.optional {
+				required = append(required, field.name)
+				// A nil pointer that isn't omitted is written as null
+				if field.typ.Kind() == reflect.Pointer {
+					properties[field.name] = map[string]interface{}{
+						"anyOf": []interface{}{properties[field.name], map[string]interface{}{"type": "null"}},
+					}
+				}
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func eventSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Event{}), nil)
+	schema["$schema"] = schemaDialect
+	schema["$id"] = "event.json"
+	schema["title"] = "Event"
+	return schema
+}
+
+func responseSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(APIResponse{}), map[reflect.Type]string{
+		reflect.TypeOf(Event{}): "event.json",
+	})
+	schema["$schema"] = schemaDialect
+	schema["$id"] = "response.json"
+	schema["title"] = "Events response"
+	return schema
+}
+
+// HTTP Handlers
+
+// schemaHandler serves /api/schema/event.json and /api/schema/response.json.
+// The response schema refers to the event schema by relative URL.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var schema map[string]interface{}
+	switch strings.TrimPrefix(r.URL.Path, "/api/schema/") {
+	case "event.json":
+		schema = eventSchema()
+	case "response.json":
+		schema = responseSchema()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, schema)
+}
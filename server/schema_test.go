@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// populate sets every field reachable from v to a non-zero value, so that
+// omitempty fields are marshaled too.
+func populate(v reflect.Value, depth int) {
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Date(2026, 10, 15, 20, 0, 0, 0, time.UTC)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Pointer:
+		if depth > 0 {
+			v.Set(reflect.New(v.Type().Elem()))
+			populate(v.Elem(), depth-1)
+		}
+	case reflect.Slice:
+		if depth > 0 {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+			populate(v.Index(0), depth-1)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			populate(v.Index(i), depth)
+		}
+	case reflect.Map:
+		if depth > 0 {
+			key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			populate(key, depth-1)
+			populate(value, depth-1)
+			v.Set(reflect.MakeMap(v.Type()))
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				populate(v.Field(i), depth)
+			}
+		}
+	}
+}
+
+// servedSchema fetches a schema from schemaHandler.
+func servedSchema(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	schemaHandler(rec, httptest.NewRequest(http.MethodGet, "/api/schema/"+name, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/schema/%s: %d", name, rec.Code)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// marshaled returns v as encoding/json writes it, decoded generically.
+func marshaled(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// validate checks value against the subset of JSON Schema that schema.go
+// generates, returning a message for each violation. refs resolves $ref
+// URLs.
+func validate(schema map[string]interface{}, value interface{}, path string, refs map[string]map[string]interface{}) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, ok := refs[ref]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved $ref %q", path, ref)}
+		}
+		return validate(target, value, path, refs)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var errs []string
+		for _, option := range anyOf {
+			optionErrs := validate(option.(map[string]interface{}), value, path, refs)
+			if len(optionErrs) == 0 {
+				return nil
+			}
+			errs = append(errs, optionErrs...)
+		}
+		return errs
+	}
+
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "":
+		return nil
+	case "null":
+		if value != nil {
+			return []string{fmt.Sprintf("%s: want null, got %T", path, value)}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", path, value)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []string{fmt.Sprintf("%s: %q is not a date-time", path, s)}
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: want boolean, got %T", path, value)}
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want %s, got %T", path, typ, value)}
+		}
+		if typ == "integer" && n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s: want integer, got %v", path, n)}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want array, got %T", path, value)}
+		}
+		var errs []string
+		if n, ok := schema["minItems"].(float64); ok && len(items) < int(n) {
+			errs = append(errs, fmt.Sprintf("%s: want at least %v items, got %d", path, n, len(items)))
+		}
+		if n, ok := schema["maxItems"].(float64); ok && len(items) > int(n) {
+			errs = append(errs, fmt.Sprintf("%s: want at most %v items, got %d", path, n, len(items)))
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			errs = append(errs, validate(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), refs)...)
+		}
+		return errs
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", path, value)}
+		}
+		var errs []string
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %q", path, name))
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				errs = append(errs, validate(property, object[key], path+"."+key, refs)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: unexpected property %q", path, key))
+				}
+			case map[string]interface{}:
+				errs = append(errs, validate(additional, object[key], path+"."+key, refs)...)
+			}
+		}
+		return errs
+	default:
+		return []string{fmt.Sprintf("%s: unknown schema type %q", path, typ)}
+	}
+	return nil
+}
+
+func populatedEvent() Event {
+	var e Event
+	populate(reflect.ValueOf(&e).Elem(), 3)
+	return e
+}
+
+func TestEventSchema(t *testing.T) {
+	schema := servedSchema(t, "event.json")
+
+	full := populatedEvent()
+	var sparse Event
+	sparse.ID, sparse.Title, sparse.AllDay = "abc123", "Open mic", true
+	for name, e := range map[string]Event{"populated": full, "all-day": sparse} {
+		for _, err := range validate(schema, marshaled(t, e), "event", nil) {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	// Every field of the populated event is described by the schema
+	properties := schema["properties"].(map[string]interface{})
+	for key := range marshaled(t, full).(map[string]interface{}) {
+		if _, ok := properties[key]; !ok {
+			t.Errorf("field %q is missing from the schema", key)
+		}
+	}
+}
+
+func TestResponseSchema(t *testing.T) {
+	refs := map[string]map[string]interface{}{"event.json": servedSchema(t, "event.json")}
+	schema := servedSchema(t, "response.json")
+
+	var response APIResponse
+	populate(reflect.ValueOf(&response).Elem(), 3)
+	response.Events = []Event{populatedEvent(), {ID: "abc123", Title: "Open mic", AllDay: true}}
+	for _, err := range validate(schema, marshaled(t, response), "response", refs) {
+		t.Error(err)
+	}
+	for _, err := range validate(schema, marshaled(t, APIResponse{Version: 2, Events: []Event{}}), "response", refs) {
+		t.Errorf("minimal: %s", err)
+	}
+}
+
+func TestSchemaRejectsDrift(t *testing.T) {
+	schema := servedSchema(t, "event.json")
+	event := marshaled(t, populatedEvent()).(map[string]interface{})
+	event["unlisted_field"] = 1
+	delete(event, "title")
+	event["latitude"] = "33.95"
+	if errs := validate(schema, event, "event", nil); len(errs) != 3 {
+		t.Errorf("want 3 violations, got %d: %v", len(errs), errs)
+	}
+}
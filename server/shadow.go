@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Parser migrations are validated by dark-launching the new source: with
+// MAPTHENS_LISTING_SOURCE=shadow, the HTML list is scraped and served as
+// before, while the events API is read alongside it and its events compared
+// with the list's. Discrepancies are logged and recorded in the run report,
+// and never reach clients.
+
+const (
+	listingAPI    = "api"
+	listingHTML   = "html"
+	listingShadow = "shadow"
+
+	maxShadowMismatches = 100
+)
+
+// Data Structures
+
+type ShadowReport struct {
+	Primary int `json:"primary_events"`
+	Shadow  int `json:"shadow_events"`
+	// Titles of events listed by only one source; the primary's are only
+	// those starting on the scraped day
+	OnlyPrimary []string         `json:"only_primary,omitempty"`
+	OnlyShadow  []string         `json:"only_shadow,omitempty"`
+	Mismatches  []ShadowMismatch `json:"mismatches,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+type ShadowMismatch struct {
+	EventID string `json:"event_id"`
+	Field   string `json:"field"`
+	Primary string `json:"primary"`
+	Shadow  string `json:"shadow"`
+}
+
+// Helper Functions
+
+func (r *ShadowReport) discrepancies() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.OnlyPrimary) + len(r.OnlyShadow) + len(r.Mismatches)
+}
+
+// shadowListEvents scrapes the HTML list for day and compares the events
+// API's listing with it, returning the list's events.
+func shadowListEvents(ctx context.Context, day string) ([]Event, *ShadowReport, error) {
+	var shadow []Event
+	var shadowErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shadow, shadowErr = fetchTribeEvents(ctx, tribeEventsAPI, day)
+	}()
+
+	primary, err := scrapeListing(ctx, flagpoleEventsURL, day)
+	wg.Wait()
+	if err != nil {
+		return nil, nil, err
+	}
+	if shadowErr != nil {
+		log.Printf("Warning: Shadow events API listing failed: %v", shadowErr)
+		return primary, &ShadowReport{Primary: len(primary), Error: shadowErr.Error()}, nil
+	}
+
+	report := compareListings(primary, shadow, day)
+	if n := report.discrepancies(); n > 0 {
+		log.Printf("Warning: Events API listing differs from the HTML list in %d places (%d only listed, %d only in the API, %d fields).",
+			n, len(report.OnlyPrimary), len(report.OnlyShadow), len(report.Mismatches))
+	} else {
+		log.Println("Events API listing matches the HTML list.")
+	}
+	return primary, report, nil
+}
+
+// compareListings matches shadow events to primary ones by ID, which both
+// derive from the event link, and compares the fields both sources fill in.
+func compareListings(primary, shadow []Event, day string) *ShadowReport {
+	report := &ShadowReport{Primary: len(primary), Shadow: len(shadow)}
+	byID := make(map[string]Event, len(primary))
+	for _, e := range primary {
+		byID[e.ID] = e
+	}
+
+	matched := map[string]bool{}
+	for _, s := range shadow {
+		p, ok := byID[s.ID]
+		if !ok {
+			report.OnlyShadow = append(report.OnlyShadow, s.Title)
+			continue
+		}
+		matched[s.ID] = true
+		for _, field := range []struct {
+			name            string
+			primary, shadow string
+		}{
+			{"title", p.Title, s.Title},
+			{"datetime", p.Datetime, s.Datetime},
+			{"start_date", p.StartDate, s.StartDate},
+			{"category", p.Category, s.Category},
+			{"venue", p.Venue, s.Venue},
+			{"address", p.Address, s.Address},
+		} {
+			if field.primary == field.shadow || len(report.Mismatches) >= maxShadowMismatches {
+				continue
+			}
+			report.Mismatches = append(report.Mismatches, ShadowMismatch{
+				EventID: s.ID,
+				Field:   field.name,
+				Primary: field.primary,
+				Shadow:  field.shadow,
+			})
+		}
+	}
+	for _, p := range primary {
+		// Later pages list events after day, which the API leaves out
+		if !matched[p.ID] && p.StartDate == day {
+			report.OnlyPrimary = append(report.OnlyPrimary, p.Title)
+		}
+	}
+	return report
+}
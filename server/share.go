@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every event gets a shareable page at /events/{id}, and /sitemap.xml lists
+// the pages of current and recent events so search engines can index them.
+// Events stay in the recent archive, and their pages keep working, for
+// recentRetention days after they end.
+
+const recentRetention = 14
+
+// Data Structures
+
+type recentEvent struct {
+	Event   Event     `json:"event"`
+	LastMod time.Time `json:"lastmod"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// Global Variables
+var (
+	recentEvents = map[string]recentEvent{}
+	recentMutex  sync.RWMutex
+	recentFile   = "recent.json"
+)
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{.Title}} | Mapthens</title>
+<meta name="description" content="{{.Datetime}} at {{.Venue}}">
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Datetime}} at {{.Venue}}">
+<link rel="stylesheet" href="/styles.css">
+</head>
+<body>
+<div class="event-item">
+  <h1>{{.Title}}</h1>
+  <p><strong>Date:</strong> {{.Datetime}}</p>
+  <p><strong>Category:</strong> {{.Category}}</p>
+  <p><strong>Venue:</strong> {{.Venue}}{{if .Address}}, {{.Address}}{{end}}</p>
+  <p>{{.Description}}</p>
+  {{- if .ShortURL}}
+  <p><strong>Share:</strong> <a href="{{.ShortURL}}">{{.ShortURL}}</a></p>
+  {{- end}}
+  <a href="{{.EventLink}}" target="_blank" rel="noopener">More Info</a>
+  &middot; <a href="/">See everything happening in Athens today</a>
+</div>
+</body>
+</html>
+`))
+
+// Helper Functions
+
+func loadRecentEvents() {
+	data, err := os.ReadFile(recentFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read recent events file: %v", err)
+		}
+		return
+	}
+
+	recent := map[string]recentEvent{}
+	if err := json.Unmarshal(data, &recent); err != nil {
+		log.Printf("Warning: Failed to parse recent events file: %v", err)
+		return
+	}
+
+	recentMutex.Lock()
+	recentEvents = recent
+	recentMutex.Unlock()
+}
+
+// updateRecentEvents adds a scrape's events to the recent archive, drops
+// those that ended more than recentRetention days ago, and saves it. The
+// file is re-read under its lock, so events that other processes sharing
+// the cache directory archived are kept, and picked up here.
+func updateRecentEvents(events []Event, scrapedAt time.Time) error {
+	cutoff := localNow().AddDate(0, 0, -recentRetention).Format("2006-01-02")
+
+	recent := map[string]recentEvent{}
+	err := updateJSONFile(recentFile, &recent, func() error {
+		for _, e := range events {
+			recent[e.ID] = recentEvent{Event: e, LastMod: scrapedAt}
+		}
+		for id, r := range recent {
+			if r.Event.EndDate < cutoff {
+				delete(recent, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recentMutex.Lock()
+	recentEvents = recent
+	recentMutex.Unlock()
+	return nil
+}
+
+// findEvent looks an event up in today's events, then the recent archive.
+func findEvent(id string) (Event, bool) {
+	if events, err := getEvents(); err == nil {
+		for _, e := range events {
+			if e.ID == id {
+				return e, true
+			}
+		}
+	}
+
+	recentMutex.RLock()
+	r, ok := recentEvents[id]
+	recentMutex.RUnlock()
+	if ok {
+		applyEdit(&r.Event)
+	}
+	return r.Event, ok
+}
+
+// baseURL is the public origin used for absolute sitemap links.
+func baseURL(r *http.Request) string {
+	if public := getConfig().PublicURL; public != "" {
+		return strings.TrimSuffix(public, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func buildSitemap(base string, events []Event, scrapedAt time.Time) sitemapURLSet {
+	lastMods := map[string]time.Time{}
+	recentMutex.RLock()
+	for id, r := range recentEvents {
+		lastMods[id] = r.LastMod
+	}
+	recentMutex.RUnlock()
+	for _, e := range events {
+		if _, ok := lastMods[e.ID]; !ok {
+			lastMods[e.ID] = scrapedAt
+		}
+	}
+
+	ids := make([]string, 0, len(lastMods))
+	for id := range lastMods {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, id := range ids {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     base + "/events/" + id,
+			LastMod: lastMods[id].UTC().Format(time.RFC3339),
+		})
+	}
+	return set
+}
+
+// HTTP Handlers
+
+func sharePageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	e, ok := findEvent(strings.TrimPrefix(r.URL.Path, "/events/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	sharePageTemplate.Execute(w, struct {
+		Event
+		ShortURL string
+	}{e, shortURL(r, e)})
+}
+
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(buildSitemap(baseURL(r), events, info.ScrapedAt))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every scraped event gets a short code, so its share page can be linked as
+// /s/{code}, e.g. https://mapthens.com/s/4fZq9aK, short enough for a post or
+// a text message. Codes are worked out from the event ID, so an event keeps
+// its code across scrapes, and only grow longer when two events' codes
+// collide. They're recorded in the event store: with Postgres in its
+// short_links table, otherwise in shortlinks.json in the cache directory,
+// where codes are dropped once their events have been gone for
+// recentRetention days, as their share pages are.
+
+const (
+	shortCodeLength    = 7
+	maxShortCodeLength = 12
+)
+
+// Data Structures
+
+type savedShortLink struct {
+	EventID  string `json:"event_id"`
+	LastSeen string `json:"last_seen"`
+}
+
+// Global Variables
+var (
+	memoryShortLinks        = map[string]savedShortLink{}
+	memoryShortLinksModTime time.Time
+	memoryShortLinksMutex   sync.Mutex
+	shortLinksFile          = "shortlinks.json"
+)
+
+// Helper Functions
+
+// shortCode is the length-character code for an event ID: the end of its
+// SHA-256 hash in base 62.
+func shortCode(id string, length int) string {
+	sum := sha256.Sum256([]byte(id))
+	encoded := new(big.Int).SetBytes(sum[:]).Text(62)
+	return encoded[len(encoded)-length:]
+}
+
+// assignShortCodes sets the short code of each event and records the codes
+// in the event store. An event whose code is taken by another event tries
+// a longer one.
+func assignShortCodes(events []Event) {
+	links := map[string]string{}
+	pending := make([]int, 0, len(events))
+	for i := range events {
+		if events[i].ID != "" {
+			pending = append(pending, i)
+		}
+	}
+	for length := shortCodeLength; len(pending) > 0 && length <= maxShortCodeLength; length++ {
+		codes := make([]string, len(pending))
+		for j, i := range pending {
+			codes[j] = shortCode(events[i].ID, length)
+		}
+		taken, err := eventStore.ShortLinks(codes)
+		if err != nil {
+			log.Printf("Warning: Failed to look up short links: %v", err)
+			return
+		}
+
+		var collided []int
+		for j, i := range pending {
+			code, id := codes[j], events[i].ID
+			if other, ok := taken[code]; ok && other != id {
+				collided = append(collided, i)
+				continue
+			}
+			if other, ok := links[code]; ok && other != id {
+				collided = append(collided, i)
+				continue
+			}
+			links[code] = id
+			events[i].ShortCode = code
+		}
+		pending = collided
+	}
+	if len(pending) > 0 {
+		log.Printf("Warning: %d events were left without short links.", len(pending))
+	}
+	if err := eventStore.SaveShortLinks(links); err != nil {
+		log.Printf("Warning: Failed to save short links: %v", err)
+	}
+}
+
+func loadShortLinks() {
+	memoryShortLinksMutex.Lock()
+	defer memoryShortLinksMutex.Unlock()
+	if err := loadShortLinksLocked(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to read short links file: %v", err)
+	}
+}
+
+// loadShortLinksLocked reads the short links file, unless it hasn't changed
+// since it was last read. Other processes sharing the cache directory add
+// codes to it. The caller holds memoryShortLinksMutex.
+func loadShortLinksLocked() error {
+	info, err := os.Stat(shortLinksFile)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(memoryShortLinksModTime) {
+		return nil
+	}
+	saved := map[string]savedShortLink{}
+	if err := readJSONFile(shortLinksFile, &saved); err != nil {
+		return err
+	}
+	memoryShortLinks, memoryShortLinksModTime = saved, info.ModTime()
+	return nil
+}
+
+// SaveShortLinks adds links to the file under its lock. A code another
+// process has already given a different event keeps it, as in Postgres.
+func (memoryStore) SaveShortLinks(links map[string]string) error {
+	memoryShortLinksMutex.Lock()
+	defer memoryShortLinksMutex.Unlock()
+
+	day := today()
+	cutoff := localNow().AddDate(0, 0, -recentRetention).Format("2006-01-02")
+	saved := map[string]savedShortLink{}
+	err := updateJSONFile(shortLinksFile, &saved, func() error {
+		for code, link := range saved {
+			if link.LastSeen < cutoff {
+				delete(saved, code)
+			}
+		}
+		for code, id := range links {
+			if link, ok := saved[code]; ok && link.EventID != id {
+				continue
+			}
+			saved[code] = savedShortLink{EventID: id, LastSeen: day}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	memoryShortLinks = saved
+	if info, err := os.Stat(shortLinksFile); err == nil {
+		memoryShortLinksModTime = info.ModTime()
+	}
+	return nil
+}
+
+func (memoryStore) ShortLinks(codes []string) (map[string]string, error) {
+	memoryShortLinksMutex.Lock()
+	defer memoryShortLinksMutex.Unlock()
+	if err := loadShortLinksLocked(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	links := map[string]string{}
+	for _, code := range codes {
+		if link, ok := memoryShortLinks[code]; ok {
+			links[code] = link.EventID
+		}
+	}
+	return links, nil
+}
+
+// shortURL is an event's absolute short link, or "" when it has no code.
+func shortURL(r *http.Request, e Event) string {
+	if e.ShortCode == "" {
+		return ""
+	}
+	return baseURL(r) + "/s/" + e.ShortCode
+}
+
+// HTTP Handlers
+
+// shortLinkHandler redirects /s/{code} to the event's share page.
+func shortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	if code == "" || len(code) > maxShortCodeLength {
+		http.NotFound(w, r)
+		return
+	}
+	links, err := eventStore.ShortLinks([]string{code})
+	if err != nil {
+		http.Error(w, "Error looking up short link", http.StatusInternalServerError)
+		return
+	}
+	id, ok := links[code]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.Redirect(w, r, "/events/"+url.PathEscape(id), http.StatusMovedPermanently)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Responses from /api/events can be signed so mirrors and other consumers
+// can check they came from this server. With MAPTHENS_SIGNING_KEY set, each
+// successful response carries the SHA-256 of its (uncompressed) body in
+// X-Payload-SHA256 and an Ed25519 signature of that 32-byte digest in
+// X-Signature, both computed before any Content-Encoding is applied.
+// X-Signature-Key-Id names the key; its public half is served by
+// /api/signing-key. A key can be made with
+//
+//	go run . signing-key
+
+// Data Structures
+
+type signingKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"` // base64
+}
+
+// signedResponseWriter holds back the body until the handler is done so the
+// whole payload can be hashed before the headers go out.
+type signedResponseWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *signedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *signedResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// Helper Functions
+
+// parseSigningKey decodes a base64 Ed25519 seed or full private key.
+func parseSigningKey(value string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %v", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		key := ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize])
+		if !bytes.Equal(key, raw) {
+			return nil, fmt.Errorf("public half doesn't match the seed")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("got %d bytes, want %d or %d", len(raw), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// signingKeyID identifies a public key by the start of its SHA-256.
+func signingKeyID(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+// generateSigningKey prints a new key for MAPTHENS_SIGNING_KEY.
+func generateSigningKey() error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("MAPTHENS_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+	fmt.Printf("# key ID %s, public key %s\n", signingKeyID(public), base64.StdEncoding.EncodeToString(public))
+	return nil
+}
+
+// withSignature signs h's successful responses when a signing key is
+// configured. It must be wrapped by withCompression, not wrap it, so the
+// signature covers the uncompressed payload.
+func withSignature(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := getConfig().SigningKey
+		if key == nil {
+			h(w, r)
+			return
+		}
+
+		sw := &signedResponseWriter{ResponseWriter: w}
+		h(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if sw.status == http.StatusOK {
+			digest := sha256.Sum256(sw.body.Bytes())
+			w.Header().Set("X-Payload-SHA256", hex.EncodeToString(digest[:]))
+			w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:])))
+			w.Header().Set("X-Signature-Key-Id", signingKeyID(key.Public().(ed25519.PublicKey)))
+		}
+		if w.Header().Get("Content-Encoding") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(sw.body.Len()))
+		}
+		w.WriteHeader(sw.status)
+		w.Write(sw.body.Bytes())
+	}
+}
+
+// HTTP Handlers
+
+// signingKeyHandler serves the public key responses are signed with, or 404
+// when responses aren't signed.
+func signingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := getConfig().SigningKey
+	if key == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	public := key.Public().(ed25519.PublicKey)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, signingKeyResponse{
+		Algorithm: "ed25519",
+		KeyID:     signingKeyID(public),
+		PublicKey: base64.StdEncoding.EncodeToString(public),
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server holds itself to three service level objectives:
+//
+//	freshness      the served events were scraped less than 26 hours ago
+//	geocoding      over 90% of the last scrape's events have coordinates
+//	api_latency    99% of /api/ requests in the last 10 minutes were
+//	               answered within 200ms
+//
+// They're evaluated every minute. When one is breached, and again when it
+// recovers, an alert is POSTed to MAPTHENS_ALERT_WEBHOOK_URL as JSON with a
+// "text" summary, so a Slack or Teams incoming webhook can take it as is.
+// An objective without enough data to judge (no scrape yet, too few
+// requests) is reported as "no_data" and never alerts. /api/status/slo
+// reports each objective's current state.
+
+const (
+	sloFreshness  = "freshness"
+	sloGeocoding  = "geocoding"
+	sloAPILatency = "api_latency"
+
+	sloOK       = "ok"
+	sloBreached = "breached"
+	sloNoData   = "no_data"
+
+	sloInterval       = time.Minute
+	latencyWindow     = 10 * time.Minute
+	maxLatencySamples = 50000
+	minLatencySamples = 20
+)
+
+// Data Structures
+
+type sloObjective struct {
+	Name        string
+	Description string
+	// Objective is the threshold, in Unit; Above says the value must stay
+	// over it rather than under
+	Objective float64
+	Unit      string
+	Above     bool
+	measure   func() (float64, bool)
+}
+
+type SLOStatus struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Objective   float64   `json:"objective"`
+	Unit        string    `json:"unit"`
+	Value       *float64  `json:"value,omitempty"`
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+type sloAlert struct {
+	SLO       string    `json:"slo"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Objective float64   `json:"objective"`
+	Unit      string    `json:"unit"`
+	At        time.Time `json:"at"`
+	Text      string    `json:"text"`
+}
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// latencyRing keeps the last maxLatencySamples samples, overwriting the
+// oldest once it's full, so recording one never copies the others.
+type latencyRing struct {
+	samples []latencySample
+	next    int
+}
+
+// Global Variables
+var (
+	sloObjectives = []sloObjective{
+		{sloFreshness, "Served events were scraped less than 26 hours ago", 26, "hours", false, measureFreshness},
+		{sloGeocoding, "Over 90% of the last scrape's events have coordinates", 90, "percent", true, measureGeocoding},
+		{sloAPILatency, "99% of API requests in the last 10 minutes took under 200ms", 200, "ms", false, measureAPILatency},
+	}
+	sloStates      = map[string]SLOStatus{}
+	sloStatesMutex sync.Mutex
+
+	latencySamples      latencyRing
+	latencySamplesMutex sync.Mutex
+
+	alertClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Helper Functions
+
+// withLatency records how long each /api/ request takes.
+func withLatency(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		started := time.Now()
+		h.ServeHTTP(w, r)
+		recordLatency(started, time.Since(started))
+	})
+}
+
+func recordLatency(at time.Time, d time.Duration) {
+	latencySamplesMutex.Lock()
+	defer latencySamplesMutex.Unlock()
+	latencySamples.add(latencySample{at, d})
+}
+
+func (r *latencyRing) add(s latencySample) {
+	if len(r.samples) < maxLatencySamples {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+func measureFreshness() (float64, bool) {
+	mutex.RLock()
+	scrapedAt := cacheTime
+	mutex.RUnlock()
+	if scrapedAt.IsZero() {
+		return 0, false
+	}
+	return since(scrapedAt).Hours(), true
+}
+
+func measureGeocoding() (float64, bool) {
+	run := lastRunReport()
+	if run == nil || run.Failed || run.EventsScraped == 0 {
+		return 0, false
+	}
+	return 100 * float64(run.EventsScraped-run.GeocodeFailures) / float64(run.EventsScraped), true
+}
+
+// measureAPILatency is the 99th percentile latency of the last
+// latencyWindow's API requests.
+func measureAPILatency() (float64, bool) {
+	latencySamplesMutex.Lock()
+	cutoff := time.Now().Add(-latencyWindow)
+	var durations []time.Duration
+	for _, s := range latencySamples.samples {
+		if s.at.After(cutoff) {
+			durations = append(durations, s.duration)
+		}
+	}
+	latencySamplesMutex.Unlock()
+
+	if len(durations) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return float64(percentile(durations, 99)) / float64(time.Millisecond), true
+}
+
+// evaluateSLOs measures every objective, alerting on those that were
+// breached or recovered since the last evaluation.
+func evaluateSLOs() []SLOStatus {
+	evaluatedAt := now()
+	var alerts []sloAlert
+	statuses := make([]SLOStatus, 0, len(sloObjectives))
+
+	sloStatesMutex.Lock()
+	for _, o := range sloObjectives {
+		status := SLOStatus{Name: o.Name, Description: o.Description, Objective: o.Objective, Unit: o.Unit, State: sloNoData, EvaluatedAt: evaluatedAt}
+		value, ok := o.measure()
+		if ok {
+			status.Value = &value
+			status.State = sloOK
+			if (o.Above && value <= o.Objective) || (!o.Above && value >= o.Objective) {
+				status.State = sloBreached
+			}
+		}
+
+		previous, seen := sloStates[o.Name]
+		status.Since = evaluatedAt
+		if seen && previous.State == status.State {
+			status.Since = previous.Since
+		}
+		// Recovering from no data isn't news; recovering from a breach is
+		if ok && status.State == sloBreached && previous.State != sloBreached {
+			alerts = append(alerts, newSLOAlert(o, status))
+		} else if ok && status.State == sloOK && previous.State == sloBreached {
+			alerts = append(alerts, newSLOAlert(o, status))
+		}
+		sloStates[o.Name] = status
+		statuses = append(statuses, status)
+	}
+	sloStatesMutex.Unlock()
+
+	for _, alert := range alerts {
+		log.Printf("Warning: %s", alert.Text)
+		if err := sendAlert(alert); err != nil {
+			log.Printf("Warning: Failed to send SLO alert: %v", err)
+		}
+	}
+	return statuses
+}
+
+func newSLOAlert(o sloObjective, status SLOStatus) sloAlert {
+	alert := sloAlert{SLO: o.Name, State: status.State, Value: *status.Value, Objective: o.Objective, Unit: o.Unit, At: status.EvaluatedAt}
+	comparison := "under"
+	if o.Above {
+		comparison = "over"
+	}
+	if status.State == sloBreached {
+		alert.Text = fmt.Sprintf("Mapthens SLO %s breached: %.1f %s, objective %s %.0f %s", o.Name, alert.Value, o.Unit, comparison, o.Objective, o.Unit)
+	} else {
+		alert.Text = fmt.Sprintf("Mapthens SLO %s recovered: %.1f %s", o.Name, alert.Value, o.Unit)
+	}
+	return alert
+}
+
+// sendAlert POSTs an alert, an sloAlert or a sourceAlert (see quarantine.go),
+// to MAPTHENS_ALERT_WEBHOOK_URL, when it's set.
+func sendAlert(alert interface{}) error {
+	return postJSON(getConfig().AlertWebhookURL, alert)
+}
+
+// postJSON POSTs v to a webhook at url, doing nothing when url is empty.
+func postJSON(url string, v interface{}) error {
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func evaluateSLOsPeriodically() {
+	for {
+		time.Sleep(sloInterval)
+		evaluateSLOs()
+	}
+}
+
+// HTTP Handlers
+
+// sloStatusHandler serves GET /api/status/slo, the objectives as last
+// evaluated.
+func sloStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sloStatesMutex.Lock()
+	statuses := make([]SLOStatus, 0, len(sloObjectives))
+	for _, o := range sloObjectives {
+		if status, ok := sloStates[o.Name]; ok {
+			statuses = append(statuses, status)
+		}
+	}
+	sloStatesMutex.Unlock()
+	// Before the first evaluation
+	if len(statuses) == 0 {
+		statuses = evaluateSLOs()
+	}
+	writeJSON(w, statuses)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// Most re-scrapes find the same listings as the last one. Each snapshot of
+// the normalized events is hashed, and when the hash matches the previous
+// snapshot the cache file is only touched to mark it fresh instead of being
+// rewritten.
+
+// Helper Functions
+
+func snapshotPath() string {
+	return dataFile + ".sha256"
+}
+
+// snapshotHash hashes the normalized events, leaving out their scrape times
+// so that a re-scrape of the same listings hashes the same.
+func snapshotHash(events []Event) (string, error) {
+	normalized := normalizeEvents(events)
+	for i := range normalized {
+		normalized[i].ScrapedAt = time.Time{}
+	}
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// snapshotUnchanged reports whether hash matches the snapshot the cache file
+// currently holds.
+func snapshotUnchanged(hash string) bool {
+	if _, err := os.Stat(dataFile); err != nil {
+		return false
+	}
+	previous, err := os.ReadFile(snapshotPath())
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(previous)) == hash
+}
+
+func saveSnapshotHash(hash string) error {
+	return writeFileAtomic(snapshotPath(), []byte(hash+"\n"), 0644)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The same event is often listed by flagpole and by another calendar such as
+// UGA's. Listings are matched on start date and title and merged field by
+// field: the time comes from the highest-priority source with a clock time
+// rather than an all-day entry, the description from whichever is longest,
+// and everything else from the highest-priority source that has it. Sources
+// that disagree on the time are recorded as conflicts in the run report.
+// Each field the merged event didn't take from its own listing (the one
+// named by source_name) is named in its field_sources, with the source of
+// the listing it came from.
+//
+// Each source's last listing of the day is kept in sources.json in the
+// cache directory, so a source can be refreshed on its own schedule (see
+// schedule.go) and merged again with the others' last listings.
+
+const (
+	originFlagpole = "flagpole"
+	originUGA      = "uga"
+)
+
+var defaultSourcePriority = []string{originFlagpole, originUGA, originVenue}
+
+// Data Structures
+
+type savedListing struct {
+	Day       string    `json:"day"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Events    []Event   `json:"events"`
+}
+
+type SourceConflict struct {
+	EventID string `json:"event_id"`
+	Title   string `json:"title"`
+	Field   string `json:"field"`
+	// Values holds each source's value, keyed by source name
+	Values map[string]string `json:"values"`
+	Chosen string            `json:"chosen"`
+}
+
+// Global Variables
+var (
+	sourceListings      = map[string]savedListing{}
+	sourceListingsMutex sync.RWMutex
+	sourcesFile         = "sources.json"
+)
+
+// Helper Functions
+
+// sourceOrigin returns the calendar a source name belongs to, e.g.
+// "flagpole" for both the flagpole API and its HTML list.
+func sourceOrigin(name string) string {
+	origin, _, _ := strings.Cut(name, "-")
+	return origin
+}
+
+func parseSourcePriority(value string) ([]string, error) {
+	if value == "" {
+		return defaultSourcePriority, nil
+	}
+	var priority []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin != originFlagpole && origin != originUGA && origin != originVenue {
+			return nil, fmt.Errorf("unknown source %q: must be %q, %q, or %q", origin, originFlagpole, originUGA, originVenue)
+		}
+		priority = append(priority, origin)
+	}
+	return priority, nil
+}
+
+// mergeSources merges events listed by more than one source. Each merged
+// event keeps the ID and provenance of its highest-priority listing, so
+// edits made to it keep applying. Two listings from the same source are
+// never merged with each other.
+func mergeSources(events []Event, priority []string) ([]Event, []SourceConflict) {
+	rank := func(e Event) int {
+		for i, origin := range priority {
+			if origin == sourceOrigin(e.SourceName) {
+				return i
+			}
+		}
+		return len(priority)
+	}
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+
+	var groups [][]Event
+	byKey := map[string][]int{}
+	for _, e := range sorted {
+		start := e.StartDate
+		if start == "" {
+			// Cached events from older files predate start dates
+			start = e.Date[:min(len(e.Date), 10)]
+		}
+		key := start + "|" + normalizeTitle(e.Title)
+		group := -1
+		for _, i := range byKey[key] {
+			if !hasOrigin(groups[i], sourceOrigin(e.SourceName)) {
+				group = i
+				break
+			}
+		}
+		if group < 0 {
+			byKey[key] = append(byKey[key], len(groups))
+			groups = append(groups, []Event{e})
+		} else {
+			groups[group] = append(groups[group], e)
+		}
+	}
+
+	merged := make([]Event, 0, len(groups))
+	var conflicts []SourceConflict
+	for _, group := range groups {
+		e, conflict := mergeListings(group)
+		merged = append(merged, e)
+		if conflict != nil {
+			log.Printf("Warning: Sources disagree on the time of %q, using %s's.", conflict.Title, conflict.Chosen)
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	if len(merged) < len(events) {
+		log.Printf("Merged %d listings found in more than one source.", len(events)-len(merged))
+	}
+	return merged, conflicts
+}
+
+func hasOrigin(group []Event, origin string) bool {
+	for _, e := range group {
+		if sourceOrigin(e.SourceName) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeListings merges one event's listings, given highest priority first,
+// and reports a conflict when they have different clock times.
+func mergeListings(listings []Event) (Event, *SourceConflict) {
+	e := listings[0]
+	if len(listings) == 1 {
+		return e, nil
+	}
+	fieldSources := map[string]string{}
+	for field, source := range e.FieldSources {
+		fieldSources[field] = source
+	}
+
+	var timed []Event
+	for _, listing := range listings {
+		if _, _, allDay, err := eventTimes(listing); err == nil && !allDay {
+			timed = append(timed, listing)
+		}
+	}
+	if len(timed) > 0 {
+		e.Date, e.StartDate, e.EndDate = timed[0].Date, timed[0].StartDate, timed[0].EndDate
+		e.Datetime = timed[0].Datetime
+		if timed[0].SourceName != e.SourceName {
+			fieldSources["datetime"] = timed[0].SourceName
+		}
+	}
+
+	for _, listing := range listings[1:] {
+		if len(listing.Description) > len(e.Description) {
+			e.Description = listing.Description
+			fieldSources["description"] = listing.SourceName
+		}
+		for _, field := range []struct {
+			name   string
+			value  string
+			target *string
+		}{
+			{"category", listing.Category, &e.Category},
+			{"event_link", listing.EventLink, &e.EventLink},
+			{"venue", listing.Venue, &e.Venue},
+			{"address", listing.Address, &e.Address},
+		} {
+			if *field.target == "" && field.value != "" {
+				*field.target = field.value
+				fieldSources[field.name] = listing.SourceName
+			}
+		}
+		if e.Latitude == 0 && e.Longitude == 0 && (listing.Latitude != 0 || listing.Longitude != 0) {
+			e.Latitude, e.Longitude = listing.Latitude, listing.Longitude
+			e.GeocodeProvider = listing.GeocodeProvider
+			fieldSources["latitude"] = listing.SourceName
+			fieldSources["longitude"] = listing.SourceName
+		}
+		if len(e.SupportActs) == 0 && len(listing.SupportActs) > 0 {
+			e.SupportActs = listing.SupportActs
+			fieldSources["support_acts"] = listing.SourceName
+		}
+		if e.DoorTime == nil && listing.DoorTime != nil {
+			e.DoorTime = listing.DoorTime
+			fieldSources["door_time"] = listing.SourceName
+		}
+	}
+	if len(fieldSources) > 0 {
+		e.FieldSources = fieldSources
+	}
+
+	return e, timeConflict(e, timed)
+}
+
+func timeConflict(e Event, timed []Event) *SourceConflict {
+	if len(timed) < 2 {
+		return nil
+	}
+	start, end, _, _ := eventTimes(timed[0])
+	conflicting := false
+	for _, listing := range timed[1:] {
+		otherStart, otherEnd, _, _ := eventTimes(listing)
+		if !otherStart.Equal(start) || !otherEnd.Equal(end) {
+			conflicting = true
+		}
+	}
+	if !conflicting {
+		return nil
+	}
+
+	conflict := &SourceConflict{
+		EventID: e.ID,
+		Title:   e.Title,
+		Field:   "datetime",
+		Values:  map[string]string{},
+		Chosen:  timed[0].SourceName,
+	}
+	for _, listing := range timed {
+		conflict.Values[listing.SourceName] = listing.Datetime
+	}
+	return conflict
+}
+
+// recordSourceListing keeps what origin listed for day. The file is re-read
+// under its lock, so other processes' listings of other sources are kept,
+// and picked up here. A failure to save it is only logged; the listing is
+// still used in memory.
+func recordSourceListing(origin, day string, events []Event) {
+	sourceListingsMutex.Lock()
+	defer sourceListingsMutex.Unlock()
+	listing := savedListing{Day: day, FetchedAt: now(), Events: events}
+	sourceListings[origin] = listing
+	saved := map[string]savedListing{}
+	err := updateJSONFile(sourcesFile, &saved, func() error {
+		saved[origin] = listing
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to save source listings: %v", err)
+		return
+	}
+	sourceListings = saved
+}
+
+// lastSourceListing returns a copy of origin's last listing, if it was for
+// day.
+func lastSourceListing(origin, day string) ([]Event, bool) {
+	sourceListingsMutex.RLock()
+	defer sourceListingsMutex.RUnlock()
+	listing, ok := sourceListings[origin]
+	if !ok || listing.Day != day {
+		return nil, false
+	}
+	events := make([]Event, len(listing.Events))
+	copy(events, listing.Events)
+	return events, true
+}
+
+func loadSourceListings() {
+	data, err := os.ReadFile(sourcesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read source listings file: %v", err)
+		}
+		return
+	}
+
+	loaded := map[string]savedListing{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("Warning: Failed to parse source listings file: %v", err)
+		return
+	}
+
+	sourceListingsMutex.Lock()
+	sourceListings = loaded
+	sourceListingsMutex.Unlock()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// The memory store answers bbox and radius queries from a grid index over
+// the cached events' coordinates, rebuilt whenever the cache is. Each cell
+// is spatialCellDegrees on a side (about 1km by 0.9km in Athens), so a
+// query only looks at the events in the cells it overlaps.
+
+const (
+	spatialCellDegrees = 0.01
+	metersPerDegree    = 111320.0 // of latitude, and of longitude at the equator
+)
+
+// Data Structures
+
+type indexCell struct {
+	lat, lng int
+}
+
+// spatialIndex holds the geocoded events in eventOrder, with their
+// positions in events listed by cell.
+type spatialIndex struct {
+	events   []Event
+	cells    map[indexCell][]int
+	min, max indexCell
+}
+
+// Global Variables
+
+// cacheIndex indexes eventsCache; it's guarded by mutex along with it.
+var cacheIndex = buildSpatialIndex(nil)
+
+// Helper Functions
+
+func cellOf(lat, lng float64) indexCell {
+	return indexCell{int(math.Floor(lat / spatialCellDegrees)), int(math.Floor(lng / spatialCellDegrees))}
+}
+
+func buildSpatialIndex(events []Event) *spatialIndex {
+	ix := &spatialIndex{cells: map[indexCell][]int{}}
+	for _, e := range events {
+		if e.Latitude == 0 && e.Longitude == 0 {
+			continue
+		}
+		cell := cellOf(e.Latitude, e.Longitude)
+		if len(ix.events) == 0 {
+			ix.min, ix.max = cell, cell
+		}
+		ix.min = indexCell{min(ix.min.lat, cell.lat), min(ix.min.lng, cell.lng)}
+		ix.max = indexCell{max(ix.max.lat, cell.lat), max(ix.max.lng, cell.lng)}
+		ix.cells[cell] = append(ix.cells[cell], len(ix.events))
+		ix.events = append(ix.events, e)
+	}
+	return ix
+}
+
+// candidates returns the positions of the events in the cells from lo to hi
+// that run on day, in eventOrder.
+func (ix *spatialIndex) candidates(lo, hi indexCell, day string) []int {
+	lo = indexCell{max(lo.lat, ix.min.lat), max(lo.lng, ix.min.lng)}
+	hi = indexCell{min(hi.lat, ix.max.lat), min(hi.lng, ix.max.lng)}
+	var found []int
+	for lat := lo.lat; lat <= hi.lat; lat++ {
+		for lng := lo.lng; lng <= hi.lng; lng++ {
+			for _, i := range ix.cells[indexCell{lat, lng}] {
+				if e := ix.events[i]; e.StartDate <= day && day <= e.EndDate {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+// within returns the events on day inside bbox, in eventOrder.
+func (ix *spatialIndex) within(day string, bbox [4]float64) []Event {
+	within := []Event{}
+	for _, i := range ix.candidates(cellOf(bbox[1], bbox[0]), cellOf(bbox[3], bbox[2]), day) {
+		if e := ix.events[i]; e.Longitude >= bbox[0] && e.Latitude >= bbox[1] && e.Longitude <= bbox[2] && e.Latitude <= bbox[3] {
+			within = append(within, e)
+		}
+	}
+	return within
+}
+
+// nearby returns up to limit events on day within radius meters of origin,
+// nearest first. Without a radius, rings of cells are searched outward from
+// origin's until limit events are found that are nearer than anything in
+// the next ring could be.
+func (ix *spatialIndex) nearby(day string, origin coordinates, radius float64, limit int) []Event {
+	if len(ix.events) == 0 {
+		return []Event{}
+	}
+	center := cellOf(origin.Latitude, origin.Longitude)
+	// The shortest a cell side gets, so ring r is at least r of these away
+	cellMeters := spatialCellDegrees * metersPerDegree * math.Cos(origin.Latitude*math.Pi/180)
+
+	var lo, hi indexCell
+	if radius > 0 {
+		dLat := radius / metersPerDegree
+		dLng := radius / (metersPerDegree * math.Max(math.Cos(origin.Latitude*math.Pi/180), 0.01))
+		lo = cellOf(origin.Latitude-dLat, origin.Longitude-dLng)
+		hi = cellOf(origin.Latitude+dLat, origin.Longitude+dLng)
+	} else {
+		lo, hi = ix.min, ix.max
+		if limit > 0 {
+			for ring := 0; ; ring++ {
+				lo = indexCell{center.lat - ring, center.lng - ring}
+				hi = indexCell{center.lat + ring, center.lng + ring}
+				covered := lo.lat <= ix.min.lat && lo.lng <= ix.min.lng && hi.lat >= ix.max.lat && hi.lng >= ix.max.lng
+				if covered {
+					break
+				}
+				found := ix.byDistance(ix.candidates(lo, hi, day), origin)
+				if len(found) >= limit && *found[limit-1].DistanceMeters <= float64(ring)*cellMeters {
+					break
+				}
+			}
+		}
+	}
+
+	nearby := []Event{}
+	for _, e := range ix.byDistance(ix.candidates(lo, hi, day), origin) {
+		if radius > 0 && *e.DistanceMeters > radius {
+			continue
+		}
+		nearby = append(nearby, e)
+	}
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby
+}
+
+// byDistance returns the events at positions, nearest to origin first, with
+// DistanceMeters set. Ties keep eventOrder.
+func (ix *spatialIndex) byDistance(positions []int, origin coordinates) []Event {
+	events := make([]Event, len(positions))
+	for j, i := range positions {
+		e := ix.events[i]
+		distance := distanceMeters(origin, coordinates{Latitude: e.Latitude, Longitude: e.Longitude})
+		e.DistanceMeters = &distance
+		events[j] = e
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return *events[i].DistanceMeters < *events[j].DistanceMeters
+	})
+	return events
+}
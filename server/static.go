@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The frontend is served from ../public. Its text assets can be
+// precompressed with
+//
+//	go run . compress-assets ../public
+//
+// which writes a .br file next to each one at Brotli's best (and slowest)
+// level. Clients that accept Brotli are sent the .br file in place of the
+// original, as long as it isn't older than the original; everything else is
+// served as is.
+
+const brotliSuffix = ".br"
+
+// compressibleAssets are the extensions of the assets worth precompressing;
+// images are already compressed.
+var compressibleAssets = []string{".css", ".html", ".js", ".json", ".svg", ".txt", ".xml"}
+
+// Helper Functions
+
+// compressAssets writes a Brotli-compressed copy of every compressible asset
+// under dir and returns how many it wrote.
+func compressAssets(dir string) (int, error) {
+	written := 0
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !containsFold(compressibleAssets, filepath.Ext(name)) {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		zw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(name+brotliSuffix, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		written++
+		return nil
+	})
+	return written, err
+}
+
+// precompressedAsset opens the .br copy of the asset at urlPath, if there is
+// an up-to-date one.
+func precompressedAsset(dir, urlPath string) (*os.File, fs.FileInfo, bool) {
+	name := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") {
+		name = path.Join(name, "index.html")
+	}
+	name = filepath.Join(dir, filepath.FromSlash(name))
+
+	original, err := os.Stat(name)
+	if err != nil || original.IsDir() {
+		return nil, nil, false
+	}
+	f, err := os.Open(name + brotliSuffix)
+	if err != nil {
+		return nil, nil, false
+	}
+	compressed, err := f.Stat()
+	if err != nil || compressed.ModTime().Before(original.ModTime()) {
+		f.Close()
+		return nil, nil, false
+	}
+	return f, compressed, true
+}
+
+// HTTP Handlers
+
+// staticHandler serves the files in dir, preferring precompressed copies.
+func staticHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "br") {
+			files.ServeHTTP(w, r)
+			return
+		}
+		f, info, ok := precompressedAsset(dir, r.URL.Path)
+		if !ok {
+			files.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		name := strings.TrimSuffix(info.Name(), brotliSuffix)
+		if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Content-Encoding", "br")
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Mapbox bills permanent and temporary geocoding separately, so requests are
+// counted per endpoint to keep an eye on quota.
+
+const (
+	geocodingPermanent = "permanent"
+	geocodingTemporary = "temporary"
+)
+
+type GeocodingStatus struct {
+	Mode     string         `json:"mode"`
+	Requests map[string]int `json:"requests"`
+	Since    time.Time      `json:"since"`
+
+	// Persisted totals across restarts, by local day and month
+	Today         int  `json:"today"`
+	ThisMonth     int  `json:"this_month"`
+	MonthlyBudget int  `json:"monthly_budget,omitempty"`
+	OverBudget    bool `json:"over_budget"`
+
+	// The in-memory geocode cache; see geocache.go
+	Cache GeocodeCacheStats `json:"cache"`
+}
+
+type StatusResponse struct {
+	Geocoding GeocodingStatus       `json:"geocoding"`
+	Fetches   map[string]FetchStats `json:"fetches"`
+	LastRun   *runMetrics           `json:"last_run,omitempty"`
+}
+
+// Global Variables
+var (
+	geocodeCounts = map[string]int{}
+	geocodeSince  = time.Now()
+	geocodeMutex  sync.Mutex
+)
+
+// Helper Functions
+
+// countGeocodeRequests records n billable geocodes. A batch request counts
+// once per address, matching how Mapbox bills it. Callers count requests
+// Mapbox accepted, not attempts.
+func countGeocodeRequests(mode string, n int) {
+	geocodeMutex.Lock()
+	defer geocodeMutex.Unlock()
+	geocodeCounts[mode] += n
+	recordGeocodeUsage(n)
+}
+
+func geocodingStatus() GeocodingStatus {
+	geocodeMutex.Lock()
+	defer geocodeMutex.Unlock()
+
+	requests := map[string]int{
+		geocodingPermanent: geocodeCounts[geocodingPermanent],
+		geocodingTemporary: geocodeCounts[geocodingTemporary],
+		matrixUsage:        geocodeCounts[matrixUsage],
+	}
+	cfg := getConfig()
+	today, thisMonth := currentUsage()
+	status := GeocodingStatus{
+		Mode:          cfg.GeocodingMode,
+		Requests:      requests,
+		Since:         geocodeSince,
+		Today:         today,
+		ThisMonth:     thisMonth,
+		MonthlyBudget: cfg.MonthlyGeocodeBudget,
+		Cache:         geocodeCache.snapshot(cfg.GeocodeCacheSize),
+	}
+	status.OverBudget = status.MonthlyBudget > 0 && status.ThisMonth >= status.MonthlyBudget
+	return status
+}
+
+// HTTP Handlers
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := StatusResponse{
+		Geocoding: geocodingStatus(),
+		Fetches:   fetchStatus(),
+		LastRun:   lastRunReport(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mapthens-server/pkg/archive"
+)
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so concurrent readers (including other server
+// processes sharing the cache directory) never observe a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return archive.WriteFileAtomic(path, data, perm)
+}
+
+// updateJSONFile changes a JSON state file under an exclusive lock on
+// path+".lock", so server processes sharing the cache directory don't
+// overwrite each other's changes. The file is read into v (a missing file
+// leaves v as it is), update changes v, and v is written back atomically.
+// When update fails the file is left as it was.
+func updateJSONFile(path string, v interface{}, update func() error) error {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := readJSONFile(path, v); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := update(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// Storage Formats
+//
+// Events are persisted either as a single indented JSON array ("json") or as
+// newline-delimited JSON with one event per line ("ndjson"). NDJSON can be
+// read as a stream and appended to without rewriting the whole file.
+
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+func validStorageFormat(format string) bool {
+	return format == formatJSON || format == formatNDJSON
+}
+
+// storageFormatFor picks the format from a file's extension, ignoring a
+// trailing ".gz".
+func storageFormatFor(path string) string {
+	ext := filepath.Ext(strings.TrimSuffix(path, gzipSuffix))
+	if strings.EqualFold(ext, ".ndjson") || strings.EqualFold(ext, ".jsonl") {
+		return formatNDJSON
+	}
+	return formatJSON
+}
+
+// Files named with a ".gz" suffix are stored gzip-compressed. Multi-day
+// snapshots repeat the same venues and descriptions, so they compress well.
+const gzipSuffix = ".gz"
+
+func isGzipped(path string) bool {
+	return strings.HasSuffix(path, gzipSuffix)
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeEvents(events []Event, format string) ([]byte, error) {
+	if format != formatNDJSON {
+		return json.MarshalIndent(events, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeEvents(r io.Reader, format string) ([]Event, error) {
+	var events []Event
+	if format != formatNDJSON {
+		if err := json.NewDecoder(r).Decode(&events); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("event %d: %v", len(events)+1, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func readEventsFile(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isGzipped(path) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return decodeEvents(r, storageFormatFor(path))
+}
+
+func writeEventsFile(path string, events []Event) error {
+	data, err := encodeEvents(events, storageFormatFor(path))
+	if err != nil {
+		return err
+	}
+	if isGzipped(path) {
+		if data, err = gzipBytes(data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// appendEventsFile adds events to the end of an NDJSON file without
+// rewriting it. JSON array files can't be appended to in place. Gzipped
+// files get a new gzip member, which readers decode as one stream.
+func appendEventsFile(path string, events []Event) error {
+	if storageFormatFor(path) != formatNDJSON {
+		return fmt.Errorf("cannot append to %s: not an NDJSON file", path)
+	}
+	data, err := encodeEvents(events, formatNDJSON)
+	if err != nil {
+		return err
+	}
+	if isGzipped(path) {
+		if data, err = gzipBytes(data); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// convertEventsFile rewrites the events in src into dst, converting between
+// formats and compression based on the file extensions.
+func convertEventsFile(src, dst string) error {
+	events, err := readEventsFile(src)
+	if err != nil {
+		return err
+	}
+	return writeEventsFile(dst, events)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts := map[string]int{}
+			err := updateJSONFile(path, &counts, func() error {
+				counts["requests"]++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	if err := readJSONFile(path, &counts); err != nil {
+		t.Fatal(err)
+	}
+	if counts["requests"] != 20 {
+		t.Errorf("requests = %d, want 20", counts["requests"])
+	}
+
+	// A failed update leaves the file as it was
+	failed := errors.New("invalid")
+	err := updateJSONFile(path, &counts, func() error {
+		counts["requests"] = 0
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("error = %v, want %v", err, failed)
+	}
+	counts = map[string]int{}
+	readJSONFile(path, &counts)
+	if counts["requests"] != 20 {
+		t.Errorf("requests after a failed update = %d, want 20", counts["requests"])
+	}
+
+	// A file that can't be parsed isn't overwritten
+	os.WriteFile(path, []byte("{"), 0644)
+	if err := updateJSONFile(path, &counts, func() error { return nil }); err == nil {
+		t.Error("updated a file that can't be parsed")
+	}
+}
+
+func TestFlushTracking(t *testing.T) {
+	at, _ := time.Parse(time.RFC3339, "2026-10-15T16:00:00Z")
+	withClock(t, at)
+	previousTrackFile, previousAnalyticsFile, previousStore := trackFile, analyticsFile, eventStore
+	t.Cleanup(func() {
+		trackFile, analyticsFile, eventStore = previousTrackFile, previousAnalyticsFile, previousStore
+		trackCounts, trackPending, dailyCounts = map[string]*EventStats{}, map[string]EventStats{}, map[string]map[string]*EventStats{}
+	})
+	dir := t.TempDir()
+	trackFile, analyticsFile, eventStore = filepath.Join(dir, "tracking.json"), filepath.Join(dir, "analytics.json"), memoryStore{}
+	trackCounts, trackPending, dailyCounts = map[string]*EventStats{}, map[string]EventStats{}, map[string]map[string]*EventStats{}
+
+	// Another process sharing the cache directory has saved its counts
+	os.WriteFile(trackFile, []byte(`{"show": {"popup_opens": 4, "link_clicks": 1}}`), 0644)
+	if err := (memoryStore{}).AddAnalytics("2026-10-15", map[string]EventStats{"show": {PopupOpens: 4, LinkClicks: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	recordTrack("show", "popup")
+	recordTrack("show", "click")
+	recordTrack("talk", "popup")
+	if err := flushTracking(); err != nil {
+		t.Fatal(err)
+	}
+	if err := flushAnalytics(); err != nil {
+		t.Fatal(err)
+	}
+	// Tracked while the flush was underway
+	recordTrack("talk", "popup")
+
+	want := map[string]EventStats{"show": {PopupOpens: 5, LinkClicks: 2}, "talk": {PopupOpens: 1}}
+	saved := map[string]EventStats{}
+	if err := readJSONFile(trackFile, &saved); err != nil {
+		t.Fatal(err)
+	}
+	daily, err := memoryStore{}.Analytics("2026-10-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stats := range want {
+		if saved[id] != stats {
+			t.Errorf("saved %s = %+v, want %+v", id, saved[id], stats)
+		}
+		if daily[id] != stats {
+			t.Errorf("analytics of %s = %+v, want %+v", id, daily[id], stats)
+		}
+	}
+	if stats := *trackCounts["talk"]; stats.PopupOpens != 2 {
+		t.Errorf("talk has %d popup opens in memory, want 2 counting the unsaved one", stats.PopupOpens)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventStore answers spatial queries over the events listed on a given day.
+// By default they're answered from the in-memory cache, which only holds
+// today, and Listed reads other days from the snapshots on disk. With MAPTHENS_DATABASE_URL set, every scrape is also archived to
+// Postgres and the queries run there (see postgres.go).
+
+// Data Structures
+
+type EventStore interface {
+	// SaveEvents records the events listed on day, replacing any saved
+	// earlier for that day.
+	SaveEvents(day string, events []Event, scrapedAt time.Time) error
+	// Within returns the events on day inside bbox (min lng, min lat, max
+	// lng, max lat).
+	Within(day string, bbox [4]float64) ([]Event, error)
+	// Nearby returns up to limit events on day, nearest first, with
+	// DistanceMeters set. A radius of 0 means no maximum distance.
+	Nearby(day string, origin coordinates, radius float64, limit int) ([]Event, error)
+	// Listed returns every event listed on each of days, keyed by day, in
+	// eventOrder. Days with no events map to an empty list.
+	Listed(days []string) (map[string][]Event, error)
+	// AddAnalytics adds tracking counts of events on day to the totals
+	// recorded for them.
+	AddAnalytics(day string, counts map[string]EventStats) error
+	// Analytics returns day's tracking counts by event ID.
+	Analytics(day string) (map[string]EventStats, error)
+	// EventAnalytics returns an event's tracking counts by day.
+	EventAnalytics(id string) (map[string]EventStats, error)
+	// SaveShortLinks records short codes and the event IDs they link to;
+	// see shortlink.go.
+	SaveShortLinks(links map[string]string) error
+	// ShortLinks returns the event IDs of the codes that are recorded.
+	ShortLinks(codes []string) (map[string]string, error)
+	// AddGeocodeUsage adds n billable Mapbox requests to day's total; see
+	// quota.go.
+	AddGeocodeUsage(day string, n int) error
+	// GeocodeUsage returns the recorded totals by day and by month.
+	GeocodeUsage() (GeocodeUsage, error)
+}
+
+type memoryStore struct{}
+
+// Global Variables
+var eventStore EventStore = memoryStore{}
+
+// Helper Functions
+
+func (memoryStore) SaveEvents(string, []Event, time.Time) error {
+	return nil
+}
+
+// Listed answers today from the cache, and other days from their daily
+// snapshot or monthly archive (see retention.go). Days with neither get the
+// multi-day events from today's listings that are still running on them.
+func (memoryStore) Listed(days []string) (map[string][]Event, error) {
+	index, err := loadArchiveIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string][]Event, len(days))
+	var running []string
+	for _, day := range days {
+		if day == today() {
+			running = append(running, day)
+			continue
+		}
+		events, ok, err := dayListing(day, index)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			running = append(running, day)
+			continue
+		}
+		// Archived events lose what the Parquet columns don't hold
+		events = normalizeEvents(events)
+		sortEvents(events)
+		listed[day] = events
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, day := range running {
+		listed[day] = []Event{}
+		for _, e := range eventsCache {
+			if e.StartDate <= day && day <= e.EndDate {
+				listed[day] = append(listed[day], e)
+			}
+		}
+	}
+	return listed, nil
+}
+
+func (memoryStore) Within(day string, bbox [4]float64) ([]Event, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return cacheIndex.within(day, bbox), nil
+}
+
+func (memoryStore) Nearby(day string, origin coordinates, radius float64, limit int) ([]Event, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return cacheIndex.nearby(day, origin, radius, limit), nil
+}
+
+// distanceMeters is the great-circle distance between two points.
+func distanceMeters(a, b coordinates) float64 {
+	const earthRadius = 6371008.8
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func parseBBox(value string) ([4]float64, error) {
+	var bbox [4]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return bbox, fmt.Errorf("expected min lng,min lat,max lng,max lat")
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox, fmt.Errorf("invalid value %q", part)
+		}
+		bbox[i] = v
+	}
+	if bbox[0] > bbox[2] || bbox[1] > bbox[3] {
+		return bbox, fmt.Errorf("expected min lng,min lat,max lng,max lat")
+	}
+	return bbox, nil
+}
+
+// eventBounds returns the bounding box and mean position of the events with
+// coordinates, or nils when none have any.
+func eventBounds(events []Event) (*[4]float64, *[2]float64) {
+	var bounds [4]float64
+	var sumLng, sumLat float64
+	n := 0
+	for _, e := range events {
+		if e.Latitude == 0 && e.Longitude == 0 {
+			continue
+		}
+		if n == 0 {
+			bounds = [4]float64{e.Longitude, e.Latitude, e.Longitude, e.Latitude}
+		}
+		bounds[0], bounds[1] = min(bounds[0], e.Longitude), min(bounds[1], e.Latitude)
+		bounds[2], bounds[3] = max(bounds[2], e.Longitude), max(bounds[3], e.Latitude)
+		sumLng += e.Longitude
+		sumLat += e.Latitude
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	centroid := [2]float64{sumLng / float64(n), sumLat / float64(n)}
+	return &bounds, &centroid
+}
+
+// HTTP Handlers
+
+// nearbyHandler answers either ?bbox=minLng,minLat,maxLng,maxLat or
+// ?from=lat,lng with optional radius (meters) and limit, for ?date= (default
+// today). Dates other than today need the Postgres store.
+func nearbyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	day := query.Get("date")
+	if day == "" {
+		day = today()
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "Invalid date parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure today's events have been scraped and saved
+	_, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var events []Event
+	order := eventOrder
+	if value := query.Get("bbox"); value != "" {
+		bbox, err := parseBBox(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid bbox parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		events, err = eventStore.Within(day, bbox)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error querying events: %v", err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		origin, err := parseOrigin(query.Get("from"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid from parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		radius, limit := 0.0, 0
+		if value := query.Get("radius"); value != "" {
+			if radius, err = strconv.ParseFloat(value, 64); err != nil || radius < 0 {
+				http.Error(w, "Invalid radius parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit < 0 || limit > maxQueryLimit {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		events, err = eventStore.Nearby(day, origin, radius, limit)
+		order = "distance"
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error querying events: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeEventsResponse(w, r, events, len(events), order, info)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anyone can submit an event with POST /api/submissions. Each submission is
+// moderated (see moderation.go) and, once approved, listed alongside the
+// scraped events on the days it runs. Submissions are kept in
+// submissions.json in the cache directory until recentRetention days after
+// they end. Fields containing HTML tags are refused, since approved
+// submissions are published without anyone reading them, and each address
+// may submit submissionRateLimit events per submissionRateWindow.
+
+const (
+	submissionPending  = "pending"
+	submissionApproved = "approved"
+	submissionRejected = "rejected"
+	sourceSubmission   = "submission"
+
+	submissionRateLimit  = 5
+	submissionRateWindow = time.Hour
+)
+
+// Data Structures
+
+type Submission struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	EventLink   string     `json:"event_link,omitempty"`
+	Venue       string     `json:"venue"`
+	Address     string     `json:"address,omitempty"`
+	Description string     `json:"description,omitempty"`
+	// Contact is how to reach the submitter; only admins see it
+	Contact string `json:"contact,omitempty"`
+
+	Status      string           `json:"status"`
+	Moderation  ModerationResult `json:"moderation"`
+	SubmittedAt time.Time        `json:"submitted_at"`
+	ReviewedBy  string           `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time       `json:"reviewed_at,omitempty"`
+	// Geocoded when the submission is approved
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+type submissionReceipt struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type submissionReview struct {
+	Status string `json:"status"`
+}
+
+// submissionQuota counts an address's submissions in the window ending at
+// resetAt.
+type submissionQuota struct {
+	count   int
+	resetAt time.Time
+}
+
+// Global Variables
+var (
+	submissions      = map[string]Submission{}
+	submissionsMutex sync.RWMutex
+	submissionsFile  = "submissions.json"
+
+	submissionQuotas      = map[string]submissionQuota{}
+	submissionQuotasMutex sync.Mutex
+
+	// markupPattern matches the start of an HTML tag, comment, or
+	// declaration
+	markupPattern = regexp.MustCompile(`<[A-Za-z/!?]`)
+)
+
+// Helper Functions
+
+// validate checks the fields a submitter fills in, reporting errors at
+// paths under path.
+func (s Submission) validate(path string) fieldErrors {
+	var errs fieldErrors
+	if strings.TrimSpace(s.Title) == "" {
+		errs.add(pointer(path, "title"), "is required")
+	}
+	if strings.TrimSpace(s.Venue) == "" {
+		errs.add(pointer(path, "venue"), "is required")
+	}
+	if s.StartsAt.IsZero() {
+		errs.add(pointer(path, "starts_at"), "is required")
+	}
+	if s.EndsAt != nil && s.EndsAt.Before(s.StartsAt) {
+		errs.add(pointer(path, "ends_at"), "is before starts_at")
+	}
+	checkLength(&errs, pointer(path, "title"), s.Title, 200)
+	checkLength(&errs, pointer(path, "venue"), s.Venue, 200)
+	checkLength(&errs, pointer(path, "address"), s.Address, 300)
+	checkLength(&errs, pointer(path, "description"), s.Description, 5000)
+	for _, field := range []struct{ name, value string }{
+		{"title", s.Title}, {"category", s.Category}, {"venue", s.Venue}, {"address", s.Address}, {"description", s.Description},
+	} {
+		if markupPattern.MatchString(field.value) {
+			errs.add(pointer(path, field.name), "must not contain HTML")
+		}
+	}
+	if s.EventLink != "" {
+		if u, err := url.Parse(s.EventLink); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.ContainsAny(s.EventLink, "<>\"' ") {
+			errs.add(pointer(path, "event_link"), "must be an http(s) URL")
+		}
+	}
+	return errs
+}
+
+func (s Submission) toEvent() Event {
+	loc := getConfig().Location
+	start, end := s.StartsAt.In(loc), s.StartsAt.In(loc)
+	if s.EndsAt != nil {
+		end = s.EndsAt.In(loc)
+	}
+	e := Event{
+		ID:          s.ID,
+		Date:        start.Format("2006-01-02"),
+		StartDate:   start.Format("2006-01-02"),
+		EndDate:     end.Format("2006-01-02"),
+		Datetime:    tribeDatetime(start, end, false),
+		Category:    s.Category,
+		Title:       s.Title,
+		EventLink:   s.EventLink,
+		Venue:       s.Venue,
+		Address:     s.Address,
+		Description: s.Description,
+		Latitude:    s.Latitude,
+		Longitude:   s.Longitude,
+		SourceName:  sourceSubmission,
+		ScrapedAt:   s.SubmittedAt,
+	}
+	if s.ReviewedAt != nil {
+		e.ScrapedAt = *s.ReviewedAt
+	}
+	if e.Latitude != 0 || e.Longitude != 0 {
+		e.GeocodeProvider = geocodeMapbox
+	}
+	return e
+}
+
+// withSubmissions adds the approved submissions running on day to events.
+func withSubmissions(events []Event, day string) []Event {
+	submissionsMutex.RLock()
+	defer submissionsMutex.RUnlock()
+
+	combined := make([]Event, len(events), len(events)+len(submissions))
+	copy(combined, events)
+	for _, s := range submissions {
+		if s.Status != submissionApproved {
+			continue
+		}
+		if e := s.toEvent(); e.StartDate <= day && e.EndDate >= day {
+			combined = append(combined, e)
+		}
+	}
+	return combined
+}
+
+// geocodeSubmission fills in an approved submission's coordinates, unless
+// the gazetteer already knows its venue.
+func geocodeSubmission(s *Submission) {
+	s.Latitude, s.Longitude = geocodeVenueAddress(s.Venue, s.Address)
+}
+
+// geocodeVenueAddress geocodes the address of an event that isn't at a
+// gazetteer venue with coordinates, answering zeros when it can't.
+func geocodeVenueAddress(venue, address string) (float64, float64) {
+	if address == "" || !tokenUsable() {
+		return 0, 0
+	}
+	if info, ok := lookupVenue(venue); ok && info.hasCoordinates() {
+		return 0, 0
+	}
+	if cached, missing := cachedGeocodes([]string{address}); len(missing) == 0 {
+		return cached[address].Latitude, cached[address].Longitude
+	}
+	if remaining, limited := geocodeBudgetRemaining(); limited && remaining == 0 {
+		return 0, 0
+	}
+	c, ok := geocodeAddresses(context.Background(), []string{address})[address]
+	if err := saveGeocodeUsage(); err != nil {
+		log.Printf("Warning: Failed to save geocoding usage: %v", err)
+	}
+	if !ok {
+		return 0, 0
+	}
+	return c.Latitude, c.Longitude
+}
+
+func loadSubmissions() {
+	data, err := os.ReadFile(submissionsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read submissions file: %v", err)
+		}
+		return
+	}
+
+	loaded := map[string]Submission{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("Warning: Failed to parse submissions file: %v", err)
+		return
+	}
+
+	submissionsMutex.Lock()
+	submissions = loaded
+	submissionsMutex.Unlock()
+}
+
+// saveSubmission stores s, dropping submissions that ended long enough ago
+// that they won't be listed again. The file is re-read under its lock, so
+// submissions saved by other processes sharing the cache directory are
+// kept, and written before the change is made in memory, so a failed save
+// changes nothing.
+func saveSubmission(s Submission) error {
+	submissionsMutex.Lock()
+	defer submissionsMutex.Unlock()
+
+	cutoff := localNow().AddDate(0, 0, -recentRetention).Format("2006-01-02")
+	saved := map[string]Submission{}
+	err := updateJSONFile(submissionsFile, &saved, func() error {
+		for id, existing := range saved {
+			if existing.toEvent().EndDate < cutoff {
+				delete(saved, id)
+			}
+		}
+		saved[s.ID] = s
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	submissions = saved
+	return nil
+}
+
+// allowSubmission counts a submission from r's address, answering false
+// and how long until it may submit again once it's over
+// submissionRateLimit.
+func allowSubmission(r *http.Request) (bool, time.Duration) {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	at := now()
+
+	submissionQuotasMutex.Lock()
+	defer submissionQuotasMutex.Unlock()
+	for a, q := range submissionQuotas {
+		if !at.Before(q.resetAt) {
+			delete(submissionQuotas, a)
+		}
+	}
+	q, ok := submissionQuotas[addr]
+	if !ok {
+		q.resetAt = at.Add(submissionRateWindow)
+	}
+	if q.count >= submissionRateLimit {
+		return false, q.resetAt.Sub(at)
+	}
+	q.count++
+	submissionQuotas[addr] = q
+	return true, 0
+}
+
+func getSubmission(id string) (Submission, bool) {
+	submissionsMutex.RLock()
+	defer submissionsMutex.RUnlock()
+	s, ok := submissions[id]
+	return s, ok
+}
+
+// HTTP Handlers
+
+// submitHandler serves POST /api/submissions, e.g.
+//
+//	{"title": "Porch Show", "starts_at": "2026-10-16T19:00:00-04:00",
+//	 "venue": "Boulevard", "address": "Boulevard, Athens, GA"}
+//
+// and answers with the submission's ID and moderation status.
+func submitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ok, wait := allowSubmission(r); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many submissions; try again later", http.StatusTooManyRequests)
+		return
+	}
+	var s Submission
+	if !decodeValidated(w, r, &s, "Invalid submission") {
+		return
+	}
+	if errs := s.validate(""); len(errs) > 0 {
+		writeValidationErrors(w, "Invalid submission", errs)
+		return
+	}
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, "Error creating submission", http.StatusInternalServerError)
+		return
+	}
+	s.ID = "s" + id[:11]
+	s.SubmittedAt = now()
+	s.ReviewedBy, s.ReviewedAt = "", nil
+	s.Latitude, s.Longitude = 0, 0
+	s.Moderation, s.Status = moderate(s)
+	if s.Status == submissionApproved {
+		geocodeSubmission(&s)
+	}
+
+	if err := saveSubmission(s); err != nil {
+		http.Error(w, fmt.Sprintf("Error saving submission: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Submission %s %q is %s (score %.2f).", s.ID, s.Title, s.Status, s.Moderation.Score)
+	if s.Status == submissionApproved {
+		renormalizeCache()
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(submissionReceipt{ID: s.ID, Status: s.Status})
+}
+
+// submissionsHandler serves the review queue:
+//
+//	GET  /api/admin/submissions?status=  list submissions (default pending)
+//	POST /api/admin/submissions/{id}     approve or reject one, e.g.
+//	                                     {"status": "approved"}
+//
+// Reviews need an OIDC token or an X-Editor header naming the reviewer.
+func submissionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/admin/submissions"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = submissionPending
+		}
+		if status != submissionPending && status != submissionApproved && status != submissionRejected {
+			http.Error(w, "Invalid status parameter: must be pending, approved, or rejected", http.StatusBadRequest)
+			return
+		}
+
+		list := []Submission{}
+		submissionsMutex.RLock()
+		for _, s := range submissions {
+			if s.Status == status {
+				list = append(list, s)
+			}
+		}
+		submissionsMutex.RUnlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].SubmittedAt.Before(list[j].SubmittedAt) })
+		writeJSON(w, list)
+
+	case id != "" && r.Method == http.MethodPost:
+		editor := adminEditor(r)
+		if editor == "" {
+			http.Error(w, "Missing X-Editor header", http.StatusBadRequest)
+			return
+		}
+		s, ok := getSubmission(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var review submissionReview
+		if !decodeJSONBody(w, r, &review) {
+			return
+		}
+		if review.Status != submissionApproved && review.Status != submissionRejected {
+			http.Error(w, "Invalid status: must be approved or rejected", http.StatusBadRequest)
+			return
+		}
+
+		reviewedAt := now()
+		s.Status, s.ReviewedBy, s.ReviewedAt = review.Status, editor, &reviewedAt
+		if s.Status == submissionApproved && s.Latitude == 0 && s.Longitude == 0 {
+			geocodeSubmission(&s)
+		}
+		if err := saveSubmission(s); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving submission: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Submission %s %s by %s.", s.ID, s.Status, editor)
+		renormalizeCache()
+		writeJSON(w, s)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Data Structures
+
+// EventSummary counts a day's events for the "tonight at a glance" panel.
+// Hours are keyed by the two-digit local start hour ("19"), with events
+// that have no clock time under "all_day".
+type EventSummary struct {
+	Date       string         `json:"date"`
+	Total      int            `json:"total"`
+	Categories map[string]int `json:"categories"`
+	Venues     map[string]int `json:"venues"`
+	Hours      map[string]int `json:"hours"`
+}
+
+// Helper Functions
+
+func summarizeEvents(events []Event, date string) EventSummary {
+	summary := EventSummary{
+		Date:       date,
+		Categories: map[string]int{},
+		Venues:     map[string]int{},
+		Hours:      map[string]int{},
+	}
+	for _, e := range events {
+		if date < e.StartDate || date > e.EndDate {
+			continue
+		}
+		summary.Total++
+		summary.Categories[e.Category]++
+		summary.Venues[e.Venue]++
+
+		start, _, allDay, err := eventTimes(e)
+		switch {
+		case err != nil:
+		case allDay:
+			summary.Hours["all_day"]++
+		default:
+			summary.Hours[start.Format("15")]++
+		}
+	}
+	return summary
+}
+
+// HTTP Handlers
+
+func summaryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = today()
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, "Invalid date parameter", http.StatusBadRequest)
+		return
+	}
+
+	var events []Event
+	if date == today() {
+		var err error
+		if events, err = getEvents(); err != nil {
+			http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// Other days are read from the store, like ?dates= in batch.go
+		listed, err := eventStore.Listed([]string{date})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error querying events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		events = listed[date]
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, summarizeEvents(events, date))
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Ticket checks. Ticketed events link to Eventbrite, Freshtix, or See
+// Tickets, either directly or from their flagpole page. Those ticketing
+// pages are read for price tiers, the on-sale date, and whether the event
+// has sold out, on their own schedule (MAPTHENS_TICKETS_INTERVAL) rather
+// than with the full scrape, since sell-outs happen between scrapes. The
+// schema.org offers the ticketing sites embed are read first; their page
+// text is the fallback.
+
+// Data Structures
+
+type Tickets struct {
+	URL      string      `json:"url"`
+	Provider string      `json:"provider"`
+	OnSaleAt *time.Time  `json:"on_sale_at,omitempty"`
+	Tiers    []PriceTier `json:"tiers,omitempty"`
+	SoldOut  bool        `json:"sold_out"`
+	// CheckedAt is when the ticketing page was last read
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type PriceTier struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+type ticketProvider struct {
+	name  string
+	hosts []string // matched with their subdomains
+}
+
+// Global Variables
+var (
+	ticketProviders = []ticketProvider{
+		{"eventbrite", []string{"eventbrite.com"}},
+		{"freshtix", []string{"freshtix.com"}},
+		{"seetickets", []string{"seetickets.us", "seetickets.com"}},
+	}
+
+	soldOutPattern  = regexp.MustCompile(`(?i)\bsold[ -]?out\b`)
+	onSalePattern   = regexp.MustCompile(`(?i)\b(?:on sale|sales start)(?:s)?(?:\s+(?:on|at|date))?:?\s+((?:[a-z]+,?\s+)?[a-z]+\.?\s+\d{1,2}(?:,?\s+\d{4})?(?:,?\s+(?:at\s+)?\d{1,2}(?::\d{2})?\s*[ap]\.?m\.?)?)`)
+	yearPattern     = regexp.MustCompile(`\d{4}`)
+	dayPattern      = regexp.MustCompile(`\d{1,2}`)
+	meridiemPattern = regexp.MustCompile(`(\d)([ap]m)\b`)
+	onSaleLayouts   = []string{
+		"Monday, January 2, 2006 3:04 pm", "Monday, January 2, 2006 3 pm", "Monday, January 2, 2006",
+		"Mon, Jan 2, 2006 3:04 pm", "Mon, Jan 2, 2006 3 pm", "Mon, Jan 2, 2006",
+		"January 2, 2006 3:04 pm", "January 2, 2006 3 pm", "January 2, 2006",
+		"Jan 2, 2006 3:04 pm", "Jan 2, 2006 3 pm", "Jan 2, 2006",
+	}
+
+	// ticketLinks maps event links to the ticketing page found on them, or
+	// "" when there's none; ticketResults maps event links to the last
+	// successful read of their ticketing page
+	ticketLinks   = map[string]string{}
+	ticketResults = map[string]Tickets{}
+	ticketMutex   sync.RWMutex
+)
+
+// Helper Functions
+
+// ticketProviderFor names the ticketing site link points to, or "".
+func ticketProviderFor(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range ticketProviders {
+		for _, h := range p.hosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return p.name
+			}
+		}
+	}
+	return ""
+}
+
+// discoverTicketLink returns the first ticketing link on an event's page.
+func discoverTicketLink(eventLink string) string {
+	doc, err := fetchDocument(context.Background(), eventLink)
+	if err != nil {
+		log.Printf("Warning: Could not look for tickets on %s: %v", eventLink, err)
+		return ""
+	}
+	found := ""
+	doc.Find("a[href]").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href, _ := a.Attr("href")
+		if ticketProviderFor(href) != "" {
+			found = href
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// ldObjects returns the JSON-LD objects embedded in doc, flattening arrays
+// and @graph lists.
+func ldObjects(doc *goquery.Document) []map[string]interface{} {
+	var objects []map[string]interface{}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			objects = append(objects, v)
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			}
+		}
+	}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, script *goquery.Selection) {
+		var v interface{}
+		if json.Unmarshal([]byte(script.Text()), &v) == nil {
+			collect(v)
+		}
+	})
+	return objects
+}
+
+func ldString(v interface{}) string {
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
+
+// ldOffers lists an object's offers, expanding AggregateOffers into the
+// offers they hold, or into their low and high prices when they hold none.
+func ldOffers(v interface{}) []map[string]interface{} {
+	var offers []map[string]interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			offers = append(offers, ldOffers(item)...)
+		}
+	case map[string]interface{}:
+		if ldString(v["@type"]) != "AggregateOffer" {
+			return []map[string]interface{}{v}
+		}
+		if nested := ldOffers(v["offers"]); len(nested) > 0 {
+			return nested
+		}
+		for _, bound := range []struct{ key, name string }{{"lowPrice", "Lowest price"}, {"highPrice", "Highest price"}} {
+			if _, ok := v[bound.key]; ok {
+				offers = append(offers, map[string]interface{}{
+					"name":          bound.name,
+					"price":         v[bound.key],
+					"priceCurrency": v["priceCurrency"],
+					"availability":  v["availability"],
+					"validFrom":     v["validFrom"],
+				})
+			}
+		}
+	}
+	return offers
+}
+
+// parseTicketPage reads the price tiers, on-sale date, and sold-out status
+// from a ticketing page.
+func parseTicketPage(doc *goquery.Document, loc *time.Location) Tickets {
+	var t Tickets
+	var offers []map[string]interface{}
+	for _, obj := range ldObjects(doc) {
+		if o, ok := obj["offers"]; ok {
+			offers = append(offers, ldOffers(o)...)
+		}
+	}
+
+	seen := map[PriceTier]bool{}
+	soldOut := 0
+	for _, o := range offers {
+		if price, ok := jsonFloat(o["price"]); ok {
+			tier := PriceTier{Name: ldString(o["name"]), Price: price, Currency: ldString(o["priceCurrency"])}
+			if tier.Name == "" {
+				tier.Name = "General Admission"
+			}
+			if !seen[tier] {
+				seen[tier] = true
+				t.Tiers = append(t.Tiers, tier)
+			}
+		}
+		if strings.HasSuffix(ldString(o["availability"]), "SoldOut") {
+			soldOut++
+		}
+		if from, err := time.Parse(time.RFC3339, ldString(o["validFrom"])); err == nil {
+			if t.OnSaleAt == nil || from.Before(*t.OnSaleAt) {
+				t.OnSaleAt = &from
+			}
+		}
+	}
+	sort.SliceStable(t.Tiers, func(i, j int) bool { return t.Tiers[i].Price < t.Tiers[j].Price })
+	if len(offers) > 0 {
+		t.SoldOut = soldOut == len(offers)
+	}
+
+	// Fall back to the page text for whatever the offers didn't say
+	text := pageText(doc)
+	if len(offers) == 0 {
+		t.SoldOut = soldOutPattern.MatchString(text)
+	}
+	if t.OnSaleAt == nil {
+		if match := onSalePattern.FindStringSubmatch(text); match != nil {
+			t.OnSaleAt = parseOnSaleDate(match[1], loc)
+		}
+	}
+	return t
+}
+
+// pageText returns the visible text of doc's body with each text node's
+// whitespace collapsed, and a space between nodes, so "Sold Out" in one
+// element doesn't run into the next.
+func pageText(doc *goquery.Document) string {
+	var parts []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+		if n.Type == html.TextNode {
+			if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Find("body").Nodes {
+		walk(n)
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseOnSaleDate parses dates like "Friday, October 17, 2026 10:00 am" or
+// "Oct 17". Without a year the next such date is assumed.
+func parseOnSaleDate(text string, loc *time.Location) *time.Time {
+	text = strings.NewReplacer(".", "", " at ", " ").Replace(strings.ToLower(strings.TrimSpace(text)))
+	text = strings.Join(strings.Fields(meridiemPattern.ReplaceAllString(text, "$1 $2")), " ")
+	noYear := !yearPattern.MatchString(text)
+	if noYear {
+		// Put the year where the layouts expect it, after the day
+		year := localNow().Format("2006")
+		if day := dayPattern.FindStringIndex(text); day != nil {
+			text = text[:day[1]] + ", " + year + text[day[1]:]
+		}
+	}
+	for _, layout := range onSaleLayouts {
+		at, err := time.ParseInLocation(layout, text, loc)
+		if err != nil {
+			continue
+		}
+		if noYear && at.Before(localNow().AddDate(0, -1, 0)) {
+			at = at.AddDate(1, 0, 0)
+		}
+		return &at
+	}
+	return nil
+}
+
+// readTickets fetches and parses a ticketing page, returning nil when it
+// can't be read.
+func readTickets(ticketURL string) *Tickets {
+	doc, err := fetchDocument(context.Background(), ticketURL)
+	if err != nil {
+		log.Printf("Warning: Could not check tickets at %s: %v", ticketURL, err)
+		return nil
+	}
+	t := parseTicketPage(doc, getConfig().Location)
+	t.URL, t.Provider, t.CheckedAt = ticketURL, ticketProviderFor(ticketURL), now()
+	return &t
+}
+
+func ticketsFor(eventLink string) *Tickets {
+	ticketMutex.RLock()
+	defer ticketMutex.RUnlock()
+	if t, ok := ticketResults[eventLink]; ok {
+		return &t
+	}
+	return nil
+}
+
+// checkEventTickets reads the ticketing page of every cached event that has
+// one and re-applies the results to the cache. Event pages are only searched
+// for ticketing links the first time an event is seen.
+func checkEventTickets() {
+	mutex.RLock()
+	seen := map[string]bool{}
+	var eventLinks []string
+	for _, e := range scrapedEvents {
+		if e.EventLink != "" && !seen[e.EventLink] {
+			seen[e.EventLink] = true
+			eventLinks = append(eventLinks, e.EventLink)
+		}
+	}
+	mutex.RUnlock()
+
+	links := make(map[string]string, len(eventLinks))
+	var undiscovered []string
+	ticketMutex.RLock()
+	for _, link := range eventLinks {
+		if ticketProviderFor(link) != "" {
+			links[link] = link
+		} else if ticketLink, ok := ticketLinks[link]; ok {
+			links[link] = ticketLink
+		} else {
+			undiscovered = append(undiscovered, link)
+		}
+	}
+	ticketMutex.RUnlock()
+	if !flagEnabled(flagDetailEnrichment) {
+		undiscovered = nil
+	}
+	for link, ticketLink := range fetchDetails(context.Background(), undiscovered, discoverTicketLink) {
+		links[link] = ticketLink
+	}
+
+	var ticketURLs []string
+	queued := map[string]bool{}
+	for _, ticketLink := range links {
+		if ticketLink != "" && !queued[ticketLink] {
+			queued[ticketLink] = true
+			ticketURLs = append(ticketURLs, ticketLink)
+		}
+	}
+	read := fetchDetails(context.Background(), ticketURLs, readTickets)
+
+	ticketMutex.Lock()
+	results := make(map[string]Tickets, len(links))
+	soldOut := 0
+	for link, ticketLink := range links {
+		if ticketLink == "" {
+			continue
+		}
+		if t := read[ticketLink]; t != nil {
+			results[link] = *t
+		} else if previous, ok := ticketResults[link]; ok {
+			// Keep the last reading rather than dropping it
+			results[link] = previous
+		} else {
+			continue
+		}
+		if results[link].SoldOut {
+			soldOut++
+		}
+	}
+	ticketLinks, ticketResults = links, results
+	ticketMutex.Unlock()
+
+	renormalizeCache()
+	log.Printf("Checked tickets for %d events, %d sold out.", len(results), soldOut)
+}
+
+func checkTicketsPeriodically() {
+	// Give the cache a moment to load before the first pass
+	time.Sleep(time.Minute)
+	for {
+		interval := getConfig().TicketCheckInterval
+		if interval == 0 {
+			// Disabled; look again later in case the config is reloaded
+			time.Sleep(time.Minute)
+			continue
+		}
+		checkEventTickets()
+		time.Sleep(interval)
+	}
+}
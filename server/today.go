@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// GET /today is a plain HTML listing of today's events for browsers that
+// can't run the map: old phones, screen readers, and anyone with JavaScript
+// off. It's rendered on the server from the cached events with no scripts
+// and little styling. Events are grouped by time of day, or by category with
+// ?group=category, and ?category= narrows the list as it does for the
+// embeddable widgets. Each title links to the event's share page.
+
+// Data Structures
+
+type todayGroup struct {
+	Name   string
+	Events []todayEvent
+}
+
+type todayEvent struct {
+	Event
+	Page string
+}
+
+type todayPage struct {
+	Date       string
+	UpdatedAt  string
+	ByCategory bool
+	Categories []string
+	Selected   map[string]bool
+	Groups     []todayGroup
+	Total      int
+}
+
+// Global Variables
+
+// todayPeriods are the time-of-day groups, in order, by the hour they start.
+var todayPeriods = []struct {
+	name string
+	from int
+}{
+	{"Morning", 0},
+	{"Afternoon", 12},
+	{"Evening", 17},
+	{"Late night", 21},
+}
+
+var todayTemplate = template.Must(template.New("today").Funcs(template.FuncMap{
+	"categoryURL": func(category string, byCategory bool) string {
+		query := url.Values{}
+		if category != "" {
+			query.Set("category", category)
+		}
+		if byCategory {
+			query.Set("group", "category")
+		}
+		if len(query) == 0 {
+			return "/today"
+		}
+		return "/today?" + query.Encode()
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Today in Athens, {{.Date}} | Mapthens</title>
+<meta name="description" content="{{.Total}} events happening in Athens, GA today">
+<style>
+  body { margin: 0 auto; max-width: 40em; padding: 12px; font-family: sans-serif; line-height: 1.4; background: #2f2f2f; color: #e0e0e0; }
+  h1 { font-size: 1.4rem; margin: 0 0 4px; }
+  h2 { font-size: 1.1rem; margin: 20px 0 4px; border-bottom: 1px solid #555; }
+  .event { padding: 8px 0; border-bottom: 1px solid #444; }
+  .event h3 { margin: 0; font-size: 1rem; }
+  .event p { margin: 2px 0; color: #b0b0b0; font-size: 0.9rem; }
+  nav p { margin: 4px 0; font-size: 0.9rem; }
+  a { color: #5dade2; }
+  .selected { font-weight: bold; color: #e0e0e0; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Today in Athens</h1>
+<p>{{.Date}} &middot; {{.Total}} event{{if ne .Total 1}}s{{end}}{{if .UpdatedAt}} &middot; updated {{.UpdatedAt}}{{end}} &middot; <a href="/">Map</a></p>
+<nav>
+<p>Group by:
+{{- if .ByCategory}} <a href="/today">time</a> &middot; <span class="selected">category</span>
+{{- else}} <span class="selected">time</span> &middot; <a href="/today?group=category">category</a>{{end}}</p>
+{{- if .Categories}}
+<p>Show: <a href="{{categoryURL "" .ByCategory}}"{{if not .Selected}} class="selected"{{end}}>all</a>
+{{- range .Categories}} &middot; <a href="{{categoryURL . $.ByCategory}}"{{if index $.Selected .}} class="selected"{{end}}>{{.}}</a>{{end}}</p>
+{{- end}}
+</nav>
+{{- range .Groups}}
+<h2>{{.Name}}</h2>
+{{- range .Events}}
+<div class="event">
+  <h3><a href="{{.Page}}">{{.Title}}</a></h3>
+  <p>{{.Datetime}} &middot; {{.Venue}}{{if .Address}}, {{.Address}}{{end}}</p>
+  {{- if .Category}}<p>{{.Category}}</p>{{end}}
+  {{- if .Description}}<p>{{.Description}}</p>{{end}}
+  {{- if .EventLink}}<p><a href="{{.EventLink}}" rel="noopener">More info</a></p>{{end}}
+</div>
+{{- end}}
+{{- else}}
+<p>No events found for today.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// Helper Functions
+
+// todayPeriod names the part of the day an event starts in.
+func todayPeriod(e Event) string {
+	start, _, allDay, err := eventTimes(e)
+	if err != nil || allDay {
+		return "All day"
+	}
+	// Multi-day events that started before today run all day today
+	if start.Format("2006-01-02") < today() {
+		return "All day"
+	}
+	name := todayPeriods[0].name
+	for _, p := range todayPeriods {
+		if start.Hour() >= p.from {
+			name = p.name
+		}
+	}
+	return name
+}
+
+// groupTodayEvents groups events, already in start time order, by time of
+// day or by category.
+func groupTodayEvents(events []Event, byCategory bool) []todayGroup {
+	limit := getConfig().DescriptionLimit
+	groups := map[string][]todayEvent{}
+	for _, e := range events {
+		name := todayPeriod(e)
+		if byCategory {
+			name = e.Category
+			if name == "" {
+				name = "Other"
+			}
+		}
+		e.Description, _ = truncateDescription(e.Description, limit)
+		groups[name] = append(groups[name], todayEvent{Event: e, Page: "/events/" + url.PathEscape(e.ID)})
+	}
+
+	var names []string
+	if byCategory {
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		names = append(names, "All day")
+		for _, p := range todayPeriods {
+			names = append(names, p.name)
+		}
+	}
+	result := []todayGroup{}
+	for _, name := range names {
+		if len(groups[name]) > 0 {
+			result = append(result, todayGroup{Name: name, Events: groups[name]})
+		}
+	}
+	return result
+}
+
+// HTTP Handlers
+
+func todayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := r.URL.Query().Get("group")
+	if group != "" && group != "time" && group != "category" {
+		http.Error(w, "Invalid group parameter: must be time or category", http.StatusBadRequest)
+		return
+	}
+
+	events, info, err := getEventsWithInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	seen := map[string]bool{}
+	var categories []string
+	for _, e := range events {
+		if e.Category != "" && !seen[e.Category] {
+			seen[e.Category] = true
+			categories = append(categories, e.Category)
+		}
+	}
+	sort.Strings(categories)
+
+	selected := parseCategories(r)
+	events = filterByCategory(events, selected)
+	page := todayPage{
+		Date:       localNow().Format("Monday, January 2"),
+		ByCategory: group == "category",
+		Categories: categories,
+		Groups:     groupTodayEvents(events, group == "category"),
+		Total:      len(events),
+	}
+	if len(selected) > 0 {
+		page.Selected = map[string]bool{}
+		for _, c := range selected {
+			for _, known := range categories {
+				if strings.EqualFold(known, c) {
+					page.Selected[known] = true
+				}
+			}
+		}
+	}
+	if !info.ScrapedAt.IsZero() {
+		page.UpdatedAt = info.ScrapedAt.In(getConfig().Location).Format("3:04 pm")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	todayTemplate.Execute(w, page)
+}
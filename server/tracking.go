@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Data Structures
+
+type EventStats struct {
+	PopupOpens int `json:"popup_opens"`
+	LinkClicks int `json:"link_clicks"`
+}
+
+type TrackRequest struct {
+	EventID string `json:"event_id"`
+	Action  string `json:"action"`
+}
+
+type PopularEvent struct {
+	EventID string `json:"event_id"`
+	Title   string `json:"title"`
+	EventStats
+	Score int `json:"score"`
+}
+
+// Global Variables
+var (
+	// trackCounts holds the saved totals as last read plus trackPending, the
+	// actions tracked since that haven't been saved yet
+	trackCounts   = map[string]*EventStats{}
+	trackPending  = map[string]EventStats{}
+	trackMutex    sync.Mutex
+	trackFile     = "tracking.json"
+	trackInterval = time.Minute
+)
+
+// Helper Functions
+
+func recordTrack(id, action string) bool {
+	if action != "popup" && action != "click" {
+		return false
+	}
+	trackMutex.Lock()
+	defer trackMutex.Unlock()
+
+	stats, ok := trackCounts[id]
+	if !ok {
+		stats = &EventStats{}
+		trackCounts[id] = stats
+	}
+
+	pending := trackPending[id]
+	switch action {
+	case "popup":
+		stats.PopupOpens++
+		pending.PopupOpens++
+	case "click":
+		stats.LinkClicks++
+		pending.LinkClicks++
+	}
+	trackPending[id] = pending
+	countDaily(id, action)
+	return true
+}
+
+func (s *EventStats) add(other EventStats) {
+	s.PopupOpens += other.PopupOpens
+	s.LinkClicks += other.LinkClicks
+}
+
+// popularityScore weights a click-through higher than a popup open, since
+// following the link is a stronger signal of interest.
+func popularityScore(s EventStats) int {
+	return s.PopupOpens + 3*s.LinkClicks
+}
+
+func loadTrackingFromFile() {
+	data, err := os.ReadFile(trackFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read tracking file: %v", err)
+		}
+		return
+	}
+
+	counts := map[string]*EventStats{}
+	if err := json.Unmarshal(data, &counts); err != nil {
+		log.Printf("Warning: Failed to parse tracking file: %v", err)
+		return
+	}
+
+	trackMutex.Lock()
+	trackCounts = counts
+	trackMutex.Unlock()
+}
+
+// flushTracking adds the actions tracked since the last flush to the
+// tracking file, under its lock so server processes sharing the cache
+// directory add to the same totals, and reads the totals back.
+func flushTracking() error {
+	trackMutex.Lock()
+	pending := trackPending
+	trackPending = map[string]EventStats{}
+	trackMutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	saved := map[string]*EventStats{}
+	err := updateJSONFile(trackFile, &saved, func() error {
+		for id, stats := range pending {
+			if saved[id] == nil {
+				saved[id] = &EventStats{}
+			}
+			saved[id].add(stats)
+		}
+		return nil
+	})
+
+	trackMutex.Lock()
+	defer trackMutex.Unlock()
+	if err != nil {
+		// Kept to be added by the next flush
+		for id, stats := range pending {
+			kept := trackPending[id]
+			kept.add(stats)
+			trackPending[id] = kept
+		}
+		return err
+	}
+	for id, stats := range trackPending {
+		if saved[id] == nil {
+			saved[id] = &EventStats{}
+		}
+		saved[id].add(stats)
+	}
+	trackCounts = saved
+	return nil
+}
+
+func flushTrackingPeriodically() {
+	ticker := time.NewTicker(trackInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := flushTracking(); err != nil {
+			log.Printf("Warning: Failed to flush tracking data: %v", err)
+		}
+		if err := flushAnalytics(); err != nil {
+			log.Printf("Warning: Failed to save analytics: %v", err)
+		}
+	}
+}
+
+func isKnownEvent(id string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, e := range eventsCache {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTP Handlers
+
+func trackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// navigator.sendBeacon posts as text/plain, so don't insist on a content type.
+	var req TrackRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !isKnownEvent(req.EventID) {
+		http.Error(w, "Unknown event", http.StatusNotFound)
+		return
+	}
+
+	if !recordTrack(req.EventID, req.Action) {
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func popularHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, err := getEvents()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	trackMutex.Lock()
+	popular := []PopularEvent{}
+	for _, e := range events {
+		stats, ok := trackCounts[e.ID]
+		if !ok {
+			continue
+		}
+		popular = append(popular, PopularEvent{
+			EventID:    e.ID,
+			Title:      e.Title,
+			EventStats: *stats,
+			Score:      popularityScore(*stats),
+		})
+	}
+	trackMutex.Unlock()
+
+	sort.SliceStable(popular, func(i, j int) bool {
+		return popular[i].Score > popular[j].Score
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(popular)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Flagpole runs The Events Calendar, whose REST API returns the same events
+// as the list page as structured JSON. It's the primary source; the HTML
+// list is only scraped when the API can't be reached or returns nonsense.
+
+const (
+	sourceTribeAPI  = "flagpole-api"
+	tribeEventsAPI  = "https://flagpole.com/wp-json/tribe/events/v1/events"
+	tribePageSize   = 50
+	tribeDateLayout = "2006-01-02 15:04:05"
+)
+
+// Data Structures
+
+type tribeResponse struct {
+	Events      []tribeEvent `json:"events"`
+	NextRestURL string       `json:"next_rest_url"`
+}
+
+type tribeEvent struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	Excerpt    string `json:"excerpt"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+	AllDay     bool   `json:"all_day"`
+	Categories []struct {
+		Name string `json:"name"`
+	} `json:"categories"`
+	// The API answers [] rather than an object for events without a venue
+	Venue json.RawMessage `json:"venue"`
+}
+
+type tribeVenue struct {
+	Venue   string `json:"venue"`
+	Address string `json:"address"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Country string `json:"country"`
+	// Numbers, or strings that may be empty
+	GeoLat interface{} `json:"geo_lat"`
+	GeoLng interface{} `json:"geo_lng"`
+}
+
+// Helper Functions
+
+// listEvents returns the events listed for day, preferring the REST API and
+// falling back to scraping the HTML list, unless the list is configured as
+// the only source.
+func listEvents(ctx context.Context, day string) ([]Event, error) {
+	if getConfig().ListingSource == listingHTML {
+		return scrapeListing(ctx, flagpoleEventsURL, day)
+	}
+	events, err := fetchTribeEvents(ctx, tribeEventsAPI, day)
+	if err == nil {
+		return events, nil
+	}
+	log.Printf("Warning: Events API unavailable, scraping the list page instead: %v", err)
+	return scrapeListing(ctx, flagpoleEventsURL, day)
+}
+
+// fetchTribeEvents pages through the API's events running on day.
+func fetchTribeEvents(ctx context.Context, apiURL, day string) ([]Event, error) {
+	params := url.Values{}
+	params.Add("start_date", day+" 00:00:00")
+	params.Add("end_date", day+" 23:59:59")
+	params.Add("per_page", strconv.Itoa(tribePageSize))
+	pageURL := apiURL + "?" + params.Encode()
+
+	var events []Event
+	for page := 1; pageURL != ""; page++ {
+		body, err := fetchPage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		var result tribeResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("error decoding events API page %d: %v", page, err)
+		}
+
+		for _, te := range result.Events {
+			e, err := te.toEvent()
+			if err != nil {
+				log.Printf("Warning: Skipping event %s from the events API: %v", te.URL, err)
+				continue
+			}
+			e.SourceName, e.SourceURL = sourceTribeAPI, pageURL
+			events = append(events, e)
+		}
+		if page >= maxListingPages {
+			log.Printf("Warning: Stopped after %d events API pages.", maxListingPages)
+			break
+		}
+		pageURL = result.NextRestURL
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no events returned for %s", day)
+	}
+	return events, nil
+}
+
+func (te tribeEvent) toEvent() (Event, error) {
+	start, err := time.Parse(tribeDateLayout, te.StartDate)
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid start date %q", te.StartDate)
+	}
+	end, err := time.Parse(tribeDateLayout, te.EndDate)
+	if err != nil || end.Before(start) {
+		end = start
+	}
+
+	e := Event{
+		Date:        start.Format("2006-01-02"),
+		StartDate:   start.Format("2006-01-02"),
+		EndDate:     end.Format("2006-01-02"),
+		Datetime:    tribeDatetime(start, end, te.AllDay),
+		Title:       html.UnescapeString(te.Title),
+		EventLink:   te.URL,
+		Description: htmlText(te.Excerpt),
+	}
+	if len(te.Categories) > 0 {
+		e.Category = html.UnescapeString(te.Categories[0].Name)
+	}
+
+	var venue tribeVenue
+	if json.Unmarshal(te.Venue, &venue) == nil {
+		e.Venue = html.UnescapeString(venue.Venue)
+		var parts []string
+		for _, part := range []string{venue.Address, venue.City, venue.State, venue.Country} {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		e.Address = strings.Join(parts, ", ")
+		lat, latOK := jsonFloat(venue.GeoLat)
+		lng, lngOK := jsonFloat(venue.GeoLng)
+		if latOK && lngOK {
+			e.Latitude, e.Longitude = lat, lng
+			e.GeocodeProvider = geocodeListing
+		}
+	}
+
+	e.ID = eventID(e)
+	return e, nil
+}
+
+// tribeDatetime renders times the way the HTML list does, e.g. "Wednesday,
+// December 10 @ 7:00 pm - 10:00 pm", so eventTimes reads both sources alike.
+func tribeDatetime(start, end time.Time, allDay bool) string {
+	sameDay := start.Format("2006-01-02") == end.Format("2006-01-02")
+	if allDay {
+		if sameDay {
+			return start.Format("Monday, January 2")
+		}
+		return start.Format("Monday, January 2") + " - " + end.Format("January 2")
+	}
+
+	text := start.Format("Monday, January 2 @ 3:04 pm")
+	switch {
+	case !sameDay:
+		text += " - " + end.Format("January 2 @ 3:04 pm")
+	case end.After(start):
+		text += " - " + end.Format("3:04 pm")
+	}
+	return text
+}
+
+func jsonFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func htmlText(fragment string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Text())
+}
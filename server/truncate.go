@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// List responses carry a shortened description, cut at a word boundary to
+// about MAPTHENS_DESCRIPTION_LIMIT characters (280 by default) and ending
+// in "…", with description_truncated set. The single-event endpoint always
+// has the full text, as do list responses given ?expand=description; see
+// expand.go.
+
+// Helper Functions
+
+// truncateDescription shortens s to at most limit characters plus an
+// ellipsis, backing up to the last space when there is one in the second
+// half.
+func truncateDescription(s string, limit int) (string, bool) {
+	runes := []rune(s)
+	if limit <= 0 || len(runes) <= limit {
+		return s, false
+	}
+	cut := limit
+	for i := limit; i > limit/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…", true
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamps are served in Athens time (MAPTHENS_TIMEZONE). Visitors
+// planning a trip from elsewhere can pass ?tz=America/Chicago, accepted by
+// every endpoint that returns events, to get the structured timestamps of a
+// response (start_time, door_time, scraped_at, the tickets' and weather's times, and
+// the envelope's) in their own zone instead. The listing's display strings
+// (date, datetime) are left as flagpole wrote them.
+//
+// Without ?tz=, a browser's Accept-Language is used when its first region
+// that has one names a country with a single timezone, e.g. en-GB is served
+// Europe/London. Countries spanning several zones, the US among them, get
+// no default, so local visitors keep Athens time.
+
+// Global Variables
+
+// regionZones maps ISO 3166 regions with a single timezone to it.
+var regionZones = map[string]string{
+	"AT": "Europe/Vienna",
+	"BE": "Europe/Brussels",
+	"CH": "Europe/Zurich",
+	"CN": "Asia/Shanghai",
+	"CZ": "Europe/Prague",
+	"DE": "Europe/Berlin",
+	"DK": "Europe/Copenhagen",
+	"FI": "Europe/Helsinki",
+	"FR": "Europe/Paris",
+	"GB": "Europe/London",
+	"GR": "Europe/Athens",
+	"HK": "Asia/Hong_Kong",
+	"IE": "Europe/Dublin",
+	"IL": "Asia/Jerusalem",
+	"IN": "Asia/Kolkata",
+	"IT": "Europe/Rome",
+	"JP": "Asia/Tokyo",
+	"KR": "Asia/Seoul",
+	"NL": "Europe/Amsterdam",
+	"NO": "Europe/Oslo",
+	"PL": "Europe/Warsaw",
+	"SE": "Europe/Stockholm",
+	"SG": "Asia/Singapore",
+	"TW": "Asia/Taipei",
+	"ZA": "Africa/Johannesburg",
+}
+
+// Helper Functions
+
+// parseTimeZone returns the zone asked for with ?tz=, or suggested by
+// Accept-Language, or nil to leave timestamps as they are.
+func parseTimeZone(r *http.Request) (*time.Location, error) {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		// LoadLocation also reads paths relative to ZONEINFO; names are
+		// all we take
+		if strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+			return nil, fmt.Errorf("unknown timezone %q", name)
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", name)
+		}
+		return loc, nil
+	}
+	if zone := acceptLanguageZone(r.Header.Get("Accept-Language")); zone != "" {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, nil
+}
+
+// acceptLanguageZone returns the zone of the first language range in header
+// that names a region, if that region has just one.
+func acceptLanguageZone(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		// q=0 means not acceptable
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		subtags := strings.Split(tag, "-")
+		for _, subtag := range subtags[1:] {
+			if len(subtag) == 2 {
+				return regionZones[strings.ToUpper(subtag)]
+			}
+		}
+	}
+	return ""
+}
+
+func inZone(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	converted := t.In(loc)
+	return &converted
+}
+
+// convertEventTimes moves the events' timestamps into loc. events must be a
+// copy; what their pointers share with the cache is copied before it's
+// changed.
+func convertEventTimes(events []Event, loc *time.Location) {
+	for i := range events {
+		e := &events[i]
+		e.StartTime = inZone(e.StartTime, loc)
+		e.DoorTime = inZone(e.DoorTime, loc)
+		e.ScrapedAt = e.ScrapedAt.In(loc)
+		if e.Tickets != nil {
+			tickets := *e.Tickets
+			tickets.OnSaleAt = inZone(tickets.OnSaleAt, loc)
+			tickets.CheckedAt = tickets.CheckedAt.In(loc)
+			e.Tickets = &tickets
+		}
+		if e.Weather != nil {
+			weather := *e.Weather
+			weather.Time = weather.Time.In(loc)
+			e.Weather = &weather
+		}
+	}
+}
+
+// convertTimes applies ?tz= to a response's events. It writes a 400 and
+// returns false when the parameter is invalid; loc is nil when timestamps
+// stay as they are.
+func convertTimes(w http.ResponseWriter, r *http.Request, events []Event) (*time.Location, bool) {
+	w.Header().Add("Vary", "Accept-Language")
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tz parameter: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if loc != nil {
+		convertEventTimes(events, loc)
+	}
+	return loc, true
+}
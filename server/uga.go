@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UGA publishes its campus calendar through Localist, whose API lists a
+// day's events as JSON. It's only fetched when MAPTHENS_UGA_CALENDAR_URL is
+// set, e.g. to https://calendar.uga.edu/api/2/events, and its events are
+// merged with flagpole's by mergeSources.
+
+const (
+	sourceUGA        = "uga-localist"
+	localistPageSize = 100
+)
+
+// Data Structures
+
+type localistResponse struct {
+	Events []struct {
+		Event localistEvent `json:"event"`
+	} `json:"events"`
+	Page struct {
+		Current int `json:"current"`
+		Total   int `json:"total"`
+	} `json:"page"`
+}
+
+type localistEvent struct {
+	Title           string `json:"title"`
+	DescriptionText string `json:"description_text"`
+	LocalistURL     string `json:"localist_url"`
+	LocationName    string `json:"location_name"`
+	Address         string `json:"address"`
+	Geo             struct {
+		// Numbers, strings or null depending on how the place was entered
+		Latitude  interface{} `json:"latitude"`
+		Longitude interface{} `json:"longitude"`
+	} `json:"geo"`
+	EventInstances []struct {
+		EventInstance struct {
+			Start  string `json:"start"`
+			End    string `json:"end"`
+			AllDay bool   `json:"all_day"`
+		} `json:"event_instance"`
+	} `json:"event_instances"`
+	Filters struct {
+		EventTypes []struct {
+			Name string `json:"name"`
+		} `json:"event_types"`
+	} `json:"filters"`
+}
+
+// Helper Functions
+
+// fetchUGAEvents pages through the Localist API's events on day.
+func fetchUGAEvents(ctx context.Context, apiURL, day string) ([]Event, error) {
+	var events []Event
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Add("start", day)
+		params.Add("end", day)
+		params.Add("pp", strconv.Itoa(localistPageSize))
+		params.Add("page", strconv.Itoa(page))
+		pageURL := apiURL + "?" + params.Encode()
+
+		body, err := fetchPage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		var result localistResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("error decoding UGA calendar page %d: %v", page, err)
+		}
+
+		for _, item := range result.Events {
+			e, err := item.Event.toEvent(day)
+			if err != nil {
+				log.Printf("Warning: Skipping event %s from the UGA calendar: %v", item.Event.LocalistURL, err)
+				continue
+			}
+			e.SourceName, e.SourceURL = sourceUGA, pageURL
+			events = append(events, e)
+		}
+		if page >= result.Page.Total {
+			break
+		}
+		if page >= maxListingPages {
+			log.Printf("Warning: Stopped after %d UGA calendar pages.", maxListingPages)
+			break
+		}
+	}
+	return events, nil
+}
+
+// toEvent converts the instance of le that runs on day.
+func (le localistEvent) toEvent(day string) (Event, error) {
+	loc := getConfig().Location
+	for _, item := range le.EventInstances {
+		instance := item.EventInstance
+		start, err := time.Parse(time.RFC3339, instance.Start)
+		if err != nil {
+			continue
+		}
+		start = start.In(loc)
+		end, err := time.Parse(time.RFC3339, instance.End)
+		if err != nil || end.Before(start) {
+			end = start
+		}
+		end = end.In(loc)
+		if start.Format("2006-01-02") > day || end.Format("2006-01-02") < day {
+			continue
+		}
+
+		e := Event{
+			Date:        start.Format("2006-01-02"),
+			StartDate:   start.Format("2006-01-02"),
+			EndDate:     end.Format("2006-01-02"),
+			Datetime:    tribeDatetime(start, end, instance.AllDay),
+			Title:       html.UnescapeString(le.Title),
+			EventLink:   le.LocalistURL,
+			Venue:       html.UnescapeString(le.LocationName),
+			Address:     strings.TrimSpace(le.Address),
+			Description: strings.TrimSpace(le.DescriptionText),
+		}
+		if len(le.Filters.EventTypes) > 0 {
+			e.Category = html.UnescapeString(le.Filters.EventTypes[0].Name)
+		}
+		lat, latOK := jsonFloat(le.Geo.Latitude)
+		lng, lngOK := jsonFloat(le.Geo.Longitude)
+		if latOK && lngOK && (lat != 0 || lng != 0) {
+			e.Latitude, e.Longitude = lat, lng
+			e.GeocodeProvider = geocodeUGA
+		}
+		e.ID = eventID(e)
+		return e, nil
+	}
+	return Event{}, fmt.Errorf("no instance on %s", day)
+}
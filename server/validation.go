@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Request bodies for submissions, queries, and webhook pushes are validated
+// in two passes, and every problem found is reported at once rather than
+// only the first:
+//
+//  1. The document is checked against the type it's decoded into, as its
+//     JSON Schema describes it (see schema.go), for wrong types, malformed
+//     dates, and unknown fields.
+//  2. The decoded value's validate method checks the rules a schema can't
+//     express well, such as required text, lengths, and ranges.
+//
+// A failed validation is answered with a 400 listing each problem with the
+// JSON Pointer of the offending value, e.g.
+//
+//	{
+//	  "error": "Invalid submission",
+//	  "errors": [
+//	    {"path": "/title", "message": "is required"},
+//	    {"path": "/starts_at", "message": "must be a date-time like \"2025-12-10T18:00:00-05:00\""}
+//	  ]
+//	}
+
+// Data Structures
+
+// FieldError is one problem with a request body. Path is a JSON Pointer;
+// an empty path means the whole document.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type fieldErrors []FieldError
+
+type validationResponse struct {
+	Error  string      `json:"error"`
+	Errors fieldErrors `json:"errors"`
+}
+
+// Helper Functions
+
+func (errs *fieldErrors) add(path, format string, args ...interface{}) {
+	*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Error lists the problems on one line, for responses that can't carry
+// them separately.
+func (errs fieldErrors) Error() string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = strings.TrimSpace(e.Path + " " + e.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// pointer appends a reference token to a JSON Pointer, escaping it as RFC
+// 6901 asks.
+func pointer(path string, token interface{}) string {
+	s := fmt.Sprint(token)
+	s = strings.ReplaceAll(s, "~", "~0")
+	s = strings.ReplaceAll(s, "/", "~1")
+	return path + "/" + s
+}
+
+// checkSchema checks a decoded JSON value against Go type t, the way t's
+// JSON Schema from typeSchema describes it. Null is accepted anywhere, as
+// encoding/json accepts it, and required fields are left to the validate
+// methods, since many types are also responses whose server-set fields a
+// client doesn't send.
+func checkSchema(value interface{}, t reflect.Type, path string, errs *fieldErrors) {
+	if value == nil {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		if s, ok := value.(string); !ok || !isDateTime(s) {
+			errs.add(path, "must be a date-time like \"2025-12-10T18:00:00-05:00\"")
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(path, "must be a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "must be true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		// Past ±2^63 it wouldn't decode into an int
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) || math.Abs(n) >= 1<<63 {
+			errs.add(path, "must be a whole number")
+		}
+	case reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			errs.add(path, "must be a number")
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			errs.add(path, "must be an array")
+			return
+		}
+		for i, item := range items {
+			checkSchema(item, t.Elem(), pointer(path, i), errs)
+		}
+	case reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok || len(items) != t.Len() {
+			errs.add(path, "must be an array of %d items", t.Len())
+			return
+		}
+		for i, item := range items {
+			checkSchema(item, t.Elem(), pointer(path, i), errs)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be an object")
+			return
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			checkSchema(object[name], t.Elem(), pointer(path, name), errs)
+		}
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be an object")
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for _, field := range jsonFields(t) {
+			fields[field.name] = field.typ
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fieldType, ok := fields[name]
+			if !ok {
+				errs.add(pointer(path, name), "is not a known field")
+				continue
+			}
+			checkSchema(object[name], fieldType, pointer(path, name), errs)
+		}
+	}
+}
+
+func isDateTime(s string) bool {
+	_, err := time.Parse(time.RFC3339, s)
+	return err == nil
+}
+
+// decodeChecked checks body against the schema of v's type and decodes it
+// into v when it passes.
+func decodeChecked(body []byte, v interface{}) fieldErrors {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fieldErrors{{Path: "", Message: "is not valid JSON: " + strings.TrimPrefix(err.Error(), "json: ")}}
+	}
+	var errs fieldErrors
+	checkSchema(document, reflect.TypeOf(v).Elem(), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fieldErrors{{Path: "", Message: strings.TrimPrefix(err.Error(), "json: ")}}
+	}
+	return nil
+}
+
+// readBody reads a request body of at most limit bytes. It writes a 400
+// and returns false when the body can't be read.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeValidated reads and schema-checks a request body into v, writing a
+// 400 listing the problems and returning false when it doesn't pass.
+func decodeValidated(w http.ResponseWriter, r *http.Request, v interface{}, message string) bool {
+	body, ok := readBody(w, r, 64<<10)
+	if !ok {
+		return false
+	}
+	if errs := decodeChecked(body, v); len(errs) > 0 {
+		writeValidationErrors(w, message, errs)
+		return false
+	}
+	return true
+}
+
+func writeValidationErrors(w http.ResponseWriter, message string, errs fieldErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(validationResponse{Error: message, Errors: errs})
+}
+
+// checkLength adds an error when s is longer than limit bytes.
+func checkLength(errs *fieldErrors, path, s string, limit int) {
+	if len(s) > limit {
+		errs.add(path, "must be at most %d characters", limit)
+	}
+}
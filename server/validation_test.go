@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	type document struct {
+		Counts  map[string]int `json:"counts"`
+		Corners [2]float64     `json:"corners"`
+	}
+	for _, test := range []struct {
+		name string
+		body string
+		typ  reflect.Type
+		want fieldErrors
+	}{
+		{"empty query", `{}`, reflect.TypeOf(EventQuery{}), nil},
+		{"full query", `{"filter": {"any": [{"categories": ["Music"]}, {"bbox": [-83.4, 33.9, -83.3, 34.0]}],
+			"starts_after": "2026-10-15T18:00:00-04:00", "outdoor": true}, "limit": 50, "offset": 0}`,
+			reflect.TypeOf(EventQuery{}), nil},
+		{"nulls", `{"filter": {"starts_after": null, "categories": null}, "limit": null}`, reflect.TypeOf(EventQuery{}), nil},
+		{"whole float", `{"limit": 5.0}`, reflect.TypeOf(EventQuery{}), nil},
+
+		{"not an object", `[]`, reflect.TypeOf(EventQuery{}), fieldErrors{{"", "must be an object"}}},
+		{"fractional", `{"limit": 2.5}`, reflect.TypeOf(EventQuery{}), fieldErrors{{"/limit", "must be a whole number"}}},
+		{"too large for an int", `{"limit": 1e19}`, reflect.TypeOf(EventQuery{}), fieldErrors{{"/limit", "must be a whole number"}}},
+		{"number as a string", `{"offset": "10"}`, reflect.TypeOf(EventQuery{}), fieldErrors{{"/offset", "must be a whole number"}}},
+		{"bool as a string", `{"filter": {"outdoor": "yes"}}`, reflect.TypeOf(EventQuery{}), fieldErrors{{"/filter/outdoor", "must be true or false"}}},
+		{"string as an array", `{"filter": {"venues": "40 Watt"}}`, reflect.TypeOf(EventQuery{}), fieldErrors{{"/filter/venues", "must be an array"}}},
+		{"bad date-time", `{"filter": {"starts_before": "2026-10-15"}}`, reflect.TypeOf(EventQuery{}),
+			fieldErrors{{"/filter/starts_before", `must be a date-time like "2025-12-10T18:00:00-05:00"`}}},
+		{"nested item", `{"filter": {"all": [{}, {"any": [{"bbox": [-83.4, "north"]}]}]}}`, reflect.TypeOf(EventQuery{}),
+			fieldErrors{{"/filter/all/1/any/0/bbox/1", "must be a number"}}},
+		{"unknown fields, sorted", `{"sort": "date", "filter": {"tags": [], "a/b~c": 1}}`, reflect.TypeOf(EventQuery{}),
+			fieldErrors{{"/filter/a~1b~0c", "is not a known field"}, {"/filter/tags", "is not a known field"}, {"/sort", "is not a known field"}}},
+		{"every problem", `{"title": 7, "starts_at": "tonight", "venue": ["Georgia Theatre"]}`, reflect.TypeOf(Submission{}),
+			fieldErrors{{"/starts_at", `must be a date-time like "2025-12-10T18:00:00-05:00"`}, {"/title", "must be a string"}, {"/venue", "must be a string"}}},
+
+		{"map and array", `{"counts": {"a": 1}, "corners": [1, 2]}`, reflect.TypeOf(document{}), nil},
+		{"map values", `{"counts": {"b": "two", "a": 1.5}}`, reflect.TypeOf(document{}),
+			fieldErrors{{"/counts/a", "must be a whole number"}, {"/counts/b", "must be a whole number"}}},
+		{"array length", `{"corners": [1, 2, 3]}`, reflect.TypeOf(document{}), fieldErrors{{"/corners", "must be an array of 2 items"}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var value interface{}
+			if errs := decodeChecked([]byte(test.body), &value); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			var got fieldErrors
+			checkSchema(value, test.typ, "", &got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("checkSchema(%s) = %v, want %v", test.body, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPointer(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		token interface{}
+		want  string
+	}{
+		{"", "title", "/title"},
+		{"/filter", 0, "/filter/0"},
+		{"", "", "/"},
+		{"", "a/b", "/a~1b"},
+		{"", "m~n", "/m~0n"},
+		// ~ is escaped first, so "~1" doesn't read back as "/"
+		{"", "~1", "/~01"},
+	} {
+		if got := pointer(test.path, test.token); got != test.want {
+			t.Errorf("pointer(%q, %v) = %q, want %q", test.path, test.token, got, test.want)
+		}
+	}
+}
+
+func TestDecodeChecked(t *testing.T) {
+	var q EventQuery
+	if errs := decodeChecked([]byte(`{"filter": {"categories": ["Music"]}, "limit": 20}`), &q); errs != nil {
+		t.Fatalf("decodeChecked() = %v", errs)
+	}
+	if q.Limit != 20 || len(q.Filter.Categories) != 1 {
+		t.Errorf("decoded %+v", q)
+	}
+
+	for _, test := range []struct {
+		body, want string
+	}{
+		{`{"limit": 20`, "is not valid JSON: unexpected end of JSON input"},
+		{``, "is not valid JSON: unexpected end of JSON input"},
+		{`{"limit": "20", "offset": 1.5}`, "/limit must be a whole number; /offset must be a whole number"},
+	} {
+		var q EventQuery
+		errs := decodeChecked([]byte(test.body), &q)
+		if len(errs) == 0 {
+			t.Errorf("decodeChecked(%s) passed", test.body)
+			continue
+		}
+		// Error trims the empty path of a whole-document problem
+		if got := errs.Error(); got != test.want {
+			t.Errorf("decodeChecked(%s) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
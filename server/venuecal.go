@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The big rooms' own calendars say more about a show than their flagpole
+// listings do: who's opening, and when doors open as well as when the
+// show starts. A gazetteer venue with an events_page (the Georgia Theatre
+// and the 40 Watt by default) has that page read on each scrape, as the
+// venuecal source, for the schema.org Event JSON-LD that ticketing and
+// calendar plugins embed. Each event found is matched to flagpole's listing
+// at the same venue on the same day, by title (either containing the other)
+// or by start time, and merged onto it by mergeSources, where venuecal ranks
+// below every other source: it adds support_acts and door_time and fills in
+// fields the listing left empty, but never replaces what flagpole listed.
+// Events flagpole doesn't list are dropped, since the venue calendars only
+// add detail. Every merged field that didn't come from the event's own
+// listing is named in its field_sources, with the source it came from.
+
+const originVenueCalendar = "venuecal"
+
+// Helper Functions
+
+// venueCalendars lists the gazetteer venues with an events page, by name.
+func venueCalendars() []VenueInfo {
+	tablesMutex.RLock()
+	defer tablesMutex.RUnlock()
+	seen := map[string]bool{}
+	var venues []VenueInfo
+	for _, venue := range venueTable {
+		if venue.EventsPage != "" && !seen[venue.Name] {
+			seen[venue.Name] = true
+			venues = append(venues, venue)
+		}
+	}
+	sort.Slice(venues, func(i, j int) bool { return venues[i].Name < venues[j].Name })
+	return venues
+}
+
+// fetchVenueCalendars reads every venue's events on day. It only fails
+// when none of the calendars could be read.
+func fetchVenueCalendars(ctx context.Context, day string) ([]Event, error) {
+	var events []Event
+	var failures []string
+	venues := venueCalendars()
+	for _, venue := range venues {
+		found, err := fetchVenueCalendar(ctx, venue, day)
+		if err != nil {
+			log.Printf("Warning: Failed to read the %s calendar: %v", venue.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", venue.Name, err))
+			continue
+		}
+		events = append(events, found...)
+	}
+	if len(venues) > 0 && len(failures) == len(venues) {
+		return nil, fmt.Errorf("no venue calendar could be read: %s", strings.Join(failures, "; "))
+	}
+	return events, nil
+}
+
+// fetchVenueCalendar reads the JSON-LD events on day from venue's events
+// page.
+func fetchVenueCalendar(ctx context.Context, venue VenueInfo, day string) ([]Event, error) {
+	doc, err := fetchDocument(ctx, venue.EventsPage)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for _, object := range ldObjects(doc) {
+		if !isLDEvent(object["@type"]) {
+			continue
+		}
+		if e, ok := venueCalendarEvent(object, venue, day); ok {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// isLDEvent reports whether a JSON-LD @type names a kind of Event, e.g.
+// MusicEvent.
+func isLDEvent(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.HasSuffix(v, "Event")
+	case []interface{}:
+		for _, t := range v {
+			if isLDEvent(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ldNames returns the names of a JSON-LD value holding one thing or a list
+// of them, e.g. an event's performers.
+func ldNames(v interface{}) []string {
+	var names []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			names = append(names, ldNames(item)...)
+		}
+	case map[string]interface{}:
+		if name := html.UnescapeString(ldString(v["name"])); name != "" {
+			names = append(names, name)
+		}
+	case string:
+		if name := html.UnescapeString(strings.TrimSpace(v)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseLDTime reads a JSON-LD date or date-time, taking those without an
+// offset to be in loc. Times of day alone, which some calendars give for
+// doorTime, are put on date. clock is false for a bare date.
+func parseLDTime(value string, date time.Time, loc *time.Location) (t time.Time, clock bool, ok bool) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), true, true
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true, true
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, false, true
+	}
+	if date.IsZero() {
+		return time.Time{}, false, false
+	}
+	for _, layout := range []string{"15:04:05", "15:04", "3:04PM", "3:04 PM", "3PM", "3 PM"} {
+		if t, err := time.Parse(layout, strings.ToUpper(value)); err == nil {
+			return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, loc), true, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// venueCalendarEvent converts a JSON-LD event at venue, if it starts on
+// day. The first performer is taken as the headliner and the rest as
+// support acts.
+func venueCalendarEvent(object map[string]interface{}, venue VenueInfo, day string) (Event, bool) {
+	loc := getConfig().Location
+	title := html.UnescapeString(ldString(object["name"]))
+	start, clock, ok := parseLDTime(ldString(object["startDate"]), time.Time{}, loc)
+	if title == "" || !ok || start.Format("2006-01-02") != day {
+		return Event{}, false
+	}
+	end := start
+	if t, _, ok := parseLDTime(ldString(object["endDate"]), start, loc); ok && !t.Before(start) {
+		end = t
+	}
+
+	e := Event{
+		Date:       start.Format("2006-01-02"),
+		StartDate:  start.Format("2006-01-02"),
+		EndDate:    end.Format("2006-01-02"),
+		Datetime:   tribeDatetime(start, end, !clock),
+		Title:      title,
+		Venue:      venue.Name,
+		SourceName: originVenueCalendar + "-" + venueID(venue.Name),
+		SourceURL:  venue.EventsPage,
+	}
+	if link := ldString(object["url"]); link != "" {
+		if base, err := url.Parse(venue.EventsPage); err == nil {
+			if resolved, err := base.Parse(link); err == nil {
+				e.EventLink = resolved.String()
+			}
+		}
+	}
+	if performers := ldNames(object["performer"]); len(performers) > 1 {
+		for _, name := range performers[1:] {
+			if !strings.EqualFold(name, performers[0]) {
+				e.SupportActs = append(e.SupportActs, name)
+			}
+		}
+	}
+	if doors, clock, ok := parseLDTime(ldString(object["doorTime"]), start, loc); ok && clock && !doors.After(start) {
+		e.DoorTime = &doors
+	}
+	e.ID = eventID(e)
+	return e, true
+}
+
+// matchVenueCalendar returns the calendar events that match one of listed's
+// flagpole events, retitled to match it so mergeSources puts them together.
+func matchVenueCalendar(listed, calendar []Event) []Event {
+	var matched []Event
+	for _, ce := range calendar {
+		calendarStart, _, calendarAllDay, _ := eventTimes(ce)
+		calendarTitle := normalizeTitle(ce.Title)
+		for _, e := range listed {
+			if sourceOrigin(e.SourceName) != originFlagpole || e.StartDate != ce.StartDate || venueID(e.Venue) != venueID(ce.Venue) {
+				continue
+			}
+			title := normalizeTitle(e.Title)
+			sameTitle := strings.Contains(title, calendarTitle) || strings.Contains(calendarTitle, title)
+			start, _, allDay, err := eventTimes(e)
+			sameStart := err == nil && !allDay && !calendarAllDay && start.Equal(calendarStart)
+			if sameTitle || sameStart {
+				ce.Title = e.Title
+				matched = append(matched, ce)
+				break
+			}
+		}
+	}
+	if dropped := len(calendar) - len(matched); dropped > 0 {
+		log.Printf("Dropped %d venue calendar events flagpole doesn't list.", dropped)
+	}
+	return matched
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Data Structures
+
+// VenueInfo is an entry in the venue gazetteer. Coordinates are optional;
+// when present they are used instead of geocoding the listing's address.
+// Website is used in place of an event link that has gone dead. Type is one
+// of venueTypes and Capacity a rough head count; both are optional.
+type VenueInfo struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Outdoor  bool     `json:"outdoor"`
+	Type     string   `json:"type,omitempty"`
+	Capacity int      `json:"capacity,omitempty"`
+	Website  string   `json:"website,omitempty"`
+	// A page listing the venue's shows as schema.org Event JSON-LD, read
+	// for the details flagpole leaves out; see venuecal.go
+	EventsPage string  `json:"events_page,omitempty"`
+	Latitude   float64 `json:"latitude,omitempty"`
+	Longitude  float64 `json:"longitude,omitempty"`
+}
+
+// CoordinateOverride pins the coordinates of a single event, every event at
+// an address, or every event at a venue, in that order of precedence.
+type CoordinateOverride struct {
+	EventID   string  `json:"event_id,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Venue     string  `json:"venue,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// defaultVenues is the built-in gazetteer of regular Athens venues, used
+// unless a venues file is configured. It takes precedence over the keyword
+// heuristics below.
+var defaultVenues = []VenueInfo{
+	{Name: "40 Watt Club", Aliases: []string{"40 Watt"}, Type: "bar", Capacity: 500, Website: "https://www.40watt.com/", EventsPage: "https://www.40watt.com/"},
+	{Name: "ACC Library", Type: "library"},
+	{Name: "Athentic Brewing Co.", Type: "bar", Capacity: 150},
+	{Name: "Bishop Park", Outdoor: true, Type: "park"},
+	{Name: "Ciné", Type: "theatre", Capacity: 100},
+	{Name: "Dudley Park", Outdoor: true, Type: "park"},
+	{Name: "Flicker Theatre & Bar", Type: "bar", Capacity: 100},
+	{Name: "Georgia Museum of Art", Type: "gallery", Website: "https://georgiamuseum.org/"},
+	{Name: "Georgia Theatre", Type: "theatre", Capacity: 1000, Website: "https://www.georgiatheatre.com/", EventsPage: "https://www.georgiatheatre.com/"},
+	{Name: "Hendershot's", Type: "bar", Capacity: 150},
+	{Name: "Hugh Hodgson Concert Hall", Type: "theatre", Capacity: 1100},
+	{Name: "Memorial Park", Outdoor: true, Type: "park"},
+	{Name: "Morton Theatre", Type: "theatre", Capacity: 550},
+	{Name: "Nowhere Bar", Type: "bar", Capacity: 200},
+	{Name: "Oconee County Library", Type: "library"},
+	{Name: "Sandy Creek Nature Center", Outdoor: true, Type: "park"},
+	{Name: "Sandy Creek Park", Outdoor: true, Type: "park"},
+	{Name: "State Botanical Garden of Georgia", Outdoor: true, Type: "park"},
+	{Name: "The Classic Center", Aliases: []string{"Classic Center"}, Type: "theatre", Capacity: 2000, Website: "https://www.classiccenter.com/"},
+}
+
+// venueTypes are the kinds of venue the gazetteer knows about.
+var venueTypes = []string{"bar", "gallery", "library", "park", "restaurant", "theatre"}
+
+// Venue sizes for ?size=, by capacity: small is up to smallVenueCapacity and
+// large is more than largeVenueCapacity.
+const (
+	smallVenueCapacity = 200
+	largeVenueCapacity = 800
+)
+
+var outdoorKeywords = regexp.MustCompile(`(?i)\b(parks?|patio|festival|fest|gardens?|trail|outdoors?|lawn|amphitheat(er|re)|farmers market|parade|hike|picnic|rooftop)\b`)
+
+// Global Variables
+var (
+	venueTable       map[string]VenueInfo
+	overridesByEvent map[string]CoordinateOverride
+	overridesByAddr  map[string]CoordinateOverride
+	overridesByVenue map[string]CoordinateOverride
+	tablesMutex      sync.RWMutex
+)
+
+// Helper Functions
+
+func normalizeVenue(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func (v VenueInfo) hasCoordinates() bool {
+	return v.Latitude != 0 || v.Longitude != 0
+}
+
+func buildVenueTable(venues []VenueInfo) map[string]VenueInfo {
+	table := make(map[string]VenueInfo, len(venues))
+	for _, v := range venues {
+		table[normalizeVenue(v.Name)] = v
+		for _, alias := range v.Aliases {
+			table[normalizeVenue(alias)] = v
+		}
+	}
+	return table
+}
+
+// loadTables (re)loads the venue gazetteer and coordinate overrides named in
+// cfg. Nothing is replaced unless both files parse.
+func loadTables(cfg Config) error {
+	venues := defaultVenues
+	if cfg.VenuesFile != "" {
+		venues = nil
+		if err := readJSONFile(cfg.VenuesFile, &venues); err != nil {
+			return err
+		}
+		for _, v := range venues {
+			if v.Type != "" && !containsFold(venueTypes, v.Type) {
+				return fmt.Errorf("%s: venue %q has unknown type %q", cfg.VenuesFile, v.Name, v.Type)
+			}
+		}
+	}
+
+	var overrides []CoordinateOverride
+	if cfg.OverridesFile != "" {
+		if err := readJSONFile(cfg.OverridesFile, &overrides); err != nil {
+			return err
+		}
+	}
+
+	byEvent := map[string]CoordinateOverride{}
+	byAddr := map[string]CoordinateOverride{}
+	byVenue := map[string]CoordinateOverride{}
+	for _, o := range overrides {
+		switch {
+		case o.EventID != "":
+			byEvent[o.EventID] = o
+		case o.Address != "":
+			byAddr[normalizeVenue(o.Address)] = o
+		case o.Venue != "":
+			byVenue[normalizeVenue(o.Venue)] = o
+		default:
+			return fmt.Errorf("%s: override needs an event_id, address, or venue", cfg.OverridesFile)
+		}
+	}
+
+	tablesMutex.Lock()
+	defer tablesMutex.Unlock()
+	venueTable = buildVenueTable(venues)
+	overridesByEvent = byEvent
+	overridesByAddr = byAddr
+	overridesByVenue = byVenue
+	return nil
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+func lookupVenue(name string) (VenueInfo, bool) {
+	tablesMutex.RLock()
+	defer tablesMutex.RUnlock()
+	info, ok := venueTable[normalizeVenue(name)]
+	return info, ok
+}
+
+func overrideCoordinates(e Event) (float64, float64, bool) {
+	tablesMutex.RLock()
+	defer tablesMutex.RUnlock()
+
+	o, ok := overridesByEvent[e.ID]
+	if !ok {
+		o, ok = overridesByAddr[normalizeVenue(e.Address)]
+	}
+	if !ok {
+		o, ok = overridesByVenue[normalizeVenue(e.Venue)]
+	}
+	return o.Latitude, o.Longitude, ok
+}
+
+// isOutdoor classifies an event using the venue table, falling back to
+// keyword matches in the venue name, title, and description.
+func isOutdoor(e Event) bool {
+	if info, ok := lookupVenue(e.Venue); ok {
+		return info.Outdoor
+	}
+	return outdoorKeywords.MatchString(e.Venue) ||
+		outdoorKeywords.MatchString(e.Title) ||
+		outdoorKeywords.MatchString(e.Description)
+}
+
+// venueSize buckets a capacity into "small", "medium", or "large", or ""
+// when the capacity isn't known.
+func venueSize(capacity int) string {
+	switch {
+	case capacity <= 0:
+		return ""
+	case capacity <= smallVenueCapacity:
+		return "small"
+	case capacity <= largeVenueCapacity:
+		return "medium"
+	default:
+		return "large"
+	}
+}
+
+func filterByVenueType(events []Event, types []string) []Event {
+	var wanted []string
+	for _, t := range types {
+		if t = strings.TrimSpace(t); t != "" {
+			wanted = append(wanted, t)
+		}
+	}
+	if len(wanted) == 0 {
+		return events
+	}
+	filtered := []Event{}
+	for _, e := range events {
+		if containsFold(wanted, e.VenueType) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+func filterByVenueSize(events []Event, size string) []Event {
+	filtered := []Event{}
+	for _, e := range events {
+		if venueSize(e.VenueCapacity) == size {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+func filterByOutdoor(events []Event, outdoor bool) []Event {
+	filtered := []Event{}
+	for _, e := range events {
+		if e.Outdoor == outdoor {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// findVenue looks a venue up by ID in the gazetteer, then among today's
+// events for venues the gazetteer doesn't know.
+func findVenue(id string) (VenueDetail, bool) {
+	tablesMutex.RLock()
+	var name string
+	for _, info := range venueTable {
+		if slugify(info.Name) == id {
+			name = info.Name
+			break
+		}
+	}
+	tablesMutex.RUnlock()
+	if name != "" {
+		return venueDetail(name), true
+	}
+
+	if events, err := getEvents(); err == nil {
+		for _, e := range events {
+			if e.Venue != "" && venueID(e.Venue) == id {
+				return venueDetail(e.Venue), true
+			}
+		}
+	}
+	return VenueDetail{}, false
+}
+
+// HTTP Handlers
+
+// venueHandler serves GET /api/venues/{id}, a venue's gazetteer entry, and
+// passes calendar requests on to venueICSHandler.
+func venueHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/events.ics") {
+		venueICSHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/venues/")
+	venue, ok := findVenue(id)
+	if id == "" || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Vary", "Accept")
+	if wantsJSONAPI(r) {
+		writeJSONAPI(w, jsonAPIDocument{Data: venueResource(r, venue)})
+		return
+	}
+	writeJSON(w, venue)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+)
+
+// /api/version reports what's deployed: the build's version, commit, and
+// time, the modes the server is configured in, and which feature flags are
+// on. Release builds stamp the build with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// and anything left unstamped falls back to what the Go toolchain recorded
+// from the checkout (debug.ReadBuildInfo), so a plain `go build` in a git
+// checkout still reports its commit.
+
+// Data Structures
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	// Modified is set when the build had uncommitted changes
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+
+	Config VersionConfig `json:"config"`
+	// Flags lists the enabled feature flags; see flags.go
+	Flags []string `json:"flags"`
+}
+
+// VersionConfig is the configuration that changes how the server behaves,
+// leaving out anything secret.
+type VersionConfig struct {
+	ConfigFile    bool   `json:"config_file"`
+	Storage       string `json:"storage"`
+	Deployment    string `json:"deployment"`
+	RefreshMode   string `json:"refresh_mode"`
+	ListingSource string `json:"listing_source"`
+	GeocodingMode string `json:"geocoding_mode"`
+	Metrics       string `json:"metrics,omitempty"`
+}
+
+// Global Variables
+
+// Set with -ldflags "-X main.version=..."
+var (
+	version   string
+	commit    string
+	buildTime string
+)
+
+// Helper Functions
+
+func buildVersion() VersionResponse {
+	v := VersionResponse{Version: version, Commit: commit, BuildTime: buildTime}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v.GoVersion = info.GoVersion
+		if v.Version == "" && info.Main.Version != "(devel)" {
+			v.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = setting.Value
+			case setting.Key == "vcs.time" && v.BuildTime == "":
+				v.BuildTime = setting.Value
+			case setting.Key == "vcs.modified" && commit == "":
+				v.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if v.Version == "" {
+		v.Version = "dev"
+	}
+
+	cfg := getConfig()
+	v.Config = VersionConfig{
+		ConfigFile:    os.Getenv("MAPTHENS_CONFIG") != "",
+		Storage:       cfg.StorageFormat,
+		Deployment:    cfg.DeploymentMode,
+		RefreshMode:   cfg.RefreshMode,
+		ListingSource: cfg.ListingSource,
+		GeocodingMode: cfg.GeocodingMode,
+		Metrics:       cfg.Metrics,
+	}
+	if cfg.DatabaseURL != "" {
+		v.Config.Storage = "postgres"
+	}
+
+	v.Flags = []string{}
+	for name := range featureFlags {
+		if flagEnabled(name) {
+			v.Flags = append(v.Flags, name)
+		}
+	}
+	sort.Strings(v.Flags)
+	return v
+}
+
+// describe is the one-line version logged at startup, e.g.
+// "v1.2.0 (3f2a9c1d0e4b)".
+func (v VersionResponse) describe() string {
+	if v.Commit == "" {
+		return v.Version
+	}
+	short := v.Commit
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	if v.Modified {
+		short += ", modified"
+	}
+	return v.Version + " (" + short + ")"
+}
+
+// HTTP Handlers
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, buildVersion())
+}
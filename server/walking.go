@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Walking times from a user's location to each event, from the Mapbox
+// Matrix API. Origins are snapped to a grid so that nearby users share
+// cached results instead of each costing a matrix request. Since anyone can
+// ask, origins farther than walkingServiceRadius from the map center are
+// rejected, the cache holds at most maxWalkingCells cells, and each matrix
+// element counts against MAPBOX_MONTHLY_BUDGET like a geocode. A cell whose
+// request failed isn't asked for again for walkingFailureTTL.
+
+const (
+	// Roughly 500m north-south at Athens' latitude.
+	walkingGridSize   = 0.005
+	walkingCacheTTL   = 24 * time.Hour
+	walkingFailureTTL = 5 * time.Minute
+	maxWalkingCells   = 2000
+	// Meters from MAPTHENS_MAP_CENTER; nobody walks to Athens from farther
+	walkingServiceRadius = 30000
+	// The walking profile allows 25 coordinates per request, one of which
+	// is the origin.
+	maxMatrixDestinations = 24
+	// Usage is counted under this mode alongside the geocoding modes
+	matrixUsage = "matrix"
+)
+
+// Data Structures
+
+type walkingCell struct {
+	// Seconds on foot per destination coordinate key. Unreachable
+	// destinations are stored as -1 so they aren't requested again.
+	durations map[string]float64
+	expiresAt time.Time
+	// Set after a failed request, until which the cell's missing
+	// destinations aren't requested
+	failedUntil time.Time
+}
+
+type mapboxMatrixResponse struct {
+	Code      string       `json:"code"`
+	Durations [][]*float64 `json:"durations"`
+}
+
+// Global Variables
+var (
+	walkingCells = map[string]*walkingCell{}
+	walkingMutex sync.Mutex
+	matrixClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Helper Functions
+
+// parseOrigin reads a "lat,lng" pair.
+func parseOrigin(value string) (coordinates, error) {
+	lat, lng, ok := strings.Cut(value, ",")
+	if !ok {
+		return coordinates{}, fmt.Errorf("expected lat,lng")
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return coordinates{}, fmt.Errorf("invalid latitude %q", lat)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return coordinates{}, fmt.Errorf("invalid longitude %q", lng)
+	}
+	return coordinates{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// checkWalkingOrigin rejects origins outside the area walking times are
+// served for.
+func checkWalkingOrigin(origin coordinates) error {
+	if distanceMeters(origin, getConfig().MapCenter) > walkingServiceRadius {
+		return fmt.Errorf("more than %d km from the map center", walkingServiceRadius/1000)
+	}
+	return nil
+}
+
+// gridCell snaps an origin to the centre of its grid cell.
+func gridCell(c coordinates) coordinates {
+	snap := func(v float64) float64 {
+		return (math.Floor(v/walkingGridSize) + 0.5) * walkingGridSize
+	}
+	return coordinates{Latitude: snap(c.Latitude), Longitude: snap(c.Longitude)}
+}
+
+func (c coordinates) key() string {
+	return fmt.Sprintf("%.5f,%.5f", c.Longitude, c.Latitude)
+}
+
+// withWalkingTimes returns a copy of events with WalkingMinutes set for each
+// geocoded event reachable on foot from origin. Matrix failures are logged
+// and leave the affected events without a walking time.
+func withWalkingTimes(events []Event, origin coordinates) []Event {
+	cell := gridCell(origin)
+	cellKey := cell.key()
+
+	var missing []coordinates
+	seen := map[string]bool{}
+	walkingMutex.Lock()
+	cached, ok := walkingCells[cellKey]
+	if !ok || now().After(cached.expiresAt) {
+		if !ok && len(walkingCells) >= maxWalkingCells {
+			evictWalkingCell()
+		}
+		cached = &walkingCell{durations: map[string]float64{}, expiresAt: now().Add(walkingCacheTTL)}
+		walkingCells[cellKey] = cached
+	}
+	if now().After(cached.failedUntil) {
+		for _, e := range events {
+			if e.Latitude == 0 && e.Longitude == 0 {
+				continue
+			}
+			dest := coordinates{Latitude: e.Latitude, Longitude: e.Longitude}
+			if _, ok := cached.durations[dest.key()]; ok || seen[dest.key()] {
+				continue
+			}
+			seen[dest.key()] = true
+			missing = append(missing, dest)
+		}
+	}
+	walkingMutex.Unlock()
+
+	for start := 0; start < len(missing); start += maxMatrixDestinations {
+		end := min(start+maxMatrixDestinations, len(missing))
+		durations, err := fetchWalkingDurations(cell, missing[start:end])
+		walkingMutex.Lock()
+		if err != nil {
+			cached.failedUntil = now().Add(walkingFailureTTL)
+		}
+		for key, seconds := range durations {
+			cached.durations[key] = seconds
+		}
+		walkingMutex.Unlock()
+		if err != nil {
+			log.Printf("Warning: Failed to fetch walking times: %v", err)
+			break
+		}
+	}
+
+	enriched := make([]Event, len(events))
+	copy(enriched, events)
+	walkingMutex.Lock()
+	defer walkingMutex.Unlock()
+	for i, e := range enriched {
+		seconds, ok := cached.durations[coordinates{Latitude: e.Latitude, Longitude: e.Longitude}.key()]
+		if !ok || seconds < 0 {
+			continue
+		}
+		minutes := int(math.Ceil(seconds / 60))
+		enriched[i].WalkingMinutes = &minutes
+	}
+	return enriched
+}
+
+// evictWalkingCell makes room for a new cell by dropping the expired ones,
+// or the one expiring soonest if none have. Callers must hold walkingMutex.
+func evictWalkingCell() {
+	oldestKey := ""
+	for key, c := range walkingCells {
+		if now().After(c.expiresAt) {
+			delete(walkingCells, key)
+		} else if oldestKey == "" || c.expiresAt.Before(walkingCells[oldestKey].expiresAt) {
+			oldestKey = key
+		}
+	}
+	if len(walkingCells) >= maxWalkingCells && oldestKey != "" {
+		delete(walkingCells, oldestKey)
+	}
+}
+
+// fetchWalkingDurations asks the Matrix API for walking durations from
+// origin to each destination, keyed by destination coordinate key.
+func fetchWalkingDurations(origin coordinates, destinations []coordinates) (map[string]float64, error) {
+	cfg := getConfig()
+	if cfg.MapboxToken == "" {
+		return nil, errTokenMissing
+	}
+	if remaining, ok := geocodeBudgetRemaining(); ok && remaining < len(destinations) {
+		return nil, fmt.Errorf("monthly Mapbox budget of %d reached", cfg.MonthlyGeocodeBudget)
+	}
+
+	points := []string{origin.key()}
+	for _, d := range destinations {
+		points = append(points, d.key())
+	}
+	params := url.Values{}
+	params.Add("sources", "0")
+	params.Add("annotations", "duration")
+	params.Add("access_token", cfg.MapboxToken)
+	requestURL := fmt.Sprintf("https://api.mapbox.com/directions-matrix/v1/mapbox/walking/%s?%s",
+		strings.Join(points, ";"), params.Encode())
+
+	resp, err := matrixClient.Get(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		setTokenHealth(tokenInvalid)
+		return nil, fmt.Errorf("%w: status code %d", errTokenRejected, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	}
+	// Mapbox bills each origin-destination pair of an accepted request
+	countGeocodeRequests(matrixUsage, len(destinations))
+	if err := saveGeocodeUsage(); err != nil {
+		log.Printf("Warning: Failed to save geocoding usage: %v", err)
+	}
+
+	var result mapboxMatrixResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding json response: %v", err)
+	}
+	if result.Code != "Ok" || len(result.Durations) != 1 || len(result.Durations[0]) != len(points) {
+		return nil, fmt.Errorf("unexpected matrix response (code %q)", result.Code)
+	}
+
+	durations := make(map[string]float64, len(destinations))
+	for i, d := range destinations {
+		seconds := result.Durations[0][i+1]
+		if seconds == nil {
+			durations[d.key()] = -1
+			continue
+		}
+		durations[d.key()] = *seconds
+	}
+	return durations, nil
+}
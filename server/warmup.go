@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The warm-gazetteer command geocodes the gazetteer's venues ahead of time,
+// e.g. at deploy, so scrapes don't spend requests on regular venues:
+//
+//	mapthens-server warm-gazetteer [-o venues.json] [-delay 200ms]
+//	mapthens-server warm-gazetteer -verify [-drift 150] [-update]
+//
+// By default only venues without coordinates are geocoded. With -verify,
+// venues that have coordinates are geocoded instead and those more than
+// -drift meters from the result are reported, failing the command unless
+// -update replaces them.
+
+const defaultDriftMeters = 150
+
+// Helper Functions
+
+// venueQuery is what the geocoder is asked for a venue; gazetteer entries
+// have no address, only the name locals know them by.
+func venueQuery(v VenueInfo) string {
+	return v.Name + ", Athens, GA"
+}
+
+func warmGazetteer(args []string) error {
+	cfg := getConfig()
+	flags := flag.NewFlagSet("warm-gazetteer", flag.ContinueOnError)
+	verify := flags.Bool("verify", false, "check stored coordinates against the geocoder instead of filling in missing ones")
+	update := flags.Bool("update", false, "with -verify, replace coordinates that drifted")
+	drift := flags.Float64("drift", defaultDriftMeters, "meters stored coordinates may be from the geocoder's")
+	delay := flags.Duration("delay", 200*time.Millisecond, "pause between geocoding requests")
+	output := flags.String("o", cfg.VenuesFile, "venues file to write (default MAPTHENS_VENUES_FILE)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("no venues file to write; set MAPTHENS_VENUES_FILE or pass -o")
+	}
+
+	venues := append([]VenueInfo(nil), defaultVenues...)
+	if cfg.VenuesFile != "" {
+		venues = nil
+		if err := readJSONFile(cfg.VenuesFile, &venues); err != nil {
+			return err
+		}
+	}
+
+	usageFile = cachePath(usageFile)
+	// Geocodes count against the budget wherever the server keeps it
+	if cfg.DatabaseURL != "" {
+		store, err := openPostgresStore(cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		eventStore = store
+	}
+	loadGeocodeUsage()
+	defer func() {
+		if err := saveGeocodeUsage(); err != nil {
+			log.Printf("Warning: Failed to save geocoding usage: %v", err)
+		}
+	}()
+
+	var geocoded, failed, drifted int
+	for i, v := range venues {
+		if v.hasCoordinates() != *verify {
+			continue
+		}
+		if remaining, limited := geocodeBudgetRemaining(); limited && remaining == 0 {
+			log.Println("Warning: Mapbox monthly budget reached, stopping.")
+			break
+		}
+		if geocoded+failed > 0 {
+			time.Sleep(*delay)
+		}
+
+		var longitude, latitude float64
+		err := retryRateLimited(context.Background(), func() (err error) {
+			longitude, latitude, err = geocodeAddress(context.Background(), venueQuery(v))
+			return err
+		})
+		if errors.Is(err, errTokenMissing) || errors.Is(err, errTokenRejected) || errors.Is(err, errRateLimited) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to geocode venue %q: %v", v.Name, err)
+			failed++
+			continue
+		}
+		geocoded++
+
+		found := coordinates{Latitude: latitude, Longitude: longitude}
+		if *verify {
+			d := distanceMeters(coordinates{Latitude: v.Latitude, Longitude: v.Longitude}, found)
+			if d <= *drift {
+				continue
+			}
+			log.Printf("Venue %q is %.0fm from the geocoder's %.5f,%.5f.", v.Name, d, latitude, longitude)
+			drifted++
+			if !*update {
+				continue
+			}
+		}
+		venues[i].Latitude, venues[i].Longitude = latitude, longitude
+	}
+	log.Printf("Geocoded %d venues, %d failed.", geocoded, failed)
+
+	if !*verify || (*update && drifted > 0) {
+		data, err := json.MarshalIndent(venues, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(*output, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote %d venues to %s.", len(venues), *output)
+	}
+	if *verify && drifted > 0 && !*update {
+		return fmt.Errorf("%d venues drifted more than %.0fm", drifted, *drift)
+	}
+	return nil
+}
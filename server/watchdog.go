@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A scrape that hangs (a connection that never answers, a parser stuck in a
+// loop) would otherwise hold the refresh forever, and the events would
+// never be refreshed again. The watchdog gives each scrape
+// MAPTHENS_SCRAPE_TIMEOUT to finish. Past that, the scrape's requests are
+// cancelled, the run is reported as failed with timed_out set, and another
+// refresh is started once refreshRetryDelay has passed, whether or not any
+// requests come in. The cancelled context is passed down through every
+// fetch and geocode of the scrape, so its remaining requests fail fast
+// instead of running alongside the retry. A scrape stuck in a loop can't be
+// stopped from outside; it's left to finish on its own and its events are
+// thrown away.
+
+// errScrapeTimeout is wrapped by the error of a scrape the watchdog gave up on.
+var errScrapeTimeout = errors.New("scrape timed out")
+
+// Helper Functions
+
+// watchedScrape runs scrapeEvents under the watchdog.
+func watchedScrape(previous []Event, origins []string) ([]Event, scrapeFindings, error) {
+	timeout := getConfig().ScrapeTimeout
+	if timeout <= 0 {
+		return scrapeEvents(context.Background(), previous, origins)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		events   []Event
+		findings scrapeFindings
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		events, findings, err := scrapeEvents(ctx, previous, origins)
+		done <- result{events, findings, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.events, r.findings, r.err
+	case <-timer.C:
+		cancel()
+		log.Printf("Warning: Scrape still running after %s, cancelling it", timeout)
+		return nil, scrapeFindings{}, fmt.Errorf("%w after %s", errScrapeTimeout, timeout)
+	}
+}
+
+// scheduleRefreshRetry refreshes the events again once refreshRetryDelay
+// has passed since the failure that was just recorded.
+func scheduleRefreshRetry() {
+	time.AfterFunc(refreshRetryDelay, func() {
+		log.Println("Retrying the refresh after a timed out scrape...")
+		if _, err := getEvents(); err != nil {
+			log.Printf("Warning: Retried refresh failed: %v", err)
+		}
+	})
+}
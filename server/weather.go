@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Forecasts for ?expand=weather come from the National Weather Service's
+// hourly forecast for the map center; Athens is small enough that one
+// forecast covers every event. The forecast is read at most once per
+// weatherCacheTTL, and a failed read isn't retried for weatherRetry. Events
+// outside the forecast's week, including past ones, get no weather.
+
+const (
+	defaultWeatherURL = "https://api.weather.gov"
+	weatherCacheTTL   = time.Hour
+	weatherRetry      = 5 * time.Minute
+)
+
+// Data Structures
+
+// Weather is the forecast for the hour an event starts, or noon for
+// all-day events.
+type Weather struct {
+	Time                time.Time `json:"time"`
+	Forecast            string    `json:"forecast"`
+	Temperature         int       `json:"temperature"`
+	TemperatureUnit     string    `json:"temperature_unit"`
+	PrecipitationChance *int      `json:"precipitation_chance,omitempty"`
+	WindSpeed           string    `json:"wind_speed,omitempty"`
+}
+
+type nwsPointResponse struct {
+	Properties struct {
+		ForecastHourly string `json:"forecastHourly"`
+	} `json:"properties"`
+}
+
+type nwsForecastResponse struct {
+	Properties struct {
+		Periods []struct {
+			StartTime                  time.Time `json:"startTime"`
+			EndTime                    time.Time `json:"endTime"`
+			Temperature                int       `json:"temperature"`
+			TemperatureUnit            string    `json:"temperatureUnit"`
+			WindSpeed                  string    `json:"windSpeed"`
+			ShortForecast              string    `json:"shortForecast"`
+			ProbabilityOfPrecipitation struct {
+				Value *int `json:"value"`
+			} `json:"probabilityOfPrecipitation"`
+		} `json:"periods"`
+	} `json:"properties"`
+}
+
+type forecastHour struct {
+	Weather
+	end time.Time
+}
+
+// Global Variables
+var (
+	weatherClient    = &http.Client{Timeout: 10 * time.Second}
+	weatherHours     []forecastHour
+	weatherFetchedAt time.Time
+	weatherErr       error
+	weatherMutex     sync.Mutex
+)
+
+// Helper Functions
+
+// getNWS decodes a National Weather Service API response, which needs a
+// User-Agent identifying the caller.
+func getNWS(requestURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	agent := "mapthens"
+	if public := getConfig().PublicURL; public != "" {
+		agent += " (" + public + ")"
+	}
+	req.Header.Set("User-Agent", agent)
+	req.Header.Set("Accept", "application/geo+json")
+
+	resp, err := weatherClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding json response: %v", err)
+	}
+	return nil
+}
+
+// fetchForecast reads the hourly forecast for the map center.
+func fetchForecast(baseURL string, center coordinates) ([]forecastHour, error) {
+	var point nwsPointResponse
+	if err := getNWS(fmt.Sprintf("%s/points/%.4f,%.4f", baseURL, center.Latitude, center.Longitude), &point); err != nil {
+		return nil, err
+	}
+	if point.Properties.ForecastHourly == "" {
+		return nil, fmt.Errorf("no hourly forecast for %.4f,%.4f", center.Latitude, center.Longitude)
+	}
+	var forecast nwsForecastResponse
+	if err := getNWS(point.Properties.ForecastHourly, &forecast); err != nil {
+		return nil, err
+	}
+
+	hours := make([]forecastHour, 0, len(forecast.Properties.Periods))
+	for _, p := range forecast.Properties.Periods {
+		hours = append(hours, forecastHour{
+			Weather: Weather{
+				Time:                p.StartTime,
+				Forecast:            p.ShortForecast,
+				Temperature:         p.Temperature,
+				TemperatureUnit:     p.TemperatureUnit,
+				PrecipitationChance: p.ProbabilityOfPrecipitation.Value,
+				WindSpeed:           p.WindSpeed,
+			},
+			end: p.EndTime,
+		})
+	}
+	return hours, nil
+}
+
+// hourlyForecast returns the cached forecast, reading it again when it's
+// stale. It returns nil when weather is turned off or can't be read.
+func hourlyForecast() []forecastHour {
+	cfg := getConfig()
+	if cfg.WeatherURL == "" {
+		return nil
+	}
+
+	weatherMutex.Lock()
+	defer weatherMutex.Unlock()
+	ttl := weatherCacheTTL
+	if weatherErr != nil {
+		ttl = weatherRetry
+	}
+	if !weatherFetchedAt.IsZero() && since(weatherFetchedAt) < ttl {
+		return weatherHours
+	}
+	hours, err := fetchForecast(cfg.WeatherURL, cfg.MapCenter)
+	weatherFetchedAt, weatherErr = now(), err
+	if err != nil {
+		log.Printf("Warning: Failed to fetch the weather forecast: %v", err)
+		return weatherHours
+	}
+	weatherHours = hours
+	return weatherHours
+}
+
+// weatherAt finds the forecast hour containing t.
+func weatherAt(hours []forecastHour, t time.Time) *Weather {
+	for _, h := range hours {
+		if !t.Before(h.Time) && t.Before(h.end) {
+			w := h.Weather
+			return &w
+		}
+	}
+	return nil
+}
+
+// weatherTime is when an event's weather is looked up: its start, or noon
+// on its first day for all-day events.
+func weatherTime(e Event) (time.Time, bool) {
+	if e.StartTime != nil {
+		return *e.StartTime, true
+	}
+	day, err := time.ParseInLocation("2006-01-02", e.StartDate, getConfig().Location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day.Add(12 * time.Hour), true
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Venues that run their own calendars can push their events to
+// POST /api/ingest/webhook instead of waiting for flagpole to list them.
+// Each venue is given a secret in MAPTHENS_WEBHOOK_SECRETS, keyed by its
+// venue ID (as in /api/venues/{id}/events.ics), and signs each push:
+//
+//	X-Mapthens-Venue: georgia-theatre
+//	X-Mapthens-Timestamp: 1760565600
+//	X-Mapthens-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// The body lists events by the venue's own IDs, e.g.
+//
+//	{"venue": "Georgia Theatre", "events": [{"id": "1234",
+//	 "title": "Drive-By Truckers", "starts_at": "2026-10-16T20:00:00-04:00"}]}
+//
+// Pushing an ID again updates that event, and "cancelled": true removes it.
+// Pushed events are kept in pushed.json in the cache directory and join the
+// cached events like another source: they're merged with flagpole's listing
+// of the same event (see sources.go) and normalized with everything else.
+
+const (
+	originVenue          = "venue"
+	webhookSignatureSkew = 5 * time.Minute
+	maxWebhookBody       = 256 << 10
+	maxWebhookEvents     = 200
+	minWebhookSecret     = 16
+)
+
+// Data Structures
+
+type webhookPush struct {
+	Venue  string         `json:"venue"`
+	Events []WebhookEvent `json:"events"`
+}
+
+// WebhookEvent is an event as a venue pushes it, and as it's stored.
+type WebhookEvent struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	EventLink   string     `json:"event_link,omitempty"`
+	Address     string     `json:"address,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Cancelled   bool       `json:"cancelled,omitempty"`
+
+	// Set by the server
+	VenueID    string    `json:"venue_id"`
+	Venue      string    `json:"venue"`
+	ReceivedAt time.Time `json:"received_at"`
+	Latitude   float64   `json:"latitude,omitempty"`
+	Longitude  float64   `json:"longitude,omitempty"`
+}
+
+type webhookReceipt struct {
+	Updated   int `json:"updated"`
+	Cancelled int `json:"cancelled"`
+}
+
+// Global Variables
+var (
+	// pushedEvents holds the pushed events by pushedKey
+	pushedEvents      = map[string]WebhookEvent{}
+	pushedEventsMutex sync.RWMutex
+	pushedFile        = "pushed.json"
+)
+
+// Helper Functions
+
+// parseWebhookSecrets reads "venue-id=secret,venue-id=secret".
+func parseWebhookSecrets(value string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		venue, secret, ok := strings.Cut(pair, "=")
+		if !ok || venue == "" {
+			return nil, fmt.Errorf("%q is not venue-id=secret", pair)
+		}
+		secrets[venue] = secret
+	}
+	return secrets, nil
+}
+
+func validateWebhookSecrets(secrets map[string]string) error {
+	for venue, secret := range secrets {
+		if len(secret) < minWebhookSecret {
+			return fmt.Errorf("secret for %s is shorter than %d characters", venue, minWebhookSecret)
+		}
+	}
+	return nil
+}
+
+func pushedKey(venueID, id string) string {
+	return venueID + "/" + id
+}
+
+// verifyWebhookSignature checks a push's timestamp and signature against
+// the venue's secret.
+func verifyWebhookSignature(secret, timestamp, signature string, body []byte) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	if skew := now().Sub(time.Unix(seconds, 0)); skew > webhookSignatureSkew || skew < -webhookSignatureSkew {
+		return fmt.Errorf("timestamp is more than %v off", webhookSignatureSkew)
+	}
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return fmt.Errorf("malformed signature")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+func (p WebhookEvent) submission() Submission {
+	return Submission{
+		Title:       p.Title,
+		StartsAt:    p.StartsAt,
+		EndsAt:      p.EndsAt,
+		Category:    p.Category,
+		EventLink:   p.EventLink,
+		Venue:       p.Venue,
+		Address:     p.Address,
+		Description: p.Description,
+		Latitude:    p.Latitude,
+		Longitude:   p.Longitude,
+		SubmittedAt: p.ReceivedAt,
+	}
+}
+
+// toEvent lists a pushed event the way submissions are listed. Its ID
+// stays the same across updates, so edits made to it keep applying.
+func (p WebhookEvent) toEvent() Event {
+	e := p.submission().toEvent()
+	sum := sha1.Sum([]byte(pushedKey(p.VenueID, p.ID)))
+	e.ID = "w" + hex.EncodeToString(sum[:])[:11]
+	e.SourceName = originVenue + "-" + p.VenueID
+	return e
+}
+
+// withPushedEvents merges the pushed events running on day into events.
+func withPushedEvents(events []Event, day string) []Event {
+	pushedEventsMutex.RLock()
+	var pushed []Event
+	for _, p := range pushedEvents {
+		if e := p.toEvent(); e.StartDate <= day && e.EndDate >= day {
+			pushed = append(pushed, e)
+		}
+	}
+	pushedEventsMutex.RUnlock()
+	if len(pushed) == 0 {
+		return events
+	}
+
+	combined := make([]Event, len(events), len(events)+len(pushed))
+	copy(combined, events)
+	merged, _ := mergeSources(append(combined, pushed...), getConfig().SourcePriority)
+	return merged
+}
+
+func loadPushedEvents() {
+	data, err := os.ReadFile(pushedFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read pushed events file: %v", err)
+		}
+		return
+	}
+
+	loaded := map[string]WebhookEvent{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("Warning: Failed to parse pushed events file: %v", err)
+		return
+	}
+
+	pushedEventsMutex.Lock()
+	pushedEvents = loaded
+	pushedEventsMutex.Unlock()
+}
+
+// savePushedEvents stores a push's updates and cancellations, dropping
+// events that ended long enough ago that they won't be listed again. As
+// with submissions, the file is re-read under its lock and nothing changes
+// in memory unless it's written.
+func savePushedEvents(updated []WebhookEvent, cancelled []string) error {
+	pushedEventsMutex.Lock()
+	defer pushedEventsMutex.Unlock()
+
+	cutoff := localNow().AddDate(0, 0, -recentRetention).Format("2006-01-02")
+	saved := map[string]WebhookEvent{}
+	err := updateJSONFile(pushedFile, &saved, func() error {
+		for key, existing := range saved {
+			if existing.toEvent().EndDate < cutoff {
+				delete(saved, key)
+			}
+		}
+		for _, p := range updated {
+			saved[pushedKey(p.VenueID, p.ID)] = p
+		}
+		for _, key := range cancelled {
+			delete(saved, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pushedEvents = saved
+	return nil
+}
+
+// HTTP Handlers
+
+// webhookHandler serves POST /api/ingest/webhook.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	venue := r.Header.Get("X-Mapthens-Venue")
+	secret, ok := getConfig().WebhookSecrets[venue]
+	if venue == "" || !ok {
+		http.Error(w, "Unknown venue", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := verifyWebhookSignature(secret, r.Header.Get("X-Mapthens-Timestamp"), r.Header.Get("X-Mapthens-Signature"), body); err != nil {
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	var push webhookPush
+	if errs := decodeChecked(body, &push); len(errs) > 0 {
+		writeValidationErrors(w, "Invalid push", errs)
+		return
+	}
+	var errs fieldErrors
+	// A venue can only push its own events
+	if push.Venue == "" || venueID(push.Venue) != venue {
+		errs.add("/venue", "%q is not %s", push.Venue, venue)
+	}
+	if len(push.Events) > maxWebhookEvents {
+		errs.add("/events", "must have at most %d events", maxWebhookEvents)
+	}
+	for i, p := range push.Events {
+		path := pointer("/events", i)
+		if p.ID == "" {
+			errs.add(pointer(path, "id"), "is required")
+		}
+		checkLength(&errs, pointer(path, "id"), p.ID, 200)
+		// The venue was checked above
+		if !p.Cancelled {
+			p.Venue = venue
+			errs = append(errs, p.submission().validate(path)...)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, "Invalid push", errs)
+		return
+	}
+
+	var updated []WebhookEvent
+	var cancelled []string
+	receivedAt := now()
+	for _, p := range push.Events {
+		if p.Cancelled {
+			cancelled = append(cancelled, pushedKey(venue, p.ID))
+			continue
+		}
+		p.VenueID, p.Venue, p.ReceivedAt = venue, push.Venue, receivedAt
+		p.Latitude, p.Longitude = geocodeVenueAddress(p.Venue, p.Address)
+		updated = append(updated, p)
+	}
+
+	if err := savePushedEvents(updated, cancelled); err != nil {
+		http.Error(w, fmt.Sprintf("Error saving events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Venue %s pushed %d events and cancelled %d.", venue, len(updated), len(cancelled))
+	renormalizeCache()
+	writeJSON(w, webhookReceipt{Updated: len(updated), Cancelled: len(cancelled)})
+}